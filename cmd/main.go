@@ -1,14 +1,232 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/kebaren/textbuffer/pkg/buffer"
+	"github.com/kebaren/textbuffer/pkg/daemon"
+	"github.com/kebaren/textbuffer/pkg/editor"
+	"github.com/kebaren/textbuffer/pkg/jsonrpc"
+	"github.com/kebaren/textbuffer/pkg/lsp"
+	"github.com/kebaren/textbuffer/pkg/sed"
 )
 
+const usage = `usage: textbuffer <command> [arguments]
+
+commands:
+  edit FILE              open FILE in the terminal editor
+  sed SCRIPT [FILE...]   run a sed script over files (-n, -E, -e, -f, -i[SUFFIX])
+  serve --lsp            run a language server document store over stdio
+  serve --socket PATH    host named buffers over JSON-RPC on a Unix socket
+  demo                   print a short demonstration of the buffer API
+`
+
 func main() {
+	if len(os.Args) < 2 {
+		runDemo()
+		return
+	}
+
+	switch os.Args[1] {
+	case "edit":
+		os.Exit(runEdit(os.Args[2:]))
+	case "sed":
+		os.Exit(runSed(os.Args[2:]))
+	case "serve":
+		os.Exit(runServe(os.Args[2:]))
+	case "demo":
+		runDemo()
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "textbuffer: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+}
+
+// runEdit 在终端编辑器中打开文件
+func runEdit(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprint(os.Stderr, "usage: textbuffer edit FILE\n")
+		return 2
+	}
+	if err := editor.Run(args[0]); err != nil {
+		fmt.Fprintf(os.Stderr, "textbuffer edit: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runSed 对文件执行 sed 脚本，没有文件时读取标准输入
+func runSed(args []string) int {
+	const sedUsage = "usage: textbuffer sed [-n] [-E] [-i[SUFFIX]] [-e SCRIPT]... [-f FILE]... [SCRIPT] [FILE...]\n"
+	var (
+		opts     sed.Options
+		scripts  []string
+		inPlace  bool
+		suffix   string
+		haveExpr bool
+	)
+	i := 0
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			i++
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			break
+		}
+		switch {
+		case arg == "-n" || arg == "--quiet" || arg == "--silent":
+			opts.Quiet = true
+		case arg == "-E" || arg == "-r" || arg == "--regexp-extended":
+			opts.Extended = true
+		case arg == "-i" || arg == "--in-place":
+			inPlace = true
+		case strings.HasPrefix(arg, "--in-place="):
+			inPlace, suffix = true, strings.TrimPrefix(arg, "--in-place=")
+		case strings.HasPrefix(arg, "-i"):
+			inPlace, suffix = true, arg[2:]
+		case arg == "-e" || arg == "-f":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "textbuffer sed: %s requires an argument\n", arg)
+				return 2
+			}
+			i++
+			script := args[i]
+			if arg == "-f" {
+				content, err := os.ReadFile(script)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "textbuffer sed: %v\n", err)
+					return 1
+				}
+				script = strings.TrimSuffix(string(content), "\n")
+			}
+			scripts = append(scripts, script)
+			haveExpr = true
+		default:
+			fmt.Fprintf(os.Stderr, "textbuffer sed: unknown option %s\n%s", arg, sedUsage)
+			return 2
+		}
+	}
+	files := args[i:]
+	if !haveExpr {
+		if len(files) == 0 {
+			fmt.Fprint(os.Stderr, sedUsage)
+			return 2
+		}
+		scripts, files = files[:1], files[1:]
+	}
+	if inPlace && len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "textbuffer sed: -i requires at least one file")
+		return 2
+	}
+
+	prog, err := sed.Compile(strings.Join(scripts, "\n"), opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "textbuffer %v\n", err)
+		return 1
+	}
+
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	status := 0
+	for _, name := range files {
+		if err := sedFile(prog, name, inPlace, suffix); err != nil {
+			fmt.Fprintf(os.Stderr, "textbuffer sed: %v\n", err)
+			status = 1
+		}
+	}
+	return status
+}
 
-	// 这里可以添加应用程序的启动代码
+// sedFile 对单个文件执行脚本，每个文件独立处理，行号和 $ 都相对于该文件
+func sedFile(prog *sed.Program, name string, inPlace bool, suffix string) error {
+	if inPlace {
+		return sed.EditFile(name, prog, suffix)
+	}
+	in := os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	tree, bom, err := sed.ReadTree(in)
+	if err != nil {
+		return err
+	}
+	prog.Apply(tree)
+	return sed.WriteTree(os.Stdout, tree, bom)
+}
+
+// runServe 运行服务器模式
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	useLSP := fs.Bool("lsp", false, "speak the language server protocol over stdio")
+	socket := fs.String("socket", "", "host named buffers over newline-delimited JSON-RPC on this Unix socket")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *useLSP == (*socket != "") {
+		fmt.Fprintln(os.Stderr, "textbuffer serve: exactly one of --lsp or --socket is required")
+		return 2
+	}
+	if *socket != "" {
+		return runDaemon(*socket)
+	}
+
+	server := lsp.NewServer()
+	if err := server.Serve(jsonrpc.NewHeaderStream(os.Stdin, os.Stdout)); err != nil {
+		fmt.Fprintf(os.Stderr, "textbuffer serve: %v\n", err)
+		return 1
+	}
+	return server.ExitCode()
+}
+
+// runDaemon 在 Unix 套接字上运行缓冲区服务器，收到中断信号时关闭
+func runDaemon(socket string) int {
+	// 删除上次异常退出时遗留的套接字文件，但不抢占仍在运行的服务器
+	if info, err := os.Lstat(socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			fmt.Fprintf(os.Stderr, "textbuffer serve: %s is already in use\n", socket)
+			return 1
+		}
+		os.Remove(socket)
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "textbuffer serve: %v\n", err)
+		return 1
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		listener.Close()
+	}()
+
+	if err := daemon.NewServer().Serve(listener); err != nil {
+		fmt.Fprintf(os.Stderr, "textbuffer serve: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runDemo 演示缓冲区的基本用法
+func runDemo() {
 	ptbb := buffer.NewPieceTreeTextBufferBuilder()
 	ptbb.AcceptChunk("abc\n")
 	ptbb.AcceptChunk("def")
@@ -48,5 +266,4 @@ func main() {
 	fmt.Printf("1->%q\n", tt.GetLineContent(1))
 	fmt.Printf("2->%q\n", tt.GetLineContent(2))
 	fmt.Printf("%q\n", tt.GetLinesRawContent())
-
 }
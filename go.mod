@@ -1,6 +1,6 @@
 module github.com/kebaren/textbuffer
 
-go 1.21
+go 1.23
 
 require github.com/stretchr/testify v1.10.0
 
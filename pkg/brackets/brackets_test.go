@@ -0,0 +1,220 @@
+package brackets
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+	"github.com/kebaren/textbuffer/pkg/buffer/buffertest"
+	"github.com/kebaren/textbuffer/pkg/common"
+	"github.com/kebaren/textbuffer/pkg/grammar"
+	"github.com/kebaren/textbuffer/pkg/tokenization"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pos(line, column int) common.Position {
+	return common.Position{LineNumber: line, Column: column}
+}
+
+func rng(line, start, end int) common.Range {
+	return common.Range{StartLineNumber: line, StartColumn: start, EndLineNumber: line, EndColumn: end}
+}
+
+const source = `func f(a []int) {
+	if a[0] > 0 {
+		g("(", ')') // )
+	}
+	/* { */
+	s := ` + "`" + `
+	]` + "`" + `
+}
+`
+
+func newIndex(t *testing.T, tree *buffer.PieceTreeBase, opts Options) *Index {
+	t.Helper()
+	idx, err := NewIndex(tree, opts)
+	require.NoError(t, err)
+	return idx
+}
+
+func TestTokenizer(t *testing.T) {
+	var tok CLikeTokenizer
+	state := tok.InitialState()
+	tokenize := func(line string) [][2]int {
+		var tokens []tokenization.Token
+		tokens, state = tok.Tokenize(line, state)
+		require.NotEmpty(t, tokens)
+		assert.Equal(t, len(line), tokens[len(tokens)-1].EndIndex)
+		return ignoredRanges(tokens)
+	}
+
+	assert.Equal(t, [][2]int{{2, 8}, {9, 12}, {13, 17}}, tokenize(`a "b\"c" 'd' // e`))
+	assert.True(t, state.Equals(clikeNormal))
+
+	assert.Equal(t, [][2]int{{2, 6}}, tokenize("x /* y"))
+	assert.True(t, state.Equals(clikeBlockComment))
+	assert.Empty(t, tokenize(""))
+	assert.Equal(t, [][2]int{{0, 4}, {5, 7}}, tokenize("z */ `w"))
+	assert.True(t, state.Equals(clikeTemplate))
+	assert.Equal(t, [][2]int{{0, 2}}, tokenize("v` u"))
+	assert.True(t, state.Equals(clikeNormal))
+
+	tokens, _ := tok.Tokenize(`f("x") // y`, clikeNormal)
+	assert.Equal(t, []tokenization.Token{
+		{StartIndex: 0, EndIndex: 2, Scopes: []string{"source"}},
+		{StartIndex: 2, EndIndex: 5, Scopes: []string{"source", "string.quoted"}},
+		{StartIndex: 5, EndIndex: 7, Scopes: []string{"source"}},
+		{StartIndex: 7, EndIndex: 11, Scopes: []string{"source", "comment"}},
+	}, tokens)
+}
+
+func TestRejectsPairsThatOpenAndCloseAlike(t *testing.T) {
+	_, err := NewIndex(buffertest.NewTree("|a|"), Options{Pairs: []Pair{{"(", ")"}, {"|", "|"}}})
+	assert.EqualError(t, err, `brackets: pair 1 opens and closes with the same text "|"`)
+}
+
+func TestMatchBracket(t *testing.T) {
+	idx := newIndex(t, buffertest.NewTree(source), Options{Tokenizer: CLikeTokenizer{}})
+
+	pair, ok := idx.MatchBracket(pos(1, 17))
+	require.True(t, ok)
+	assert.Equal(t, BracketPair{Open: rng(1, 17, 18), Close: rng(8, 1, 2), Pair: 2}, pair)
+
+	// The bracket after the cursor is preferred, then the one before it.
+	pair, ok = idx.MatchBracket(pos(1, 7))
+	require.True(t, ok)
+	assert.Equal(t, BracketPair{Open: rng(1, 7, 8), Close: rng(1, 15, 16), Pair: 0}, pair)
+	pair, ok = idx.MatchBracket(pos(1, 16))
+	require.True(t, ok)
+	assert.Equal(t, BracketPair{Open: rng(1, 7, 8), Close: rng(1, 15, 16), Pair: 0}, pair)
+	pair, ok = idx.MatchBracket(pos(1, 11))
+	require.True(t, ok)
+	assert.Equal(t, BracketPair{Open: rng(1, 10, 11), Close: rng(1, 11, 12), Pair: 1}, pair)
+
+	// Brackets in strings and comments are ignored.
+	pair, ok = idx.MatchBracket(pos(3, 4))
+	require.True(t, ok)
+	assert.Equal(t, BracketPair{Open: rng(3, 4, 5), Close: rng(3, 13, 14), Pair: 0}, pair)
+	_, ok = idx.MatchBracket(pos(3, 6))
+	assert.False(t, ok)
+	pair, ok = idx.MatchBracket(pos(4, 2))
+	require.True(t, ok)
+	assert.Equal(t, BracketPair{Open: rng(2, 14, 15), Close: rng(4, 2, 3), Pair: 2}, pair)
+	_, ok = idx.MatchBracket(pos(7, 2))
+	assert.False(t, ok)
+
+	// Without a tokenizer every bracket counts.
+	plain := newIndex(t, buffertest.NewTree(source), Options{})
+	assert.Len(t, plain.Brackets(3), 5)
+	assert.Len(t, idx.Brackets(3), 2)
+}
+
+func TestFindEnclosingBrackets(t *testing.T) {
+	idx := newIndex(t, buffertest.NewTree(source), Options{Tokenizer: CLikeTokenizer{}})
+
+	pair, ok := idx.FindEnclosingBrackets(pos(3, 8))
+	require.True(t, ok)
+	assert.Equal(t, BracketPair{Open: rng(3, 4, 5), Close: rng(3, 13, 14), Pair: 0}, pair)
+
+	pair, ok = idx.FindEnclosingBrackets(pos(3, 16))
+	require.True(t, ok)
+	assert.Equal(t, BracketPair{Open: rng(2, 14, 15), Close: rng(4, 2, 3), Pair: 2}, pair)
+
+	pair, ok = idx.FindEnclosingBrackets(pos(6, 3))
+	require.True(t, ok)
+	assert.Equal(t, BracketPair{Open: rng(1, 17, 18), Close: rng(8, 1, 2), Pair: 2}, pair)
+
+	_, ok = idx.FindEnclosingBrackets(pos(1, 3))
+	assert.False(t, ok)
+	_, ok = idx.FindEnclosingBrackets(pos(9, 1))
+	assert.False(t, ok)
+}
+
+func TestCustomPairs(t *testing.T) {
+	tree := buffertest.NewTree("begin\n  <a>\nend")
+	idx := newIndex(t, tree, Options{Pairs: []Pair{{"begin", "end"}, {"<", ">"}}})
+	pair, ok := idx.MatchBracket(pos(3, 4))
+	require.True(t, ok)
+	assert.Equal(t, BracketPair{Open: rng(1, 1, 6), Close: rng(3, 1, 4), Pair: 0}, pair)
+	pair, ok = idx.FindEnclosingBrackets(pos(2, 4))
+	require.True(t, ok)
+	assert.Equal(t, BracketPair{Open: rng(2, 3, 4), Close: rng(2, 5, 6), Pair: 1}, pair)
+}
+
+func TestBracketsWithDepth(t *testing.T) {
+	idx := newIndex(t, buffertest.NewTree("({[]})\n)(\n[\n]"), Options{})
+	var depths []int
+	for _, b := range idx.GetBracketsWithDepth(1, 4) {
+		depths = append(depths, b.Depth)
+	}
+	assert.Equal(t, []int{0, 1, 2, 2, 1, 0, -1, 0, 1, 1}, depths)
+
+	b := idx.GetBracketsWithDepth(4, 4)
+	require.Len(t, b, 1)
+	assert.Equal(t, ColorizedBracket{Bracket: Bracket{Range: rng(4, 1, 2), Pair: 1}, Depth: 1}, b[0])
+
+	// Depths past several checkpoints.
+	text := strings.Repeat("{\n", 1000) + strings.Repeat("}\n", 1000)
+	tree := buffertest.NewTree(text)
+	idx = newIndex(t, tree, Options{})
+	b = idx.GetBracketsWithDepth(1500, 1500)
+	require.Len(t, b, 1)
+	assert.Equal(t, 500, b[0].Depth)
+	tree.ApplyEdit(buffer.NewEditOperation(0, 0, "{"))
+	b = idx.GetBracketsWithDepth(1500, 1500)
+	require.Len(t, b, 1)
+	assert.Equal(t, 501, b[0].Depth)
+}
+
+func TestIncrementalIndex(t *testing.T) {
+	r := rand.New(rand.NewSource(41))
+	pieces := []string{"(", ")", "{", "}\n", "[", "]", "\n", "/*", "*/", "//", `"`, "`", "x"}
+	tree := buffertest.NewTree(source)
+	opts := Options{Tokenizer: CLikeTokenizer{}}
+	idx := newIndex(t, tree, opts)
+	history := buffer.NewHistory(tree, 0)
+
+	for i := 0; i < 400; i++ {
+		length := tree.GetLength()
+		op := buffer.NewEditOperation(r.Intn(length+1), 0, "")
+		if r.Intn(3) == 0 && length > op.Offset {
+			op.Length = r.Intn(min(length-op.Offset, 8)) + 1
+		}
+		for n := r.Intn(3); n > 0; n-- {
+			op.Text += pieces[r.Intn(len(pieces))]
+		}
+		history.Apply([]buffer.EditOperation{op})
+
+		expected := newIndex(t, tree, opts)
+		expected.Dispose()
+		require.Equal(t, expected.lines, idx.lines, "after edit %d", i)
+		require.Equal(t, expected.GetBracketsWithDepth(1, tree.GetLineCount()), idx.GetBracketsWithDepth(1, tree.GetLineCount()), "after edit %d", i)
+	}
+
+	// Undoing every edit through the history keeps the index in sync.
+	for history.CanUndo() {
+		history.Undo()
+	}
+	assert.Equal(t, source, tree.GetLinesRawContent())
+	assert.Equal(t, newIndex(t, buffertest.NewTree(source), opts).lines, idx.lines)
+}
+
+func TestGrammarTokenizer(t *testing.T) {
+	g, err := grammar.Parse([]byte(`{"scopeName": "source.x", "patterns": [
+		{"match": "#.*", "name": "comment.line.x"},
+		{"begin": "<<", "end": ">>", "name": "string.unquoted.x"}]}`))
+	require.NoError(t, err)
+	tree := buffertest.NewTree("(a # )\n<< ]\n) >> ]\n[")
+	idx := newIndex(t, tree, Options{Tokenizer: g})
+	all := func() []ColorizedBracket { return idx.GetBracketsWithDepth(1, tree.GetLineCount()) }
+	assert.Len(t, all(), 3)
+
+	// Closing the string earlier is followed to the lines after it, whose start state changed.
+	tree.ApplyEdit(buffer.NewEditOperation(tree.GetOffsetAt(2, 4), 0, ">>"))
+	expected := newIndex(t, tree, Options{Tokenizer: g})
+	expected.Dispose()
+	assert.Equal(t, expected.GetBracketsWithDepth(1, tree.GetLineCount()), all())
+	assert.Len(t, all(), 5)
+}
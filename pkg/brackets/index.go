@@ -0,0 +1,420 @@
+package brackets
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+	"github.com/kebaren/textbuffer/pkg/common"
+	"github.com/kebaren/textbuffer/pkg/tokenization"
+)
+
+// checkpointInterval 每隔多少行缓存一次行首未闭合的开括号
+const checkpointInterval = 256
+
+// Pair 括号对，Open 和 Close 不能相同
+type Pair struct {
+	Open  string
+	Close string
+}
+
+// DefaultPairs 默认的括号对
+var DefaultPairs = []Pair{{"(", ")"}, {"[", "]"}, {"{", "}"}}
+
+// Options 括号索引的选项
+type Options struct {
+	// Pairs 括号对，为空时使用 DefaultPairs
+	Pairs []Pair
+	// Tokenizer 分词器，作用域以 string 或 comment 开头的标记中的括号不参与匹配，为 nil 时所有括号都参与匹配
+	// 例如 CLikeTokenizer 或 grammar.Grammar
+	Tokenizer tokenization.Tokenizer
+}
+
+// Bracket 文档中的一个括号
+type Bracket struct {
+	// Range 括号所在的范围
+	Range common.Range
+	// Pair 括号对在 Options.Pairs 中的下标
+	Pair int
+	// Open 是否为开括号
+	Open bool
+}
+
+// BracketPair 一对匹配的括号
+type BracketPair struct {
+	// Open 开括号所在的范围
+	Open common.Range
+	// Close 闭括号所在的范围
+	Close common.Range
+	// Pair 括号对在 Options.Pairs 中的下标
+	Pair int
+}
+
+// ColorizedBracket 带有嵌套深度的括号，用于括号着色
+type ColorizedBracket struct {
+	Bracket
+	// Depth 嵌套深度，最外层为 0，不匹配的闭括号为 -1
+	Depth int
+}
+
+// lineBracket 行内的括号，start 和 end 是从 0 开始的字节偏移量
+type lineBracket struct {
+	start, end int
+	pair       int
+	open       bool
+}
+
+// lineData 每行缓存的括号和行末的词法状态
+type lineData struct {
+	brackets []lineBracket
+	state    tokenization.State
+}
+
+// Index 括号索引，缓存每行的括号，修改文本缓冲区后只重新扫描受影响的行
+// 词法状态改变时继续向后扫描，直到行首的状态与之前相同
+type Index struct {
+	tree      *buffer.PieceTreeBase
+	pairs     []Pair
+	tokenizer tokenization.Tokenizer
+	// first 可以作为括号开头的字节
+	first [256]bool
+	lines []lineData
+	// checkpoints 第 1+k*checkpointInterval 行行首未闭合的开括号，只有前 len(checkpoints) 个有效
+	checkpoints [][]int
+	// removeListener 取消注册内容修改监听器
+	removeListener func()
+}
+
+// NewIndex 创建括号索引，括号对的开括号和闭括号相同时返回错误
+func NewIndex(tree *buffer.PieceTreeBase, opts Options) (*Index, error) {
+	idx := &Index{tree: tree, pairs: opts.Pairs, tokenizer: opts.Tokenizer}
+	if len(idx.pairs) == 0 {
+		idx.pairs = DefaultPairs
+	}
+	for i, p := range idx.pairs {
+		if p.Open == p.Close {
+			return nil, fmt.Errorf("brackets: pair %d opens and closes with the same text %q", i, p.Open)
+		}
+	}
+	for _, p := range idx.pairs {
+		if p.Open != "" {
+			idx.first[p.Open[0]] = true
+		}
+		if p.Close != "" {
+			idx.first[p.Close[0]] = true
+		}
+	}
+
+	idx.lines = make([]lineData, tree.GetLineCount())
+	state := idx.initialState()
+	for i := range idx.lines {
+		idx.lines[i] = idx.scanLine(i+1, state)
+		state = idx.lines[i].state
+	}
+	idx.removeListener = tree.AddChangeListener(idx.onContentChange)
+	return idx, nil
+}
+
+// initialState 返回第一行开始时的词法状态
+func (idx *Index) initialState() tokenization.State {
+	if idx.tokenizer == nil {
+		return nil
+	}
+	return idx.tokenizer.InitialState()
+}
+
+// stateBefore 返回第 index 行（从 0 开始）行首的词法状态
+func (idx *Index) stateBefore(index int) tokenization.State {
+	if index == 0 {
+		return idx.initialState()
+	}
+	return idx.lines[index-1].state
+}
+
+// sameState 判断两个词法状态是否相同，没有分词器时状态都是 nil
+func sameState(a, b tokenization.State) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Equals(b)
+}
+
+// scanLine 从词法状态 state 开始扫描一行中的括号
+func (idx *Index) scanLine(lineNumber int, state tokenization.State) lineData {
+	line := idx.tree.GetLineContent(lineNumber)
+	var ignored [][2]int
+	if idx.tokenizer != nil {
+		var tokens []tokenization.Token
+		tokens, state = idx.tokenizer.Tokenize(line, state.Clone())
+		ignored = ignoredRanges(tokens)
+	}
+
+	var brackets []lineBracket
+	for i := 0; i < len(line); {
+		if len(ignored) > 0 && i >= ignored[0][0] {
+			i = max(i, ignored[0][1])
+			ignored = ignored[1:]
+			continue
+		}
+		if !idx.first[line[i]] {
+			i++
+			continue
+		}
+		if b, ok := idx.bracketAt(line, i); ok {
+			brackets = append(brackets, b)
+			i = b.end
+			continue
+		}
+		i++
+	}
+	return lineData{brackets: brackets, state: state}
+}
+
+// bracketAt 返回从 i 开始的最长的括号
+func (idx *Index) bracketAt(line string, i int) (lineBracket, bool) {
+	best := lineBracket{start: -1}
+	for p, pair := range idx.pairs {
+		if pair.Open != "" && len(pair.Open) > best.end-i && strings.HasPrefix(line[i:], pair.Open) {
+			best = lineBracket{start: i, end: i + len(pair.Open), pair: p, open: true}
+		}
+		if pair.Close != "" && len(pair.Close) > best.end-i && strings.HasPrefix(line[i:], pair.Close) {
+			best = lineBracket{start: i, end: i + len(pair.Close), pair: p}
+		}
+	}
+	return best, best.start >= 0
+}
+
+// OnLinesReplaced 通知索引从 startLine 开始的 oldLineCount 个行被替换为 newLineCount 个新行
+func (idx *Index) OnLinesReplaced(startLine, oldLineCount, newLineCount int) {
+	index := min(max(startLine-1, 0), len(idx.lines))
+	oldLineCount = max(min(oldLineCount, len(idx.lines)-index), 0)
+	newLineCount = max(min(newLineCount, idx.tree.GetLineCount()-index), 0)
+
+	// oldState 后面第一行在替换之前的行首状态
+	state := idx.stateBefore(index)
+	oldState := state
+	if oldLineCount > 0 {
+		oldState = idx.lines[index+oldLineCount-1].state
+	}
+
+	lines := make([]lineData, newLineCount)
+	for i := range lines {
+		lines[i] = idx.scanLine(startLine+i, state)
+		state = lines[i].state
+	}
+	tail := append([]lineData(nil), idx.lines[index+oldLineCount:]...)
+	idx.lines = append(append(idx.lines[:index], lines...), tail...)
+
+	// 行末的状态改变时，后面的行需要重新扫描，直到行首状态与之前相同
+	for i := index + newLineCount; i < len(idx.lines) && !sameState(state, oldState); i++ {
+		oldState = idx.lines[i].state
+		idx.lines[i] = idx.scanLine(i+1, state)
+		state = idx.lines[i].state
+	}
+
+	if valid := index/checkpointInterval + 1; len(idx.checkpoints) > valid {
+		idx.checkpoints = idx.checkpoints[:valid]
+	}
+}
+
+// onContentChange 文本缓冲区修改后更新受影响的行
+func (idx *Index) onContentChange(change buffer.ContentChange) {
+	idx.OnLinesReplaced(change.StartLine(), change.OldLineCount(), change.NewLineCount())
+}
+
+// Dispose 停止跟踪文本缓冲区的修改
+func (idx *Index) Dispose() {
+	idx.removeListener()
+}
+
+// toBracket 把行内的括号转换为 Bracket
+func toBracket(lineNumber int, b lineBracket) Bracket {
+	return Bracket{
+		Range: common.Range{StartLineNumber: lineNumber, StartColumn: b.start + 1, EndLineNumber: lineNumber, EndColumn: b.end + 1},
+		Pair:  b.pair,
+		Open:  b.open,
+	}
+}
+
+// Brackets 返回一行中的括号，行号无效时返回 nil
+func (idx *Index) Brackets(lineNumber int) []Bracket {
+	if lineNumber < 1 || lineNumber > len(idx.lines) {
+		return nil
+	}
+	var result []Bracket
+	for _, b := range idx.lines[lineNumber-1].brackets {
+		result = append(result, toBracket(lineNumber, b))
+	}
+	return result
+}
+
+// MatchBracket 返回位置处的括号和与之匹配的括号
+// 优先使用从该位置开始的括号，其次是在该位置结束的括号；没有括号或找不到匹配时返回 false
+func (idx *Index) MatchBracket(position common.Position) (BracketPair, bool) {
+	if position.LineNumber < 1 || position.LineNumber > len(idx.lines) {
+		return BracketPair{}, false
+	}
+	brackets := idx.lines[position.LineNumber-1].brackets
+	offset := position.Column - 1
+	at := -1
+	for i, b := range brackets {
+		if b.start <= offset && offset < b.end {
+			at = i
+			break
+		}
+		if b.end == offset {
+			at = i
+		}
+	}
+	if at < 0 {
+		return BracketPair{}, false
+	}
+
+	b := toBracket(position.LineNumber, brackets[at])
+	if b.Open {
+		if c, ok := idx.findClose(position.LineNumber, at); ok {
+			return BracketPair{Open: b.Range, Close: c.Range, Pair: b.Pair}, true
+		}
+	} else if o, ok := idx.findOpen(position.LineNumber, at); ok {
+		return BracketPair{Open: o.Range, Close: b.Range, Pair: b.Pair}, true
+	}
+	return BracketPair{}, false
+}
+
+// findClose 向后查找与第 lineNumber 行第 at 个开括号匹配的闭括号，只统计同一种括号的嵌套
+func (idx *Index) findClose(lineNumber, at int) (Bracket, bool) {
+	pair := idx.lines[lineNumber-1].brackets[at].pair
+	depth := 0
+	for line := lineNumber; line <= len(idx.lines); line++ {
+		brackets := idx.lines[line-1].brackets
+		start := 0
+		if line == lineNumber {
+			start = at
+		}
+		for _, b := range brackets[start:] {
+			if b.pair != pair {
+				continue
+			}
+			if b.open {
+				depth++
+			} else if depth--; depth == 0 {
+				return toBracket(line, b), true
+			}
+		}
+	}
+	return Bracket{}, false
+}
+
+// findOpen 向前查找与第 lineNumber 行第 at 个闭括号匹配的开括号，只统计同一种括号的嵌套
+func (idx *Index) findOpen(lineNumber, at int) (Bracket, bool) {
+	pair := idx.lines[lineNumber-1].brackets[at].pair
+	depth := 0
+	for line := lineNumber; line >= 1; line-- {
+		brackets := idx.lines[line-1].brackets
+		end := len(brackets) - 1
+		if line == lineNumber {
+			end = at
+		}
+		for i := end; i >= 0; i-- {
+			b := brackets[i]
+			if b.pair != pair {
+				continue
+			}
+			if !b.open {
+				depth++
+			} else if depth--; depth == 0 {
+				return toBracket(line, b), true
+			}
+		}
+	}
+	return Bracket{}, false
+}
+
+// FindEnclosingBrackets 返回包围位置的最内层的一对括号，找不到时返回 false
+func (idx *Index) FindEnclosingBrackets(position common.Position) (BracketPair, bool) {
+	if position.LineNumber < 1 || position.LineNumber > len(idx.lines) {
+		return BracketPair{}, false
+	}
+	offset := position.Column - 1
+	// unclosed 每种括号在位置之前已经向前跳过的闭括号数量
+	unclosed := make([]int, len(idx.pairs))
+	for line := position.LineNumber; line >= 1; line-- {
+		brackets := idx.lines[line-1].brackets
+		for i := len(brackets) - 1; i >= 0; i-- {
+			b := brackets[i]
+			if line == position.LineNumber && b.end > offset {
+				continue
+			}
+			if !b.open {
+				unclosed[b.pair]++
+				continue
+			}
+			if unclosed[b.pair] > 0 {
+				unclosed[b.pair]--
+				continue
+			}
+			// 没有闭括号的开括号不包围任何位置，继续向前查找
+			if c, ok := idx.findClose(line, i); ok {
+				return BracketPair{Open: toBracket(line, b).Range, Close: c.Range, Pair: b.pair}, true
+			}
+		}
+	}
+	return BracketPair{}, false
+}
+
+// applyBracket 用括号更新未闭合的开括号栈，返回括号的嵌套深度
+func applyBracket(stack []int, b lineBracket) ([]int, int) {
+	if b.open {
+		return append(stack, b.pair), len(stack)
+	}
+	if n := len(stack); n > 0 && stack[n-1] == b.pair {
+		return stack[:n-1], n - 1
+	}
+	return stack, -1
+}
+
+// stackBefore 返回第 lineNumber 行行首未闭合的开括号，从最近的检查点开始计算
+func (idx *Index) stackBefore(lineNumber int) []int {
+	k := (lineNumber - 1) / checkpointInterval
+	if len(idx.checkpoints) == 0 {
+		idx.checkpoints = append(idx.checkpoints, nil)
+	}
+	for len(idx.checkpoints) <= k {
+		last := len(idx.checkpoints) - 1
+		stack := append([]int(nil), idx.checkpoints[last]...)
+		for line := last*checkpointInterval + 1; line <= (last+1)*checkpointInterval; line++ {
+			for _, b := range idx.lines[line-1].brackets {
+				stack, _ = applyBracket(stack, b)
+			}
+		}
+		idx.checkpoints = append(idx.checkpoints, stack)
+	}
+
+	stack := append([]int(nil), idx.checkpoints[k]...)
+	for line := k*checkpointInterval + 1; line < lineNumber; line++ {
+		for _, b := range idx.lines[line-1].brackets {
+			stack, _ = applyBracket(stack, b)
+		}
+	}
+	return stack
+}
+
+// GetBracketsWithDepth 返回 [startLine, endLine] 中的括号以及它们的嵌套深度
+// 闭括号只与最近的未闭合开括号匹配，不同种类的括号交叉时闭括号被视为不匹配
+func (idx *Index) GetBracketsWithDepth(startLine, endLine int) []ColorizedBracket {
+	startLine = max(startLine, 1)
+	endLine = min(endLine, len(idx.lines))
+	if startLine > endLine {
+		return nil
+	}
+	var result []ColorizedBracket
+	stack := idx.stackBefore(startLine)
+	for line := startLine; line <= endLine; line++ {
+		for _, b := range idx.lines[line-1].brackets {
+			var depth int
+			stack, depth = applyBracket(stack, b)
+			result = append(result, ColorizedBracket{Bracket: toBracket(line, b), Depth: depth})
+		}
+	}
+	return result
+}
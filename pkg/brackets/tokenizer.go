@@ -0,0 +1,175 @@
+package brackets
+
+import (
+	"strings"
+
+	"github.com/kebaren/textbuffer/pkg/tokenization"
+)
+
+// isIgnoredScope 判断作用域是否为字符串或注释，按 TextMate 的命名约定以 string 或 comment 开头
+func isIgnoredScope(scope string) bool {
+	for _, prefix := range []string{"string", "comment"} {
+		if rest, ok := strings.CutPrefix(scope, prefix); ok && (rest == "" || rest[0] == '.') {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoredRanges 返回标记中位于字符串或注释作用域内的字节范围 [start, end)，相邻的范围被合并
+func ignoredRanges(tokens []tokenization.Token) [][2]int {
+	var ranges [][2]int
+	for _, tok := range tokens {
+		ignored := false
+		for _, scope := range tok.Scopes {
+			if isIgnoredScope(scope) {
+				ignored = true
+				break
+			}
+		}
+		if !ignored || tok.StartIndex >= tok.EndIndex {
+			continue
+		}
+		if n := len(ranges); n > 0 && ranges[n-1][1] == tok.StartIndex {
+			ranges[n-1][1] = tok.EndIndex
+		} else {
+			ranges = append(ranges, [2]int{tok.StartIndex, tok.EndIndex})
+		}
+	}
+	return ranges
+}
+
+// clikeState C 风格语言的词法状态
+type clikeState int
+
+const (
+	clikeNormal clikeState = iota
+	// clikeBlockComment 在 /* */ 注释中
+	clikeBlockComment
+	// clikeTemplate 在可以跨行的 ` 字符串中
+	clikeTemplate
+)
+
+// Clone 返回状态的副本
+func (s clikeState) Clone() tokenization.State {
+	return s
+}
+
+// Equals 判断两个状态是否相同
+func (s clikeState) Equals(other tokenization.State) bool {
+	o, ok := other.(clikeState)
+	return ok && o == s
+}
+
+// scope 返回可以跨行的状态 s 所在的注释或字符串的作用域
+func (s clikeState) scope() string {
+	if s == clikeTemplate {
+		return clikeTemplateScope
+	}
+	return clikeCommentScope
+}
+
+// C 风格语言的标记使用的作用域
+const (
+	clikeScope         = "source"
+	clikeCommentScope  = "comment"
+	clikeStringScope   = "string.quoted"
+	clikeTemplateScope = "string.template"
+)
+
+// clikeSpan 行内的一段注释或字符串
+type clikeSpan struct {
+	start, end int
+	scope      string
+}
+
+// CLikeTokenizer C 风格语言的词法分析器，识别 // 和 /* */ 注释、" 和 ' 字符串以及可以跨行的 ` 字符串
+// 标记的作用域为 source，注释和字符串再追加 comment、string.quoted 或 string.template
+type CLikeTokenizer struct{}
+
+// InitialState 返回第一行开始时的状态
+func (CLikeTokenizer) InitialState() tokenization.State {
+	return clikeNormal
+}
+
+// Tokenize 返回覆盖整行的标记和行末的状态
+func (CLikeTokenizer) Tokenize(line string, state tokenization.State) ([]tokenization.Token, tokenization.State) {
+	s, _ := state.(clikeState)
+	spans, end := clikeSpans(line, s)
+	var tokens []tokenization.Token
+	last := 0
+	for _, span := range spans {
+		if span.start > last {
+			tokens = append(tokens, tokenization.Token{StartIndex: last, EndIndex: span.start, Scopes: []string{clikeScope}})
+		}
+		tokens = append(tokens, tokenization.Token{StartIndex: span.start, EndIndex: span.end, Scopes: []string{clikeScope, span.scope}})
+		last = span.end
+	}
+	if last < len(line) || len(tokens) == 0 {
+		tokens = append(tokens, tokenization.Token{StartIndex: last, EndIndex: len(line), Scopes: []string{clikeScope}})
+	}
+	return tokens, end
+}
+
+// clikeSpans 从状态 s 开始分析一行，按顺序返回注释和字符串的范围以及行末的状态
+func clikeSpans(line string, s clikeState) ([]clikeSpan, clikeState) {
+	var spans []clikeSpan
+	start := 0
+	for i := 0; i < len(line); {
+		switch s {
+		case clikeBlockComment:
+			j := strings.Index(line[i:], "*/")
+			if j < 0 {
+				return append(spans, clikeSpan{start, len(line), s.scope()}), s
+			}
+			i += j + 2
+			spans = append(spans, clikeSpan{start, i, s.scope()})
+			s = clikeNormal
+		case clikeTemplate:
+			j := indexUnescaped(line, i, '`')
+			if j < 0 {
+				return append(spans, clikeSpan{start, len(line), s.scope()}), s
+			}
+			i = j + 1
+			spans = append(spans, clikeSpan{start, i, s.scope()})
+			s = clikeNormal
+		default:
+			c := line[i]
+			switch {
+			case c == '/' && strings.HasPrefix(line[i:], "//"):
+				return append(spans, clikeSpan{i, len(line), clikeCommentScope}), clikeNormal
+			case c == '/' && strings.HasPrefix(line[i:], "/*"):
+				start, i, s = i, i+2, clikeBlockComment
+			case c == '`':
+				start, i, s = i, i+1, clikeTemplate
+			case c == '"' || c == '\'':
+				// 普通字符串不能跨行，没有结束的引号时到行尾为止
+				j := indexUnescaped(line, i+1, c)
+				if j < 0 {
+					return append(spans, clikeSpan{i, len(line), clikeStringScope}), clikeNormal
+				}
+				spans = append(spans, clikeSpan{i, j + 1, clikeStringScope})
+				i = j + 1
+			default:
+				i++
+			}
+		}
+	}
+	if s != clikeNormal && start < len(line) {
+		spans = append(spans, clikeSpan{start, len(line), s.scope()})
+	}
+	return spans, s
+}
+
+// indexUnescaped 返回从 from 开始第一个没有被反斜杠转义的 quote 的位置，不存在时返回 -1
+func indexUnescaped(line string, from int, quote byte) int {
+	for i := from; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case quote:
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,8 @@
+package buffertest
+
+import "github.com/kebaren/textbuffer/pkg/buffer"
+
+// NewTree 用 text 创建片段树，见 buffer.NewPieceTree
+func NewTree(text string) *buffer.PieceTreeBase {
+	return buffer.NewPieceTree(text)
+}
@@ -0,0 +1,180 @@
+package buffer
+
+import (
+	"slices"
+
+	"github.com/kebaren/textbuffer/pkg/common"
+)
+
+// EditOperation 基于偏移量的编辑操作
+// 先删除 [Offset, Offset+Length) 范围内的内容，再在 Offset 处插入 Text
+type EditOperation struct {
+	// Offset 起始偏移量
+	Offset int
+	// Length 删除的长度
+	Length int
+	// Text 插入的文本
+	Text string
+}
+
+// NewEditOperation 创建一个新的编辑操作
+func NewEditOperation(offset, length int, text string) EditOperation {
+	return EditOperation{
+		Offset: offset,
+		Length: length,
+		Text:   text,
+	}
+}
+
+// IsNoop 判断编辑操作是否不会改变内容
+func (op EditOperation) IsNoop() bool {
+	return op.Length == 0 && len(op.Text) == 0
+}
+
+// ContentChange 对内容的一次修改
+type ContentChange struct {
+	// Offset 起始偏移量
+	Offset int
+	// DeletedLength 删除的长度
+	DeletedLength int
+	// InsertedLength 插入的长度
+	InsertedLength int
+	// Range 被替换的范围，使用修改前的位置
+	Range common.Range
+	// End 插入的文本结束的位置，使用修改后的位置
+	End common.Position
+}
+
+// StartLine 返回第一个被替换的行
+func (c ContentChange) StartLine() int {
+	return c.Range.StartLineNumber
+}
+
+// OldLineCount 返回修改前被替换的行数
+func (c ContentChange) OldLineCount() int {
+	return c.Range.EndLineNumber - c.Range.StartLineNumber + 1
+}
+
+// NewLineCount 返回修改后替换它们的行数
+func (c ContentChange) NewLineCount() int {
+	return c.End.LineNumber - c.Range.StartLineNumber + 1
+}
+
+// changeListener 内容修改监听器，用指针区分注册的同一个函数
+type changeListener struct {
+	fn func(ContentChange)
+}
+
+// AddChangeListener 注册内容修改监听器，返回取消注册的函数
+// 监听器在每次 Insert、Delete 以及基于它们的 ApplyEdit、ApplyEdits、InsertChecked、DeleteChecked 修改之后按注册顺序调用，
+// ApplyEdit 的删除和插入合并为一次修改；
+// 注意 SetEOL 和 NormalizeEOL 只替换换行符，行号和列不变，不会通知监听器
+func (t *PieceTreeBase) AddChangeListener(fn func(ContentChange)) (remove func()) {
+	l := &changeListener{fn: fn}
+	t.changeListeners = append(t.changeListeners, l)
+	return func() {
+		t.changeListeners = slices.DeleteFunc(t.changeListeners, func(other *changeListener) bool {
+			return other == l
+		})
+	}
+}
+
+// GetValueInOffsetRange 获取偏移量范围 [startOffset, endOffset) 内的值
+func (t *PieceTreeBase) GetValueInOffsetRange(startOffset, endOffset int) string {
+	if startOffset < 0 {
+		startOffset = 0
+	}
+	if endOffset > t.length {
+		endOffset = t.length
+	}
+	if startOffset >= endOffset {
+		return ""
+	}
+
+	return t.GetValueInRange2(t.NodeAt(startOffset), t.NodeAt(endOffset))
+}
+
+// ApplyEdit 应用单个编辑操作，返回其逆操作
+func (t *PieceTreeBase) ApplyEdit(op EditOperation) EditOperation {
+	if op.Offset < 0 {
+		op.Offset = 0
+	}
+	if op.Offset > t.length {
+		op.Offset = t.length
+	}
+	if op.Length < 0 {
+		op.Length = 0
+	}
+	if op.Offset+op.Length > t.length {
+		op.Length = t.length - op.Offset
+	}
+
+	if op.IsNoop() {
+		return op
+	}
+
+	deleted := ""
+	if op.Length > 0 {
+		deleted = t.GetValueInOffsetRange(op.Offset, op.Offset+op.Length)
+	}
+	t.replace(op.Offset, op.Length, op.Text, t.isEOLNormalizedText(op.Text))
+	return NewEditOperation(op.Offset, len(op.Text), deleted)
+}
+
+// replace 删除 [offset, offset+length) 范围内的内容并在 offset 处插入 value，然后通知监听器
+// 调用者保证范围在文档内
+func (t *PieceTreeBase) replace(offset, length int, value string, eolNormalized bool) {
+	var start, end *common.Position
+	if len(t.changeListeners) > 0 {
+		start, end = t.GetPositionAt(offset), t.GetPositionAt(offset+length)
+	}
+
+	if length > 0 {
+		t.delete(offset, length)
+	}
+	if len(value) > 0 {
+		t.insert(offset, value, eolNormalized)
+	}
+
+	if len(t.changeListeners) > 0 {
+		change := ContentChange{
+			Offset:         offset,
+			DeletedLength:  length,
+			InsertedLength: len(value),
+			Range:          *common.NewRange(start.LineNumber, start.Column, end.LineNumber, end.Column),
+			End:            *t.GetPositionAt(offset + len(value)),
+		}
+		// 监听器可能在回调中取消注册
+		for _, l := range slices.Clone(t.changeListeners) {
+			l.fn(change)
+		}
+	}
+}
+
+// ApplyEdits 依次应用一组编辑操作
+// 每个操作的偏移量都基于前一个操作完成后的内容，返回的逆操作按撤销顺序排列
+func (t *PieceTreeBase) ApplyEdits(ops []EditOperation) []EditOperation {
+	inverse := make([]EditOperation, len(ops))
+	for i, op := range ops {
+		inverse[len(ops)-1-i] = t.ApplyEdit(op)
+	}
+	return inverse
+}
+
+// isEOLNormalizedText 判断文本中的换行符是否都与当前换行符一致
+func (t *PieceTreeBase) isEOLNormalizedText(text string) bool {
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\r':
+			if t.EOL != "\r\n" || i+1 >= len(text) || text[i+1] != '\n' {
+				return false
+			}
+			i++
+		case '\n':
+			if t.EOL != "\n" {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,204 @@
+package buffer
+
+import (
+	"iter"
+	"strings"
+
+	"github.com/kebaren/textbuffer/pkg/common"
+)
+
+// FindNextMatch 查找位置之后（包括位置）开始的第一个匹配，到达文档末尾时从头开始
+// 没有匹配时返回 nil
+func (t *PieceTreeBase) FindNextMatch(query string, from common.Position, opts SearchOptions) (*FindMatch, error) {
+	if query == "" {
+		return nil, nil
+	}
+	s, err := newSearcher(query, opts)
+	if err != nil {
+		return nil, err
+	}
+	from = t.ValidatePosition(from)
+
+	if s.multiline {
+		offset := t.GetOffsetAt(from.LineNumber, from.Column)
+		text := t.GetLinesRawContent()
+		var first, next []int
+		s.findInText(text, func(loc []int) bool {
+			if first == nil {
+				first = loc
+			}
+			if loc[0] >= offset {
+				next = loc
+				return false
+			}
+			return true
+		})
+		if next == nil {
+			next = first
+		}
+		return t.multilineMatch(s, text, next), nil
+	}
+
+	lineCount := t.GetLineCount()
+	for lineNumber, line := range t.Lines(from.LineNumber, lineCount) {
+		minStart := 0
+		if lineNumber == from.LineNumber {
+			minStart = from.Column - 1
+		}
+		if loc := s.firstInLine(line, minStart); loc != nil {
+			return t.lineMatch(s, lineNumber, line, loc), nil
+		}
+	}
+	// 从头开始，起始行中位置之后的匹配在上面已经找过了
+	for lineNumber, line := range t.Lines(1, from.LineNumber) {
+		if loc := s.firstInLine(line, 0); loc != nil {
+			return t.lineMatch(s, lineNumber, line, loc), nil
+		}
+	}
+	return nil, nil
+}
+
+// FindPreviousMatch 查找位置之前（包括位置）结束的最后一个匹配，到达文档开头时从末尾开始
+// 不跨行的查询通过 TreeNode.Prev 倒序遍历片段逐行向前搜索，不需要从头扫描文档；没有匹配时返回 nil
+func (t *PieceTreeBase) FindPreviousMatch(query string, from common.Position, opts SearchOptions) (*FindMatch, error) {
+	if query == "" {
+		return nil, nil
+	}
+	s, err := newSearcher(query, opts)
+	if err != nil {
+		return nil, err
+	}
+	from = t.ValidatePosition(from)
+
+	if s.multiline {
+		// 正则表达式不能倒序匹配，跨行查询只能正向扫描到位置为止
+		offset := t.GetOffsetAt(from.LineNumber, from.Column)
+		text := t.GetLinesRawContent()
+		var last, prev []int
+		s.findInText(text, func(loc []int) bool {
+			if loc[1] <= offset {
+				prev = loc
+			}
+			last = loc
+			return true
+		})
+		if prev == nil {
+			prev = last
+		}
+		return t.multilineMatch(s, text, prev), nil
+	}
+
+	lineEnd := t.GetOffsetAt(from.LineNumber, len(t.GetLineContent(from.LineNumber))+1)
+	lineNumber := from.LineNumber
+	for _, line := range t.linesBefore(lineEnd) {
+		maxEnd := len(line)
+		if lineNumber == from.LineNumber {
+			maxEnd = from.Column - 1
+		}
+		if loc := s.lastInLine(line, maxEnd); loc != nil {
+			return t.lineMatch(s, lineNumber, line, loc), nil
+		}
+		lineNumber--
+	}
+	// 从末尾开始，起始行中位置之前的匹配在上面已经找过了
+	lineNumber = t.GetLineCount()
+	for _, line := range t.linesBefore(t.length) {
+		if lineNumber < from.LineNumber {
+			break
+		}
+		if loc := s.lastInLine(line, len(line)); loc != nil {
+			return t.lineMatch(s, lineNumber, line, loc), nil
+		}
+		lineNumber--
+	}
+	return nil, nil
+}
+
+// firstInLine 返回行中第一个开始位置不小于 minStart 的匹配
+func (s *searcher) firstInLine(line string, minStart int) []int {
+	var result []int
+	s.findInText(line, func(loc []int) bool {
+		if loc[0] >= minStart {
+			result = loc
+			return false
+		}
+		return true
+	})
+	return result
+}
+
+// lastInLine 返回行中最后一个结束位置不大于 maxEnd 的匹配
+func (s *searcher) lastInLine(line string, maxEnd int) []int {
+	var result []int
+	s.findInText(line, func(loc []int) bool {
+		if loc[1] > maxEnd {
+			return false
+		}
+		result = loc
+		return true
+	})
+	return result
+}
+
+// lineMatch 根据行内的匹配位置创建搜索结果
+func (t *PieceTreeBase) lineMatch(s *searcher, lineNumber int, line string, loc []int) *FindMatch {
+	lineOffset := t.GetOffsetAt(lineNumber, 1)
+	match := &FindMatch{
+		StartOffset: lineOffset + loc[0],
+		EndOffset:   lineOffset + loc[1],
+		Range:       common.NewRange(lineNumber, loc[0]+1, lineNumber, loc[1]+1),
+	}
+	if s.opts.CaptureMatches {
+		match.Matches = captures(line, loc)
+	}
+	return match
+}
+
+// multilineMatch 根据全文中的匹配位置创建搜索结果，loc 为 nil 时返回 nil
+func (t *PieceTreeBase) multilineMatch(s *searcher, text string, loc []int) *FindMatch {
+	if loc == nil {
+		return nil
+	}
+	start := t.GetPositionAt(loc[0])
+	end := t.GetPositionAt(loc[1])
+	match := &FindMatch{
+		StartOffset: loc[0],
+		EndOffset:   loc[1],
+		Range:       common.NewRange(start.LineNumber, start.Column, end.LineNumber, end.Column),
+	}
+	if s.opts.CaptureMatches {
+		match.Matches = captures(text, loc)
+	}
+	return match
+}
+
+// linesBefore 从 offset 所在的行开始倒序返回每一行的开始偏移量和内容（不包括换行符）
+// offset 应该位于行尾（换行符之前），第一行的内容截止到 offset；通过 TreeNode.Prev 倒序遍历片段
+func (t *PieceTreeBase) linesBefore(offset int) iter.Seq2[int, string] {
+	return func(yield func(int, string) bool) {
+		// tail 当前行已经读到的内容，afterLF 上一个换行符是否为 \n，此时紧挨着的 \r 属于同一个换行符
+		tail, afterLF := "", false
+		for start, s := range t.chunksBefore(offset) {
+			for len(s) > 0 {
+				if afterLF {
+					afterLF = false
+					if s[len(s)-1] == '\r' {
+						s = s[:len(s)-1]
+						continue
+					}
+				}
+				i := strings.LastIndexAny(s, "\r\n")
+				if i < 0 {
+					tail = s + tail
+					break
+				}
+				if !yield(start+i+1, s[i+1:]+tail) {
+					return
+				}
+				tail, afterLF = "", s[i] == '\n'
+				s = s[:i]
+			}
+		}
+		yield(0, tail)
+	}
+}
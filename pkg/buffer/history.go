@@ -0,0 +1,95 @@
+package buffer
+
+// History 基于编辑操作逆操作的撤销/重做栈
+// 每次 Apply 记录为一个批次，撤销和重做都以批次为单位
+type History struct {
+	// tree 被编辑的片段树
+	tree *PieceTreeBase
+	// undoStack 撤销栈，每个元素是一个批次的逆操作
+	undoStack [][]EditOperation
+	// redoStack 重做栈
+	redoStack [][]EditOperation
+	// limit 最多保留的批次数量，小于等于 0 时不限制
+	limit int
+}
+
+// NewHistory 创建一个新的撤销/重做栈，limit 小于等于 0 时不限制批次数量
+func NewHistory(tree *PieceTreeBase, limit int) *History {
+	return &History{
+		tree:  tree,
+		limit: limit,
+	}
+}
+
+// Tree 返回被编辑的片段树
+func (h *History) Tree() *PieceTreeBase {
+	return h.tree
+}
+
+// Apply 应用一组编辑操作并记录为一个批次，返回逆操作
+// 新的编辑会清空重做栈
+func (h *History) Apply(ops []EditOperation) []EditOperation {
+	inverse := h.tree.ApplyEdits(ops)
+	h.Push(inverse)
+	return inverse
+}
+
+// Push 记录一个已经应用到片段树上的批次的逆操作
+func (h *History) Push(inverse []EditOperation) {
+	h.redoStack = nil
+	if isNoopBatch(inverse) {
+		return
+	}
+	h.undoStack = append(h.undoStack, inverse)
+	if h.limit > 0 && len(h.undoStack) > h.limit {
+		h.undoStack = h.undoStack[len(h.undoStack)-h.limit:]
+	}
+}
+
+// CanUndo 是否可以撤销
+func (h *History) CanUndo() bool {
+	return len(h.undoStack) > 0
+}
+
+// CanRedo 是否可以重做
+func (h *History) CanRedo() bool {
+	return len(h.redoStack) > 0
+}
+
+// Undo 撤销最近的批次，返回实际应用的编辑操作，没有可撤销的批次时返回 nil
+func (h *History) Undo() []EditOperation {
+	if !h.CanUndo() {
+		return nil
+	}
+	ops := h.undoStack[len(h.undoStack)-1]
+	h.undoStack = h.undoStack[:len(h.undoStack)-1]
+	h.redoStack = append(h.redoStack, h.tree.ApplyEdits(ops))
+	return ops
+}
+
+// Redo 重做最近撤销的批次，返回实际应用的编辑操作，没有可重做的批次时返回 nil
+func (h *History) Redo() []EditOperation {
+	if !h.CanRedo() {
+		return nil
+	}
+	ops := h.redoStack[len(h.redoStack)-1]
+	h.redoStack = h.redoStack[:len(h.redoStack)-1]
+	h.undoStack = append(h.undoStack, h.tree.ApplyEdits(ops))
+	return ops
+}
+
+// Clear 清空撤销和重做栈
+func (h *History) Clear() {
+	h.undoStack = nil
+	h.redoStack = nil
+}
+
+// isNoopBatch 判断批次中的操作是否都不会改变内容
+func isNoopBatch(ops []EditOperation) bool {
+	for _, op := range ops {
+		if !op.IsNoop() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,250 @@
+package buffer
+
+import "strings"
+
+// guessIndentationLineLimit 猜测缩进时最多检查的行数
+const guessIndentationLineLimit = 10000
+
+// IndentationOptions 缩进设置
+type IndentationOptions struct {
+	// TabSize 制表符的宽度
+	TabSize int
+	// IndentSize 一级缩进的宽度，为 0 时与 TabSize 相同
+	IndentSize int
+	// InsertSpaces 是否用空格缩进
+	InsertSpaces bool
+}
+
+// indentSize 返回一级缩进的宽度
+func (o IndentationOptions) indentSize() int {
+	if o.IndentSize > 0 {
+		return o.IndentSize
+	}
+	return o.tabSize()
+}
+
+// tabSize 返回制表符的宽度，无效时为 4
+func (o IndentationOptions) tabSize() int {
+	if o.TabSize > 0 {
+		return o.TabSize
+	}
+	return 4
+}
+
+// spacesDiffResult 相邻两行缩进差异的计算结果
+type spacesDiffResult struct {
+	spacesDiff         int
+	looksLikeAlignment bool
+}
+
+// spacesDiff 计算两行缩进之间相差的空格数，a、b 是两行的内容，aLength、bLength 是缩进的长度
+func spacesDiff(a string, aLength int, b string, bLength int, result *spacesDiffResult) {
+	result.spacesDiff = 0
+	result.looksLikeAlignment = false
+
+	// 跳过相同的前缀，比较剩余部分中的空格和制表符
+	// 例如 "\t" 和 "\t    " 相差 4 个空格
+	i := 0
+	for i < aLength && i < bLength && a[i] == b[i] {
+		i++
+	}
+
+	aSpaces, aTabs := 0, 0
+	for j := i; j < aLength; j++ {
+		if a[j] == ' ' {
+			aSpaces++
+		} else {
+			aTabs++
+		}
+	}
+	bSpaces, bTabs := 0, 0
+	for j := i; j < bLength; j++ {
+		if b[j] == ' ' {
+			bSpaces++
+		} else {
+			bTabs++
+		}
+	}
+	if (aSpaces > 0 && aTabs > 0) || (bSpaces > 0 && bTabs > 0) {
+		return
+	}
+
+	tabsDiff := aTabs - bTabs
+	if tabsDiff < 0 {
+		tabsDiff = -tabsDiff
+	}
+	diff := aSpaces - bSpaces
+	if diff < 0 {
+		diff = -diff
+	}
+
+	if tabsDiff == 0 {
+		result.spacesDiff = diff
+		// 缩进的差异可能是为了对齐，例如：
+		// const a = b + c,
+		//       d = b + c;
+		if diff > 0 && bSpaces-1 >= 0 && bSpaces-1 < len(a) && bSpaces < len(b) &&
+			b[bSpaces] != ' ' && a[bSpaces-1] == ' ' && strings.HasSuffix(a, ",") {
+			result.looksLikeAlignment = true
+		}
+		return
+	}
+	if diff%tabsDiff == 0 {
+		result.spacesDiff = diff / tabsDiff
+	}
+}
+
+// GuessIndentation 根据文档开头最多 10000 行推断使用制表符还是空格缩进以及缩进宽度
+// 无法判断时使用给定的默认值
+func (t *PieceTreeBase) GuessIndentation(defaultTabSize int, defaultInsertSpaces bool) IndentationOptions {
+	linesCount := t.GetLineCount()
+	if linesCount > guessIndentationLineLimit {
+		linesCount = guessIndentationLineLimit
+	}
+
+	linesWithTabs, linesWithSpaces := 0, 0
+	previousLine, previousIndentation := "", 0
+	// spacesDiffCount[n] 相邻两行缩进相差 n 个空格的次数
+	var spacesDiffCount [9]int
+	var tmp spacesDiffResult
+
+	for lineNumber := 1; lineNumber <= linesCount; lineNumber++ {
+		line := t.GetLineContent(lineNumber)
+		hasContent, indentation, spaces, tabs := false, 0, 0, 0
+		for j := 0; j < len(line); j++ {
+			if line[j] == '\t' {
+				tabs++
+			} else if line[j] == ' ' {
+				spaces++
+			} else {
+				hasContent = true
+				indentation = j
+				break
+			}
+		}
+		// 忽略空行和只有空白的行
+		if !hasContent {
+			continue
+		}
+		if tabs > 0 {
+			linesWithTabs++
+		} else if spaces > 1 {
+			linesWithSpaces++
+		}
+
+		spacesDiff(previousLine, previousIndentation, line, indentation, &tmp)
+		// 看起来是对齐的行不作为依据，除非差异正好是默认的缩进宽度，例如 Markdown 列表
+		if tmp.looksLikeAlignment && !(defaultInsertSpaces && defaultTabSize == tmp.spacesDiff) {
+			continue
+		}
+		if tmp.spacesDiff < len(spacesDiffCount) {
+			spacesDiffCount[tmp.spacesDiff]++
+		}
+		previousLine, previousIndentation = line, indentation
+	}
+
+	insertSpaces := defaultInsertSpaces
+	if linesWithTabs != linesWithSpaces {
+		insertSpaces = linesWithTabs < linesWithSpaces
+	}
+
+	tabSize := defaultTabSize
+	// 只有用空格缩进时才推断宽度
+	if insertSpaces {
+		score := 0
+		for _, size := range []int{2, 4, 6, 8, 3, 5, 7} {
+			if spacesDiffCount[size] > score {
+				score = spacesDiffCount[size]
+				tabSize = size
+			}
+		}
+		// 推断为 4 但 2 的次数也不少时选择 2
+		if tabSize == 4 && spacesDiffCount[4] > 0 && spacesDiffCount[2] > 0 && spacesDiffCount[2] >= spacesDiffCount[4]/2 {
+			tabSize = 2
+		}
+	}
+
+	return IndentationOptions{TabSize: tabSize, IndentSize: tabSize, InsertSpaces: insertSpaces}
+}
+
+// leadingWhitespace 返回行首空白的长度和可见宽度
+func leadingWhitespace(line string, tabSize int) (length, visible int) {
+	for length < len(line) {
+		switch line[length] {
+		case ' ':
+			visible++
+		case '\t':
+			visible += tabSize - visible%tabSize
+		default:
+			return length, visible
+		}
+		length++
+	}
+	return length, visible
+}
+
+// buildIndentation 生成可见宽度为 visible 的缩进
+func buildIndentation(visible int, opts IndentationOptions) string {
+	if opts.InsertSpaces {
+		return strings.Repeat(" ", visible)
+	}
+	tabSize := opts.tabSize()
+	return strings.Repeat("\t", visible/tabSize) + strings.Repeat(" ", visible%tabSize)
+}
+
+// reindentLines 对 [startLine, endLine] 中的每一行用 indent 计算新的缩进宽度，作为一批编辑应用
+// indent 返回 -1 表示不修改该行，返回按撤销顺序排列的逆操作
+func (t *PieceTreeBase) reindentLines(startLine, endLine int, opts IndentationOptions, indent func(line string, visible int) int) []EditOperation {
+	if startLine < 1 {
+		startLine = 1
+	}
+	if lineCount := t.GetLineCount(); endLine > lineCount {
+		endLine = lineCount
+	}
+
+	var ops []EditOperation
+	for lineNumber := endLine; lineNumber >= startLine; lineNumber-- {
+		line := t.GetLineContent(lineNumber)
+		length, visible := leadingWhitespace(line, opts.tabSize())
+		target := indent(line, visible)
+		if target < 0 {
+			continue
+		}
+		if text := buildIndentation(target, opts); text != line[:length] {
+			ops = append(ops, NewEditOperation(t.GetOffsetAt(lineNumber, 1), length, text))
+		}
+	}
+	return t.ApplyEdits(ops)
+}
+
+// Indent 把 [startLine, endLine] 中的每一行缩进到下一个缩进位置，跳过空行
+// 行首的空白按照 opts 重新生成，返回按撤销顺序排列的逆操作
+func (t *PieceTreeBase) Indent(startLine, endLine int, opts IndentationOptions) []EditOperation {
+	size := opts.indentSize()
+	return t.reindentLines(startLine, endLine, opts, func(line string, visible int) int {
+		if line == "" {
+			return -1
+		}
+		return (visible/size + 1) * size
+	})
+}
+
+// Outdent 把 [startLine, endLine] 中的每一行减少到上一个缩进位置，没有缩进的行不变
+// 返回按撤销顺序排列的逆操作
+func (t *PieceTreeBase) Outdent(startLine, endLine int, opts IndentationOptions) []EditOperation {
+	size := opts.indentSize()
+	return t.reindentLines(startLine, endLine, opts, func(line string, visible int) int {
+		if visible == 0 {
+			return -1
+		}
+		return ((visible+size-1)/size - 1) * size
+	})
+}
+
+// ConvertIndentation 按照 opts.InsertSpaces 把所有行的缩进转换为空格或制表符，保持可见宽度不变
+// 返回按撤销顺序排列的逆操作
+func (t *PieceTreeBase) ConvertIndentation(opts IndentationOptions) []EditOperation {
+	return t.reindentLines(1, t.GetLineCount(), opts, func(line string, visible int) int {
+		return visible
+	})
+}
@@ -0,0 +1,175 @@
+package buffer
+
+import (
+	"iter"
+	"unicode/utf8"
+)
+
+// 基于 iter.Seq 的迭代器
+// 迭代器在每次迭代时读取片段树的当前内容，迭代过程中修改片段树的结果是未定义的
+
+// Lines 按顺序返回 [from, to] 范围内的行号和行内容（不包括换行符），范围被限制到文档内
+func (t *PieceTreeBase) Lines(from, to int) iter.Seq2[int, string] {
+	return func(yield func(int, string) bool) {
+		from, to := max(from, 1), min(to, t.GetLineCount())
+		for line := from; line <= to; line++ {
+			if !yield(line, t.GetLineContent(line)) {
+				return
+			}
+		}
+	}
+}
+
+// LinesReverse 从 to 到 from 倒序返回 [from, to] 范围内的行号和行内容，范围被限制到文档内
+func (t *PieceTreeBase) LinesReverse(from, to int) iter.Seq2[int, string] {
+	return func(yield func(int, string) bool) {
+		from, to := max(from, 1), min(to, t.GetLineCount())
+		for line := to; line >= from; line-- {
+			if !yield(line, t.GetLineContent(line)) {
+				return
+			}
+		}
+	}
+}
+
+// Pieces 按顺序返回每个片段的内容，返回的字符串直接引用缓冲区，不会复制
+func (t *PieceTreeBase) Pieces() iter.Seq[string] {
+	return func(yield func(string) bool) {
+		for _, content := range t.chunksFrom(0) {
+			if !yield(content) {
+				return
+			}
+		}
+	}
+}
+
+// chunksFrom 按顺序返回从偏移量 offset 开始的各个片段的内容及其开始偏移量，跳过空片段
+func (t *PieceTreeBase) chunksFrom(offset int) iter.Seq2[int, string] {
+	return func(yield func(int, string) bool) {
+		offset = max(offset, 0)
+		if offset >= t.length {
+			return
+		}
+		pos := t.NodeAt(offset)
+		start := pos.NodeStartOffset + pos.Remainder
+		content := t.GetNodeContent(pos.Node)[pos.Remainder:]
+		for node := pos.Node; node != SENTINEL; node = node.Next() {
+			if node != pos.Node {
+				content = t.GetNodeContent(node)
+			}
+			if len(content) > 0 && !yield(start, content) {
+				return
+			}
+			start += len(content)
+		}
+	}
+}
+
+// chunksBefore 倒序返回偏移量 offset 之前的各个片段的内容及其开始偏移量，跳过空片段
+func (t *PieceTreeBase) chunksBefore(offset int) iter.Seq2[int, string] {
+	return func(yield func(int, string) bool) {
+		offset = min(offset, t.length)
+		if offset <= 0 {
+			return
+		}
+		// offset 位于片段边界时，NodeAt 可能返回前一个片段（Remainder 等于片段长度）或后一个片段（Remainder 为 0），
+		// 两种情况下片段中 Remainder 之前的内容都正好在 offset 之前
+		pos := t.NodeAt(offset)
+		start := pos.NodeStartOffset
+		content := t.GetNodeContent(pos.Node)[:pos.Remainder]
+		for node := pos.Node; node != SENTINEL; node = node.Prev() {
+			if node != pos.Node {
+				content = t.GetNodeContent(node)
+				start -= len(content)
+			}
+			if len(content) > 0 && !yield(start, content) {
+				return
+			}
+		}
+	}
+}
+
+// Runes 从偏移量 offset 开始按顺序返回每个字符的偏移量和字符
+// 跨越片段边界的多字节字符被正确拼接，不合法的字节作为 utf8.RuneError 逐个返回
+func (t *PieceTreeBase) Runes(offset int) iter.Seq2[int, rune] {
+	return func(yield func(int, rune) bool) {
+		// carry 上一个片段末尾不完整的字符
+		carry, carryStart := "", 0
+		for start, s := range t.chunksFrom(offset) {
+			if carry != "" {
+				s, start, carry = carry+s, carryStart, ""
+			}
+			for i := 0; i < len(s); {
+				if !utf8.FullRuneInString(s[i:]) {
+					carry, carryStart = s[i:], start+i
+					break
+				}
+				r, size := utf8.DecodeRuneInString(s[i:])
+				if !yield(start+i, r) {
+					return
+				}
+				i += size
+			}
+		}
+		for i := 0; i < len(carry); i++ {
+			if !yield(carryStart+i, utf8.RuneError) {
+				return
+			}
+		}
+	}
+}
+
+// RunesReverse 从偏移量 offset 之前的字符开始倒序返回每个字符的偏移量和字符
+// 跨越片段边界的多字节字符被正确拼接，不合法的字节作为 utf8.RuneError 逐个返回
+func (t *PieceTreeBase) RunesReverse(offset int) iter.Seq2[int, rune] {
+	return func(yield func(int, rune) bool) {
+		// carry 后一个片段开头的后续字节，需要与这个片段末尾的字节拼接
+		carry, carryStart := "", 0
+		for start, s := range t.chunksBefore(offset) {
+			if carry != "" {
+				s, carry = s+carry, ""
+			}
+			for i := len(s); i > 0; {
+				if i < utf8.UTFMax && isContinuation(s[:i]) {
+					carry, carryStart = s[:i], start
+					break
+				}
+				r, size := utf8.DecodeLastRuneInString(s[:i])
+				i -= size
+				if !yield(start+i, r) {
+					return
+				}
+			}
+		}
+		for i := len(carry) - 1; i >= 0; i-- {
+			if !yield(carryStart+i, utf8.RuneError) {
+				return
+			}
+		}
+	}
+}
+
+// isContinuation 判断字符串是否全部由 UTF-8 后续字节组成
+func isContinuation(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if utf8.RuneStart(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches 按顺序返回文档中的匹配，查询不合法时返回错误
+// 与 FindMatches 相同，不跨行的查询逐行搜索，只在迭代时才查找
+func (t *PieceTreeBase) Matches(query string, opts SearchOptions) (iter.Seq[FindMatch], error) {
+	if query == "" {
+		return func(func(FindMatch) bool) {}, nil
+	}
+	s, err := newSearcher(query, opts)
+	if err != nil {
+		return nil, err
+	}
+	return func(yield func(FindMatch) bool) {
+		t.eachMatch(s, opts.CaptureMatches, yield)
+	}, nil
+}
@@ -28,11 +28,10 @@ func NewLineStarts() *LineStarts {
 // CreateLineStartsFast 快速创建行起始位置
 func CreateLineStartsFast(text string, isBasicASCII bool) []int {
 	result := []int{0}
-	ustr := []rune(text)
-	for i := 0; i < len(ustr); i++ {
-		ch := ustr[i]
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
 		if ch == '\r' {
-			if i+1 < len(ustr) && ustr[i+1] == '\n' {
+			if i+1 < len(text) && text[i+1] == '\n' {
 				i++
 			}
 			result = append(result, i+1)
@@ -47,17 +46,16 @@ func CreateLineStartsFast(text string, isBasicASCII bool) []int {
 func CreateLineStarts(text string) *LineStarts {
 	result := NewLineStarts()
 	result.IsBasicASCII = true
-	ustr := []rune(text)
-	for i, length := 0, len(ustr); i < length; i++ {
-		ch := ustr[i]
+	for i, length := 0, len(text); i < length; i++ {
+		ch := text[i]
 
-		if !IsBasicASCII(ch) {
+		if ch >= 0x80 {
 			result.IsBasicASCII = false
 		}
 
 		if ch == '\r' {
 			result.CRCount++
-			if i+1 < length && ustr[i+1] == '\n' {
+			if i+1 < length && text[i+1] == '\n' {
 				result.CRLFCount++
 				i++
 			}
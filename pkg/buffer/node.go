@@ -232,8 +232,8 @@ func LeftRotate(tree *PieceTreeBase, x *TreeNode) {
 	y.Left = x
 	x.Parent = y
 
-	y.SizeLeft += x.SizeLeft
-	y.LFLeft += x.LFLeft
+	y.SizeLeft += x.SizeLeft + x.Piece.Length
+	y.LFLeft += x.LFLeft + x.Piece.LineFeedCnt
 }
 
 // RightRotate 右旋转
@@ -258,8 +258,8 @@ func RightRotate(tree *PieceTreeBase, y *TreeNode) {
 	x.Right = y
 	y.Parent = x
 
-	y.SizeLeft -= x.SizeLeft
-	y.LFLeft -= x.LFLeft
+	y.SizeLeft -= x.SizeLeft + x.Piece.Length
+	y.LFLeft -= x.LFLeft + x.Piece.LineFeedCnt
 }
 
 // Detach 分离节点
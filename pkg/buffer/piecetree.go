@@ -32,6 +32,8 @@ type PieceTreeBase struct {
 		LineNumber int
 		Value      string
 	}
+	// changeListeners 每次修改内容后按注册顺序调用的监听器
+	changeListeners []*changeListener
 }
 
 // NewPieceTreeBase 创建一个新的片段树基础结构
@@ -239,7 +241,7 @@ func (t *PieceTreeBase) NodeAt(offset int) NodePosition {
 			t.searchCache.Set(CacheEntry{
 				Node:                ret.Node,
 				NodeStartOffset:     ret.NodeStartOffset,
-				NodeStartLineNumber: ret.Remainder,
+				NodeStartLineNumber: 0,
 			})
 			return ret
 		} else {
@@ -661,39 +663,43 @@ func (t *PieceTreeBase) GetIndexOf(node *TreeNode, accumulatedValue int) struct
 
 // GetPositionAt 获取指定偏移量的位置
 func (t *PieceTreeBase) GetPositionAt(offset int) *common.Position {
-	if offset > t.length {
-		return common.NewPosition(t.lineCnt, 1)
+	if offset < 0 {
+		offset = 0
 	}
 
-	pos := t.NodeAt(offset)
-	if pos.Node == nil {
-		return common.NewPosition(1, 1)
-	}
+	x := t.Root
+	lfCnt := 0
+	originalOffset := offset
 
-	remainder := pos.Remainder
-	node := pos.Node
+	for x != SENTINEL {
+		if x.SizeLeft != 0 && x.SizeLeft >= offset {
+			x = x.Left
+		} else if x.SizeLeft+x.Piece.Length >= offset {
+			out := t.GetIndexOf(x, offset-x.SizeLeft)
+			lfCnt += x.LFLeft + out.Index
+
+			if out.Index == 0 {
+				lineStartOffset := t.GetOffsetAt(lfCnt+1, 1)
+				column := originalOffset - lineStartOffset
+				return common.NewPosition(lfCnt+1, column+1)
+			}
 
-	var out struct {
-		Index     int
-		Remainder int
-	}
+			return common.NewPosition(lfCnt+1, out.Remainder+1)
+		} else {
+			offset -= x.SizeLeft + x.Piece.Length
+			lfCnt += x.LFLeft + x.Piece.LineFeedCnt
 
-	if remainder == 0 {
-		out = struct {
-			Index     int
-			Remainder int
-		}{
-			Index:     0,
-			Remainder: 0,
+			if x.Right == SENTINEL {
+				// 最后一个节点
+				lineStartOffset := t.GetOffsetAt(lfCnt+1, 1)
+				column := originalOffset - offset - lineStartOffset
+				return common.NewPosition(lfCnt+1, column+1)
+			}
+			x = x.Right
 		}
-	} else {
-		out = t.GetIndexOf(node, remainder)
 	}
 
-	lineNumber := node.LFLeft + out.Index + 1
-	column := out.Remainder + 1
-
-	return common.NewPosition(lineNumber, column)
+	return common.NewPosition(1, 1)
 }
 
 // GetContentOfSubTree 获取子树的内容
@@ -718,57 +724,51 @@ func (t *PieceTreeBase) GetLineRawContent(lineNumber int, endOffset int) string
 	var ret string
 
 	// Try to use search cache
-	if t.searchCache != nil {
-		cache := t.searchCache.Get2(lineNumber)
-		if cache != nil {
-			x = cache.Node
-			prevAccumulatedValue := t.GetAccumulatedValue(x, lineNumber-cache.NodeStartLineNumber-2)
-			buffer := t.buffers[x.Piece.BufferIndex].Buffer
-			startOffset := t.OffsetInBuffer(x.Piece.BufferIndex, x.Piece.Start)
+	if cache := t.searchCache.Get2(lineNumber); cache != nil {
+		x = cache.Node
+		prevAccumulatedValue := t.GetAccumulatedValue(x, lineNumber-cache.NodeStartLineNumber-1)
+		buffer := t.buffers[x.Piece.BufferIndex].Buffer
+		startOffset := t.OffsetInBuffer(x.Piece.BufferIndex, x.Piece.Start)
 
-			if cache.NodeStartLineNumber+x.Piece.LineFeedCnt == lineNumber {
-				ret = buffer[startOffset+prevAccumulatedValue : startOffset+x.Piece.Length]
-			} else {
-				accumulatedValue := t.GetAccumulatedValue(x, lineNumber-cache.NodeStartLineNumber-1)
-				return buffer[startOffset+prevAccumulatedValue : startOffset+accumulatedValue-endOffset]
-			}
+		if cache.NodeStartLineNumber+x.Piece.LineFeedCnt != lineNumber {
+			accumulatedValue := t.GetAccumulatedValue(x, lineNumber-cache.NodeStartLineNumber)
+			return buffer[startOffset+prevAccumulatedValue : startOffset+accumulatedValue-endOffset]
 		}
-	}
-
-	nodeStartOffset := 0
-	originalLineNumber := lineNumber
-
-	// Search in tree
-	for x != SENTINEL {
-		if x.Left != SENTINEL && x.LFLeft >= lineNumber-1 {
-			x = x.Left
-		} else if x.LFLeft+x.Piece.LineFeedCnt > lineNumber-1 {
-			prevAccumulatedValue := t.GetAccumulatedValue(x, lineNumber-x.LFLeft-2)
-			accumulatedValue := t.GetAccumulatedValue(x, lineNumber-x.LFLeft-1)
-			buffer := t.buffers[x.Piece.BufferIndex].Buffer
-			startOffset := t.OffsetInBuffer(x.Piece.BufferIndex, x.Piece.Start)
-			nodeStartOffset += x.SizeLeft
+		ret = buffer[startOffset+prevAccumulatedValue : startOffset+x.Piece.Length]
+	} else {
+		nodeStartOffset := 0
+		originalLineNumber := lineNumber
+
+		// Search in tree
+		for x != SENTINEL {
+			if x.Left != SENTINEL && x.LFLeft >= lineNumber-1 {
+				x = x.Left
+			} else if x.LFLeft+x.Piece.LineFeedCnt > lineNumber-1 {
+				prevAccumulatedValue := t.GetAccumulatedValue(x, lineNumber-x.LFLeft-2)
+				accumulatedValue := t.GetAccumulatedValue(x, lineNumber-x.LFLeft-1)
+				buffer := t.buffers[x.Piece.BufferIndex].Buffer
+				startOffset := t.OffsetInBuffer(x.Piece.BufferIndex, x.Piece.Start)
+				nodeStartOffset += x.SizeLeft
 
-			if t.searchCache != nil {
 				t.searchCache.Set(CacheEntry{
 					Node:                x,
 					NodeStartOffset:     nodeStartOffset,
 					NodeStartLineNumber: originalLineNumber - (lineNumber - 1 - x.LFLeft),
 				})
-			}
 
-			return buffer[startOffset+prevAccumulatedValue : startOffset+accumulatedValue-endOffset]
-		} else if x.LFLeft+x.Piece.LineFeedCnt == lineNumber-1 {
-			prevAccumulatedValue := t.GetAccumulatedValue(x, lineNumber-x.LFLeft-2)
-			buffer := t.buffers[x.Piece.BufferIndex].Buffer
-			startOffset := t.OffsetInBuffer(x.Piece.BufferIndex, x.Piece.Start)
+				return buffer[startOffset+prevAccumulatedValue : startOffset+accumulatedValue-endOffset]
+			} else if x.LFLeft+x.Piece.LineFeedCnt == lineNumber-1 {
+				prevAccumulatedValue := t.GetAccumulatedValue(x, lineNumber-x.LFLeft-2)
+				buffer := t.buffers[x.Piece.BufferIndex].Buffer
+				startOffset := t.OffsetInBuffer(x.Piece.BufferIndex, x.Piece.Start)
 
-			ret = buffer[startOffset+prevAccumulatedValue : startOffset+x.Piece.Length]
-			break
-		} else {
-			lineNumber -= x.LFLeft + x.Piece.LineFeedCnt
-			nodeStartOffset += x.SizeLeft + x.Piece.Length
-			x = x.Right
+				ret = buffer[startOffset+prevAccumulatedValue : startOffset+x.Piece.Length]
+				break
+			} else {
+				lineNumber -= x.LFLeft + x.Piece.LineFeedCnt
+				nodeStartOffset += x.SizeLeft + x.Piece.Length
+				x = x.Right
+			}
 		}
 	}
 
@@ -822,6 +822,10 @@ func (t *PieceTreeBase) GetLineContent(lineNumber int) string {
 
 // GetLineLength 获取指定行的长度
 func (t *PieceTreeBase) GetLineLength(lineNumber int) int {
+	if !t.EOLNormalized {
+		// 换行符的长度不一定是 EOLLength
+		return len(t.GetLineContent(lineNumber))
+	}
 	if lineNumber == t.GetLineCount() {
 		startOffset := t.GetOffsetAt(lineNumber, 1)
 		return t.GetLength() - startOffset
@@ -1061,14 +1065,14 @@ func (t *PieceTreeBase) CreateNewPieces(text string) []Piece {
 		t.EndWithCR(t.buffers[0].Buffer) {
 		t.lastChangeBufferPos = BufferCursor{
 			Line:   t.lastChangeBufferPos.Line,
-			Column: t.lastChangeBufferPos.Column,
+			Column: t.lastChangeBufferPos.Column + 1,
 		}
 		start = t.lastChangeBufferPos
 		for i := 0; i < len(lineStarts); i++ {
-			lineStarts[i] = startOffset + 1
+			lineStarts[i] += startOffset + 1
 		}
 
-		t.buffers[0].LineStarts = append(t.buffers[0].LineStarts, lineStarts[:1]...)
+		t.buffers[0].LineStarts = append(t.buffers[0].LineStarts, lineStarts[1:]...)
 		t.buffers[0].Buffer += "_" + text
 		startOffset += 1
 
@@ -1194,8 +1198,22 @@ func (t *PieceTreeBase) InsertContentToNodeRight(value string, node *TreeNode) {
 	}
 }
 
-// Insert 在指定偏移量处插入内容
+// Insert 在指定偏移量处插入内容，然后通知修改监听器
 func (t *PieceTreeBase) Insert(offset int, value string, eolNormalized bool) {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > t.length {
+		offset = t.length
+	}
+	if len(value) == 0 {
+		return
+	}
+	t.replace(offset, 0, value, eolNormalized)
+}
+
+// insert 在指定偏移量处插入内容，不通知监听器
+func (t *PieceTreeBase) insert(offset int, value string, eolNormalized bool) {
 	// 参数检查和边界处理
 	if offset < 0 {
 		offset = 0
@@ -1238,6 +1256,7 @@ func (t *PieceTreeBase) Insert(offset int, value string, eolNormalized bool) {
 			len(value) < AverageBufferSize {
 			// 直接追加到已更改的缓冲区
 			t.AppendToNode(node, value)
+			t.searchCache.Validate(offset)
 			t.ComputeBufferMetadata()
 			return
 		}
@@ -1347,11 +1366,29 @@ func (t *PieceTreeBase) Insert(offset int, value string, eolNormalized bool) {
 	}
 
 	// 更新元数据
+	t.searchCache.Validate(offset)
 	t.ComputeBufferMetadata()
 }
 
-// Delete 删除指定范围的内容
+// Delete 删除指定范围的内容，然后通知修改监听器
 func (t *PieceTreeBase) Delete(offset, cnt int) {
+	if t == nil {
+		return
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= t.length || cnt <= 0 {
+		return
+	}
+	if offset+cnt > t.length {
+		cnt = t.length - offset
+	}
+	t.replace(offset, cnt, "", true)
+}
+
+// delete 删除指定范围的内容，不通知监听器
+func (t *PieceTreeBase) delete(offset, cnt int) {
 	// 参数检查
 	if t == nil {
 		return
@@ -1564,12 +1601,21 @@ func (t *PieceTreeBase) Delete(offset, cnt int) {
 		}
 	}
 
-	// 验证CRLF连接处
-	if startNode != nil && startNode != SENTINEL && startNode.Piece.Length > 0 {
-		t.ValidateCRLFWithNextNode(startNode)
+	// 验证CRLF连接处：删除点之前的节点可能以 \r 结尾，之后的节点可能以 \n 开头
+	t.searchCache.Validate(offset)
+	if offset > 0 {
+		pos := t.NodeAt(offset)
+		if pos.Node != nil && pos.Node != SENTINEL {
+			if pos.Remainder == 0 && pos.Node.Prev() != SENTINEL {
+				t.ValidateCRLFWithNextNode(pos.Node.Prev())
+			} else if pos.Remainder == pos.Node.Piece.Length {
+				t.ValidateCRLFWithNextNode(pos.Node)
+			}
+		}
 	}
 
 	// 更新元数据
+	t.searchCache.Validate(offset)
 	t.ComputeBufferMetadata()
 
 	// 确保删除后的长度正确
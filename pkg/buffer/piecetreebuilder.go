@@ -17,7 +17,7 @@ const UTF8BOMCharacter = "\uFEFF" // UTF8_BOM
 
 // StartsWithUTF8BOM 检查字符串是否以 UTF-8 BOM 开头
 func StartsWithUTF8BOM(str string) bool {
-	return len(str) > 0 && str[0] == byte(0xEF) && str[1] == byte(0xBB) && str[2] == byte(0xBF)
+	return len(str) >= 3 && str[0] == byte(0xEF) && str[1] == byte(0xBB) && str[2] == byte(0xBF)
 }
 
 // PieceTreeTextBufferFactory 片段树文本缓冲区工厂
@@ -131,6 +131,13 @@ func NewPieceTreeTextBufferBuilder() *PieceTreeTextBufferBuilder {
 	}
 }
 
+// NewPieceTree 用 text 创建片段树，text 中的换行符保持不变，开头的 BOM 被去掉；text 没有换行符时默认换行符为 LF
+func NewPieceTree(text string) *PieceTreeBase {
+	builder := NewPieceTreeTextBufferBuilder()
+	builder.AcceptChunk(text)
+	return builder.Finish(false).Create(LF)
+}
+
 // AcceptChunk 接受一个文本块
 func (b *PieceTreeTextBufferBuilder) AcceptChunk(chunk string) {
 	if len(chunk) == 0 {
@@ -140,7 +147,7 @@ func (b *PieceTreeTextBufferBuilder) AcceptChunk(chunk string) {
 	if len(b.chunks) == 0 {
 		if StartsWithUTF8BOM(chunk) {
 			b.BOM = UTF8BOMCharacter
-			chunk = chunk[1:]
+			chunk = chunk[len(UTF8BOMCharacter):]
 		}
 	}
 
@@ -159,8 +166,8 @@ func (b *PieceTreeTextBufferBuilder) AcceptChunk(chunk string) {
 
 // acceptChunk1 接受一个文本块（内部方法）
 func (b *PieceTreeTextBufferBuilder) acceptChunk1(chunk string, allowEmptyStrings bool) {
-	if !allowEmptyStrings && len(chunk) == 0 {
-		// 没有要做的事情
+	if !allowEmptyStrings && len(chunk) == 0 && !b.hasPreviousChar {
+		// 没有要做的事情；保留的 \r 即使 chunk 为空也要写出，否则会丢失
 		return
 	}
 
@@ -197,7 +204,9 @@ func (b *PieceTreeTextBufferBuilder) Finish(normalizeEOL bool) *PieceTreeTextBuf
 // finish 完成构建（内部方法）
 func (b *PieceTreeTextBufferBuilder) finish() {
 	if len(b.chunks) == 0 {
+		// 保留的字符（如果有）已经随空块写出
 		b.acceptChunk1("", true)
+		b.hasPreviousChar = false
 	}
 
 	if b.hasPreviousChar {
@@ -0,0 +1,252 @@
+package buffer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// replaceTokenKind 替换模板中记号的类型
+type replaceTokenKind int
+
+const (
+	// tokenText 字面文本
+	tokenText replaceTokenKind = iota
+	// tokenGroup 捕获组引用
+	tokenGroup
+	// tokenUpperNext \u 下一个字符大写
+	tokenUpperNext
+	// tokenLowerNext \l 下一个字符小写
+	tokenLowerNext
+	// tokenUpper \U 之后的文本大写
+	tokenUpper
+	// tokenLower \L 之后的文本小写
+	tokenLower
+	// tokenEndCase \E 结束 \U 或 \L
+	tokenEndCase
+)
+
+// replaceToken 替换模板中的一个记号
+type replaceToken struct {
+	kind  replaceTokenKind
+	text  string
+	group int
+}
+
+// replacePattern 解析后的替换模板
+type replacePattern struct {
+	tokens []replaceToken
+}
+
+// parseReplacePattern 解析正则表达式替换模板
+// 支持 $0-$99、$&、${n}、${name}、$$，\n、\t、\\、\$，以及大小写修饰 \u、\l、\U、\L、\E
+// 编号超出捕获组数量的 $n 按字面文本处理，${...} 引用不存在的捕获组时返回错误
+func parseReplacePattern(replacement string, s *searcher) (*replacePattern, error) {
+	groups := s.re.NumSubexp()
+	p := &replacePattern{}
+	var text strings.Builder
+	emit := func(tok replaceToken) {
+		if text.Len() > 0 {
+			p.tokens = append(p.tokens, replaceToken{kind: tokenText, text: text.String()})
+			text.Reset()
+		}
+		p.tokens = append(p.tokens, tok)
+	}
+
+	for i := 0; i < len(replacement); i++ {
+		c := replacement[i]
+		if i+1 >= len(replacement) || (c != '$' && c != '\\') {
+			text.WriteByte(c)
+			continue
+		}
+		next := replacement[i+1]
+
+		if c == '\\' {
+			i++
+			switch next {
+			case 'n':
+				text.WriteByte('\n')
+			case 't':
+				text.WriteByte('\t')
+			case '\\', '$':
+				text.WriteByte(next)
+			case 'u':
+				emit(replaceToken{kind: tokenUpperNext})
+			case 'l':
+				emit(replaceToken{kind: tokenLowerNext})
+			case 'U':
+				emit(replaceToken{kind: tokenUpper})
+			case 'L':
+				emit(replaceToken{kind: tokenLower})
+			case 'E':
+				emit(replaceToken{kind: tokenEndCase})
+			default:
+				text.WriteByte(c)
+				text.WriteByte(next)
+			}
+			continue
+		}
+
+		switch {
+		case next == '$':
+			text.WriteByte('$')
+			i++
+		case next == '&':
+			emit(replaceToken{kind: tokenGroup, group: 0})
+			i++
+		case next >= '0' && next <= '9':
+			group := int(next - '0')
+			width := 1
+			if i+2 < len(replacement) && replacement[i+2] >= '0' && replacement[i+2] <= '9' {
+				if two := group*10 + int(replacement[i+2]-'0'); two <= groups {
+					group, width = two, 2
+				}
+			}
+			if group > groups {
+				text.WriteByte(c)
+				continue
+			}
+			emit(replaceToken{kind: tokenGroup, group: group})
+			i += width
+		case next == '{':
+			end := strings.IndexByte(replacement[i+2:], '}')
+			if end < 0 {
+				text.WriteByte(c)
+				continue
+			}
+			name := replacement[i+2 : i+2+end]
+			group, err := strconv.Atoi(name)
+			if err != nil {
+				group = s.re.SubexpIndex(name)
+			}
+			if group < 0 || group > groups {
+				return nil, fmt.Errorf("buffer: unknown capture group %q in replacement", name)
+			}
+			emit(replaceToken{kind: tokenGroup, group: group})
+			i += 2 + end
+		default:
+			text.WriteByte(c)
+		}
+	}
+	if text.Len() > 0 {
+		p.tokens = append(p.tokens, replaceToken{kind: tokenText, text: text.String()})
+	}
+	return p, nil
+}
+
+// literalReplacePattern 返回按字面文本替换的模板
+func literalReplacePattern(replacement string) *replacePattern {
+	return &replacePattern{tokens: []replaceToken{{kind: tokenText, text: replacement}}}
+}
+
+// expand 根据匹配的捕获组生成替换文本
+func (p *replacePattern) expand(matches []string) string {
+	var sb strings.Builder
+	var caseMode, nextMode replaceTokenKind = tokenEndCase, tokenEndCase
+	for _, tok := range p.tokens {
+		var piece string
+		switch tok.kind {
+		case tokenText:
+			piece = tok.text
+		case tokenGroup:
+			if tok.group < len(matches) {
+				piece = matches[tok.group]
+			}
+		case tokenUpperNext, tokenLowerNext:
+			nextMode = tok.kind
+			continue
+		default:
+			caseMode = tok.kind
+			continue
+		}
+
+		switch caseMode {
+		case tokenUpper:
+			piece = strings.ToUpper(piece)
+		case tokenLower:
+			piece = strings.ToLower(piece)
+		}
+		if nextMode != tokenEndCase && piece != "" {
+			r, size := utf8.DecodeRuneInString(piece)
+			if nextMode == tokenUpperNext {
+				r = unicode.ToUpper(r)
+			} else {
+				r = unicode.ToLower(r)
+			}
+			piece = string(r) + piece[size:]
+			nextMode = tokenEndCase
+		}
+		sb.WriteString(piece)
+	}
+	return sb.String()
+}
+
+// preserveCase 让替换文本沿用匹配文本的大小写形式：全大写、全小写或首字母大写
+func preserveCase(matched, replacement string) string {
+	if matched == "" || replacement == "" {
+		return replacement
+	}
+	upper, lower := strings.ToUpper(matched), strings.ToLower(matched)
+	switch {
+	case upper == lower:
+		// 没有大小写之分的文本
+		return replacement
+	case matched == upper:
+		return strings.ToUpper(replacement)
+	case matched == lower:
+		return strings.ToLower(replacement)
+	}
+
+	first, _ := utf8.DecodeRuneInString(matched)
+	r, size := utf8.DecodeRuneInString(replacement)
+	switch {
+	case unicode.IsUpper(first):
+		return string(unicode.ToUpper(r)) + replacement[size:]
+	case unicode.IsLower(first):
+		return string(unicode.ToLower(r)) + replacement[size:]
+	}
+	return replacement
+}
+
+// ReplaceOptions 替换选项，只对替换有意义的选项放在这里而不是 SearchOptions 中
+type ReplaceOptions struct {
+	SearchOptions
+	// PreserveCase 让替换文本沿用匹配文本的大小写形式
+	PreserveCase bool
+}
+
+// ReplaceAll 把所有匹配替换为 replacement，作为一批编辑应用，返回替换的数量和按撤销顺序排列的逆操作
+// 正则表达式模式下 replacement 是模板，见 parseReplacePattern；否则按字面文本替换
+func (t *PieceTreeBase) ReplaceAll(query, replacement string, opts ReplaceOptions) (int, []EditOperation, error) {
+	if query == "" {
+		return 0, nil, nil
+	}
+	s, err := newSearcher(query, opts.SearchOptions)
+	if err != nil {
+		return 0, nil, err
+	}
+	pattern := literalReplacePattern(replacement)
+	if opts.IsRegex {
+		if pattern, err = parseReplacePattern(replacement, s); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	matches := t.findMatches(s, true, 0)
+	ops := make([]EditOperation, 0, len(matches))
+	// 从后往前应用，前面匹配的偏移量不受影响
+	for i := len(matches) - 1; i >= 0; i-- {
+		m := matches[i]
+		text := pattern.expand(m.Matches)
+		if opts.PreserveCase {
+			text = preserveCase(m.Matches[0], text)
+		}
+		if text == m.Matches[0] {
+			continue
+		}
+		ops = append(ops, NewEditOperation(m.StartOffset, m.EndOffset-m.StartOffset, text))
+	}
+	return len(matches), t.ApplyEdits(ops), nil
+}
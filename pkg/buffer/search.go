@@ -0,0 +1,228 @@
+package buffer
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kebaren/textbuffer/pkg/common"
+)
+
+// DefaultWordSeparators 默认的单词分隔符（空白字符总是分隔符）
+const DefaultWordSeparators = common.DefaultWordSeparators
+
+// SearchOptions 搜索选项
+type SearchOptions struct {
+	// IsRegex 查询是否为正则表达式（RE2 语法）
+	IsRegex bool
+	// MatchCase 是否区分大小写
+	MatchCase bool
+	// WholeWord 是否只匹配完整的单词
+	WholeWord bool
+	// WordSeparators 单词分隔符，为空时使用 DefaultWordSeparators
+	WordSeparators string
+	// CaptureMatches 是否在结果中返回捕获组
+	CaptureMatches bool
+}
+
+// FindMatch 搜索结果
+type FindMatch struct {
+	// StartOffset 匹配开始的偏移量
+	StartOffset int
+	// EndOffset 匹配结束的偏移量（不包含）
+	EndOffset int
+	// Range 匹配的范围
+	Range *common.Range
+	// Matches 完整匹配和捕获组，仅在 CaptureMatches 为 true 时填充
+	Matches []string
+}
+
+// searcher 编译好的搜索条件
+type searcher struct {
+	re         *regexp.Regexp
+	opts       SearchOptions
+	classifier *common.WordClassifier
+	multiline  bool
+}
+
+// newSearcher 根据查询和选项创建搜索条件
+func newSearcher(query string, opts SearchOptions) (*searcher, error) {
+	pattern := query
+	if !opts.IsRegex {
+		pattern = regexp.QuoteMeta(query)
+	}
+	flags := "(?m)"
+	if !opts.MatchCase {
+		flags = "(?mi)"
+	}
+
+	re, err := regexp.Compile(flags + pattern)
+	if err != nil {
+		return nil, fmt.Errorf("buffer: invalid search pattern: %v", err)
+	}
+
+	return &searcher{
+		re:         re,
+		opts:       opts,
+		classifier: common.NewWordClassifier(opts.WordSeparators),
+		multiline:  isMultilineQuery(flags + pattern),
+	}, nil
+}
+
+// isMultilineQuery 判断编译前的模式是否需要在整个文本上匹配：
+// 模式中有可能匹配 \r 或 \n 的部分（包括 \s、\D、\W、[^...]、[[:space:]] 和 (?s) 下的 .），
+// 或者有只在整个文本首尾匹配的 \A 和 \z
+func isMultilineQuery(pattern string) bool {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return false
+	}
+	return needsWholeText(re)
+}
+
+// needsWholeText 判断语法树中是否有可能匹配换行符或者依赖文本首尾的节点
+func needsWholeText(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if strings.ContainsAny(string(re.Rune), "\r\n") {
+			return true
+		}
+	case syntax.OpCharClass:
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			lo, hi := re.Rune[i], re.Rune[i+1]
+			if (lo <= '\n' && '\n' <= hi) || (lo <= '\r' && '\r' <= hi) {
+				return true
+			}
+		}
+	case syntax.OpAnyChar, syntax.OpBeginText, syntax.OpEndText:
+		return true
+	}
+	for _, sub := range re.Sub {
+		if needsWholeText(sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// isWholeWord 判断 text[start:end] 是否是一个完整的单词匹配
+func (s *searcher) isWholeWord(text string, start, end int) bool {
+	if !s.opts.WholeWord {
+		return true
+	}
+
+	if start > 0 {
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		first, _ := utf8.DecodeRuneInString(text[start:end])
+		if !s.classifier.IsWordBoundary(before, first) {
+			return false
+		}
+	}
+	if end < len(text) {
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		last, _ := utf8.DecodeLastRuneInString(text[start:end])
+		if !s.classifier.IsWordBoundary(last, after) {
+			return false
+		}
+	}
+	return true
+}
+
+// findInText 在 text 中查找匹配，回调参数为匹配在 text 中的位置，回调返回 false 时停止
+// 总是在整个 text 上查找，^ 和 \b 才能看到前一个匹配之前的内容；regexp 不能从指定偏移开始查找，
+// 所以每轮把查找数量翻倍并跳过已经处理过的匹配，回调提前停止时不会找出全部匹配
+func (s *searcher) findInText(text string, callback func(loc []int) bool) {
+	for n, seen := 8, 0; ; n *= 2 {
+		locs := s.re.FindAllStringSubmatchIndex(text, n)
+		for _, loc := range locs[seen:] {
+			if loc[0] == loc[1] {
+				// 跳过空匹配
+				continue
+			}
+			if s.isWholeWord(text, loc[0], loc[1]) && !callback(loc) {
+				return
+			}
+		}
+		if len(locs) < n {
+			return
+		}
+		seen = len(locs)
+	}
+}
+
+// captures 根据匹配位置提取完整匹配和捕获组
+func captures(text string, loc []int) []string {
+	matches := make([]string, len(loc)/2)
+	for i := range matches {
+		if loc[2*i] >= 0 {
+			matches[i] = text[loc[2*i]:loc[2*i+1]]
+		}
+	}
+	return matches
+}
+
+// FindMatches 查找文档中的所有匹配，limit 小于等于 0 时不限制数量
+// 不跨行的查询逐行搜索，不需要把整个文档读入内存
+func (t *PieceTreeBase) FindMatches(query string, opts SearchOptions, limit int) ([]FindMatch, error) {
+	if query == "" {
+		return nil, nil
+	}
+	s, err := newSearcher(query, opts)
+	if err != nil {
+		return nil, err
+	}
+	return t.findMatches(s, opts.CaptureMatches, limit), nil
+}
+
+// findMatches 用编译好的搜索条件查找匹配
+func (t *PieceTreeBase) findMatches(s *searcher, captureMatches bool, limit int) []FindMatch {
+	var result []FindMatch
+	t.eachMatch(s, captureMatches, func(match FindMatch) bool {
+		result = append(result, match)
+		return limit <= 0 || len(result) < limit
+	})
+	return result
+}
+
+// eachMatch 按顺序把每个匹配传给 yield，yield 返回 false 时停止
+func (t *PieceTreeBase) eachMatch(s *searcher, captureMatches bool, yield func(FindMatch) bool) {
+	if s.multiline {
+		text := t.GetLinesRawContent()
+		s.findInText(text, func(loc []int) bool {
+			start := t.GetPositionAt(loc[0])
+			end := t.GetPositionAt(loc[1])
+			match := FindMatch{
+				StartOffset: loc[0],
+				EndOffset:   loc[1],
+				Range:       common.NewRange(start.LineNumber, start.Column, end.LineNumber, end.Column),
+			}
+			if captureMatches {
+				match.Matches = captures(text, loc)
+			}
+			return yield(match)
+		})
+		return
+	}
+
+	stopped := false
+	for lineNumber, line := range t.Lines(1, t.GetLineCount()) {
+		lineOffset := t.GetOffsetAt(lineNumber, 1)
+		s.findInText(line, func(loc []int) bool {
+			match := FindMatch{
+				StartOffset: lineOffset + loc[0],
+				EndOffset:   lineOffset + loc[1],
+				Range:       common.NewRange(lineNumber, loc[0]+1, lineNumber, loc[1]+1),
+			}
+			if captureMatches {
+				match.Matches = captures(line, loc)
+			}
+			stopped = !yield(match)
+			return !stopped
+		})
+		if stopped {
+			return
+		}
+	}
+}
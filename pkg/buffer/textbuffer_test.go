@@ -1,9 +1,15 @@
 package buffer
 
 import (
+	"math/rand"
+	"regexp"
+	"strings"
 	"testing"
+	"unicode/utf8"
 
+	"github.com/kebaren/textbuffer/pkg/common"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func createEmptyTextBuffer() *PieceTreeBase {
@@ -693,3 +699,908 @@ func TestDeleteCommaSpace(t *testing.T) {
 	// Final assertion
 	assert.Equal(t, "HelloWorld", tb.GetLinesRawContent())
 }
+
+func TestRotationKeepsSubtreeMetadata(t *testing.T) {
+	// Appending pieces one at a time forces left rotations, prepending forces right rotations.
+	tb := createEmptyTextBuffer()
+	for i := 0; i < 64; i++ {
+		tb.Insert(0, "a\n", false)
+		tb.Insert(tb.GetLength(), "b\n", false)
+	}
+	assert.Equal(t, 256, tb.GetLength())
+	assert.Equal(t, 129, tb.GetLineCount())
+	assert.Equal(t, "a", tb.GetLineContent(64))
+	assert.Equal(t, "b", tb.GetLineContent(65))
+	assert.Equal(t, 130, tb.GetOffsetAt(66, 1))
+}
+
+func TestGetPositionAtAcrossPieces(t *testing.T) {
+	tb := createEmptyTextBuffer()
+	tb.Insert(0, "cd\nef", false)
+	tb.Insert(0, "ab\n", false)
+	tb.Insert(tb.GetLength(), "\ngh", false)
+	assert.Equal(t, "ab\ncd\nef\ngh", tb.GetLinesRawContent())
+	assert.Equal(t, common.NewPosition(1, 3), tb.GetPositionAt(2))
+	assert.Equal(t, common.NewPosition(2, 1), tb.GetPositionAt(3))
+	assert.Equal(t, common.NewPosition(3, 3), tb.GetPositionAt(8))
+	assert.Equal(t, common.NewPosition(4, 3), tb.GetPositionAt(11))
+	assert.Equal(t, common.NewPosition(1, 1), tb.GetPositionAt(-1))
+}
+
+func TestGetLineContentUsesCachedNode(t *testing.T) {
+	tb := createEmptyTextBuffer()
+	tb.Insert(0, "1\n2\n3\n4", false)
+	tb.Insert(0, "0\n", false)
+	// The second read of each line is answered from the search cache.
+	for round := 0; round < 2; round++ {
+		for line := 1; line <= 5; line++ {
+			assert.Equal(t, string(rune('0'+line-1)), tb.GetLineContent(line), "round %d", round)
+		}
+	}
+}
+
+func TestInsertAfterTrailingCR(t *testing.T) {
+	tb := createEmptyTextBuffer()
+	tb.Insert(0, "a\r", false)
+	tb.Insert(0, "x", false)
+	tb.Insert(tb.GetLength(), "\nb\nc", false)
+	assert.Equal(t, "xa\r\nb\nc", tb.GetLinesRawContent())
+	assert.Equal(t, 3, tb.GetLineCount())
+	assert.Equal(t, "xa", tb.GetLineContent(1))
+	assert.Equal(t, "b", tb.GetLineContent(2))
+	assert.Equal(t, "c", tb.GetLineContent(3))
+}
+
+func TestLineStartsAndBOMUseBytes(t *testing.T) {
+	lineStarts := CreateLineStarts("é\r\nü\rx\n")
+	assert.Equal(t, []int{0, 4, 7, 9}, lineStarts.Offsets)
+	assert.False(t, lineStarts.IsBasicASCII)
+	assert.Equal(t, []int{0, 4}, CreateLineStartsFast("中\ny", false))
+
+	assert.False(t, StartsWithUTF8BOM("\xef"))
+	builder := NewPieceTreeTextBufferBuilder()
+	builder.AcceptChunk(UTF8BOMCharacter + "héllo")
+	assert.Equal(t, "héllo", builder.Finish(false).Create(LF).GetLinesRawContent())
+}
+
+// splitModelLines splits text the same way the piece tree counts lines.
+func splitModelLines(s string) []string {
+	var lines []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\r' {
+			lines = append(lines, s[start:i])
+			if i+1 < len(s) && s[i+1] == '\n' {
+				i++
+			}
+			start = i + 1
+		} else if s[i] == '\n' {
+			lines = append(lines, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(lines, s[start:])
+}
+
+func TestRandomInsertDeleteMatchesModel(t *testing.T) {
+	alphabet := []string{"a", "xy", "\n", "\r", "\r\n", "世界", "hello\r\nworld", strings.Repeat("long\n", 20000)}
+	for seed := int64(0); seed < 25; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		tb := createEmptyTextBuffer()
+		model := ""
+		for step := 0; step < 50; step++ {
+			if len(model) == 0 || r.Intn(3) > 0 {
+				offset := r.Intn(len(model) + 1)
+				text := alphabet[r.Intn(len(alphabet)-1)]
+				if r.Intn(10) == 0 {
+					text = alphabet[len(alphabet)-1]
+				}
+				tb.Insert(offset, text, false)
+				model = model[:offset] + text + model[offset:]
+			} else {
+				offset := r.Intn(len(model))
+				cnt := r.Intn(len(model)-offset) + 1
+				tb.Delete(offset, cnt)
+				model = model[:offset] + model[offset+cnt:]
+			}
+
+			if !assert.Equal(t, model, tb.GetLinesRawContent(), "seed %d step %d", seed, step) {
+				return
+			}
+			lines := splitModelLines(model)
+			assert.Equal(t, len(lines), tb.GetLineCount(), "seed %d step %d", seed, step)
+			for i := 0; i < len(lines); i += 1 + len(lines)/50 {
+				assert.Equal(t, lines[i], tb.GetLineContent(i+1), "seed %d step %d line %d", seed, step, i+1)
+			}
+			for offset := 0; offset <= len(model); offset += 1 + len(model)/50 {
+				pos := tb.GetPositionAt(offset)
+				if offset > 0 && offset < len(model) && model[offset-1] == '\r' && model[offset] == '\n' {
+					continue
+				}
+				assert.Equal(t, offset, tb.GetOffsetAt(pos.LineNumber, pos.Column), "seed %d step %d offset %d", seed, step, offset)
+			}
+		}
+	}
+}
+
+func TestApplyEditsReturnsInverse(t *testing.T) {
+	tb := createEmptyTextBuffer()
+	tb.Insert(0, "Hello World\nSecond line", true)
+	original := tb.GetLinesRawContent()
+
+	inverse := tb.ApplyEdits([]EditOperation{
+		NewEditOperation(0, 5, "Goodbye"),
+		NewEditOperation(8, 5, "Earth\r\n"),
+		NewEditOperation(1000, 0, "!"),
+	})
+	assert.Equal(t, "Goodbye Earth\r\n\nSecond line!", tb.GetLinesRawContent())
+	assert.Equal(t, "Earth\r\n", tb.GetValueInOffsetRange(8, 15))
+
+	tb.ApplyEdits(inverse)
+	assert.Equal(t, original, tb.GetLinesRawContent())
+	assert.Equal(t, 2, tb.GetLineCount())
+}
+
+func TestChangeListeners(t *testing.T) {
+	tb := createEmptyTextBuffer()
+	tb.Insert(0, "one\ntwo\nthree", false)
+
+	var changes []ContentChange
+	remove := tb.AddChangeListener(func(change ContentChange) {
+		changes = append(changes, change)
+	})
+	var order []int
+	tb.AddChangeListener(func(ContentChange) { order = append(order, 1) })
+	tb.AddChangeListener(func(ContentChange) { order = append(order, 2) })
+
+	// Replace "o\ntw" with three lines.
+	tb.ApplyEdit(NewEditOperation(2, 4, "X\nY\nZ"))
+	require.Len(t, changes, 1)
+	change := changes[0]
+	assert.Equal(t, 2, change.Offset)
+	assert.Equal(t, 4, change.DeletedLength)
+	assert.Equal(t, 5, change.InsertedLength)
+	assert.Equal(t, "[1,3 -> 2,3]", change.Range.String())
+	assert.Equal(t, common.Position{LineNumber: 3, Column: 2}, change.End)
+	assert.Equal(t, 1, change.StartLine())
+	assert.Equal(t, 2, change.OldLineCount())
+	assert.Equal(t, 3, change.NewLineCount())
+	assert.Equal(t, []int{1, 2}, order)
+
+	// No-op edits are not reported; clamped edits and the primitive mutators are.
+	tb.ApplyEdit(NewEditOperation(3, 0, ""))
+	tb.Insert(0, "", false)
+	tb.Delete(tb.GetLength(), 3)
+	tb.ApplyEdit(NewEditOperation(-5, -1, "!"))
+	require.Len(t, changes, 2)
+	assert.Equal(t, 0, changes[1].Offset)
+	assert.Equal(t, 0, changes[1].DeletedLength)
+
+	tb.Insert(100, "\nend", false)
+	require.NoError(t, tb.InsertChecked(0, ">"))
+	tb.Delete(0, 2)
+	require.NoError(t, tb.DeleteChecked(0, 1))
+	require.Len(t, changes, 6)
+	assert.Equal(t, 15, changes[2].Offset)
+	assert.Equal(t, "[1,1 -> 1,1]", changes[3].Range.String())
+	assert.Equal(t, common.Position{LineNumber: 1, Column: 2}, changes[3].End)
+	assert.Equal(t, 2, changes[4].DeletedLength)
+	assert.Equal(t, "[1,1 -> 1,2]", changes[5].Range.String())
+
+	remove()
+	tb.ApplyEdit(NewEditOperation(0, 1, ""))
+	assert.Len(t, changes, 6)
+	assert.Len(t, order, 14)
+}
+
+func TestFindMatches(t *testing.T) {
+	tb := createEmptyTextBuffer()
+	tb.Insert(0, "foo bar Foo\nfoobar 世界 foo\n", true)
+
+	matches, err := tb.FindMatches("foo", SearchOptions{}, 0)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 4)
+	assert.Equal(t, 8, matches[1].StartOffset)
+	assert.Equal(t, "[2,1 -> 2,4]", matches[2].Range.String())
+
+	matches, err = tb.FindMatches("foo", SearchOptions{MatchCase: true, WholeWord: true}, 0)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 2)
+	assert.Equal(t, 0, matches[0].StartOffset)
+	assert.Equal(t, 26, matches[1].StartOffset)
+	assert.Equal(t, "foo", tb.GetValueInOffsetRange(matches[1].StartOffset, matches[1].EndOffset))
+
+	matches, err = tb.FindMatches(`(\w+)\n(\w+)`, SearchOptions{IsRegex: true, CaptureMatches: true}, 1)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 1)
+	assert.Equal(t, []string{"Foo\nfoobar", "Foo", "foobar"}, matches[0].Matches)
+	assert.Equal(t, "[1,9 -> 2,7]", matches[0].Range.String())
+
+	_, err = tb.FindMatches("(", SearchOptions{IsRegex: true}, 0)
+	assert.Error(t, err)
+
+	// Anchors and word boundaries see the text before the previous match.
+	tb = createEmptyTextBuffer()
+	tb.Insert(0, "aab ab\n"+strings.Repeat("x", 20), false)
+	matches, err = tb.FindMatches(`^a`, SearchOptions{IsRegex: true}, 0)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 1)
+	matches, err = tb.FindMatches(`\ba`, SearchOptions{IsRegex: true}, 0)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 2)
+	assert.Equal(t, 4, matches[1].StartOffset)
+	matches, err = tb.FindMatches(`(?m)^a|b\n`, SearchOptions{IsRegex: true}, 0)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 2)
+	assert.Equal(t, 5, matches[1].StartOffset)
+	matches, err = tb.FindMatches(`x`, SearchOptions{IsRegex: true}, 0)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 20)
+	matches, err = tb.FindMatches(`x`, SearchOptions{IsRegex: true}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, matches, 10)
+}
+
+func TestMultilineQueryDetection(t *testing.T) {
+	tb := createEmptyTextBuffer()
+	tb.Insert(0, "a\nb a\r\nb\na", false)
+	for _, query := range []string{`a\W+b`, `a\s+b`, `a[^x]+?b`, `(?s)a.+?b`, `a[[:space:]]+b`} {
+		matches, err := tb.FindMatches(query, SearchOptions{IsRegex: true}, 0)
+		require.NoError(t, err)
+		assert.Len(t, matches, 2, query)
+	}
+	// The CRLF pair needs two characters, so only the first line break fits.
+	matches, err := tb.FindMatches(`a\Db`, SearchOptions{IsRegex: true}, 0)
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	assert.Equal(t, "[1,1 -> 2,2]", matches[0].Range.String())
+
+	// \A and \z anchor to the whole text, not to each line.
+	matches, err = tb.FindMatches(`\Aa`, SearchOptions{IsRegex: true}, 0)
+	require.NoError(t, err)
+	assert.Len(t, matches, 1)
+	matches, err = tb.FindMatches(`a\z`, SearchOptions{IsRegex: true}, 0)
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	assert.Equal(t, 9, matches[0].StartOffset)
+
+	// Queries that cannot match a line break still search line by line.
+	for query, multiline := range map[string]bool{`a.b`: false, `a\Sb`: false, `^a$`: false, `a\nb`: true, `[\r]`: true} {
+		assert.Equal(t, multiline, isMultilineQuery("(?m)"+query), query)
+	}
+}
+
+func TestHistoryUndoRedo(t *testing.T) {
+	tb := createEmptyTextBuffer()
+	tb.Insert(0, "one two", true)
+	history := NewHistory(tb, 0)
+
+	history.Apply([]EditOperation{NewEditOperation(4, 3, "three")})
+	history.Apply([]EditOperation{NewEditOperation(0, 0, "zero "), NewEditOperation(100, 0, "!")})
+	assert.Equal(t, "zero one three!", tb.GetLinesRawContent())
+
+	assert.NotNil(t, history.Undo())
+	assert.Equal(t, "one three", tb.GetLinesRawContent())
+	assert.NotNil(t, history.Undo())
+	assert.Equal(t, "one two", tb.GetLinesRawContent())
+	assert.Nil(t, history.Undo())
+
+	assert.NotNil(t, history.Redo())
+	assert.Equal(t, "one three", tb.GetLinesRawContent())
+	history.Apply([]EditOperation{NewEditOperation(0, 3, "1")})
+	assert.False(t, history.CanRedo())
+	assert.Equal(t, "1 three", tb.GetLinesRawContent())
+
+	limited := NewHistory(tb, 1)
+	limited.Apply([]EditOperation{NewEditOperation(0, 0, "a")})
+	limited.Apply([]EditOperation{NewEditOperation(0, 0, "b")})
+	limited.Undo()
+	assert.False(t, limited.CanUndo())
+	assert.Equal(t, "a1 three", tb.GetLinesRawContent())
+}
+
+func TestReplaceAll(t *testing.T) {
+	regex := ReplaceOptions{SearchOptions: SearchOptions{IsRegex: true}}
+	cases := []struct {
+		name        string
+		text        string
+		query       string
+		replacement string
+		opts        ReplaceOptions
+		expected    string
+		count       int
+	}{
+		{"literal", "a.b a.b", "a.b", "$1", ReplaceOptions{}, "$1 $1", 2},
+		{"numbered groups", "key=value", `(\w+)=(\w+)`, "$2=$1 [$0] $$", regex, "value=key [key=value] $", 1},
+		{"named groups", "2024-05", `(?P<y>\d+)-(?P<m>\d+)`, "${m}/${y}", regex, "05/2024", 1},
+		{"missing numbered group is literal", "ab", "(a)", "$5$1", regex, "$5ab", 1},
+		{"escapes", "a,b", ",", `\n\t\\`, regex, "a\n\t\\b", 1},
+		{"case modifiers", "hello world", `(\w+) (\w+)`, `\u$1 \U$2\E!`, regex, "Hello WORLD!", 1},
+		{"lower modifiers", "HELLO WORLD", `(\w+) (\w+)`, `\l$1 \L$2`, regex, "hELLO world", 1},
+		{"preserve case", "foo Foo FOO", "foo", "bar", ReplaceOptions{PreserveCase: true}, "bar Bar BAR", 3},
+		{"preserve case with regex", "fooBar", `foo(\w+)`, "baz$1", ReplaceOptions{SearchOptions: SearchOptions{IsRegex: true}, PreserveCase: true}, "bazBar", 1},
+		{"multiline", "a\nb\nc", `\n`, " ", regex, "a b c", 2},
+		{"unchanged matches are counted", "aa", "a", "a", ReplaceOptions{}, "aa", 2},
+		{"no match", "abc", "x", "y", ReplaceOptions{}, "abc", 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tb := createEmptyTextBuffer()
+			tb.Insert(0, c.text, false)
+			count, inverse, err := tb.ReplaceAll(c.query, c.replacement, c.opts)
+			assert.NoError(t, err)
+			assert.Equal(t, c.count, count)
+			assert.Equal(t, c.expected, tb.GetLinesRawContent())
+
+			tb.ApplyEdits(inverse)
+			assert.Equal(t, c.text, tb.GetLinesRawContent())
+		})
+	}
+
+	tb := createEmptyTextBuffer()
+	tb.Insert(0, "abc", false)
+	_, _, err := tb.ReplaceAll("(b)", "${name}", regex)
+	assert.Error(t, err)
+	assert.Equal(t, "abc", tb.GetLinesRawContent())
+}
+
+func TestWordAtPosition(t *testing.T) {
+	tb := createEmptyTextBuffer()
+	tb.Insert(0, "foo.bar(baz)  qux\n世界abc 你好\n", false)
+
+	word := tb.GetWordAtPosition(common.Position{LineNumber: 1, Column: 2}, nil)
+	assert.Equal(t, &WordAtPosition{Word: "foo", StartColumn: 1, EndColumn: 4}, word)
+	// the word before the cursor wins when the cursor touches two words
+	word = tb.GetWordAtPosition(common.Position{LineNumber: 1, Column: 4}, nil)
+	assert.Equal(t, "foo", word.Word)
+	word = tb.GetWordAtPosition(common.Position{LineNumber: 1, Column: 5}, nil)
+	assert.Equal(t, "bar", word.Word)
+	assert.Nil(t, tb.GetWordAtPosition(common.Position{LineNumber: 1, Column: 14}, nil))
+
+	// CJK runs are words of their own
+	word = tb.GetWordAtPosition(common.Position{LineNumber: 2, Column: 1}, nil)
+	assert.Equal(t, &WordAtPosition{Word: "世界", StartColumn: 1, EndColumn: 7}, word)
+	word = tb.GetWordAtPosition(common.Position{LineNumber: 2, Column: 8}, nil)
+	assert.Equal(t, "abc", word.Word)
+
+	// custom separators
+	word = tb.GetWordAtPosition(common.Position{LineNumber: 1, Column: 2}, common.NewWordClassifier("("))
+	assert.Equal(t, "foo.bar", word.Word)
+
+	until := tb.GetWordUntilPosition(common.Position{LineNumber: 1, Column: 7}, nil)
+	assert.Equal(t, WordAtPosition{Word: "ba", StartColumn: 5, EndColumn: 7}, until)
+	until = tb.GetWordUntilPosition(common.Position{LineNumber: 1, Column: 14}, nil)
+	assert.Equal(t, WordAtPosition{StartColumn: 14, EndColumn: 14}, until)
+}
+
+func TestWordNavigation(t *testing.T) {
+	tb := createEmptyTextBuffer()
+	tb.Insert(0, "foo.bar  baz\n\n  qux", false)
+
+	next := func(line, column int) common.Position {
+		return tb.NextWordStart(common.Position{LineNumber: line, Column: column}, nil)
+	}
+	prev := func(line, column int) common.Position {
+		return tb.PrevWordEnd(common.Position{LineNumber: line, Column: column}, nil)
+	}
+
+	assert.Equal(t, common.Position{LineNumber: 1, Column: 4}, next(1, 1))
+	assert.Equal(t, common.Position{LineNumber: 1, Column: 5}, next(1, 4))
+	assert.Equal(t, common.Position{LineNumber: 1, Column: 10}, next(1, 5))
+	assert.Equal(t, common.Position{LineNumber: 3, Column: 3}, next(1, 10))
+	assert.Equal(t, common.Position{LineNumber: 3, Column: 6}, next(3, 3))
+
+	assert.Equal(t, common.Position{LineNumber: 1, Column: 13}, prev(3, 6))
+	assert.Equal(t, common.Position{LineNumber: 1, Column: 13}, prev(3, 3))
+	assert.Equal(t, common.Position{LineNumber: 1, Column: 8}, prev(1, 13))
+	assert.Equal(t, common.Position{LineNumber: 1, Column: 5}, prev(1, 8))
+	assert.Equal(t, common.Position{LineNumber: 1, Column: 1}, prev(1, 3))
+}
+
+func TestGuessIndentation(t *testing.T) {
+	cases := []struct {
+		name         string
+		text         string
+		tabSize      int
+		insertSpaces bool
+	}{
+		{"tabs keep the default size", "a\n\tb\n\t\tc\n\td\n", 8, false},
+		{"two spaces", "a\n  b\n    c\n  d\ne\n", 2, true},
+		{"four spaces", "a\n    b\n        c\n    d\ne\n    f\n", 4, true},
+		{"alignment is ignored", "x = 1,\n    y = 2\nz\n  w\n", 2, true},
+		{"no indentation keeps defaults", "a\nb\n", 8, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tb := createEmptyTextBuffer()
+			tb.Insert(0, c.text, false)
+			guess := tb.GuessIndentation(8, false)
+			assert.Equal(t, c.tabSize, guess.TabSize)
+			assert.Equal(t, c.insertSpaces, guess.InsertSpaces)
+		})
+	}
+}
+
+func TestIndentOutdent(t *testing.T) {
+	spaces := IndentationOptions{TabSize: 4, InsertSpaces: true}
+	tabs := IndentationOptions{TabSize: 4}
+
+	tb := createEmptyTextBuffer()
+	tb.Insert(0, "a\n\n  b\n\tc", false)
+	inverse := tb.Indent(1, 4, spaces)
+	assert.Equal(t, "    a\n\n    b\n        c", tb.GetLinesRawContent())
+	tb.ApplyEdits(inverse)
+	assert.Equal(t, "a\n\n  b\n\tc", tb.GetLinesRawContent())
+
+	tb.Indent(2, 4, tabs)
+	assert.Equal(t, "a\n\n\tb\n\t\tc", tb.GetLinesRawContent())
+
+	tb.Outdent(1, 4, tabs)
+	assert.Equal(t, "a\n\nb\n\tc", tb.GetLinesRawContent())
+
+	tb = createEmptyTextBuffer()
+	tb.Insert(0, "      a\n   b", false)
+	tb.Outdent(1, 2, IndentationOptions{TabSize: 4, IndentSize: 2, InsertSpaces: true})
+	assert.Equal(t, "    a\n  b", tb.GetLinesRawContent())
+}
+
+func TestConvertIndentation(t *testing.T) {
+	tb := createEmptyTextBuffer()
+	tb.Insert(0, "\ta\n      b\n  \tc\nd", false)
+	tb.ConvertIndentation(IndentationOptions{TabSize: 4, InsertSpaces: true})
+	assert.Equal(t, "    a\n      b\n    c\nd", tb.GetLinesRawContent())
+
+	inverse := tb.ConvertIndentation(IndentationOptions{TabSize: 4})
+	assert.Equal(t, "\ta\n\t  b\n\tc\nd", tb.GetLinesRawContent())
+	tb.ApplyEdits(inverse)
+	assert.Equal(t, "    a\n      b\n    c\nd", tb.GetLinesRawContent())
+}
+
+func TestVisibleColumnFromPosition(t *testing.T) {
+	tb := createEmptyTextBuffer()
+	tb.Insert(0, "abc\n\t世界x", false)
+
+	assert.Equal(t, 3, tb.GetVisibleColumnFromPosition(common.Position{LineNumber: 1, Column: 4}, 4))
+	assert.Equal(t, 8, tb.GetVisibleColumnFromPosition(common.Position{LineNumber: 2, Column: 8}, 4))
+	assert.Equal(t, 9, tb.GetVisibleColumnFromPosition(common.Position{LineNumber: 2, Column: 9}, 4))
+
+	assert.Equal(t, common.Position{LineNumber: 2, Column: 5}, tb.GetPositionFromVisibleColumn(2, 7, 4))
+	assert.Equal(t, common.Position{LineNumber: 2, Column: 9}, tb.GetPositionFromVisibleColumn(2, 9, 4))
+	assert.Equal(t, common.Position{LineNumber: 1, Column: 4}, tb.GetPositionFromVisibleColumn(1, 20, 4))
+}
+
+func TestValidatePosition(t *testing.T) {
+	tb := createEmptyTextBuffer()
+	tb.Insert(0, "a世b\r\nxy\r\n", false)
+
+	pos := func(line, column int) common.Position {
+		return common.Position{LineNumber: line, Column: column}
+	}
+	assert.Equal(t, pos(1, 1), tb.ValidatePosition(pos(-1, 0)))
+	assert.Equal(t, pos(1, 6), tb.ValidatePosition(pos(1, 99)))
+	assert.Equal(t, pos(3, 1), tb.ValidatePosition(pos(7, 3)))
+	// Columns inside the three-byte 世 move to its start.
+	assert.Equal(t, pos(1, 2), tb.ValidatePosition(pos(1, 3)))
+	assert.Equal(t, pos(1, 2), tb.ValidatePosition(pos(1, 4)))
+
+	assert.Equal(t, *common.NewRange(1, 2, 2, 3), tb.ValidateRange(common.Range{StartLineNumber: 2, StartColumn: 9, EndLineNumber: 1, EndColumn: 3}))
+
+	// Moving never lands inside a character or a CRLF.
+	assert.Equal(t, pos(1, 5), tb.ModifyPosition(pos(1, 2), 1))
+	assert.Equal(t, pos(1, 2), tb.ModifyPosition(pos(1, 5), -1))
+	assert.Equal(t, pos(2, 1), tb.ModifyPosition(pos(1, 6), 1))
+	assert.Equal(t, pos(1, 6), tb.ModifyPosition(pos(2, 1), -1))
+	assert.Equal(t, pos(2, 1), tb.ModifyPosition(pos(1, 6), 2))
+	assert.Equal(t, pos(3, 1), tb.ModifyPosition(pos(1, 1), 100))
+	assert.Equal(t, pos(1, 1), tb.ModifyPosition(pos(2, 2), -100))
+}
+
+func TestCheckedOperations(t *testing.T) {
+	tb := createEmptyTextBuffer()
+	tb.Insert(0, "a世b\r\nxy", false)
+
+	assert.ErrorIs(t, tb.InsertChecked(-1, "x"), ErrOutOfRange)
+	assert.ErrorIs(t, tb.InsertChecked(100, "x"), ErrOutOfRange)
+	assert.ErrorIs(t, tb.InsertChecked(2, "x"), ErrNotBoundary)
+	assert.ErrorIs(t, tb.InsertChecked(6, "x"), ErrNotBoundary)
+	assert.ErrorIs(t, tb.DeleteChecked(0, 2), ErrNotBoundary)
+	assert.ErrorIs(t, tb.DeleteChecked(5, 1), ErrNotBoundary)
+	assert.ErrorIs(t, tb.DeleteChecked(7, 5), ErrOutOfRange)
+	assert.ErrorIs(t, tb.DeleteChecked(0, -1), ErrOutOfRange)
+	assert.Equal(t, "a世b\r\nxy", tb.GetLinesRawContent())
+
+	assert.NoError(t, tb.InsertChecked(4, "!"))
+	assert.NoError(t, tb.DeleteChecked(1, 3))
+	assert.NoError(t, tb.InsertChecked(tb.GetLength(), "z"))
+	assert.Equal(t, "a!b\r\nxyz", tb.GetLinesRawContent())
+
+	value, err := tb.GetValueInRangeChecked(*common.NewRange(1, 2, 2, 2), "\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "!b\nx", value)
+	_, err = tb.GetValueInRangeChecked(common.Range{StartLineNumber: 1, StartColumn: 1, EndLineNumber: 3, EndColumn: 1}, "")
+	assert.ErrorIs(t, err, ErrOutOfRange)
+	_, err = tb.GetValueInRangeChecked(common.Range{StartLineNumber: 1, StartColumn: 5, EndLineNumber: 1, EndColumn: 1}, "")
+	assert.ErrorIs(t, err, ErrOutOfRange)
+	_, err = tb.GetValueInRangeChecked(common.Range{StartLineNumber: 2, StartColumn: 1, EndLineNumber: 1, EndColumn: 1}, "")
+	assert.ErrorIs(t, err, ErrInvalidRange)
+
+	// Columns inside a multi-byte character are rejected instead of splitting it.
+	tb.Insert(tb.GetLength(), "\nbé", false)
+	_, err = tb.GetValueInRangeChecked(*common.NewRange(3, 3, 3, 4), "")
+	assert.ErrorIs(t, err, ErrNotBoundary)
+	_, err = tb.GetValueInRangeChecked(*common.NewRange(2, 1, 3, 3), "")
+	assert.ErrorIs(t, err, ErrNotBoundary)
+	value, err = tb.GetValueInRangeChecked(*common.NewRange(3, 2, 3, 4), "")
+	assert.NoError(t, err)
+	assert.Equal(t, "é", value)
+}
+
+func TestIterators(t *testing.T) {
+	tb := createEmptyTextBuffer()
+	// Build "a中\nb文\nc" so that both multi-byte characters straddle pieces.
+	tb.Insert(0, "\xad\nb\xe6", false)
+	tb.Insert(0, "a\xe4\xb8", false)
+	tb.Insert(tb.GetLength(), "\x96\x87\nc", false)
+	assert.Equal(t, "a中\nb文\nc", tb.GetLinesRawContent())
+
+	pieces := []string{}
+	for piece := range tb.Pieces() {
+		pieces = append(pieces, piece)
+	}
+	assert.Greater(t, len(pieces), 1)
+	assert.Equal(t, tb.GetLinesRawContent(), strings.Join(pieces, ""))
+
+	lines := map[int]string{}
+	for n, line := range tb.Lines(0, 10) {
+		lines[n] = line
+	}
+	assert.Equal(t, map[int]string{1: "a中", 2: "b文", 3: "c"}, lines)
+	order := []int{}
+	for n := range tb.LinesReverse(2, 3) {
+		order = append(order, n)
+	}
+	assert.Equal(t, []int{3, 2}, order)
+
+	type item struct {
+		offset int
+		r      rune
+	}
+	var forward, backward []item
+	for off, r := range tb.Runes(1) {
+		forward = append(forward, item{off, r})
+	}
+	assert.Equal(t, []item{{1, '中'}, {4, '\n'}, {5, 'b'}, {6, '文'}, {9, '\n'}, {10, 'c'}}, forward)
+	for off, r := range tb.RunesReverse(9) {
+		backward = append(backward, item{off, r})
+	}
+	assert.Equal(t, []item{{6, '文'}, {5, 'b'}, {4, '\n'}, {1, '中'}, {0, 'a'}}, backward)
+
+	// Breaking out early stops the iteration.
+	count := 0
+	for range tb.Runes(0) {
+		count++
+		if count == 2 {
+			break
+		}
+	}
+	assert.Equal(t, 2, count)
+
+	// Stray bytes are reported one by one as RuneError.
+	bad := createEmptyTextBuffer()
+	bad.Insert(0, "\x80x\xe4", false)
+	forward, backward = nil, nil
+	for off, r := range bad.Runes(0) {
+		forward = append(forward, item{off, r})
+	}
+	assert.Equal(t, []item{{0, utf8.RuneError}, {1, 'x'}, {2, utf8.RuneError}}, forward)
+	for off, r := range bad.RunesReverse(bad.GetLength()) {
+		backward = append(backward, item{off, r})
+	}
+	assert.Equal(t, []item{{2, utf8.RuneError}, {1, 'x'}, {0, utf8.RuneError}}, backward)
+
+	matches, err := tb.Matches(`\p{Han}`, SearchOptions{IsRegex: true})
+	assert.NoError(t, err)
+	var found []int
+	for m := range matches {
+		found = append(found, m.StartOffset)
+		if len(found) == 1 {
+			assert.Equal(t, *common.NewRange(1, 2, 1, 5), *m.Range)
+		}
+	}
+	assert.Equal(t, []int{1, 6}, found)
+	_, err = tb.Matches("(", SearchOptions{IsRegex: true})
+	assert.Error(t, err)
+}
+
+func TestFindNextAndPreviousMatch(t *testing.T) {
+	tb := createEmptyTextBuffer()
+	tb.Insert(0, "foo bar\r\nfoobar foo\n", false)
+	tb.Insert(0, "x foo\rbar ", false)
+	tb.Insert(tb.GetLength(), "end foo", false)
+	// Lines: "x foo", "bar foo bar", "foobar foo", "end foo"
+	pos := func(line, column int) common.Position {
+		return common.Position{LineNumber: line, Column: column}
+	}
+	find := func(next bool, query string, from common.Position, opts SearchOptions) *common.Range {
+		var m *FindMatch
+		var err error
+		if next {
+			m, err = tb.FindNextMatch(query, from, opts)
+		} else {
+			m, err = tb.FindPreviousMatch(query, from, opts)
+		}
+		require.NoError(t, err)
+		if m == nil {
+			return nil
+		}
+		assert.Equal(t, tb.GetOffsetAt(m.Range.StartLineNumber, m.Range.StartColumn), m.StartOffset)
+		assert.Equal(t, tb.GetOffsetAt(m.Range.EndLineNumber, m.Range.EndColumn), m.EndOffset)
+		return m.Range
+	}
+
+	assert.Equal(t, common.NewRange(1, 3, 1, 6), find(true, "foo", pos(1, 3), SearchOptions{}))
+	assert.Equal(t, common.NewRange(2, 5, 2, 8), find(true, "foo", pos(1, 4), SearchOptions{}))
+	assert.Equal(t, common.NewRange(3, 8, 3, 11), find(true, "foo", pos(3, 2), SearchOptions{}))
+	// Wraps around the end of the document.
+	assert.Equal(t, common.NewRange(1, 3, 1, 6), find(true, "foo", pos(4, 6), SearchOptions{}))
+	assert.Equal(t, common.NewRange(3, 8, 3, 11), find(true, "foo", pos(3, 1), SearchOptions{WholeWord: true}))
+
+	assert.Equal(t, common.NewRange(2, 5, 2, 8), find(false, "foo", pos(2, 8), SearchOptions{}))
+	assert.Equal(t, common.NewRange(1, 3, 1, 6), find(false, "foo", pos(2, 7), SearchOptions{}))
+	assert.Equal(t, common.NewRange(3, 1, 3, 4), find(false, "foo", pos(3, 7), SearchOptions{}))
+	assert.Equal(t, common.NewRange(2, 5, 2, 8), find(false, "foo", pos(3, 7), SearchOptions{WholeWord: true}))
+	// Wraps around the start of the document.
+	assert.Equal(t, common.NewRange(4, 5, 4, 8), find(false, "foo", pos(1, 5), SearchOptions{}))
+	assert.Equal(t, common.NewRange(2, 9, 2, 12), find(false, `b\w+$`, pos(2, 11), SearchOptions{IsRegex: true}))
+	assert.Equal(t, common.NewRange(2, 1, 2, 4), find(false, `^b\w+`, pos(2, 11), SearchOptions{IsRegex: true}))
+
+	// Multi-line queries.
+	assert.Equal(t, common.NewRange(2, 9, 3, 4), find(true, `bar\r?\nfoo`, pos(1, 1), SearchOptions{IsRegex: true}))
+	assert.Equal(t, common.NewRange(2, 9, 3, 4), find(false, `bar\r?\nfoo`, pos(1, 1), SearchOptions{IsRegex: true}))
+	assert.Nil(t, find(false, `foo\nbaz`, pos(1, 1), SearchOptions{IsRegex: true}))
+
+	assert.Nil(t, find(true, "missing", pos(2, 2), SearchOptions{}))
+	assert.Nil(t, find(false, "missing", pos(2, 2), SearchOptions{}))
+	m, err := tb.FindPreviousMatch(`(\w+) foo`, pos(4, 1), SearchOptions{IsRegex: true, CaptureMatches: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foobar foo", "foobar"}, m.Matches)
+	_, err = tb.FindNextMatch("(", pos(1, 1), SearchOptions{IsRegex: true})
+	assert.Error(t, err)
+}
+
+func TestLinesBefore(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	alphabet := []string{"a", "é", "\n", "\r", "\r\n"}
+	for iter := 0; iter < 50; iter++ {
+		tb := createEmptyTextBuffer()
+		for i := 0; i < 20; i++ {
+			var sb strings.Builder
+			for j := rng.Intn(6); j >= 0; j-- {
+				sb.WriteString(alphabet[rng.Intn(len(alphabet))])
+			}
+			tb.Insert(rng.Intn(tb.GetLength()+1), sb.String(), false)
+		}
+
+		lineNumber := tb.GetLineCount()
+		for start, line := range tb.linesBefore(tb.GetLength()) {
+			require.GreaterOrEqual(t, lineNumber, 1)
+			assert.Equal(t, tb.GetLineContent(lineNumber), line)
+			assert.Equal(t, tb.GetOffsetAt(lineNumber, 1), start)
+			lineNumber--
+		}
+		assert.Equal(t, 0, lineNumber)
+	}
+}
+
+func TestReverseIterationWithCachedNode(t *testing.T) {
+	newBuffer := func() *PieceTreeBase {
+		tb := createEmptyTextBuffer()
+		tb.Insert(0, "c\n", false)
+		tb.Insert(0, "ab", false)
+		// Cache the first piece; the cache also answers for the offset at its end.
+		assert.Equal(t, "a", tb.GetValueInOffsetRange(0, 1))
+		return tb
+	}
+
+	tb := newBuffer()
+	var runes []rune
+	for _, r := range tb.RunesReverse(2) {
+		runes = append(runes, r)
+	}
+	assert.Equal(t, []rune("ba"), runes)
+
+	tb = newBuffer()
+	var chunks []string
+	for start, chunk := range tb.chunksBefore(3) {
+		assert.Equal(t, tb.GetValueInOffsetRange(start, start+len(chunk)), chunk)
+		chunks = append(chunks, chunk)
+	}
+	assert.Equal(t, []string{"c", "ab"}, chunks)
+
+	tb = newBuffer()
+	match, err := tb.FindPreviousMatch("a", common.Position{LineNumber: 1, Column: 2}, SearchOptions{})
+	require.NoError(t, err)
+	require.NotNil(t, match)
+	assert.Equal(t, "[1,1 -> 1,2]", match.Range.String())
+
+	// Forward and reverse iteration agree with the text from every offset,
+	// whichever piece the cache holds.
+	rng := rand.New(rand.NewSource(49))
+	tb = createEmptyTextBuffer()
+	for i := 0; i < 30; i++ {
+		tb.Insert(rng.Intn(tb.GetLength()+1), []string{"x", "yz\n", "é", "\r\n", "中"}[rng.Intn(5)], false)
+	}
+	text := tb.GetLinesRawContent()
+	for offset := 0; offset <= len(text); offset++ {
+		tb.GetValueInOffsetRange(rng.Intn(len(text)), len(text))
+
+		var sb strings.Builder
+		for start, chunk := range tb.chunksBefore(offset) {
+			require.Equal(t, text[start:start+len(chunk)], chunk, "offset %d", offset)
+			sb.WriteString(chunk)
+		}
+		require.Equal(t, offset, sb.Len(), "offset %d", offset)
+
+		sb.Reset()
+		for start, chunk := range tb.chunksFrom(offset) {
+			require.Equal(t, text[start:start+len(chunk)], chunk, "offset %d", offset)
+			sb.WriteString(chunk)
+		}
+		require.Equal(t, len(text)-offset, sb.Len(), "offset %d", offset)
+	}
+}
+
+func TestSearchWithWarmCaches(t *testing.T) {
+	tb := createEmptyTextBuffer()
+	tb.Insert(0, "c\nfoo", false)
+	tb.Insert(0, "foo b", false)
+	tb.Insert(5, "ar\n", false)
+	// Lines: "foo bar", "c", "foo"
+	pos := func(line, column int) common.Position {
+		return common.Position{LineNumber: line, Column: column}
+	}
+
+	// Every piece boundary is cached in turn before searching around it.
+	for offset := 0; offset <= tb.GetLength(); offset++ {
+		tb.GetPositionAt(offset)
+		tb.GetValueInOffsetRange(max(offset-1, 0), offset)
+		_, err := tb.FindMatches("o", SearchOptions{}, 0)
+		require.NoError(t, err)
+
+		m, err := tb.FindPreviousMatch("foo", pos(2, 2), SearchOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "[1,1 -> 1,4]", m.Range.String(), "offset %d", offset)
+		m, err = tb.FindPreviousMatch("bar", pos(1, 8), SearchOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "[1,5 -> 1,8]", m.Range.String(), "offset %d", offset)
+		m, err = tb.FindNextMatch("foo", pos(1, 2), SearchOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "[3,1 -> 3,4]", m.Range.String(), "offset %d", offset)
+	}
+	// Anchors still see the text before an earlier match on the same line.
+	matches, err := tb.FindMatches(`^\w`, SearchOptions{IsRegex: true}, 0)
+	require.NoError(t, err)
+	require.Len(t, matches, 3)
+	assert.Equal(t, "[2,1 -> 2,2]", matches[1].Range.String())
+	m, err := tb.FindPreviousMatch(`^\w`, pos(1, 8), SearchOptions{IsRegex: true})
+	require.NoError(t, err)
+	assert.Equal(t, "[1,1 -> 1,2]", m.Range.String())
+}
+
+func TestFindNextAndPreviousMatchFuzz(t *testing.T) {
+	rng := rand.New(rand.NewSource(50))
+	alphabet := []string{"a", "b", "é", "中", " ", "\n", "\r\n", "\r"}
+	queries := []struct {
+		query string
+		regex bool
+	}{
+		{"a", false}, {"ab", false}, {"é", false}, {"中a", false}, {"a b", false},
+		{"a+", true}, {"[bé]a", true}, {"^a", true}, {"a$", true}, {`\w+`, true},
+	}
+	lineBreak := regexp.MustCompile(`\r\n|\r|\n`)
+
+	for iter := 0; iter < 200; iter++ {
+		tb := createEmptyTextBuffer()
+		for i := rng.Intn(30); i >= 0; i-- {
+			var sb strings.Builder
+			for j := rng.Intn(5); j >= 0; j-- {
+				sb.WriteString(alphabet[rng.Intn(len(alphabet))])
+			}
+			// Inserting at a byte offset may split a character or CRLF, which the search must cope with.
+			tb.Insert(rng.Intn(tb.GetLength()+1), sb.String(), false)
+		}
+		q := queries[rng.Intn(len(queries))]
+		opts := SearchOptions{IsRegex: q.regex, MatchCase: true}
+
+		// Brute force: scan every line forward for non-empty matches.
+		pattern := q.query
+		if !q.regex {
+			pattern = regexp.QuoteMeta(pattern)
+		}
+		re := regexp.MustCompile("(?m)" + pattern)
+		var matches []common.Range
+		for i, line := range lineBreak.Split(tb.GetLinesRawContent(), -1) {
+			for _, loc := range re.FindAllStringIndex(line, -1) {
+				if loc[1] > loc[0] {
+					matches = append(matches, *common.NewRange(i+1, loc[0]+1, i+1, loc[1]+1))
+				}
+			}
+		}
+
+		for n := 0; n < 10; n++ {
+			from := tb.ValidatePosition(common.Position{LineNumber: rng.Intn(tb.GetLineCount()) + 1, Column: rng.Intn(12) + 1})
+			// Warm the caches with unrelated lookups between searches.
+			tb.GetPositionAt(rng.Intn(tb.GetLength() + 1))
+			tb.GetValueInOffsetRange(rng.Intn(tb.GetLength()+1), tb.GetLength())
+
+			var next, prev *common.Range
+			for i := range matches {
+				m := &matches[i]
+				if next == nil && !common.PositionIsBefore(m.GetStartPosition(), &from) {
+					next = m
+				}
+				if common.PositionIsBeforeOrEqual(m.GetEndPosition(), &from) {
+					prev = m
+				}
+			}
+			if len(matches) > 0 {
+				if next == nil {
+					next = &matches[0]
+				}
+				if prev == nil {
+					prev = &matches[len(matches)-1]
+				}
+			}
+
+			got, err := tb.FindNextMatch(q.query, from, opts)
+			require.NoError(t, err)
+			if next == nil {
+				assert.Nil(t, got, "next %q from %v in %q", q.query, from, tb.GetLinesRawContent())
+			} else if assert.NotNil(t, got, "next %q from %v in %q", q.query, from, tb.GetLinesRawContent()) {
+				assert.Equal(t, next, got.Range, "next %q from %v in %q", q.query, from, tb.GetLinesRawContent())
+			}
+
+			got, err = tb.FindPreviousMatch(q.query, from, opts)
+			require.NoError(t, err)
+			if prev == nil {
+				assert.Nil(t, got, "previous %q from %v in %q", q.query, from, tb.GetLinesRawContent())
+			} else if assert.NotNil(t, got, "previous %q from %v in %q", q.query, from, tb.GetLinesRawContent()) {
+				assert.Equal(t, prev, got.Range, "previous %q from %v in %q", q.query, from, tb.GetLinesRawContent())
+			}
+		}
+	}
+}
+
+func TestNewPieceTree(t *testing.T) {
+	tree := NewPieceTree("\ufeffa\r\nb\rc\n")
+	assert.Equal(t, "a\r\nb\rc\n", tree.GetLinesRawContent(), "line endings are kept and the BOM is dropped")
+	assert.Equal(t, 4, tree.GetLineCount())
+	assert.Equal(t, "\r\n", tree.GetEOL(), "the EOL follows the text")
+	assert.Equal(t, "\n", NewPieceTree("").GetEOL())
+}
+
+func TestBuilderEmitsHeldBackCROnce(t *testing.T) {
+	tests := []struct {
+		chunks []string
+		lines  int
+	}{
+		{[]string{"\r"}, 2},
+		{[]string{"a\r"}, 2},
+		{[]string{"\r\r"}, 3},
+		{[]string{"a\r", "\r"}, 3},
+		{[]string{"a\r", "\r", "b"}, 3},
+		{[]string{"a\r", "\nb"}, 2},
+		{[]string{"a\r", "\r\n", "b"}, 3},
+	}
+	for _, tt := range tests {
+		builder := NewPieceTreeTextBufferBuilder()
+		for _, chunk := range tt.chunks {
+			builder.AcceptChunk(chunk)
+		}
+		tb := builder.Finish(false).Create(LF)
+		text := strings.Join(tt.chunks, "")
+		assert.Equal(t, text, tb.GetLinesRawContent(), "%q", tt.chunks)
+		assert.Equal(t, tt.lines, tb.GetLineCount(), "%q", tt.chunks)
+	}
+}
@@ -0,0 +1,148 @@
+package buffer
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/kebaren/textbuffer/pkg/common"
+)
+
+var (
+	// ErrOutOfRange 偏移量、行号或列号超出文档
+	ErrOutOfRange = errors.New("buffer: out of range")
+	// ErrNotBoundary 偏移量或列号位于多字节 UTF-8 字符或 CRLF 的中间
+	ErrNotBoundary = errors.New("buffer: not on a character boundary")
+	// ErrInvalidRange 范围的结束位置在开始位置之前
+	ErrInvalidRange = errors.New("buffer: range end is before its start")
+)
+
+// snapColumn 把行内的列号限制在 [1, len(line)+1] 内，落在多字节字符中间时向前（forward 为 false）或向后移动到字符边界
+func snapColumn(line string, column int, forward bool) int {
+	index := min(max(column-1, 0), len(line))
+	for index > 0 && index < len(line) && !utf8.RuneStart(line[index]) {
+		if forward {
+			index++
+		} else {
+			index--
+		}
+	}
+	return index + 1
+}
+
+// ValidatePosition 返回离位置最近的合法位置
+// 行号被限制到 [1, 行数]，列号被限制到 [1, 行长度+1]（换行符之前），落在多字节字符中间的列移动到字符开头
+func (t *PieceTreeBase) ValidatePosition(pos common.Position) common.Position {
+	line := min(max(pos.LineNumber, 1), t.GetLineCount())
+	return common.Position{LineNumber: line, Column: snapColumn(t.GetLineContent(line), pos.Column, false)}
+}
+
+// ValidateRange 返回两端都经过 ValidatePosition 的范围，结束位置在开始位置之前时交换两者
+func (t *PieceTreeBase) ValidateRange(r common.Range) common.Range {
+	start := t.ValidatePosition(common.Position{LineNumber: r.StartLineNumber, Column: r.StartColumn})
+	end := t.ValidatePosition(common.Position{LineNumber: r.EndLineNumber, Column: r.EndColumn})
+	return *common.NewRange(start.LineNumber, start.Column, end.LineNumber, end.Column)
+}
+
+// ModifyPosition 返回位置移动 offset 字节之后的合法位置，超出文档时限制在文档的开头或末尾
+// 结果落在多字节字符或换行符中间时，沿移动的方向移动到最近的边界
+func (t *PieceTreeBase) ModifyPosition(pos common.Position, offset int) common.Position {
+	pos = t.ValidatePosition(pos)
+	target := min(max(t.GetOffsetAt(pos.LineNumber, pos.Column)+offset, 0), t.GetLength())
+	result := t.GetPositionAt(target)
+	line := t.GetLineContent(result.LineNumber)
+	if result.Column > len(line)+1 {
+		// 位于 CRLF 中间
+		if offset > 0 && result.LineNumber < t.GetLineCount() {
+			return common.Position{LineNumber: result.LineNumber + 1, Column: 1}
+		}
+		return common.Position{LineNumber: result.LineNumber, Column: len(line) + 1}
+	}
+	return common.Position{LineNumber: result.LineNumber, Column: snapColumn(line, result.Column, offset > 0)}
+}
+
+// isBoundary 判断偏移量是否位于字符边界上，不在多字节 UTF-8 字符或 CRLF 的中间
+func (t *PieceTreeBase) isBoundary(offset int) bool {
+	if offset <= 0 || offset >= t.length {
+		return true
+	}
+	around := t.GetValueInOffsetRange(offset-1, offset+1)
+	return utf8.RuneStart(around[1]) && !(around[0] == '\r' && around[1] == '\n')
+}
+
+// CheckOffset 检查偏移量是否在 [0, 长度] 内并且位于字符边界上
+// 超出文档时返回包装了 ErrOutOfRange 的错误，位于字符中间时返回包装了 ErrNotBoundary 的错误
+func (t *PieceTreeBase) CheckOffset(offset int) error {
+	if offset < 0 || offset > t.length {
+		return fmt.Errorf("%w: offset %d not in [0, %d]", ErrOutOfRange, offset, t.length)
+	}
+	if !t.isBoundary(offset) {
+		return fmt.Errorf("%w: offset %d", ErrNotBoundary, offset)
+	}
+	return nil
+}
+
+// CheckPosition 检查位置是否在文档内并且列号位于字符边界上
+// 超出文档时返回包装了 ErrOutOfRange 的错误，位于多字节字符中间时返回包装了 ErrNotBoundary 的错误
+func (t *PieceTreeBase) CheckPosition(lineNumber, column int) error {
+	if lineNumber < 1 || lineNumber > t.GetLineCount() {
+		return fmt.Errorf("%w: line %d not in [1, %d]", ErrOutOfRange, lineNumber, t.GetLineCount())
+	}
+	line := t.GetLineContent(lineNumber)
+	if column < 1 || column > len(line)+1 {
+		return fmt.Errorf("%w: column %d not in [1, %d] on line %d", ErrOutOfRange, column, len(line)+1, lineNumber)
+	}
+	if column <= len(line) && !utf8.RuneStart(line[column-1]) {
+		return fmt.Errorf("%w: column %d on line %d", ErrNotBoundary, column, lineNumber)
+	}
+	return nil
+}
+
+// CheckRange 检查范围的两端是否都是合法的位置，并且结束位置不在开始位置之前
+// 结束位置在开始位置之前时返回包装了 ErrInvalidRange 的错误
+func (t *PieceTreeBase) CheckRange(r common.Range) error {
+	if err := t.CheckPosition(r.StartLineNumber, r.StartColumn); err != nil {
+		return err
+	}
+	if err := t.CheckPosition(r.EndLineNumber, r.EndColumn); err != nil {
+		return err
+	}
+	if common.PositionIsBefore(r.GetEndPosition(), r.GetStartPosition()) {
+		return fmt.Errorf("%w: %s", ErrInvalidRange, r.String())
+	}
+	return nil
+}
+
+// InsertChecked 在偏移量处插入文本，偏移量超出文档或不在字符边界上时返回错误并且不做修改
+func (t *PieceTreeBase) InsertChecked(offset int, value string) error {
+	if err := t.CheckOffset(offset); err != nil {
+		return err
+	}
+	t.Insert(offset, value, t.isEOLNormalizedText(value))
+	return nil
+}
+
+// DeleteChecked 删除 [offset, offset+length) 范围内的内容，范围超出文档或两端不在字符边界上时返回错误并且不做修改
+func (t *PieceTreeBase) DeleteChecked(offset, length int) error {
+	if length < 0 {
+		return fmt.Errorf("%w: negative length %d", ErrOutOfRange, length)
+	}
+	if err := t.CheckOffset(offset); err != nil {
+		return err
+	}
+	if err := t.CheckOffset(offset + length); err != nil {
+		return err
+	}
+	t.Delete(offset, length)
+	return nil
+}
+
+// GetValueInRangeChecked 返回范围内的文本，eol 不为空时把换行符替换为 eol
+// 位置超出文档时返回 ErrOutOfRange，列号位于多字节字符中间时返回 ErrNotBoundary，
+// 结束位置在开始位置之前时返回 ErrInvalidRange
+func (t *PieceTreeBase) GetValueInRangeChecked(r common.Range, eol string) (string, error) {
+	if err := t.CheckRange(r); err != nil {
+		return "", err
+	}
+	return t.GetValueInRange(r.StartLineNumber, r.StartColumn, r.EndLineNumber, r.EndColumn, eol), nil
+}
@@ -0,0 +1,23 @@
+package buffer
+
+import "github.com/kebaren/textbuffer/pkg/common"
+
+// GetVisibleColumnFromPosition 返回位置的显示列（从 0 开始），制表符展开到 tabSize 的倍数，宽字符占两列
+func (t *PieceTreeBase) GetVisibleColumnFromPosition(position common.Position, tabSize int) int {
+	if position.LineNumber < 1 || position.LineNumber > t.GetLineCount() {
+		return 0
+	}
+	return common.VisibleColumnFromColumn(t.GetLineContent(position.LineNumber), position.Column, tabSize)
+}
+
+// GetPositionFromVisibleColumn 返回行内最接近显示列 visibleColumn 的位置，结果总是在字素簇边界上
+func (t *PieceTreeBase) GetPositionFromVisibleColumn(lineNumber, visibleColumn, tabSize int) common.Position {
+	if lineCount := t.GetLineCount(); lineNumber > lineCount {
+		lineNumber = lineCount
+	}
+	if lineNumber < 1 {
+		lineNumber = 1
+	}
+	line := t.GetLineContent(lineNumber)
+	return common.Position{LineNumber: lineNumber, Column: common.ColumnFromVisibleColumn(line, visibleColumn, tabSize)}
+}
@@ -0,0 +1,155 @@
+package buffer
+
+import (
+	"unicode/utf8"
+
+	"github.com/kebaren/textbuffer/pkg/common"
+)
+
+// WordAtPosition 位置处的单词
+type WordAtPosition struct {
+	// Word 单词文本
+	Word string
+	// StartColumn 单词开始的列（从 1 开始）
+	StartColumn int
+	// EndColumn 单词结束的列（不包含）
+	EndColumn int
+}
+
+// wordSegment 一行中类别相同的一段连续字符（不包含空白），[start, end) 是字节下标
+type wordSegment struct {
+	start, end int
+	class      common.WordCharacterClass
+}
+
+// isWord 判断这一段是否为单词（分隔符组成的段不是单词）
+func (s wordSegment) isWord() bool {
+	return s.class == common.WordCharacterRegular || s.class == common.WordCharacterCJK
+}
+
+// wordSegments 把一行拆分为类别相同的字符段，空白字符不属于任何段
+func wordSegments(line string, classifier *common.WordClassifier) []wordSegment {
+	var segments []wordSegment
+	for i := 0; i < len(line); {
+		r, size := utf8.DecodeRuneInString(line[i:])
+		class := classifier.Get(r)
+		if class != common.WordCharacterWhitespace {
+			if n := len(segments); n > 0 && segments[n-1].end == i && segments[n-1].class == class {
+				segments[n-1].end = i + size
+			} else {
+				segments = append(segments, wordSegment{start: i, end: i + size, class: class})
+			}
+		}
+		i += size
+	}
+	return segments
+}
+
+// classifierOrDefault classifier 为 nil 时返回使用默认分隔符的分类器
+func classifierOrDefault(classifier *common.WordClassifier) *common.WordClassifier {
+	if classifier == nil {
+		return common.NewWordClassifier("")
+	}
+	return classifier
+}
+
+// clampColumn 把列号限制在行内，返回对应的字节下标
+func clampColumn(line string, column int) int {
+	if column < 1 {
+		return 0
+	}
+	if column > len(line)+1 {
+		return len(line)
+	}
+	return column - 1
+}
+
+// GetWordAtPosition 返回包含位置或与位置相邻的单词，光标两侧都是单词时选择前面的单词，没有时返回 nil
+// classifier 为 nil 时使用默认分隔符
+func (t *PieceTreeBase) GetWordAtPosition(position common.Position, classifier *common.WordClassifier) *WordAtPosition {
+	if position.LineNumber < 1 || position.LineNumber > t.GetLineCount() {
+		return nil
+	}
+	line := t.GetLineContent(position.LineNumber)
+	index := clampColumn(line, position.Column)
+	for _, seg := range wordSegments(line, classifierOrDefault(classifier)) {
+		if seg.start > index {
+			break
+		}
+		if seg.isWord() && index <= seg.end {
+			return &WordAtPosition{
+				Word:        line[seg.start:seg.end],
+				StartColumn: seg.start + 1,
+				EndColumn:   seg.end + 1,
+			}
+		}
+	}
+	return nil
+}
+
+// GetWordUntilPosition 返回位置处的单词从开头到位置的部分，没有单词时返回位置处的空单词
+func (t *PieceTreeBase) GetWordUntilPosition(position common.Position, classifier *common.WordClassifier) WordAtPosition {
+	word := t.GetWordAtPosition(position, classifier)
+	if word == nil {
+		return WordAtPosition{StartColumn: position.Column, EndColumn: position.Column}
+	}
+	column := position.Column
+	if column > word.EndColumn {
+		column = word.EndColumn
+	}
+	if column < word.StartColumn {
+		column = word.StartColumn
+	}
+	return WordAtPosition{
+		Word:        word.Word[:column-word.StartColumn],
+		StartColumn: word.StartColumn,
+		EndColumn:   column,
+	}
+}
+
+// NextWordStart 返回位置之后下一个单词或分隔符段的开始位置，可以跨行
+// 之后没有单词时返回文档末尾
+func (t *PieceTreeBase) NextWordStart(position common.Position, classifier *common.WordClassifier) common.Position {
+	classifier = classifierOrDefault(classifier)
+	lineCount := t.GetLineCount()
+	if position.LineNumber < 1 {
+		position = common.Position{LineNumber: 1, Column: 1}
+	}
+	for lineNumber := position.LineNumber; lineNumber <= lineCount; lineNumber++ {
+		line := t.GetLineContent(lineNumber)
+		index := -1
+		if lineNumber == position.LineNumber {
+			index = clampColumn(line, position.Column)
+		}
+		for _, seg := range wordSegments(line, classifier) {
+			if seg.start > index {
+				return common.Position{LineNumber: lineNumber, Column: seg.start + 1}
+			}
+		}
+	}
+	return common.Position{LineNumber: lineCount, Column: t.GetLineLength(lineCount) + 1}
+}
+
+// PrevWordEnd 返回位置之前上一个单词或分隔符段的结束位置，可以跨行
+// 之前没有单词时返回文档开头
+func (t *PieceTreeBase) PrevWordEnd(position common.Position, classifier *common.WordClassifier) common.Position {
+	classifier = classifierOrDefault(classifier)
+	lineCount := t.GetLineCount()
+	if position.LineNumber > lineCount {
+		position = common.Position{LineNumber: lineCount, Column: t.GetLineLength(lineCount) + 1}
+	}
+	for lineNumber := position.LineNumber; lineNumber >= 1; lineNumber-- {
+		line := t.GetLineContent(lineNumber)
+		index := len(line) + 1
+		if lineNumber == position.LineNumber {
+			index = clampColumn(line, position.Column)
+		}
+		segments := wordSegments(line, classifier)
+		for i := len(segments) - 1; i >= 0; i-- {
+			if segments[i].end < index {
+				return common.Position{LineNumber: lineNumber, Column: segments[i].end + 1}
+			}
+		}
+	}
+	return common.Position{LineNumber: 1, Column: 1}
+}
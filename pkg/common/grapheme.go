@@ -0,0 +1,217 @@
+package common
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// 字素簇分割
+// 实现了 UAX #29 扩展字素簇规则的常用子集：CRLF、控制字符、组合标记、
+// 零宽连接符连接的表情符号序列、区域指示符（国旗）对以及韩文音节，
+// 不处理 Prepend 类字符
+
+// hangulType 韩文字母类型
+type hangulType int
+
+const (
+	hangulNone hangulType = iota
+	hangulL
+	hangulV
+	hangulT
+	hangulLV
+	hangulLVT
+)
+
+// zeroWidthJoiner 零宽连接符 U+200D
+const zeroWidthJoiner = 0x200D
+
+// getHangulType 返回韩文字母类型
+func getHangulType(r rune) hangulType {
+	switch {
+	case (r >= 0x1100 && r <= 0x115F) || (r >= 0xA960 && r <= 0xA97C):
+		return hangulL
+	case (r >= 0x1160 && r <= 0x11A7) || (r >= 0xD7B0 && r <= 0xD7C6):
+		return hangulV
+	case (r >= 0x11A8 && r <= 0x11FF) || (r >= 0xD7CB && r <= 0xD7FB):
+		return hangulT
+	case r >= 0xAC00 && r <= 0xD7A3:
+		if (r-0xAC00)%28 == 0 {
+			return hangulLV
+		}
+		return hangulLVT
+	}
+	return hangulNone
+}
+
+// isGraphemeControl 判断字符是否为控制字符（前后总是断开）
+func isGraphemeControl(r rune) bool {
+	if r == zeroWidthJoiner || r == 0x200C {
+		return false
+	}
+	return unicode.Is(unicode.Cc, r) || r == 0x2028 || r == 0x2029 ||
+		(unicode.Is(unicode.Cf, r) && !isGraphemeExtend(r))
+}
+
+// isGraphemeExtend 判断字符是否附着在前一个字符上
+func isGraphemeExtend(r rune) bool {
+	switch {
+	case r == 0x200C:
+		return true
+	case r >= 0xFE00 && r <= 0xFE0F:
+		// 变体选择符
+		return true
+	case r >= 0xE0020 && r <= 0xE007F:
+		// 标签字符（用于子区域旗帜）
+		return true
+	case r >= 0x1F3FB && r <= 0x1F3FF:
+		// 表情肤色修饰符
+		return true
+	case r >= 0xE0100 && r <= 0xE01EF:
+		return true
+	}
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc)
+}
+
+// isRegionalIndicator 判断字符是否为区域指示符
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// isExtendedPictographic 判断字符是否为表情符号（近似 Extended_Pictographic 属性）
+func isExtendedPictographic(r rune) bool {
+	switch {
+	case r == 0x00A9 || r == 0x00AE || r == 0x203C || r == 0x2049 || r == 0x2122 || r == 0x2139:
+		return true
+	case r >= 0x2194 && r <= 0x21AA:
+		return true
+	case r >= 0x231A && r <= 0x23FF:
+		return true
+	case r >= 0x25AA && r <= 0x27BF:
+		return true
+	case r >= 0x2934 && r <= 0x2935:
+		return true
+	case r >= 0x2B05 && r <= 0x2B55:
+		return true
+	case r == 0x3030 || r == 0x303D || r == 0x3297 || r == 0x3299:
+		return true
+	case r >= 0x1F000 && r <= 0x1F1E5:
+		return true
+	case r >= 0x1F200 && r <= 0x1F3FA:
+		return true
+	case r >= 0x1F400 && r <= 0x1FAFF:
+		return true
+	case r >= 0x1FC00 && r <= 0x1FFFD:
+		return true
+	}
+	return false
+}
+
+// hangulJoins 判断两个韩文字母之间是否不能断开
+func hangulJoins(prev, next hangulType) bool {
+	switch prev {
+	case hangulL:
+		return next == hangulL || next == hangulV || next == hangulLV || next == hangulLVT
+	case hangulLV, hangulV:
+		return next == hangulV || next == hangulT
+	case hangulLVT, hangulT:
+		return next == hangulT
+	}
+	return false
+}
+
+// NextGraphemeBoundary 返回从字节偏移量 offset 开始的字素簇的结束位置
+// offset 必须位于字素簇边界上，offset 大于等于 len(s) 时返回 len(s)
+func NextGraphemeBoundary(s string, offset int) int {
+	if offset >= len(s) {
+		return len(s)
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	first, size := utf8.DecodeRuneInString(s[offset:])
+	i := offset + size
+	if first == '\r' {
+		if i < len(s) && s[i] == '\n' {
+			return i + 1
+		}
+		return i
+	}
+	if isGraphemeControl(first) {
+		return i
+	}
+
+	prev := first
+	prevHangul := getHangulType(first)
+	pictographic := isExtendedPictographic(first)
+	regionalCount := 0
+	if isRegionalIndicator(first) {
+		regionalCount = 1
+	}
+
+	for i < len(s) {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		hangul := getHangulType(r)
+		join := false
+		switch {
+		case isGraphemeControl(r) || r == '\r' || r == '\n':
+			join = false
+		case isGraphemeExtend(r) || r == zeroWidthJoiner:
+			join = true
+		case prev == zeroWidthJoiner && pictographic && isExtendedPictographic(r):
+			join = true
+		case isRegionalIndicator(r) && isRegionalIndicator(prev) && regionalCount%2 == 1:
+			join = true
+			regionalCount++
+		case hangulJoins(prevHangul, hangul):
+			join = true
+		}
+		if !join {
+			break
+		}
+
+		if !isGraphemeExtend(r) && r != zeroWidthJoiner {
+			pictographic = isExtendedPictographic(r)
+		}
+		if isGraphemeExtend(r) {
+			hangul = hangulNone
+		}
+		prev = r
+		prevHangul = hangul
+		i += size
+	}
+	return i
+}
+
+// PrevGraphemeBoundary 返回字节偏移量 offset 之前的字素簇的开始位置
+// offset 小于等于 0 时返回 0
+func PrevGraphemeBoundary(s string, offset int) int {
+	if offset <= 0 {
+		return 0
+	}
+	if offset > len(s) {
+		offset = len(s)
+	}
+
+	// 可打印的 ASCII 字符之前总是字素簇边界，从最近的一个开始向前扫描即可
+	start := offset - 1
+	for start > 0 && (s[start] < 0x20 || s[start] > 0x7E) {
+		start--
+	}
+
+	boundary := start
+	for i := start; i < offset; {
+		boundary = i
+		i = NextGraphemeBoundary(s, i)
+	}
+	return boundary
+}
+
+// GraphemeCount 返回字符串中字素簇的数量
+func GraphemeCount(s string) int {
+	count := 0
+	for i := 0; i < len(s); i = NextGraphemeBoundary(s, i) {
+		count++
+	}
+	return count
+}
@@ -0,0 +1,50 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// segments splits s into grapheme clusters.
+func segments(s string) []string {
+	var result []string
+	for i := 0; i < len(s); {
+		next := NextGraphemeBoundary(s, i)
+		result = append(result, s[i:next])
+		i = next
+	}
+	return result
+}
+
+func TestGraphemeSegmentation(t *testing.T) {
+	cases := []struct {
+		text     string
+		expected []string
+	}{
+		{"abc", []string{"a", "b", "c"}},
+		{"a\r\nb", []string{"a", "\r\n", "b"}},
+		{"éx", []string{"é", "x"}},
+		{"世界", []string{"世", "界"}},
+		{"👍🏽!", []string{"👍🏽", "!"}},
+		{"👨‍👩‍👧x", []string{"👨‍👩‍👧", "x"}},
+		{"🇯🇵🇫🇷🇩", []string{"🇯🇵", "🇫🇷", "🇩"}},
+		{"각가", []string{"각", "가"}},
+		{"❤️", []string{"❤️"}},
+		{"a‍b", []string{"a‍", "b"}},
+		{"\t́", []string{"\t", "́"}},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, segments(c.text), "%q", c.text)
+		assert.Equal(t, len(c.expected), GraphemeCount(c.text), "%q", c.text)
+
+		// Walking backwards must find the same boundaries.
+		var backward []string
+		for i := len(c.text); i > 0; {
+			prev := PrevGraphemeBoundary(c.text, i)
+			backward = append([]string{c.text[prev:i]}, backward...)
+			i = prev
+		}
+		assert.Equal(t, c.expected, backward, "%q", c.text)
+	}
+}
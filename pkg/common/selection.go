@@ -0,0 +1,107 @@
+package common
+
+import "fmt"
+
+// SelectionDirection 选择的方向
+type SelectionDirection int
+
+const (
+	// SelectionLTR 活动位置在锚点之后（或两者相同）
+	SelectionLTR SelectionDirection = iota
+	// SelectionRTL 活动位置在锚点之前
+	SelectionRTL
+)
+
+// Selection 表示编辑器中的选择
+// 锚点是开始选择的位置，活动位置是光标所在的位置，两者相同时选择为空
+type Selection struct {
+	// AnchorLineNumber 锚点的行号（从 1 开始）
+	AnchorLineNumber int
+	// AnchorColumn 锚点的列号（从 1 开始）
+	AnchorColumn int
+	// ActiveLineNumber 活动位置的行号
+	ActiveLineNumber int
+	// ActiveColumn 活动位置的列号
+	ActiveColumn int
+}
+
+// NewSelection 创建一个新的选择
+func NewSelection(anchorLineNumber, anchorColumn, activeLineNumber, activeColumn int) *Selection {
+	return &Selection{
+		AnchorLineNumber: anchorLineNumber,
+		AnchorColumn:     anchorColumn,
+		ActiveLineNumber: activeLineNumber,
+		ActiveColumn:     activeColumn,
+	}
+}
+
+// SelectionFromPositions 从锚点和活动位置创建选择
+func SelectionFromPositions(anchor, active *Position) *Selection {
+	return NewSelection(anchor.LineNumber, anchor.Column, active.LineNumber, active.Column)
+}
+
+// SelectionFromRange 从范围和方向创建选择
+func SelectionFromRange(r *Range, direction SelectionDirection) *Selection {
+	if direction == SelectionRTL {
+		return NewSelection(r.EndLineNumber, r.EndColumn, r.StartLineNumber, r.StartColumn)
+	}
+	return NewSelection(r.StartLineNumber, r.StartColumn, r.EndLineNumber, r.EndColumn)
+}
+
+// GetAnchor 返回锚点
+func (s *Selection) GetAnchor() *Position {
+	return NewPosition(s.AnchorLineNumber, s.AnchorColumn)
+}
+
+// GetActive 返回活动位置
+func (s *Selection) GetActive() *Position {
+	return NewPosition(s.ActiveLineNumber, s.ActiveColumn)
+}
+
+// GetDirection 返回选择的方向
+func (s *Selection) GetDirection() SelectionDirection {
+	if PositionIsBefore(s.GetActive(), s.GetAnchor()) {
+		return SelectionRTL
+	}
+	return SelectionLTR
+}
+
+// IsEmpty 测试选择是否为空
+func (s *Selection) IsEmpty() bool {
+	return s.AnchorLineNumber == s.ActiveLineNumber && s.AnchorColumn == s.ActiveColumn
+}
+
+// ToRange 返回选择覆盖的范围
+func (s *Selection) ToRange() *Range {
+	return NewRange(s.AnchorLineNumber, s.AnchorColumn, s.ActiveLineNumber, s.ActiveColumn)
+}
+
+// GetStartPosition 返回锚点和活动位置中靠前的一个
+func (s *Selection) GetStartPosition() *Position {
+	return s.ToRange().GetStartPosition()
+}
+
+// GetEndPosition 返回锚点和活动位置中靠后的一个
+func (s *Selection) GetEndPosition() *Position {
+	return s.ToRange().GetEndPosition()
+}
+
+// SetActive 返回锚点不变、活动位置移动到 (lineNumber, column) 的新选择
+func (s *Selection) SetActive(lineNumber, column int) *Selection {
+	return NewSelection(s.AnchorLineNumber, s.AnchorColumn, lineNumber, column)
+}
+
+// EqualsSelection 测试此选择是否等于其他选择（包括方向）
+func (s *Selection) EqualsSelection(other *Selection) bool {
+	return SelectionEquals(s, other)
+}
+
+// SelectionEquals 测试选择 a 是否等于 b（包括方向）
+func SelectionEquals(a, b *Selection) bool {
+	return a != nil && b != nil && *a == *b
+}
+
+// String 转换为人类可读的表示形式
+func (s *Selection) String() string {
+	return fmt.Sprintf("[%d,%d -> %d,%d]", s.AnchorLineNumber, s.AnchorColumn, s.ActiveLineNumber, s.ActiveColumn)
+}
@@ -0,0 +1,139 @@
+package common
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 显示宽度
+// 东亚宽字符（Unicode East Asian Width 为 W 或 F）和表情符号占两列，
+// 组合标记、格式字符和控制字符本身不占列，其它字符占一列
+
+// wideRanges East Asian Width 为 W 或 F 的字符范围，按起点排序
+var wideRanges = [][2]rune{
+	{0x1100, 0x115F}, {0x231A, 0x231B}, {0x2329, 0x232A}, {0x23E9, 0x23EC},
+	{0x23F0, 0x23F0}, {0x23F3, 0x23F3}, {0x25FD, 0x25FE}, {0x2614, 0x2615},
+	{0x2648, 0x2653}, {0x267F, 0x267F}, {0x2693, 0x2693}, {0x26A1, 0x26A1},
+	{0x26AA, 0x26AB}, {0x26BD, 0x26BE}, {0x26C4, 0x26C5}, {0x26CE, 0x26CE},
+	{0x26D4, 0x26D4}, {0x26EA, 0x26EA}, {0x26F2, 0x26F3}, {0x26F5, 0x26F5},
+	{0x26FA, 0x26FA}, {0x26FD, 0x26FD}, {0x2705, 0x2705}, {0x270A, 0x270B},
+	{0x2728, 0x2728}, {0x274C, 0x274C}, {0x274E, 0x274E}, {0x2753, 0x2755},
+	{0x2757, 0x2757}, {0x2795, 0x2797}, {0x27B0, 0x27B0}, {0x27BF, 0x27BF},
+	{0x2B1B, 0x2B1C}, {0x2B50, 0x2B50}, {0x2B55, 0x2B55}, {0x2E80, 0x303E},
+	{0x3041, 0x33FF}, {0x3400, 0x4DBF}, {0x4E00, 0x9FFF}, {0xA000, 0xA4CF},
+	{0xA960, 0xA97F}, {0xAC00, 0xD7A3}, {0xF900, 0xFAFF}, {0xFE10, 0xFE19},
+	{0xFE30, 0xFE6F}, {0xFF00, 0xFF60}, {0xFFE0, 0xFFE6}, {0x16FE0, 0x16FE4},
+	{0x17000, 0x18AFF}, {0x1B000, 0x1B2FF}, {0x1F004, 0x1F004}, {0x1F0CF, 0x1F0CF},
+	{0x1F18E, 0x1F18E}, {0x1F191, 0x1F19A}, {0x1F1E6, 0x1F1FF}, {0x1F200, 0x1F202},
+	{0x1F210, 0x1F23B}, {0x1F240, 0x1F248}, {0x1F250, 0x1F251}, {0x1F260, 0x1F265},
+	{0x1F300, 0x1F320}, {0x1F32D, 0x1F335}, {0x1F337, 0x1F37C}, {0x1F37E, 0x1F393},
+	{0x1F3A0, 0x1F3CA}, {0x1F3CF, 0x1F3D3}, {0x1F3E0, 0x1F3F0}, {0x1F3F4, 0x1F3F4},
+	{0x1F3F8, 0x1F43E}, {0x1F440, 0x1F440}, {0x1F442, 0x1F4FC}, {0x1F4FF, 0x1F53D},
+	{0x1F54B, 0x1F54E}, {0x1F550, 0x1F567}, {0x1F57A, 0x1F57A}, {0x1F595, 0x1F596},
+	{0x1F5A4, 0x1F5A4}, {0x1F5FB, 0x1F64F}, {0x1F680, 0x1F6C5}, {0x1F6CC, 0x1F6CC},
+	{0x1F6D0, 0x1F6D2}, {0x1F6D5, 0x1F6D7}, {0x1F6DC, 0x1F6DF}, {0x1F6EB, 0x1F6EC},
+	{0x1F6F4, 0x1F6FC}, {0x1F7E0, 0x1F7EB}, {0x1F7F0, 0x1F7F0}, {0x1F90C, 0x1F93A},
+	{0x1F93C, 0x1F945}, {0x1F947, 0x1F9FF}, {0x1FA70, 0x1FAFF}, {0x20000, 0x2FFFD},
+	{0x30000, 0x3FFFD},
+}
+
+// emojiPresentation 变体选择符 VS16，要求按表情符号显示
+const emojiPresentation = 0xFE0F
+
+// IsWide 判断字符是否为东亚宽字符或宽表情符号
+func IsWide(r rune) bool {
+	if r < 0x1100 {
+		return false
+	}
+	i := sort.Search(len(wideRanges), func(i int) bool { return wideRanges[i][1] >= r })
+	return i < len(wideRanges) && wideRanges[i][0] <= r
+}
+
+// RuneWidth 返回单个字符的显示宽度：0、1 或 2
+func RuneWidth(r rune) int {
+	switch {
+	case r < 0x20 || (r >= 0x7F && r < 0xA0):
+		return 0
+	case r < 0x300:
+		return 1
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	case r >= 0x1160 && r <= 0x11FF:
+		// 韩文中声和终声与前面的初声组合
+		return 0
+	case IsWide(r):
+		return 2
+	}
+	return 1
+}
+
+// GraphemeWidth 返回一个字素簇的显示宽度
+// 宽度由第一个字符决定，带 VS16 的字符按表情符号占两列，非空的字素簇至少占一列
+func GraphemeWidth(cluster string) int {
+	if cluster == "" {
+		return 0
+	}
+	r, _ := utf8.DecodeRuneInString(cluster)
+	width := RuneWidth(r)
+	if width == 1 && strings.ContainsRune(cluster, emojiPresentation) {
+		width = 2
+	}
+	if width == 0 {
+		width = 1
+	}
+	return width
+}
+
+// NextTabStop 返回显示列 visibleColumn 之后的下一个制表位
+func NextTabStop(visibleColumn, tabSize int) int {
+	if tabSize <= 0 {
+		tabSize = 1
+	}
+	return visibleColumn + tabSize - visibleColumn%tabSize
+}
+
+// VisibleColumnFromColumn 返回行内列号 column（从 1 开始的字节列）处的显示列（从 0 开始）
+// 制表符展开到下一个制表位，宽字符占两列；column 落在字素簇中间时按该字素簇之后计算
+func VisibleColumnFromColumn(line string, column, tabSize int) int {
+	visible := 0
+	end := column - 1
+	for i := 0; i < end && i < len(line); {
+		next := NextGraphemeBoundary(line, i)
+		if line[i] == '\t' {
+			visible = NextTabStop(visible, tabSize)
+		} else {
+			visible += GraphemeWidth(line[i:next])
+		}
+		i = next
+	}
+	return visible
+}
+
+// ColumnFromVisibleColumn 返回最接近显示列 visibleColumn 的字素簇边界的列号（从 1 开始的字节列）
+// 两侧距离相同时选择前面的边界，超过行尾时返回行尾
+func ColumnFromVisibleColumn(line string, visibleColumn, tabSize int) int {
+	if visibleColumn <= 0 {
+		return 1
+	}
+	visible := 0
+	for i := 0; i < len(line); {
+		next := NextGraphemeBoundary(line, i)
+		after := visible
+		if line[i] == '\t' {
+			after = NextTabStop(visible, tabSize)
+		} else {
+			after += GraphemeWidth(line[i:next])
+		}
+		if after >= visibleColumn {
+			if after-visibleColumn < visibleColumn-visible {
+				return next + 1
+			}
+			return i + 1
+		}
+		visible = after
+		i = next
+	}
+	return len(line) + 1
+}
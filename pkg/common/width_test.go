@@ -0,0 +1,61 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWidth(t *testing.T) {
+	assert.Equal(t, 1, RuneWidth('a'))
+	assert.Equal(t, 1, RuneWidth('é'))
+	assert.Equal(t, 2, RuneWidth('世'))
+	assert.Equal(t, 2, RuneWidth('Ａ'))
+	assert.Equal(t, 2, RuneWidth('😀'))
+	assert.Equal(t, 0, RuneWidth('́'))
+	assert.Equal(t, 0, RuneWidth('‍'))
+	assert.Equal(t, 0, RuneWidth('\x01'))
+
+	assert.Equal(t, 2, GraphemeWidth("👨‍👩‍👧"))
+	assert.Equal(t, 2, GraphemeWidth("❤️"))
+	assert.Equal(t, 1, GraphemeWidth("❤"))
+	assert.Equal(t, 2, GraphemeWidth("🇯🇵"))
+	assert.Equal(t, 1, GraphemeWidth("é"))
+	assert.Equal(t, 2, GraphemeWidth("각"))
+	assert.Equal(t, 0, GraphemeWidth(""))
+}
+
+func TestVisibleColumns(t *testing.T) {
+	line := "\ta世界b👍🏽c"
+	cases := []struct {
+		column  int
+		visible int
+	}{
+		{1, 0},
+		{2, 4},
+		{3, 5},
+		{6, 7},
+		{9, 9},
+		{10, 10},
+		{18, 12},
+		{19, 13},
+		{100, 13},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.visible, VisibleColumnFromColumn(line, c.column, 4), "column %d", c.column)
+	}
+
+	// The middle of a wide character snaps to the nearest boundary, preferring the one before.
+	assert.Equal(t, 1, ColumnFromVisibleColumn(line, 0, 4))
+	assert.Equal(t, 1, ColumnFromVisibleColumn(line, 1, 4))
+	assert.Equal(t, 2, ColumnFromVisibleColumn(line, 3, 4))
+	assert.Equal(t, 3, ColumnFromVisibleColumn(line, 5, 4))
+	assert.Equal(t, 3, ColumnFromVisibleColumn(line, 6, 4))
+	assert.Equal(t, 6, ColumnFromVisibleColumn(line, 7, 4))
+	assert.Equal(t, 10, ColumnFromVisibleColumn(line, 10, 4))
+	assert.Equal(t, 19, ColumnFromVisibleColumn(line, 13, 4))
+	assert.Equal(t, 19, ColumnFromVisibleColumn(line, 50, 4))
+
+	assert.Equal(t, 8, NextTabStop(4, 4))
+	assert.Equal(t, 4, NextTabStop(3, 4))
+}
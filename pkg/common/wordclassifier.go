@@ -0,0 +1,96 @@
+package common
+
+import (
+	"strings"
+	"unicode"
+)
+
+// DefaultWordSeparators 默认的单词分隔符（空白字符总是分隔符）
+const DefaultWordSeparators = "`~!@#$%^&*()-=+[{]}\\|;:'\",.<>/?"
+
+// WordCharacterClass 字符在单词划分中的类别
+type WordCharacterClass int
+
+const (
+	// WordCharacterRegular 普通单词字符：字母、数字、组合标记、下划线等
+	WordCharacterRegular WordCharacterClass = iota
+	// WordCharacterWhitespace 空白字符，不属于任何单词
+	WordCharacterWhitespace
+	// WordCharacterSeparator 分隔符：分隔符字符串中的字符和非 ASCII 的 Unicode 标点
+	WordCharacterSeparator
+	// WordCharacterCJK 中日韩文字，连续的中日韩文字组成一个单词，与相邻的其它文字之间是单词边界
+	WordCharacterCJK
+)
+
+// WordClassifier 可配置的单词字符分类器
+type WordClassifier struct {
+	// separators 分隔符字符串
+	separators string
+	// ascii ASCII 字符的类别缓存
+	ascii [128]WordCharacterClass
+}
+
+// NewWordClassifier 创建单词字符分类器，separators 为空时使用 DefaultWordSeparators
+func NewWordClassifier(separators string) *WordClassifier {
+	if separators == "" {
+		separators = DefaultWordSeparators
+	}
+	c := &WordClassifier{separators: separators}
+	for i := range c.ascii {
+		c.ascii[i] = c.classify(rune(i))
+	}
+	return c
+}
+
+// Separators 返回分隔符字符串
+func (c *WordClassifier) Separators() string {
+	return c.separators
+}
+
+// Get 返回字符的类别
+func (c *WordClassifier) Get(r rune) WordCharacterClass {
+	if r >= 0 && r < 128 {
+		return c.ascii[r]
+	}
+	return c.classify(r)
+}
+
+// classify 计算字符的类别
+func (c *WordClassifier) classify(r rune) WordCharacterClass {
+	switch {
+	case unicode.IsSpace(r):
+		return WordCharacterWhitespace
+	case strings.ContainsRune(c.separators, r):
+		return WordCharacterSeparator
+	case IsCJK(r):
+		return WordCharacterCJK
+	case unicode.IsLetter(r), unicode.IsDigit(r), unicode.IsMark(r), r == '_':
+		return WordCharacterRegular
+	case r >= 128 && unicode.IsPunct(r):
+		// ASCII 标点只由分隔符字符串决定
+		return WordCharacterSeparator
+	}
+	return WordCharacterRegular
+}
+
+// IsWordCharacter 判断字符是否可以组成单词（普通单词字符或中日韩文字）
+func (c *WordClassifier) IsWordCharacter(r rune) bool {
+	class := c.Get(r)
+	return class == WordCharacterRegular || class == WordCharacterCJK
+}
+
+// IsWordBoundary 判断相邻的两个字符之间是否为单词边界
+// 类别不同的字符之间、以及空白和分隔符的两侧总是边界
+func (c *WordClassifier) IsWordBoundary(before, after rune) bool {
+	a, b := c.Get(before), c.Get(after)
+	return a != b || a == WordCharacterWhitespace || a == WordCharacterSeparator
+}
+
+// IsCJK 判断字符是否为中日韩文字：汉字、平假名、片假名和韩文
+func IsCJK(r rune) bool {
+	if r < 0x1100 {
+		return false
+	}
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) ||
+		r == 0x30FC // 长音符 ー
+}
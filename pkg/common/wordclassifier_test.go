@@ -0,0 +1,40 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWordClassifier(t *testing.T) {
+	c := NewWordClassifier("")
+	assert.Equal(t, DefaultWordSeparators, c.Separators())
+
+	assert.Equal(t, WordCharacterRegular, c.Get('a'))
+	assert.Equal(t, WordCharacterRegular, c.Get('_'))
+	assert.Equal(t, WordCharacterRegular, c.Get('é'))
+	assert.Equal(t, WordCharacterRegular, c.Get('٣'))
+	assert.Equal(t, WordCharacterWhitespace, c.Get('\t'))
+	assert.Equal(t, WordCharacterWhitespace, c.Get('　'))
+	assert.Equal(t, WordCharacterSeparator, c.Get('.'))
+	assert.Equal(t, WordCharacterSeparator, c.Get('，'))
+	assert.Equal(t, WordCharacterCJK, c.Get('世'))
+	assert.Equal(t, WordCharacterCJK, c.Get('カ'))
+	assert.Equal(t, WordCharacterCJK, c.Get('한'))
+
+	custom := NewWordClassifier("-")
+	assert.Equal(t, WordCharacterSeparator, custom.Get('-'))
+	assert.Equal(t, WordCharacterRegular, custom.Get('$'))
+	assert.True(t, custom.IsWordCharacter('$'))
+	assert.False(t, custom.IsWordCharacter('-'))
+}
+
+func TestWordBoundary(t *testing.T) {
+	c := NewWordClassifier("")
+	assert.False(t, c.IsWordBoundary('a', 'b'))
+	assert.False(t, c.IsWordBoundary('世', '界'))
+	assert.True(t, c.IsWordBoundary('a', '世'))
+	assert.True(t, c.IsWordBoundary('a', ' '))
+	assert.True(t, c.IsWordBoundary('(', '('))
+	assert.True(t, c.IsWordBoundary(' ', ' '))
+}
@@ -0,0 +1,201 @@
+package crdt
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalEditsMirrorIntoTree(t *testing.T) {
+	r := NewReplica(1)
+	_, err := r.Insert(0, "hello\nworld")
+	require.NoError(t, err)
+	_, err = r.Insert(5, ", 世界")
+	require.NoError(t, err)
+	_, err = r.Delete(0, 1)
+	require.NoError(t, err)
+
+	assert.Equal(t, "ello, 世界\nworld", r.String())
+	assert.Equal(t, r.String(), r.Tree().GetLinesRawContent())
+	assert.Equal(t, 2, r.Tree().GetLineCount())
+	assert.Equal(t, "world", r.Tree().GetLineContent(2))
+
+	_, err = r.Insert(7, "x")
+	assert.ErrorIs(t, err, ErrNotCharBoundary)
+	_, err = r.Delete(14, 100)
+	assert.ErrorIs(t, err, ErrOutOfRange)
+
+	// Invalid UTF-8 would be stored raw in the tree but as U+FFFD in the items.
+	_, err = r.Insert(0, "a\x9bb")
+	assert.ErrorIs(t, err, ErrInvalidUTF8)
+	assert.Equal(t, "ello, 世界\nworld", r.String())
+	assert.Equal(t, r.String(), r.Tree().GetLinesRawContent())
+}
+
+func TestConcurrentInsertsAtSamePosition(t *testing.T) {
+	a, b := NewReplica(1), NewReplica(2)
+	base, _ := a.Insert(0, "ac")
+	require.NoError(t, b.ApplyUpdate(base))
+
+	ua, _ := a.Insert(1, "X")
+	ub, _ := b.Insert(1, "Y")
+	require.NoError(t, a.ApplyUpdate(ub))
+	require.NoError(t, b.ApplyUpdate(ua))
+
+	assert.Equal(t, a.String(), b.String())
+	assert.Equal(t, a.Tree().GetLinesRawContent(), b.Tree().GetLinesRawContent())
+	assert.Len(t, a.String(), 4)
+}
+
+func TestEncodingRoundTrip(t *testing.T) {
+	u := &Update{
+		Inserts: []InsertRun{
+			{Client: 7, Clock: 0, Lamport: 1, Text: "ab"},
+			{Client: 9, Clock: 3, Lamport: 12, HasOrigin: true, Origin: ID{Client: 7, Clock: 1}, Text: "世界"},
+		},
+		Deletes: []DeleteRun{{Client: 7, Clock: 0, Length: 2}},
+	}
+	decoded, err := DecodeUpdate(EncodeUpdate(u))
+	require.NoError(t, err)
+	assert.Equal(t, u, decoded)
+
+	sv := StateVector{1: 10, 300: 2}
+	decodedSV, err := DecodeStateVector(EncodeStateVector(sv))
+	require.NoError(t, err)
+	assert.Equal(t, sv, decodedSV)
+
+	_, err = DecodeUpdate([]byte{updateFormatVersion, 5})
+	assert.ErrorIs(t, err, ErrMalformed)
+	invalid := EncodeUpdate(&Update{Inserts: []InsertRun{{Client: 7, Lamport: 1, Text: "a\xffb"}}})
+	_, err = DecodeUpdate(invalid)
+	assert.ErrorIs(t, err, ErrMalformed)
+	r := NewReplica(1)
+	assert.ErrorIs(t, r.ApplyUpdate(invalid), ErrMalformed)
+	assert.Equal(t, "", r.String())
+	_, err = DecodeStateVector(nil)
+	assert.ErrorIs(t, err, ErrMalformed)
+}
+
+func TestHugeDeleteRunStaysBounded(t *testing.T) {
+	// A tiny update asking to delete 2^64-1 characters must not be expanded one ID at a time.
+	overflow := EncodeUpdate(&Update{Deletes: []DeleteRun{{Client: 7, Clock: 2, Length: math.MaxUint64}}})
+	_, err := DecodeUpdate(overflow)
+	assert.ErrorIs(t, err, ErrMalformed)
+	_, err = DecodeUpdate(EncodeUpdate(&Update{Deletes: []DeleteRun{{Client: 7, Clock: 2}}}))
+	assert.ErrorIs(t, err, ErrMalformed)
+
+	r := NewReplica(1)
+	huge := EncodeUpdate(&Update{Deletes: []DeleteRun{{Client: 7, Clock: 1, Length: math.MaxUint64 - 1}}})
+	require.NoError(t, r.ApplyUpdate(huge))
+	assert.True(t, r.HasPending())
+
+	// Characters that arrive later are deleted by the pending run.
+	require.NoError(t, r.ApplyUpdate(EncodeUpdate(&Update{Inserts: []InsertRun{{Client: 7, Clock: 0, Lamport: 1, Text: "abc"}}})))
+	assert.Equal(t, "a", r.String())
+	assert.Equal(t, "a", r.Tree().GetLinesRawContent())
+	assert.True(t, r.HasPending())
+}
+
+var randomTexts = []string{"a", "bc", "\n", "世", "\r\n", "xyz"}
+
+// randomEdit performs a random local edit on r and returns the resulting update.
+func randomEdit(t *testing.T, rng *rand.Rand, r *Replica) []byte {
+	content := r.String()
+	boundaries := []int{}
+	for i := range content {
+		boundaries = append(boundaries, i)
+	}
+	boundaries = append(boundaries, len(content))
+
+	start := boundaries[rng.Intn(len(boundaries))]
+	if len(content) == 0 || rng.Intn(3) > 0 {
+		update, err := r.Insert(start, randomTexts[rng.Intn(len(randomTexts))])
+		require.NoError(t, err)
+		return update
+	}
+
+	end := start
+	for _, b := range boundaries {
+		if b > start && rng.Intn(3) == 0 {
+			end = b
+			break
+		}
+	}
+	update, err := r.Delete(start, end-start)
+	require.NoError(t, err)
+	return update
+}
+
+func TestRandomDeliveryConverges(t *testing.T) {
+	for seed := int64(0); seed < 40; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		replicas := []*Replica{NewReplica(1), NewReplica(2), NewReplica(3), NewReplica(4)}
+		queues := make([][][]byte, len(replicas))
+
+		for step := 0; step < 150; step++ {
+			i := rng.Intn(len(replicas))
+			if rng.Intn(2) == 0 {
+				update := randomEdit(t, rng, replicas[i])
+				for j := range replicas {
+					if j != i {
+						queues[j] = append(queues[j], update)
+					}
+				}
+				continue
+			}
+
+			// Deliver a random queued update, possibly duplicating it.
+			if len(queues[i]) == 0 {
+				continue
+			}
+			k := rng.Intn(len(queues[i]))
+			require.NoError(t, replicas[i].ApplyUpdate(queues[i][k]))
+			if rng.Intn(10) > 0 {
+				queues[i] = append(queues[i][:k], queues[i][k+1:]...)
+			}
+		}
+
+		for i, r := range replicas {
+			rng.Shuffle(len(queues[i]), func(a, b int) { queues[i][a], queues[i][b] = queues[i][b], queues[i][a] })
+			for _, update := range queues[i] {
+				require.NoError(t, r.ApplyUpdate(update))
+			}
+		}
+
+		expected := replicas[0].String()
+		for i, r := range replicas {
+			assert.False(t, r.HasPending(), "seed %d replica %d", seed, i)
+			assert.Equal(t, expected, r.String(), "seed %d replica %d", seed, i)
+			assert.Equal(t, expected, r.Tree().GetLinesRawContent(), "seed %d replica %d", seed, i)
+		}
+	}
+}
+
+func TestStateVectorSync(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	a, b := NewReplica(1), NewReplica(2)
+	for i := 0; i < 50; i++ {
+		randomEdit(t, rng, a)
+		randomEdit(t, rng, b)
+	}
+
+	// Exchange only what each side is missing, through the binary state vector.
+	svA, err := DecodeStateVector(EncodeStateVector(a.StateVector()))
+	require.NoError(t, err)
+	svB, err := DecodeStateVector(EncodeStateVector(b.StateVector()))
+	require.NoError(t, err)
+	require.NoError(t, b.ApplyUpdate(a.EncodeStateAsUpdate(svB)))
+	require.NoError(t, a.ApplyUpdate(b.EncodeStateAsUpdate(svA)))
+
+	assert.Equal(t, a.String(), b.String())
+	assert.Equal(t, a.Tree().GetLinesRawContent(), b.Tree().GetLinesRawContent())
+	assert.Equal(t, a.StateVector(), b.StateVector())
+
+	// A fresh replica can be bootstrapped from an empty state vector.
+	c := NewReplica(3)
+	require.NoError(t, c.ApplyUpdate(a.EncodeStateAsUpdate(StateVector{})))
+	assert.Equal(t, a.String(), c.String())
+}
@@ -0,0 +1,232 @@
+package crdt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"unicode/utf8"
+)
+
+// 二进制格式版本
+const (
+	updateFormatVersion      = 1
+	stateVectorFormatVersion = 1
+)
+
+// ErrMalformed 二进制数据格式错误
+var ErrMalformed = errors.New("crdt: malformed binary data")
+
+// StateVector 状态向量，记录每个客户端已集成的连续插入数量
+type StateVector map[uint64]uint64
+
+// InsertRun 一段连续插入
+// 第 k 个字符的 ID 为 (Client, Clock+k)，Lamport 时间戳为 Lamport+k，
+// 第一个字符的左侧原点为 Origin，其余字符的原点为前一个字符
+type InsertRun struct {
+	// Client 客户端
+	Client uint64
+	// Clock 第一个字符的时钟
+	Clock uint64
+	// Lamport 第一个字符的 Lamport 时间戳
+	Lamport uint64
+	// HasOrigin 是否有左侧原点，没有表示插入在文档开头
+	HasOrigin bool
+	// Origin 左侧原点
+	Origin ID
+	// Text 插入的文本，必须是合法的 UTF-8
+	Text string
+}
+
+// DeleteRun 一段连续删除，删除 ID 为 (Client, Clock) 到 (Client, Clock+Length-1) 的字符
+type DeleteRun struct {
+	// Client 客户端
+	Client uint64
+	// Clock 起始时钟
+	Clock uint64
+	// Length 长度
+	Length uint64
+}
+
+// Update 更新，可以在副本之间以任意顺序传递
+type Update struct {
+	// Inserts 插入
+	Inserts []InsertRun
+	// Deletes 删除
+	Deletes []DeleteRun
+}
+
+// EncodeUpdate 将更新编码为二进制格式
+func EncodeUpdate(u *Update) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(updateFormatVersion)
+
+	writeUvarint(&buf, uint64(len(u.Inserts)))
+	for _, run := range u.Inserts {
+		writeUvarint(&buf, run.Client)
+		writeUvarint(&buf, run.Clock)
+		writeUvarint(&buf, run.Lamport)
+		if run.HasOrigin {
+			buf.WriteByte(1)
+			writeUvarint(&buf, run.Origin.Client)
+			writeUvarint(&buf, run.Origin.Clock)
+		} else {
+			buf.WriteByte(0)
+		}
+		writeUvarint(&buf, uint64(len(run.Text)))
+		buf.WriteString(run.Text)
+	}
+
+	writeUvarint(&buf, uint64(len(u.Deletes)))
+	for _, run := range u.Deletes {
+		writeUvarint(&buf, run.Client)
+		writeUvarint(&buf, run.Clock)
+		writeUvarint(&buf, run.Length)
+	}
+
+	return buf.Bytes()
+}
+
+// DecodeUpdate 从二进制格式解码更新
+func DecodeUpdate(data []byte) (*Update, error) {
+	r := bytes.NewReader(data)
+	version, err := r.ReadByte()
+	if err != nil || version != updateFormatVersion {
+		return nil, ErrMalformed
+	}
+
+	u := &Update{}
+	n, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < n; i++ {
+		var run InsertRun
+		if run.Client, err = binary.ReadUvarint(r); err != nil {
+			return nil, ErrMalformed
+		}
+		if run.Clock, err = binary.ReadUvarint(r); err != nil {
+			return nil, ErrMalformed
+		}
+		if run.Lamport, err = binary.ReadUvarint(r); err != nil {
+			return nil, ErrMalformed
+		}
+		flag, err := r.ReadByte()
+		if err != nil || flag > 1 {
+			return nil, ErrMalformed
+		}
+		if flag == 1 {
+			run.HasOrigin = true
+			if run.Origin.Client, err = binary.ReadUvarint(r); err != nil {
+				return nil, ErrMalformed
+			}
+			if run.Origin.Clock, err = binary.ReadUvarint(r); err != nil {
+				return nil, ErrMalformed
+			}
+		}
+		size, err := readCount(r)
+		if err != nil {
+			return nil, err
+		}
+		text := make([]byte, size)
+		if _, err := io.ReadFull(r, text); err != nil || !utf8.Valid(text) {
+			return nil, ErrMalformed
+		}
+		run.Text = string(text)
+		u.Inserts = append(u.Inserts, run)
+	}
+
+	n, err = readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < n; i++ {
+		var run DeleteRun
+		if run.Client, err = binary.ReadUvarint(r); err != nil {
+			return nil, ErrMalformed
+		}
+		if run.Clock, err = binary.ReadUvarint(r); err != nil {
+			return nil, ErrMalformed
+		}
+		if run.Length, err = binary.ReadUvarint(r); err != nil {
+			return nil, ErrMalformed
+		}
+		// 时钟不会超过 uint64，超出的删除不可能对应任何字符
+		if run.Length == 0 || run.Length > math.MaxUint64-run.Clock {
+			return nil, ErrMalformed
+		}
+		u.Deletes = append(u.Deletes, run)
+	}
+
+	if r.Len() != 0 {
+		return nil, ErrMalformed
+	}
+	return u, nil
+}
+
+// EncodeStateVector 将状态向量编码为二进制格式
+func EncodeStateVector(sv StateVector) []byte {
+	clients := make([]uint64, 0, len(sv))
+	for client := range sv {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i] < clients[j] })
+
+	var buf bytes.Buffer
+	buf.WriteByte(stateVectorFormatVersion)
+	writeUvarint(&buf, uint64(len(clients)))
+	for _, client := range clients {
+		writeUvarint(&buf, client)
+		writeUvarint(&buf, sv[client])
+	}
+	return buf.Bytes()
+}
+
+// DecodeStateVector 从二进制格式解码状态向量
+func DecodeStateVector(data []byte) (StateVector, error) {
+	r := bytes.NewReader(data)
+	version, err := r.ReadByte()
+	if err != nil || version != stateVectorFormatVersion {
+		return nil, ErrMalformed
+	}
+
+	n, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	sv := make(StateVector, n)
+	for i := uint64(0); i < n; i++ {
+		client, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		clock, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		sv[client] = clock
+	}
+
+	if r.Len() != 0 {
+		return nil, ErrMalformed
+	}
+	return sv, nil
+}
+
+// writeUvarint 写入无符号变长整数
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	buf.Write(tmp[:n])
+}
+
+// readCount 读取长度，并确保它不超过剩余数据的长度
+func readCount(r *bytes.Reader) (uint64, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()) {
+		return 0, ErrMalformed
+	}
+	return n, nil
+}
@@ -0,0 +1,420 @@
+package crdt
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+)
+
+var (
+	// ErrOutOfRange 偏移量超出文档范围
+	ErrOutOfRange = errors.New("crdt: offset out of range")
+	// ErrNotCharBoundary 偏移量没有落在字符边界上
+	ErrNotCharBoundary = errors.New("crdt: offset is not on a character boundary")
+	// ErrInvalidUTF8 插入的文本不是合法的 UTF-8，副本按字符拆分文本，无法表示非法字节
+	ErrInvalidUTF8 = errors.New("crdt: text is not valid UTF-8")
+)
+
+// ID 字符的唯一标识，Clock 在每个客户端内从 0 开始连续递增
+type ID struct {
+	// Client 客户端
+	Client uint64
+	// Clock 时钟
+	Clock uint64
+}
+
+// item 序列中的一个字符
+type item struct {
+	// id 标识
+	id ID
+	// lamport Lamport 时间戳，用于确定并发插入的顺序
+	lamport uint64
+	// hasOrigin 是否有左侧原点
+	hasOrigin bool
+	// origin 左侧原点
+	origin ID
+	// content 字符内容
+	content string
+	// deleted 是否已删除（墓碑）
+	deleted bool
+}
+
+// after 判断在同一原点后 a 是否应排在 b 之前
+func (a *item) after(b *item) bool {
+	if a.lamport != b.lamport {
+		return a.lamport > b.lamport
+	}
+	return a.id.Client > b.id.Client
+}
+
+// Replica 基于 RGA 的文本副本
+// 所有本地和远程操作都同步到片段树中，行和偏移量查询直接使用片段树
+type Replica struct {
+	// client 本副本的客户端标识
+	client uint64
+	// lamport 已见过的最大 Lamport 时间戳
+	lamport uint64
+	// items 按文档顺序排列的字符，包括墓碑
+	items []*item
+	// index 根据 ID 查找字符
+	index map[ID]*item
+	// stateVector 每个客户端已集成的连续插入数量
+	stateVector StateVector
+	// pendingInserts 因缺少依赖而暂未集成的插入
+	pendingInserts []*item
+	// pendingDeletes 因目标尚未到达而暂未执行的删除，按段保存
+	pendingDeletes []DeleteRun
+	// tree 文档内容
+	tree *buffer.PieceTreeBase
+}
+
+// NewReplica 创建一个新的空副本
+func NewReplica(client uint64) *Replica {
+	builder := buffer.NewPieceTreeTextBufferBuilder()
+	return &Replica{
+		client:      client,
+		items:       make([]*item, 0),
+		index:       make(map[ID]*item),
+		stateVector: make(StateVector),
+		tree:        builder.Finish(false).Create(buffer.LF),
+	}
+}
+
+// Client 返回客户端标识
+func (r *Replica) Client() uint64 {
+	return r.client
+}
+
+// Tree 返回文档对应的片段树，调用方不应直接修改它
+func (r *Replica) Tree() *buffer.PieceTreeBase {
+	return r.tree
+}
+
+// String 返回文档内容
+func (r *Replica) String() string {
+	var sb strings.Builder
+	for _, it := range r.items {
+		if !it.deleted {
+			sb.WriteString(it.content)
+		}
+	}
+	return sb.String()
+}
+
+// StateVector 返回状态向量的副本
+func (r *Replica) StateVector() StateVector {
+	sv := make(StateVector, len(r.stateVector))
+	for client, clock := range r.stateVector {
+		sv[client] = clock
+	}
+	return sv
+}
+
+// HasPending 是否有尚未集成的远程操作
+func (r *Replica) HasPending() bool {
+	return len(r.pendingInserts) > 0 || len(r.pendingDeletes) > 0
+}
+
+// Insert 在偏移量处插入文本，返回需要发送给其他副本的更新
+func (r *Replica) Insert(offset int, text string) ([]byte, error) {
+	index, err := r.visibleIndex(offset)
+	if err != nil {
+		return nil, err
+	}
+	if len(text) == 0 {
+		return EncodeUpdate(&Update{}), nil
+	}
+	if !utf8.ValidString(text) {
+		return nil, ErrInvalidUTF8
+	}
+
+	run := InsertRun{
+		Client:  r.client,
+		Clock:   r.stateVector[r.client],
+		Lamport: r.lamport + 1,
+	}
+	// 原点是插入位置前最近的可见字符
+	for i := index - 1; i >= 0; i-- {
+		if !r.items[i].deleted {
+			run.HasOrigin = true
+			run.Origin = r.items[i].id
+			break
+		}
+	}
+	run.Text = text
+
+	items := splitRun(run)
+	offset = r.integrate(items[0])
+	for _, it := range items[1:] {
+		r.integrate(it)
+	}
+	r.tree.Insert(offset, text, false)
+	return EncodeUpdate(&Update{Inserts: []InsertRun{run}}), nil
+}
+
+// Delete 删除偏移量处长度为 length 的文本，返回需要发送给其他副本的更新
+func (r *Replica) Delete(offset, length int) ([]byte, error) {
+	if _, err := r.visibleIndex(offset); err != nil {
+		return nil, err
+	}
+	if _, err := r.visibleIndex(offset + length); err != nil {
+		return nil, err
+	}
+
+	u := &Update{}
+	pos := 0
+	for _, it := range r.items {
+		if it.deleted {
+			continue
+		}
+		if pos >= offset+length {
+			break
+		}
+		if pos >= offset {
+			u.Deletes = appendDelete(u.Deletes, it.id)
+		}
+		pos += len(it.content)
+	}
+
+	for _, run := range u.Deletes {
+		r.deleteRun(run)
+	}
+	return EncodeUpdate(u), nil
+}
+
+// ApplyUpdate 应用来自其他副本的更新，更新可以重复或乱序到达
+func (r *Replica) ApplyUpdate(data []byte) error {
+	u, err := DecodeUpdate(data)
+	if err != nil {
+		return err
+	}
+
+	for _, run := range u.Inserts {
+		r.pendingInserts = append(r.pendingInserts, splitRun(run)...)
+	}
+	r.pendingDeletes = append(r.pendingDeletes, u.Deletes...)
+
+	r.integratePending()
+	return nil
+}
+
+// EncodeStateAsUpdate 编码状态向量为 sv 的副本所缺少的内容
+func (r *Replica) EncodeStateAsUpdate(sv StateVector) []byte {
+	u := &Update{}
+
+	// 按客户端和时钟收集缺少的字符
+	missing := make(map[uint64][]*item)
+	for _, it := range r.items {
+		if it.id.Clock >= sv[it.id.Client] {
+			missing[it.id.Client] = append(missing[it.id.Client], it)
+		}
+		if it.deleted {
+			u.Deletes = appendDelete(u.Deletes, it.id)
+		}
+	}
+
+	for _, client := range sortedClients(missing) {
+		items := missing[client]
+		sortByClock(items)
+		for _, it := range items {
+			if n := len(u.Inserts); n > 0 {
+				last := &u.Inserts[n-1]
+				count := uint64(utf8.RuneCountInString(last.Text))
+				if last.Client == it.id.Client &&
+					last.Clock+count == it.id.Clock &&
+					last.Lamport+count == it.lamport &&
+					it.hasOrigin && it.origin == (ID{Client: last.Client, Clock: it.id.Clock - 1}) {
+					last.Text += it.content
+					continue
+				}
+			}
+			u.Inserts = append(u.Inserts, InsertRun{
+				Client:    it.id.Client,
+				Clock:     it.id.Clock,
+				Lamport:   it.lamport,
+				HasOrigin: it.hasOrigin,
+				Origin:    it.origin,
+				Text:      it.content,
+			})
+		}
+	}
+
+	return EncodeUpdate(u)
+}
+
+// integratePending 集成所有依赖已满足的待处理操作
+func (r *Replica) integratePending() {
+	for progressed := true; progressed; {
+		progressed = false
+
+		remaining := r.pendingInserts[:0]
+		for _, it := range r.pendingInserts {
+			if _, ok := r.index[it.id]; ok {
+				// 重复的插入
+				progressed = true
+				continue
+			}
+			_, originKnown := r.index[it.origin]
+			if it.id.Clock == r.stateVector[it.id.Client] && (!it.hasOrigin || originKnown) {
+				r.tree.Insert(r.integrate(it), it.content, false)
+				progressed = true
+				continue
+			}
+			remaining = append(remaining, it)
+		}
+		r.pendingInserts = remaining
+	}
+
+	remaining := r.pendingDeletes[:0]
+	for _, run := range r.pendingDeletes {
+		if rest, ok := r.deleteRun(run); ok {
+			remaining = append(remaining, rest)
+		}
+	}
+	r.pendingDeletes = remaining
+}
+
+// deleteRun 删除一段中已经集成的字符，返回尚未到达的剩余部分
+// 每个客户端已集成的时钟是连续的，循环次数不超过实际存在的字符数
+func (r *Replica) deleteRun(run DeleteRun) (DeleteRun, bool) {
+	end := run.Clock + run.Length
+	known := min(end, r.stateVector[run.Client])
+	for clock := run.Clock; clock < known; clock++ {
+		r.delete(ID{Client: run.Client, Clock: clock})
+	}
+	if known >= end {
+		return DeleteRun{}, false
+	}
+	start := max(run.Clock, known)
+	return DeleteRun{Client: run.Client, Clock: start, Length: end - start}, true
+}
+
+// integrate 把字符插入到序列中，返回它在文档中的偏移量，调用方负责同步到片段树
+func (r *Replica) integrate(it *item) int {
+	i := 0
+	if it.hasOrigin {
+		for i < len(r.items) && r.items[i].id != it.origin {
+			i++
+		}
+		i++
+	}
+	// 跳过同一原点后优先级更高的并发插入及其后代
+	for i < len(r.items) && r.items[i].after(it) {
+		i++
+	}
+
+	offset := 0
+	for _, prev := range r.items[:i] {
+		if !prev.deleted {
+			offset += len(prev.content)
+		}
+	}
+
+	r.items = append(r.items, nil)
+	copy(r.items[i+1:], r.items[i:])
+	r.items[i] = it
+	r.index[it.id] = it
+	r.stateVector[it.id.Client] = it.id.Clock + 1
+	if it.lamport > r.lamport {
+		r.lamport = it.lamport
+	}
+	return offset
+}
+
+// delete 把字符标记为墓碑并同步到片段树
+func (r *Replica) delete(id ID) {
+	target, ok := r.index[id]
+	if !ok || target.deleted {
+		return
+	}
+
+	offset := 0
+	for _, it := range r.items {
+		if it == target {
+			break
+		}
+		if !it.deleted {
+			offset += len(it.content)
+		}
+	}
+
+	target.deleted = true
+	r.tree.Delete(offset, len(target.content))
+}
+
+// visibleIndex 返回偏移量对应的序列下标，偏移量必须落在字符边界上
+func (r *Replica) visibleIndex(offset int) (int, error) {
+	if offset < 0 {
+		return 0, ErrOutOfRange
+	}
+
+	pos := 0
+	for i, it := range r.items {
+		if it.deleted {
+			continue
+		}
+		if pos == offset {
+			return i, nil
+		}
+		pos += len(it.content)
+		if pos > offset {
+			return 0, ErrNotCharBoundary
+		}
+	}
+	if pos == offset {
+		return len(r.items), nil
+	}
+	return 0, ErrOutOfRange
+}
+
+// splitRun 把一段连续插入拆分为单个字符
+func splitRun(run InsertRun) []*item {
+	items := make([]*item, 0, len(run.Text))
+	k := uint64(0)
+	for _, ch := range run.Text {
+		it := &item{
+			id:        ID{Client: run.Client, Clock: run.Clock + k},
+			lamport:   run.Lamport + k,
+			hasOrigin: run.HasOrigin,
+			origin:    run.Origin,
+			content:   string(ch),
+		}
+		if k > 0 {
+			it.hasOrigin = true
+			it.origin = ID{Client: run.Client, Clock: run.Clock + k - 1}
+		}
+		items = append(items, it)
+		k++
+	}
+	return items
+}
+
+// appendDelete 追加一个删除，能合并时合并到最后一段
+func appendDelete(runs []DeleteRun, id ID) []DeleteRun {
+	if n := len(runs); n > 0 {
+		last := &runs[n-1]
+		if last.Client == id.Client && last.Clock+last.Length == id.Clock {
+			last.Length++
+			return runs
+		}
+	}
+	return append(runs, DeleteRun{Client: id.Client, Clock: id.Clock, Length: 1})
+}
+
+// sortedClients 返回按升序排列的客户端
+func sortedClients(m map[uint64][]*item) []uint64 {
+	clients := make([]uint64, 0, len(m))
+	for client := range m {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i] < clients[j] })
+	return clients
+}
+
+// sortByClock 按时钟升序排列字符
+func sortByClock(items []*item) {
+	sort.Slice(items, func(i, j int) bool { return items[i].id.Clock < items[j].id.Clock })
+}
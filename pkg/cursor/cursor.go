@@ -0,0 +1,318 @@
+package cursor
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+	"github.com/kebaren/textbuffer/pkg/common"
+)
+
+// Collection 多光标集合
+// 每个光标是一个选择，集合总是按开始位置排序，重叠的选择被合并；
+// 修改文本缓冲区时所有光标随之变换
+type Collection struct {
+	tree *buffer.PieceTreeBase
+	// selections 按开始位置排序且互不重叠的选择
+	selections []common.Selection
+	// primary 主光标在 selections 中的下标
+	primary int
+	// replacing 正在应用 replace 的编辑，光标由 replace 设置
+	replacing bool
+	// removeListener 取消注册内容修改监听器
+	removeListener func()
+}
+
+// NewCollection 创建一个只有一个位于文档开头的光标的集合
+func NewCollection(tree *buffer.PieceTreeBase) *Collection {
+	c := &Collection{
+		tree:       tree,
+		selections: []common.Selection{*common.NewSelection(1, 1, 1, 1)},
+	}
+	c.removeListener = tree.AddChangeListener(c.onContentChange)
+	return c
+}
+
+// Tree 返回光标所在的片段树
+func (c *Collection) Tree() *buffer.PieceTreeBase {
+	return c.tree
+}
+
+// Count 返回光标的数量
+func (c *Collection) Count() int {
+	return len(c.selections)
+}
+
+// Selections 返回按开始位置排序的所有选择
+func (c *Collection) Selections() []common.Selection {
+	return append([]common.Selection(nil), c.selections...)
+}
+
+// Primary 返回主光标的选择
+func (c *Collection) Primary() common.Selection {
+	return c.selections[c.primary]
+}
+
+// PrimaryIndex 返回主光标在 Selections 中的下标
+func (c *Collection) PrimaryIndex() int {
+	return c.primary
+}
+
+// SetSelections 替换所有选择，第一个选择成为主光标，sels 为空时不做任何事
+// 超出文档的位置被限制到文档内
+func (c *Collection) SetSelections(sels []common.Selection) {
+	if len(sels) == 0 {
+		return
+	}
+	c.set(append([]common.Selection(nil), sels...), 0)
+}
+
+// AddSelection 添加一个选择并使其成为主光标
+func (c *Collection) AddSelection(sel common.Selection) {
+	c.set(append(c.Selections(), sel), len(c.selections))
+}
+
+// AddCursor 在位置 pos 添加一个空选择并使其成为主光标
+func (c *Collection) AddCursor(pos common.Position) {
+	c.AddSelection(*common.SelectionFromPositions(&pos, &pos))
+}
+
+// Move 用 move 移动每个光标的活动位置，selecting 为 true 时保留锚点以扩展选择，否则选择变为空
+func (c *Collection) Move(move func(common.Position) common.Position, selecting bool) {
+	sels := c.Selections()
+	for i, sel := range sels {
+		active := move(*sel.GetActive())
+		if selecting {
+			sels[i] = *sel.SetActive(active.LineNumber, active.Column)
+		} else {
+			sels[i] = *common.SelectionFromPositions(&active, &active)
+		}
+	}
+	c.set(sels, c.primary)
+}
+
+// ColumnSelect 从 anchor 到 active 的列（矩形）选择，每行一个选择，主光标位于 active 所在的行
+// 列按显示列对齐，制表符展开到 tabSize 的倍数，短的行被限制到行尾
+func (c *Collection) ColumnSelect(anchor, active common.Position, tabSize int) {
+	anchor, active = c.validate(anchor), c.validate(active)
+	anchorVisible := common.VisibleColumnFromColumn(c.tree.GetLineContent(anchor.LineNumber), anchor.Column, tabSize)
+	activeVisible := common.VisibleColumnFromColumn(c.tree.GetLineContent(active.LineNumber), active.Column, tabSize)
+	step := 1
+	if active.LineNumber < anchor.LineNumber {
+		step = -1
+	}
+
+	var sels []common.Selection
+	for line := anchor.LineNumber; ; line += step {
+		content := c.tree.GetLineContent(line)
+		sels = append(sels, *common.NewSelection(line,
+			common.ColumnFromVisibleColumn(content, anchorVisible, tabSize),
+			line, common.ColumnFromVisibleColumn(content, activeVisible, tabSize)))
+		if line == active.LineNumber {
+			break
+		}
+	}
+	c.set(sels, len(sels)-1)
+}
+
+// validate 把位置限制到文档内
+func (c *Collection) validate(pos common.Position) common.Position {
+	return c.tree.ValidatePosition(pos)
+}
+
+// set 限制、排序并合并选择，primary 是主光标在 sels 中的下标
+// 合并时，两个选择都不为空时只合并重叠的，有一个为空时相接的也合并；
+// 合并后的选择保留主光标的方向，主光标为空时保留另一个选择的方向
+func (c *Collection) set(sels []common.Selection, primary int) {
+	type entry struct {
+		sel     common.Selection
+		primary bool
+	}
+	entries := make([]entry, len(sels))
+	for i, sel := range sels {
+		anchor, active := c.validate(*sel.GetAnchor()), c.validate(*sel.GetActive())
+		entries[i] = entry{sel: *common.SelectionFromPositions(&anchor, &active), primary: i == primary}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i].sel.ToRange(), entries[j].sel.ToRange()
+		if cmp := common.PositionCompare(a.GetStartPosition(), b.GetStartPosition()); cmp != 0 {
+			return cmp < 0
+		}
+		return common.PositionIsBefore(a.GetEndPosition(), b.GetEndPosition())
+	})
+
+	merged := entries[:1]
+	for _, cur := range entries[1:] {
+		prev := &merged[len(merged)-1]
+		start, end := cur.sel.GetStartPosition(), prev.sel.GetEndPosition()
+		overlaps := common.PositionIsBefore(start, end)
+		if prev.sel.IsEmpty() || cur.sel.IsEmpty() {
+			overlaps = common.PositionIsBeforeOrEqual(start, end)
+		}
+		if !overlaps {
+			merged = append(merged, cur)
+			continue
+		}
+		direction := prev.sel.GetDirection()
+		if prev.sel.IsEmpty() || (cur.primary && !cur.sel.IsEmpty()) {
+			direction = cur.sel.GetDirection()
+		}
+		prev.sel = *common.SelectionFromRange(prev.sel.ToRange().PlusRange(cur.sel.ToRange()), direction)
+		prev.primary = prev.primary || cur.primary
+	}
+
+	c.selections = make([]common.Selection, len(merged))
+	c.primary = 0
+	for i, e := range merged {
+		c.selections[i] = e.sel
+		if e.primary {
+			c.primary = i
+		}
+	}
+}
+
+// offsetAt 返回位置的偏移量
+func (c *Collection) offsetAt(lineNumber, column int) int {
+	return c.tree.GetOffsetAt(lineNumber, column)
+}
+
+// transformPosition 把位置变换到编辑之后
+// 编辑把 change.Range 替换为在 change.End 结束的文本：之前的位置不变；
+// 纯插入位置上的位置被推到插入文本之后；被替换范围的开头不变，内部移动到插入文本之后
+func transformPosition(pos common.Position, change buffer.ContentChange) common.Position {
+	start, end := change.Range.GetStartPosition(), change.Range.GetEndPosition()
+	switch {
+	case common.PositionIsBefore(&pos, start):
+		return pos
+	case pos == *start && change.DeletedLength > 0:
+		return pos
+	case pos == *start, common.PositionIsBefore(&pos, end):
+		return change.End
+	case pos.LineNumber == end.LineNumber:
+		return common.Position{LineNumber: change.End.LineNumber, Column: change.End.Column + pos.Column - end.Column}
+	default:
+		return common.Position{LineNumber: pos.LineNumber + change.End.LineNumber - end.LineNumber, Column: pos.Column}
+	}
+}
+
+// onContentChange 文本缓冲区修改后变换所有光标，replace 自己设置编辑后的光标
+func (c *Collection) onContentChange(change buffer.ContentChange) {
+	if c.replacing {
+		return
+	}
+	sels := make([]common.Selection, len(c.selections))
+	for i, sel := range c.selections {
+		anchor, active := transformPosition(*sel.GetAnchor(), change), transformPosition(*sel.GetActive(), change)
+		sels[i] = *common.SelectionFromPositions(&anchor, &active)
+	}
+	c.set(sels, c.primary)
+}
+
+// Dispose 停止跟踪文本缓冲区的修改
+func (c *Collection) Dispose() {
+	c.removeListener()
+}
+
+// replace 用 texts[i] 替换第 i 个光标对应的偏移量范围 ranges[i]，之后光标移动到插入文本之后
+// ranges 按光标的顺序排列，与前一个范围重叠的部分被忽略；返回按撤销顺序排列的逆操作
+func (c *Collection) replace(ranges [][2]int, texts []string) []buffer.EditOperation {
+	for i := 1; i < len(ranges); i++ {
+		ranges[i][0] = max(ranges[i][0], ranges[i-1][1])
+		ranges[i][1] = max(ranges[i][1], ranges[i][0])
+	}
+
+	// 从后向前应用，前面的范围的偏移量不受影响
+	var ops []buffer.EditOperation
+	for i := len(ranges) - 1; i >= 0; i-- {
+		op := buffer.NewEditOperation(ranges[i][0], ranges[i][1]-ranges[i][0], texts[i])
+		if !op.IsNoop() {
+			ops = append(ops, op)
+		}
+	}
+	c.replacing = true
+	inverse := c.tree.ApplyEdits(ops)
+	c.replacing = false
+
+	sels := make([]common.Selection, len(ranges))
+	delta := 0
+	for i, r := range ranges {
+		pos := c.tree.GetPositionAt(r[0] + delta + len(texts[i]))
+		sels[i] = *common.SelectionFromPositions(pos, pos)
+		delta += len(texts[i]) - (r[1] - r[0])
+	}
+	c.set(sels, c.primary)
+	return inverse
+}
+
+// selectionRanges 返回每个选择的偏移量范围
+func (c *Collection) selectionRanges() [][2]int {
+	ranges := make([][2]int, len(c.selections))
+	for i, sel := range c.selections {
+		r := sel.ToRange()
+		ranges[i] = [2]int{c.offsetAt(r.StartLineNumber, r.StartColumn), c.offsetAt(r.EndLineNumber, r.EndColumn)}
+	}
+	return ranges
+}
+
+// Type 在每个光标处输入文本，选择的内容被替换，返回按撤销顺序排列的逆操作
+func (c *Collection) Type(text string) []buffer.EditOperation {
+	texts := make([]string, len(c.selections))
+	for i := range texts {
+		texts[i] = text
+	}
+	return c.replace(c.selectionRanges(), texts)
+}
+
+// Paste 在每个光标处粘贴文本，返回按撤销顺序排列的逆操作
+// 有多个光标且文本的行数（忽略末尾的换行）与光标数相同时，每个光标粘贴其中的一行，否则每个光标粘贴全部文本
+func (c *Collection) Paste(text string) []buffer.EditOperation {
+	lines := strings.Split(strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r"), "\n")
+	if len(c.selections) == 1 || len(lines) != len(c.selections) {
+		return c.Type(text)
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return c.replace(c.selectionRanges(), lines)
+}
+
+// Backspace 删除每个选择的内容，空选择删除光标之前的字素簇，在行首时与上一行合并
+// 返回按撤销顺序排列的逆操作
+func (c *Collection) Backspace() []buffer.EditOperation {
+	ranges := c.selectionRanges()
+	for i, sel := range c.selections {
+		if !sel.IsEmpty() {
+			continue
+		}
+		line, column := sel.ActiveLineNumber, sel.ActiveColumn
+		switch {
+		case column > 1:
+			prev := common.PrevGraphemeBoundary(c.tree.GetLineContent(line), column-1)
+			ranges[i][0] = c.offsetAt(line, prev+1)
+		case line > 1:
+			ranges[i][0] = c.offsetAt(line-1, c.tree.GetLineLength(line-1)+1)
+		}
+	}
+	return c.replace(ranges, make([]string, len(ranges)))
+}
+
+// Delete 删除每个选择的内容，空选择删除光标之后的字素簇，在行尾时与下一行合并
+// 返回按撤销顺序排列的逆操作
+func (c *Collection) Delete() []buffer.EditOperation {
+	ranges := c.selectionRanges()
+	for i, sel := range c.selections {
+		if !sel.IsEmpty() {
+			continue
+		}
+		line, column := sel.ActiveLineNumber, sel.ActiveColumn
+		content := c.tree.GetLineContent(line)
+		switch {
+		case column <= len(content):
+			next := common.NextGraphemeBoundary(content, column-1)
+			ranges[i][1] = c.offsetAt(line, next+1)
+		case line < c.tree.GetLineCount():
+			ranges[i][1] = c.offsetAt(line+1, 1)
+		}
+	}
+	return c.replace(ranges, make([]string, len(ranges)))
+}
@@ -0,0 +1,170 @@
+package cursor
+
+import (
+	"testing"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+	"github.com/kebaren/textbuffer/pkg/buffer/buffertest"
+	"github.com/kebaren/textbuffer/pkg/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func newCollection(text string) *Collection {
+	return NewCollection(buffertest.NewTree(text))
+}
+
+func sel(anchorLine, anchorColumn, activeLine, activeColumn int) common.Selection {
+	return *common.NewSelection(anchorLine, anchorColumn, activeLine, activeColumn)
+}
+
+func cursorAt(line, column int) common.Selection {
+	return sel(line, column, line, column)
+}
+
+func value(c *Collection) string {
+	return c.Tree().GetValueInOffsetRange(0, c.Tree().GetLength())
+}
+
+func TestSelection(t *testing.T) {
+	s := common.NewSelection(3, 4, 1, 2)
+	assert.Equal(t, common.SelectionRTL, s.GetDirection())
+	assert.Equal(t, common.NewRange(1, 2, 3, 4), s.ToRange())
+	assert.Equal(t, common.NewPosition(1, 2), s.GetStartPosition())
+	assert.False(t, s.IsEmpty())
+	assert.True(t, common.SelectionFromRange(s.ToRange(), common.SelectionRTL).EqualsSelection(s))
+	assert.Equal(t, common.SelectionLTR, s.SetActive(3, 4).GetDirection())
+	assert.True(t, s.SetActive(3, 4).IsEmpty())
+	assert.Equal(t, "[3,4 -> 1,2]", s.String())
+}
+
+func TestMerge(t *testing.T) {
+	c := newCollection("abcdef\nghijkl")
+	// Overlapping selections merge, touching non-empty ones do not.
+	c.SetSelections([]common.Selection{sel(1, 3, 1, 5), sel(1, 1, 1, 3), sel(1, 4, 1, 2), sel(2, 1, 2, 3), sel(2, 3, 2, 5)})
+	assert.Equal(t, []common.Selection{sel(1, 1, 1, 5), sel(2, 1, 2, 3), sel(2, 3, 2, 5)}, c.Selections())
+	assert.Equal(t, 0, c.PrimaryIndex())
+
+	// A cursor touching a selection merges into it and the primary keeps its direction.
+	c.SetSelections([]common.Selection{cursorAt(2, 3), sel(2, 3, 2, 1), cursorAt(1, 1)})
+	assert.Equal(t, []common.Selection{cursorAt(1, 1), sel(2, 3, 2, 1)}, c.Selections())
+	assert.Equal(t, sel(2, 3, 2, 1), c.Primary())
+
+	// Positions are clamped and duplicate cursors collapse.
+	c.SetSelections([]common.Selection{cursorAt(9, 9), cursorAt(2, 7), cursorAt(0, 0)})
+	assert.Equal(t, []common.Selection{cursorAt(1, 1), cursorAt(2, 7)}, c.Selections())
+	assert.Equal(t, 1, c.PrimaryIndex())
+
+	c.AddCursor(common.Position{LineNumber: 1, Column: 4})
+	assert.Equal(t, 3, c.Count())
+	assert.Equal(t, cursorAt(1, 4), c.Primary())
+}
+
+func TestType(t *testing.T) {
+	c := newCollection("one\ntwo\nthree")
+	c.SetSelections([]common.Selection{cursorAt(1, 1), cursorAt(1, 4), sel(2, 1, 2, 4), cursorAt(3, 6)})
+	inverse := c.Type("ab")
+	assert.Equal(t, "aboneab\nab\nthreeab", value(c))
+	assert.Equal(t, []common.Selection{cursorAt(1, 3), cursorAt(1, 8), cursorAt(2, 3), cursorAt(3, 8)}, c.Selections())
+
+	c.Type("\n")
+	assert.Equal(t, "ab\noneab\n\nab\n\nthreeab\n", value(c))
+	assert.Equal(t, []common.Selection{cursorAt(2, 1), cursorAt(3, 1), cursorAt(5, 1), cursorAt(7, 1)}, c.Selections())
+
+	c2 := newCollection("one\ntwo\nthree")
+	c2.SetSelections([]common.Selection{cursorAt(1, 1), cursorAt(1, 4), sel(2, 1, 2, 4), cursorAt(3, 6)})
+	c2.Type("ab")
+	c2.Tree().ApplyEdits(inverse)
+	assert.Equal(t, "one\ntwo\nthree", value(c2))
+}
+
+func TestBackspaceAndDelete(t *testing.T) {
+	c := newCollection("ab\néx\n\U0001F468‍\U0001F469y")
+	c.SetSelections([]common.Selection{cursorAt(1, 1), cursorAt(2, 1), cursorAt(2, 4), cursorAt(3, 12)})
+	c.Backspace()
+	assert.Equal(t, "abx\ny", value(c))
+	assert.Equal(t, []common.Selection{cursorAt(1, 1), cursorAt(1, 3), cursorAt(2, 1)}, c.Selections())
+
+	c.Delete()
+	assert.Equal(t, "b\n", value(c))
+	assert.Equal(t, []common.Selection{cursorAt(1, 1), cursorAt(1, 2), cursorAt(2, 1)}, c.Selections())
+
+	// A cursor at the end of the document deletes nothing.
+	c.SetSelections([]common.Selection{cursorAt(2, 1)})
+	assert.Empty(t, c.Delete())
+	assert.Equal(t, "b\n", value(c))
+
+	// Non-empty selections are deleted as a whole and adjacent deletions do not overlap.
+	c = newCollection("abc\ndef")
+	c.SetSelections([]common.Selection{sel(1, 2, 2, 2), cursorAt(2, 3)})
+	c.Backspace()
+	assert.Equal(t, "af", value(c))
+	assert.Equal(t, []common.Selection{cursorAt(1, 2)}, c.Selections())
+}
+
+func TestBackspaceMixedEOL(t *testing.T) {
+	// Backspace at the start of a line removes the whole line break, whatever its length.
+	c := newCollection("ab\r\ncd\nef")
+	c.SetSelections([]common.Selection{cursorAt(2, 1)})
+	c.Backspace()
+	assert.Equal(t, "abcd\nef", value(c))
+	assert.Equal(t, []common.Selection{cursorAt(1, 3)}, c.Selections())
+
+	c = newCollection("ab\r\ncd\nef")
+	c.SetSelections([]common.Selection{cursorAt(3, 1)})
+	c.Backspace()
+	assert.Equal(t, "ab\r\ncdef", value(c))
+	assert.Equal(t, []common.Selection{cursorAt(2, 3)}, c.Selections())
+}
+
+func TestPaste(t *testing.T) {
+	c := newCollection("a\nb\nc")
+	c.SetSelections([]common.Selection{cursorAt(1, 2), cursorAt(2, 2), cursorAt(3, 2)})
+	c.Paste("1\r\n2\r\n3\r\n")
+	assert.Equal(t, "a1\nb2\nc3", value(c))
+
+	// The line count does not match the cursor count, so each cursor gets the whole text.
+	c.Paste("x\ny")
+	assert.Equal(t, "a1x\ny\nb2x\ny\nc3x\ny", value(c))
+	assert.Equal(t, []common.Selection{cursorAt(2, 2), cursorAt(4, 2), cursorAt(6, 2)}, c.Selections())
+}
+
+func TestColumnSelect(t *testing.T) {
+	c := newCollection("abcdef\n\tx\nab\nabcdefgh")
+	c.ColumnSelect(common.Position{LineNumber: 1, Column: 2}, common.Position{LineNumber: 4, Column: 6}, 4)
+	assert.Equal(t, []common.Selection{sel(1, 2, 1, 6), sel(2, 1, 2, 3), sel(3, 2, 3, 3), sel(4, 2, 4, 6)}, c.Selections())
+	assert.Equal(t, 3, c.PrimaryIndex())
+
+	c.Type("-")
+	assert.Equal(t, "a-f\n-\na-\na-fgh", value(c))
+
+	// Selecting upwards keeps the primary cursor on the active line.
+	c.ColumnSelect(common.Position{LineNumber: 4, Column: 4}, common.Position{LineNumber: 2, Column: 1}, 4)
+	assert.Equal(t, []common.Selection{sel(2, 2, 2, 1), sel(3, 3, 3, 1), sel(4, 4, 4, 1)}, c.Selections())
+	assert.Equal(t, 0, c.PrimaryIndex())
+}
+
+func TestApplyEdit(t *testing.T) {
+	c := newCollection("hello world\nfoo")
+	c.SetSelections([]common.Selection{cursorAt(1, 1), sel(1, 7, 1, 12), cursorAt(2, 2)})
+
+	// Insertions push cursors at the insertion point and shift later ones.
+	c.Tree().ApplyEdit(buffer.NewEditOperation(0, 0, "> "))
+	assert.Equal(t, []common.Selection{cursorAt(1, 3), sel(1, 9, 1, 14), cursorAt(2, 2)}, c.Selections())
+
+	// Deleting across selections collapses them and cursors merge.
+	history := buffer.NewHistory(c.Tree(), 0)
+	history.Apply([]buffer.EditOperation{buffer.NewEditOperation(4, 12, "")})
+	assert.Equal(t, "> heo", value(c))
+	assert.Equal(t, []common.Selection{cursorAt(1, 3), cursorAt(1, 5)}, c.Selections())
+
+	// Undoing through the history moves the cursor past the restored text.
+	history.Undo()
+	assert.Equal(t, "> hello world\nfoo", value(c))
+	assert.Equal(t, []common.Selection{cursorAt(1, 3), cursorAt(2, 3)}, c.Selections())
+
+	// Positions after a multi-line replacement keep their offset from its end.
+	c.SetSelections([]common.Selection{cursorAt(1, 1), sel(1, 6, 2, 2), cursorAt(2, 3)})
+	c.Tree().ApplyEdit(buffer.NewEditOperation(7, 7, "1\n2\n3"))
+	assert.Equal(t, "> hello1\n2\n3foo", value(c))
+	assert.Equal(t, []common.Selection{cursorAt(1, 1), sel(1, 6, 3, 3), cursorAt(3, 4)}, c.Selections())
+}
@@ -0,0 +1,232 @@
+package daemon
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kebaren/textbuffer/pkg/jsonrpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// client is a test client that reads responses in order and records notifications.
+type client struct {
+	t             *testing.T
+	stream        jsonrpc.Stream
+	nextID        int
+	notifications []*jsonrpc.Message
+}
+
+func dial(t *testing.T, socket string) *client {
+	conn, err := net.Dial("unix", socket)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return &client{t: t, stream: jsonrpc.NewLineStream(conn, conn)}
+}
+
+// call sends a request and waits for its response; a nil result is ignored.
+func (c *client) call(method string, params interface{}, result interface{}) *jsonrpc.Error {
+	c.nextID++
+	id := json.RawMessage(strconv.Itoa(c.nextID))
+	raw, err := json.Marshal(params)
+	require.NoError(c.t, err)
+	require.NoError(c.t, c.stream.Write(&jsonrpc.Message{JSONRPC: jsonrpc.Version, ID: &id, Method: method, Params: raw}))
+
+	for {
+		msg, err := c.stream.Read()
+		require.NoError(c.t, err)
+		if msg.IsNotification() {
+			c.notifications = append(c.notifications, msg)
+			continue
+		}
+		require.Equal(c.t, string(id), string(*msg.ID))
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if result != nil {
+			require.NoError(c.t, json.Unmarshal(msg.Result, result))
+		}
+		return nil
+	}
+}
+
+// mustCall is call that requires success.
+func (c *client) mustCall(method string, params interface{}, result interface{}) {
+	rpcErr := c.call(method, params, result)
+	require.Nil(c.t, rpcErr, "%s: %v", method, rpcErr)
+}
+
+func startServer(t *testing.T) (*Server, string) {
+	dir, err := os.MkdirTemp("", "tbd")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	socket := filepath.Join(dir, "d.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	server := NewServer()
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(listener) }()
+	t.Cleanup(func() {
+		listener.Close()
+		assert.NoError(t, <-done)
+	})
+	return server, socket
+}
+
+func TestEditUndoSaveAcrossClients(t *testing.T) {
+	server, socket := startServer(t)
+	path := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, os.WriteFile(path, []byte("\xEF\xBB\xBFhello\r\nworld\r\n"), 0600))
+
+	editor := dial(t, socket)
+	watcher := dial(t, socket)
+
+	var info BufferInfo
+	editor.mustCall("open", OpenParams{Name: "main", Path: path}, &info)
+	assert.Equal(t, 3, info.LineCount)
+	assert.Equal(t, "\r\n", info.EOL)
+	assert.Equal(t, 14, info.Length)
+
+	watcher.mustCall("subscribe", NameParams{Name: "main"}, nil)
+
+	var version VersionResult
+	editor.mustCall("edit", EditParams{Name: "main", Edits: []Edit{
+		{Offset: 0, Length: 5, Text: "goodbye"},
+		{Offset: 14, Length: 0, Text: "!"},
+	}}, &version)
+	assert.Equal(t, 1, version.Version)
+
+	stale := 0
+	rpcErr := editor.call("edit", EditParams{Name: "main", Version: &stale, Edits: []Edit{{Offset: 0, Text: "x"}}}, nil)
+	require.NotNil(t, rpcErr)
+	assert.Equal(t, CodeVersionConflict, rpcErr.Code)
+
+	rpcErr = editor.call("edit", EditParams{Name: "main", Edits: []Edit{{Offset: 0, Text: "x"}, {Offset: 100}}}, nil)
+	require.NotNil(t, rpcErr)
+	assert.Equal(t, jsonrpc.CodeInvalidParams, rpcErr.Code)
+
+	var read ReadResult
+	editor.mustCall("read", ReadParams{Name: "main"}, &read)
+	assert.Equal(t, "goodbye\r\nworld!\r\n", read.Text)
+
+	end := 7
+	editor.mustCall("read", ReadParams{Name: "main", Start: 4, End: &end}, &read)
+	assert.Equal(t, "bye", read.Text)
+
+	var search SearchResult
+	editor.mustCall("search", SearchParams{Name: "main", Query: "O", Limit: 3}, &search)
+	require.Len(t, search.Matches, 3)
+	assert.Equal(t, Match{Start: 10, End: 11, StartLine: 2, StartColumn: 2, EndLine: 2, EndColumn: 3}, search.Matches[2])
+
+	var undo UndoResult
+	editor.mustCall("undo", NameParams{Name: "main"}, &undo)
+	assert.Equal(t, UndoResult{Version: 2, Changed: true}, undo)
+	editor.mustCall("undo", NameParams{Name: "main"}, &undo)
+	assert.Equal(t, UndoResult{Version: 2, Changed: false}, undo)
+	editor.mustCall("redo", NameParams{Name: "main"}, &undo)
+	assert.Equal(t, UndoResult{Version: 3, Changed: true}, undo)
+
+	var saved SaveResult
+	editor.mustCall("save", SaveParams{Name: "main"}, &saved)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "\xEF\xBB\xBFgoodbye\r\nworld!\r\n", string(content))
+
+	// The watcher replays the notifications onto its own copy of the text.
+	var infos []BufferInfo
+	watcher.mustCall("list", struct{}{}, &infos)
+	require.Len(t, infos, 1)
+	assert.False(t, infos[0].Dirty)
+
+	text := "hello\r\nworld\r\n"
+	versions := []int{}
+	for _, msg := range watcher.notifications {
+		if msg.Method != MethodDidChange {
+			continue
+		}
+		var event ChangeEvent
+		require.NoError(t, json.Unmarshal(msg.Params, &event))
+		for _, change := range event.Changes {
+			text = text[:change.Offset] + change.Text + text[change.Offset+change.Length:]
+		}
+		versions = append(versions, event.Version)
+	}
+	assert.Equal(t, []int{1, 2, 3}, versions)
+	assert.Equal(t, "goodbye\r\nworld!\r\n", text)
+	assert.Equal(t, MethodDidSave, watcher.notifications[len(watcher.notifications)-1].Method)
+
+	assert.Equal(t, 3, server.Buffer("main").Version)
+}
+
+func TestErrors(t *testing.T) {
+	_, socket := startServer(t)
+	c := dial(t, socket)
+
+	rpcErr := c.call("read", ReadParams{Name: "missing"}, nil)
+	require.NotNil(t, rpcErr)
+	assert.Equal(t, CodeUnknownBuffer, rpcErr.Code)
+
+	rpcErr = c.call("frobnicate", struct{}{}, nil)
+	require.NotNil(t, rpcErr)
+	assert.Equal(t, jsonrpc.CodeMethodNotFound, rpcErr.Code)
+
+	c.mustCall("open", OpenParams{Name: "scratch", Text: "abc"}, nil)
+	rpcErr = c.call("save", SaveParams{Name: "scratch"}, nil)
+	require.NotNil(t, rpcErr)
+	assert.Equal(t, jsonrpc.CodeInvalidParams, rpcErr.Code)
+
+	rpcErr = c.call("search", SearchParams{Name: "scratch", Query: "(", IsRegex: true}, nil)
+	require.NotNil(t, rpcErr)
+	assert.Equal(t, jsonrpc.CodeInvalidParams, rpcErr.Code)
+
+	// Reopening a buffer under another path is an error.
+	path := filepath.Join(t.TempDir(), "other.txt")
+	rpcErr = c.call("open", OpenParams{Name: "scratch", Path: path}, nil)
+	require.NotNil(t, rpcErr)
+	assert.Equal(t, jsonrpc.CodeInvalidParams, rpcErr.Code)
+	c.mustCall("open", OpenParams{Name: "scratch"}, nil)
+
+	c.mustCall("close", NameParams{Name: "scratch"}, nil)
+	var infos []BufferInfo
+	c.mustCall("list", struct{}{}, &infos)
+	assert.Empty(t, infos)
+}
+
+// stalledConn never lets writes through until it is closed.
+type stalledConn struct {
+	io.Reader
+	*io.PipeWriter
+	closed chan struct{}
+}
+
+func (c *stalledConn) Close() error {
+	close(c.closed)
+	return c.PipeWriter.Close()
+}
+
+func TestSessionQueueIsBounded(t *testing.T) {
+	_, w := io.Pipe()
+	conn := &stalledConn{Reader: strings.NewReader(""), PipeWriter: w, closed: make(chan struct{})}
+	sess := newSession(conn)
+	sess.maxQueue = 8
+
+	msg, err := jsonrpc.NewNotification("ping", nil)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		sess.send(msg)
+	}
+	<-conn.closed
+	sess.mu.Lock()
+	assert.True(t, sess.closed)
+	assert.Empty(t, sess.queue)
+	sess.mu.Unlock()
+	sess.close()
+}
@@ -0,0 +1,173 @@
+package daemon
+
+// 错误码
+const (
+	// CodeUnknownBuffer 缓冲区不存在
+	CodeUnknownBuffer = -32001
+	// CodeVersionConflict 编辑基于的版本与当前版本不一致
+	CodeVersionConflict = -32002
+	// CodeIOError 读写文件失败
+	CodeIOError = -32003
+)
+
+// 通知方法名
+const (
+	// MethodDidChange 缓冲区内容变更通知
+	MethodDidChange = "didChange"
+	// MethodDidSave 缓冲区保存通知
+	MethodDidSave = "didSave"
+	// MethodDidClose 缓冲区关闭通知
+	MethodDidClose = "didClose"
+)
+
+// NameParams 只包含缓冲区名称的参数
+type NameParams struct {
+	// Name 缓冲区名称
+	Name string `json:"name"`
+}
+
+// OpenParams open 参数
+type OpenParams struct {
+	// Name 缓冲区名称
+	Name string `json:"name"`
+	// Path 文件路径，文件不存在时创建空缓冲区
+	Path string `json:"path,omitempty"`
+	// Text 没有指定路径时的初始内容
+	Text string `json:"text,omitempty"`
+}
+
+// BufferInfo 缓冲区信息
+type BufferInfo struct {
+	// Name 缓冲区名称
+	Name string `json:"name"`
+	// Path 文件路径
+	Path string `json:"path,omitempty"`
+	// Version 版本，每次修改加一
+	Version int `json:"version"`
+	// Length 内容长度（字节）
+	Length int `json:"length"`
+	// LineCount 行数
+	LineCount int `json:"lineCount"`
+	// EOL 换行符
+	EOL string `json:"eol"`
+	// Dirty 是否有未保存的修改
+	Dirty bool `json:"dirty"`
+}
+
+// Edit 基于字节偏移量的编辑
+type Edit struct {
+	// Offset 起始偏移量
+	Offset int `json:"offset"`
+	// Length 删除的长度
+	Length int `json:"length"`
+	// Text 插入的文本
+	Text string `json:"text"`
+}
+
+// EditParams edit 参数
+type EditParams struct {
+	// Name 缓冲区名称
+	Name string `json:"name"`
+	// Version 编辑基于的版本，为空时不检查
+	Version *int `json:"version,omitempty"`
+	// Edits 按顺序应用的编辑，每个编辑的偏移量基于前一个编辑完成后的内容
+	Edits []Edit `json:"edits"`
+}
+
+// VersionResult 只包含版本的结果
+type VersionResult struct {
+	// Version 当前版本
+	Version int `json:"version"`
+}
+
+// UndoResult undo/redo 结果
+type UndoResult struct {
+	// Version 当前版本
+	Version int `json:"version"`
+	// Changed 是否有修改被撤销或重做
+	Changed bool `json:"changed"`
+}
+
+// ReadParams read 参数
+type ReadParams struct {
+	// Name 缓冲区名称
+	Name string `json:"name"`
+	// Start 起始偏移量
+	Start int `json:"start"`
+	// End 结束偏移量（不包含），为空时读到末尾
+	End *int `json:"end,omitempty"`
+}
+
+// ReadResult read 结果
+type ReadResult struct {
+	// Version 读取时的版本
+	Version int `json:"version"`
+	// Text 内容
+	Text string `json:"text"`
+}
+
+// SearchParams search 参数
+type SearchParams struct {
+	// Name 缓冲区名称
+	Name string `json:"name"`
+	// Query 查询
+	Query string `json:"query"`
+	// IsRegex 查询是否为正则表达式
+	IsRegex bool `json:"isRegex,omitempty"`
+	// MatchCase 是否区分大小写
+	MatchCase bool `json:"matchCase,omitempty"`
+	// WholeWord 是否只匹配完整的单词
+	WholeWord bool `json:"wholeWord,omitempty"`
+	// Limit 最多返回的匹配数量，0 表示不限制
+	Limit int `json:"limit,omitempty"`
+}
+
+// Match 搜索结果
+type Match struct {
+	// Start 匹配开始的偏移量
+	Start int `json:"start"`
+	// End 匹配结束的偏移量（不包含）
+	End int `json:"end"`
+	// StartLine 开始行号（从 1 开始）
+	StartLine int `json:"startLine"`
+	// StartColumn 开始列号（从 1 开始，以字节计）
+	StartColumn int `json:"startColumn"`
+	// EndLine 结束行号
+	EndLine int `json:"endLine"`
+	// EndColumn 结束列号
+	EndColumn int `json:"endColumn"`
+}
+
+// SearchResult search 结果
+type SearchResult struct {
+	// Version 搜索时的版本
+	Version int `json:"version"`
+	// Matches 匹配
+	Matches []Match `json:"matches"`
+}
+
+// SaveParams save 参数
+type SaveParams struct {
+	// Name 缓冲区名称
+	Name string `json:"name"`
+	// Path 保存路径，为空时使用打开时的路径
+	Path string `json:"path,omitempty"`
+}
+
+// SaveResult save 结果
+type SaveResult struct {
+	// Path 保存路径
+	Path string `json:"path"`
+	// Version 保存的版本
+	Version int `json:"version"`
+}
+
+// ChangeEvent didChange 通知参数
+type ChangeEvent struct {
+	// Name 缓冲区名称
+	Name string `json:"name"`
+	// Version 变更后的版本
+	Version int `json:"version"`
+	// Changes 按顺序应用的编辑
+	Changes []Edit `json:"changes"`
+}
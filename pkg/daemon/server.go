@@ -0,0 +1,412 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+	"github.com/kebaren/textbuffer/pkg/fsutil"
+	"github.com/kebaren/textbuffer/pkg/jsonrpc"
+)
+
+// DefaultHistoryLimit 每个缓冲区默认保留的撤销批次数量
+const DefaultHistoryLimit = 1000
+
+// Buffer 服务器托管的命名缓冲区
+type Buffer struct {
+	// Name 缓冲区名称
+	Name string
+	// Path 文件路径
+	Path string
+	// BOM 打开文件时读到的 BOM，保存时写回
+	BOM string
+	// Version 版本，每次修改加一
+	Version int
+	// SavedVersion 最近一次保存时的版本
+	SavedVersion int
+	// History 撤销/重做栈
+	History *buffer.History
+	// subscribers 订阅变更通知的会话
+	subscribers map[*session]struct{}
+}
+
+// Tree 返回缓冲区内容
+func (b *Buffer) Tree() *buffer.PieceTreeBase {
+	return b.History.Tree()
+}
+
+// info 返回缓冲区信息
+func (b *Buffer) info() BufferInfo {
+	tree := b.Tree()
+	return BufferInfo{
+		Name:      b.Name,
+		Path:      b.Path,
+		Version:   b.Version,
+		Length:    tree.GetLength(),
+		LineCount: tree.GetLineCount(),
+		EOL:       tree.GetEOL(),
+		Dirty:     b.Version != b.SavedVersion,
+	}
+}
+
+// Server 托管多个命名缓冲区的服务器，通过 JSON-RPC 提供访问
+// 所有客户端共享同一份缓冲区，修改会以 didChange 通知推送给订阅者
+type Server struct {
+	// mu 保护所有缓冲区
+	mu sync.Mutex
+	// buffers 打开的缓冲区
+	buffers map[string]*Buffer
+	// historyLimit 每个缓冲区保留的撤销批次数量
+	historyLimit int
+}
+
+// NewServer 创建一个新的服务器
+func NewServer() *Server {
+	return &Server{
+		buffers:      make(map[string]*Buffer),
+		historyLimit: DefaultHistoryLimit,
+	}
+}
+
+// Serve 接受连接直到监听器被关闭
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			_ = s.ServeConn(conn)
+		}()
+	}
+}
+
+// ServeConn 处理一个连接上的消息直到连接关闭
+func (s *Server) ServeConn(conn io.ReadWriter) error {
+	sess := newSession(conn)
+	defer func() {
+		s.unsubscribeAll(sess)
+		sess.close()
+	}()
+
+	for {
+		msg, err := sess.stream.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			rpcErr, ok := err.(*jsonrpc.Error)
+			if !ok {
+				return err
+			}
+			sess.send(jsonrpc.NewResponse(nil, nil, rpcErr))
+			continue
+		}
+		if msg.IsResponse() {
+			continue
+		}
+
+		result, err := s.dispatch(sess, msg)
+		if msg.IsRequest() {
+			sess.send(jsonrpc.NewResponse(msg.ID, result, err))
+		}
+	}
+}
+
+// Buffer 返回命名缓冲区，不存在时返回 nil
+// 返回的缓冲区不能在服务器运行时并发修改
+func (s *Server) Buffer(name string) *Buffer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buffers[name]
+}
+
+// dispatch 根据方法名分发消息
+func (s *Server) dispatch(sess *session, msg *jsonrpc.Message) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch msg.Method {
+	case "open":
+		return s.open(msg.Params)
+	case "close":
+		return s.close(msg.Params)
+	case "list":
+		return s.list(), nil
+	case "edit":
+		return s.edit(msg.Params)
+	case "read":
+		return s.read(msg.Params)
+	case "search":
+		return s.search(msg.Params)
+	case "undo":
+		return s.undo(msg.Params, false)
+	case "redo":
+		return s.undo(msg.Params, true)
+	case "save":
+		return s.save(msg.Params)
+	case "subscribe":
+		return s.subscribe(sess, msg.Params, true)
+	case "unsubscribe":
+		return s.subscribe(sess, msg.Params, false)
+	}
+	return nil, jsonrpc.NewError(jsonrpc.CodeMethodNotFound, "method %q not found", msg.Method)
+}
+
+// lookup 解析参数并查找缓冲区
+func (s *Server) lookup(raw json.RawMessage, params interface{}, name func() string) (*Buffer, error) {
+	if err := jsonrpc.UnmarshalParams(raw, params); err != nil {
+		return nil, err
+	}
+	b, ok := s.buffers[name()]
+	if !ok {
+		return nil, jsonrpc.NewError(CodeUnknownBuffer, "buffer %q is not open", name())
+	}
+	return b, nil
+}
+
+// open 打开缓冲区，已经打开时直接返回其信息，请求的路径必须与已打开的路径相同
+func (s *Server) open(raw json.RawMessage) (interface{}, error) {
+	var params OpenParams
+	if err := jsonrpc.UnmarshalParams(raw, &params); err != nil {
+		return nil, err
+	}
+	if params.Name == "" {
+		return nil, jsonrpc.NewError(jsonrpc.CodeInvalidParams, "buffer name is required")
+	}
+	if b, ok := s.buffers[params.Name]; ok {
+		if params.Path != b.Path {
+			return nil, jsonrpc.NewError(jsonrpc.CodeInvalidParams, "buffer %q is already open with path %q", params.Name, b.Path)
+		}
+		return b.info(), nil
+	}
+
+	text := params.Text
+	if params.Path != "" {
+		content, err := os.ReadFile(params.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, jsonrpc.NewError(CodeIOError, "%s", err.Error())
+		}
+		text = string(content)
+	}
+
+	builder := buffer.NewPieceTreeTextBufferBuilder()
+	builder.AcceptChunk(text)
+	tree := builder.Finish(false).Create(buffer.LF)
+
+	b := &Buffer{
+		Name:        params.Name,
+		Path:        params.Path,
+		BOM:         builder.BOM,
+		History:     buffer.NewHistory(tree, s.historyLimit),
+		subscribers: make(map[*session]struct{}),
+	}
+	s.buffers[b.Name] = b
+	return b.info(), nil
+}
+
+// close 关闭缓冲区，未保存的修改会被丢弃
+func (s *Server) close(raw json.RawMessage) (interface{}, error) {
+	var params NameParams
+	b, err := s.lookup(raw, &params, func() string { return params.Name })
+	if err != nil {
+		return nil, err
+	}
+	s.notify(b, MethodDidClose, NameParams{Name: b.Name})
+	delete(s.buffers, b.Name)
+	return nil, nil
+}
+
+// list 返回所有缓冲区的信息（按名称排序）
+func (s *Server) list() []BufferInfo {
+	infos := make([]BufferInfo, 0, len(s.buffers))
+	for _, b := range s.buffers {
+		infos = append(infos, b.info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// edit 应用一组编辑，作为一个撤销批次
+func (s *Server) edit(raw json.RawMessage) (interface{}, error) {
+	var params EditParams
+	b, err := s.lookup(raw, &params, func() string { return params.Name })
+	if err != nil {
+		return nil, err
+	}
+	if params.Version != nil && *params.Version != b.Version {
+		return nil, jsonrpc.NewError(CodeVersionConflict, "buffer %q is at version %d, not %d", b.Name, b.Version, *params.Version)
+	}
+
+	// 先检查所有编辑，保证要么全部应用，要么都不应用
+	length := b.Tree().GetLength()
+	ops := make([]buffer.EditOperation, 0, len(params.Edits))
+	for i, edit := range params.Edits {
+		if edit.Offset < 0 || edit.Length < 0 || edit.Offset+edit.Length > length {
+			return nil, jsonrpc.NewError(jsonrpc.CodeInvalidParams, "edit %d [%d, %d) is out of range [0, %d]", i, edit.Offset, edit.Offset+edit.Length, length)
+		}
+		length += len(edit.Text) - edit.Length
+		if op := buffer.NewEditOperation(edit.Offset, edit.Length, edit.Text); !op.IsNoop() {
+			ops = append(ops, op)
+		}
+	}
+	if len(ops) == 0 {
+		return VersionResult{Version: b.Version}, nil
+	}
+
+	b.History.Apply(ops)
+	s.changed(b, ops)
+	return VersionResult{Version: b.Version}, nil
+}
+
+// read 读取偏移量范围内的内容
+func (s *Server) read(raw json.RawMessage) (interface{}, error) {
+	var params ReadParams
+	b, err := s.lookup(raw, &params, func() string { return params.Name })
+	if err != nil {
+		return nil, err
+	}
+
+	tree := b.Tree()
+	end := tree.GetLength()
+	if params.End != nil {
+		end = *params.End
+	}
+	if params.Start < 0 || params.Start > end || end > tree.GetLength() {
+		return nil, jsonrpc.NewError(jsonrpc.CodeInvalidParams, "range [%d, %d) is out of range [0, %d]", params.Start, end, tree.GetLength())
+	}
+	return ReadResult{Version: b.Version, Text: tree.GetValueInOffsetRange(params.Start, end)}, nil
+}
+
+// search 查找匹配
+func (s *Server) search(raw json.RawMessage) (interface{}, error) {
+	var params SearchParams
+	b, err := s.lookup(raw, &params, func() string { return params.Name })
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := b.Tree().FindMatches(params.Query, buffer.SearchOptions{
+		IsRegex:   params.IsRegex,
+		MatchCase: params.MatchCase,
+		WholeWord: params.WholeWord,
+	}, params.Limit)
+	if err != nil {
+		return nil, jsonrpc.NewError(jsonrpc.CodeInvalidParams, "%s", err.Error())
+	}
+
+	matches := make([]Match, len(found))
+	for i, m := range found {
+		matches[i] = Match{
+			Start:       m.StartOffset,
+			End:         m.EndOffset,
+			StartLine:   m.Range.StartLineNumber,
+			StartColumn: m.Range.StartColumn,
+			EndLine:     m.Range.EndLineNumber,
+			EndColumn:   m.Range.EndColumn,
+		}
+	}
+	return SearchResult{Version: b.Version, Matches: matches}, nil
+}
+
+// undo 撤销或重做最近的批次
+func (s *Server) undo(raw json.RawMessage, redo bool) (interface{}, error) {
+	var params NameParams
+	b, err := s.lookup(raw, &params, func() string { return params.Name })
+	if err != nil {
+		return nil, err
+	}
+
+	var ops []buffer.EditOperation
+	if redo {
+		ops = b.History.Redo()
+	} else {
+		ops = b.History.Undo()
+	}
+	if ops == nil {
+		return UndoResult{Version: b.Version}, nil
+	}
+	s.changed(b, ops)
+	return UndoResult{Version: b.Version, Changed: true}, nil
+}
+
+// save 把缓冲区写入文件，先写临时文件再重命名，避免写入中途失败损坏原文件
+func (s *Server) save(raw json.RawMessage) (interface{}, error) {
+	var params SaveParams
+	b, err := s.lookup(raw, &params, func() string { return params.Name })
+	if err != nil {
+		return nil, err
+	}
+	path := params.Path
+	if path == "" {
+		path = b.Path
+	}
+	if path == "" {
+		return nil, jsonrpc.NewError(jsonrpc.CodeInvalidParams, "buffer %q has no path", b.Name)
+	}
+
+	if err := fsutil.WriteFile(path, []byte(b.BOM+b.Tree().GetLinesRawContent())); err != nil {
+		return nil, jsonrpc.NewError(CodeIOError, "%s", err.Error())
+	}
+	b.Path = path
+	b.SavedVersion = b.Version
+	s.notify(b, MethodDidSave, SaveResult{Path: path, Version: b.Version})
+	return SaveResult{Path: path, Version: b.Version}, nil
+}
+
+// subscribe 订阅或取消订阅缓冲区的变更通知
+func (s *Server) subscribe(sess *session, raw json.RawMessage, on bool) (interface{}, error) {
+	var params NameParams
+	b, err := s.lookup(raw, &params, func() string { return params.Name })
+	if err != nil {
+		return nil, err
+	}
+	if on {
+		b.subscribers[sess] = struct{}{}
+	} else {
+		delete(b.subscribers, sess)
+	}
+	return b.info(), nil
+}
+
+// unsubscribeAll 取消会话的所有订阅
+func (s *Server) unsubscribeAll(sess *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range s.buffers {
+		delete(b.subscribers, sess)
+	}
+}
+
+// changed 增加版本并通知订阅者
+func (s *Server) changed(b *Buffer, ops []buffer.EditOperation) {
+	b.Version++
+	changes := make([]Edit, len(ops))
+	for i, op := range ops {
+		changes[i] = Edit{Offset: op.Offset, Length: op.Length, Text: op.Text}
+	}
+	s.notify(b, MethodDidChange, ChangeEvent{Name: b.Name, Version: b.Version, Changes: changes})
+}
+
+// notify 向缓冲区的订阅者发送通知，调用时必须持有锁以保证通知顺序
+func (s *Server) notify(b *Buffer, method string, params interface{}) {
+	if len(b.subscribers) == 0 {
+		return
+	}
+	msg, err := jsonrpc.NewNotification(method, params)
+	if err != nil {
+		return
+	}
+	for sess := range b.subscribers {
+		sess.send(msg)
+	}
+}
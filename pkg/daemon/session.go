@@ -0,0 +1,103 @@
+package daemon
+
+import (
+	"io"
+	"sync"
+
+	"github.com/kebaren/textbuffer/pkg/jsonrpc"
+)
+
+// maxQueuedMessages 每个会话最多排队的消息数量，超过时断开连接
+const maxQueuedMessages = 4096
+
+// session 一个客户端连接
+// 发往客户端的消息先进入队列，由单独的 goroutine 按顺序写出，
+// 这样不读取消息的客户端不会阻塞服务器，通知之间的顺序也与版本顺序一致；
+// 队列已满说明客户端读得太慢，此时丢弃队列并断开连接，避免内存无限增长
+type session struct {
+	// conn 连接，实现了 io.Closer 时在队列溢出时被关闭
+	conn io.ReadWriter
+	// stream 消息流
+	stream jsonrpc.Stream
+	// maxQueue 最多排队的消息数量
+	maxQueue int
+	// mu 保护 queue 和 closed
+	mu sync.Mutex
+	// cond 队列有新消息或会话关闭时发出信号
+	cond *sync.Cond
+	// queue 待写出的消息
+	queue []*jsonrpc.Message
+	// closed 会话是否已关闭
+	closed bool
+	// done 写出 goroutine 退出时关闭
+	done chan struct{}
+}
+
+// newSession 创建一个新的会话并启动写出 goroutine
+func newSession(conn io.ReadWriter) *session {
+	s := &session{
+		conn:     conn,
+		stream:   jsonrpc.NewLineStream(conn, conn),
+		maxQueue: maxQueuedMessages,
+		done:     make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	go s.writeLoop()
+	return s
+}
+
+// send 把消息加入发送队列，队列已满时丢弃所有消息并断开连接
+func (s *session) send(msg *jsonrpc.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	if len(s.queue) >= s.maxQueue {
+		s.closed = true
+		s.queue = nil
+		s.cond.Signal()
+		if closer, ok := s.conn.(io.Closer); ok {
+			// 关闭连接同时让阻塞的读写返回
+			_ = closer.Close()
+		}
+		return
+	}
+	s.queue = append(s.queue, msg)
+	s.cond.Signal()
+}
+
+// close 关闭会话，已在队列中的消息仍会被写出
+func (s *session) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.cond.Signal()
+	s.mu.Unlock()
+	<-s.done
+}
+
+// writeLoop 按顺序写出队列中的消息
+func (s *session) writeLoop() {
+	defer close(s.done)
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if len(s.queue) == 0 {
+			s.mu.Unlock()
+			return
+		}
+		msg := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+
+		if err := s.stream.Write(msg); err != nil {
+			s.mu.Lock()
+			s.closed = true
+			s.queue = nil
+			s.mu.Unlock()
+			return
+		}
+	}
+}
@@ -0,0 +1,531 @@
+package editor
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+	"github.com/kebaren/textbuffer/pkg/common"
+	"github.com/kebaren/textbuffer/pkg/fsutil"
+)
+
+// DefaultTabSize 默认制表符宽度
+const DefaultTabSize = 4
+
+// Editor 终端编辑器的状态
+// 编辑器本身不做任何终端输入输出，HandleKey 处理按键，Render 输出整个屏幕
+type Editor struct {
+	// path 文件路径
+	path string
+	// bom 打开文件时读到的 BOM，保存时写回
+	bom string
+	// history 撤销/重做栈，同时持有片段树
+	history *buffer.History
+	// version 每次修改加一
+	version int
+	// savedVersion 最近一次保存时的版本
+	savedVersion int
+
+	// line 光标所在行（从 1 开始）
+	line int
+	// col 光标在行内的字节偏移量（从 0 开始）
+	col int
+	// wantCol 上下移动时希望保持的显示列
+	wantCol int
+	// rowOffset 第一个可见行之前的行数
+	rowOffset int
+	// colOffset 第一个可见的显示列
+	colOffset int
+
+	// rows 屏幕行数
+	rows int
+	// cols 屏幕列数
+	cols int
+	// tabSize 制表符宽度
+	tabSize int
+
+	// message 状态消息
+	message string
+	// prompting 是否正在输入搜索内容
+	prompting bool
+	// query 正在输入或上一次的搜索内容
+	query string
+	// quitConfirm 是否已经提示过未保存的修改
+	quitConfirm bool
+	// quit 是否退出
+	quit bool
+}
+
+// Open 打开文件，文件不存在时创建空缓冲区，保存时再创建文件
+func Open(path string) (*Editor, error) {
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	e := NewEditor(string(content))
+	e.path = path
+	if os.IsNotExist(err) {
+		e.message = "New file"
+	}
+	return e, nil
+}
+
+// NewEditor 创建一个编辑给定文本的编辑器
+func NewEditor(text string) *Editor {
+	builder := buffer.NewPieceTreeTextBufferBuilder()
+	builder.AcceptChunk(text)
+	tree := builder.Finish(false).Create(buffer.LF)
+
+	return &Editor{
+		bom:     builder.BOM,
+		history: buffer.NewHistory(tree, 0),
+		line:    1,
+		rows:    24,
+		cols:    80,
+		tabSize: DefaultTabSize,
+		message: "Ctrl-S save | Ctrl-Q quit | Ctrl-F find | Ctrl-N next | Ctrl-Z undo | Ctrl-Y redo",
+	}
+}
+
+// Tree 返回正在编辑的片段树
+func (e *Editor) Tree() *buffer.PieceTreeBase {
+	return e.history.Tree()
+}
+
+// Cursor 返回光标位置，列以字节计
+func (e *Editor) Cursor() common.Position {
+	return common.Position{LineNumber: e.line, Column: e.col + 1}
+}
+
+// SetSize 设置屏幕大小
+func (e *Editor) SetSize(rows, cols int) {
+	if rows < 3 {
+		rows = 3
+	}
+	if cols < 1 {
+		cols = 1
+	}
+	e.rows, e.cols = rows, cols
+	e.scroll()
+}
+
+// IsDirty 判断是否有未保存的修改
+func (e *Editor) IsDirty() bool {
+	return e.version != e.savedVersion
+}
+
+// ShouldQuit 判断编辑器是否应该退出
+func (e *Editor) ShouldQuit() bool {
+	return e.quit
+}
+
+// Message 返回当前的状态消息
+func (e *Editor) Message() string {
+	return e.message
+}
+
+// textRows 返回用于显示文本的行数，最后两行是状态栏和消息栏
+func (e *Editor) textRows() int {
+	return e.rows - 2
+}
+
+// lineContent 返回指定行的内容
+func (e *Editor) lineContent(lineNumber int) string {
+	return e.Tree().GetLineContent(lineNumber)
+}
+
+// offset 返回光标的偏移量
+func (e *Editor) offset() int {
+	return e.Tree().GetOffsetAt(e.line, e.col+1)
+}
+
+// moveToOffset 把光标移动到偏移量处
+func (e *Editor) moveToOffset(offset int) {
+	pos := e.Tree().GetPositionAt(offset)
+	e.line = pos.LineNumber
+	e.col = pos.Column - 1
+	e.wantCol = visualColumn(e.lineContent(e.line), e.col, e.tabSize)
+}
+
+// HandleKey 处理一次按键
+func (e *Editor) HandleKey(key Key) {
+	if e.prompting {
+		e.handlePromptKey(key)
+		e.scroll()
+		return
+	}
+
+	quitConfirm := e.quitConfirm
+	e.quitConfirm = false
+
+	switch key.Code {
+	case KeyRune:
+		e.insert(string(key.Rune))
+	case KeyTab:
+		e.insert("\t")
+	case KeyEnter:
+		e.insert(e.Tree().GetEOL())
+	case KeyBackspace:
+		e.backspace()
+	case KeyDelete:
+		e.deleteForward()
+	case KeyLeft:
+		e.moveLeft()
+	case KeyRight:
+		e.moveRight()
+	case KeyUp:
+		e.moveVertical(-1)
+	case KeyDown:
+		e.moveVertical(1)
+	case KeyPageUp:
+		e.moveVertical(-e.textRows())
+	case KeyPageDown:
+		e.moveVertical(e.textRows())
+	case KeyHome:
+		e.col = 0
+		e.wantCol = 0
+	case KeyEnd:
+		e.col = len(e.lineContent(e.line))
+		e.wantCol = visualColumn(e.lineContent(e.line), e.col, e.tabSize)
+	case KeyF3:
+		e.findNext()
+	case KeyCtrl:
+		e.handleCtrl(key.Rune, quitConfirm)
+	}
+	e.scroll()
+}
+
+// handleCtrl 处理 Ctrl 组合键
+func (e *Editor) handleCtrl(r rune, quitConfirm bool) {
+	switch r {
+	case 's':
+		if err := e.Save(); err != nil {
+			e.message = "Save failed: " + err.Error()
+		}
+	case 'q':
+		if e.IsDirty() && !quitConfirm {
+			e.message = "Unsaved changes; press Ctrl-Q again to quit"
+			e.quitConfirm = true
+			return
+		}
+		e.quit = true
+	case 'f':
+		e.prompting = true
+		e.query = ""
+		e.message = ""
+	case 'n':
+		e.findNext()
+	case 'z':
+		e.undo(false)
+	case 'y':
+		e.undo(true)
+	case 'a':
+		e.col = 0
+		e.wantCol = 0
+	case 'e':
+		e.HandleKey(Key{Code: KeyEnd})
+	}
+}
+
+// handlePromptKey 处理搜索输入
+func (e *Editor) handlePromptKey(key Key) {
+	switch key.Code {
+	case KeyRune:
+		e.query += string(key.Rune)
+	case KeyBackspace:
+		if len(e.query) > 0 {
+			e.query = e.query[:common.PrevGraphemeBoundary(e.query, len(e.query))]
+		}
+	case KeyEnter:
+		e.prompting = false
+		e.findNext()
+	case KeyEscape:
+		e.prompting = false
+		e.message = ""
+	case KeyCtrl:
+		if key.Rune == 'c' || key.Rune == 'g' {
+			e.prompting = false
+			e.message = ""
+		}
+	}
+}
+
+// apply 应用一个编辑并把光标移动到插入文本之后
+func (e *Editor) apply(offset, length int, text string) {
+	e.history.Apply([]buffer.EditOperation{buffer.NewEditOperation(offset, length, text)})
+	e.version++
+	e.moveToOffset(offset + len(text))
+}
+
+// insert 在光标处插入文本
+func (e *Editor) insert(text string) {
+	e.apply(e.offset(), 0, text)
+}
+
+// backspace 删除光标前的字素簇，在行首时与上一行合并
+func (e *Editor) backspace() {
+	if e.col > 0 {
+		line := e.lineContent(e.line)
+		prev := common.PrevGraphemeBoundary(line, e.col)
+		start := e.Tree().GetOffsetAt(e.line, prev+1)
+		e.apply(start, e.offset()-start, "")
+		return
+	}
+	if e.line > 1 {
+		prevLine := e.lineContent(e.line - 1)
+		start := e.Tree().GetOffsetAt(e.line-1, len(prevLine)+1)
+		e.apply(start, e.offset()-start, "")
+	}
+}
+
+// deleteForward 删除光标后的字素簇，在行尾时与下一行合并
+func (e *Editor) deleteForward() {
+	line := e.lineContent(e.line)
+	offset := e.offset()
+	if e.col < len(line) {
+		next := common.NextGraphemeBoundary(line, e.col)
+		e.apply(offset, next-e.col, "")
+		return
+	}
+	if e.line < e.Tree().GetLineCount() {
+		end := e.Tree().GetOffsetAt(e.line+1, 1)
+		e.apply(offset, end-offset, "")
+	}
+}
+
+// moveLeft 光标左移一个字素簇
+func (e *Editor) moveLeft() {
+	if e.col > 0 {
+		e.col = common.PrevGraphemeBoundary(e.lineContent(e.line), e.col)
+	} else if e.line > 1 {
+		e.line--
+		e.col = len(e.lineContent(e.line))
+	}
+	e.wantCol = visualColumn(e.lineContent(e.line), e.col, e.tabSize)
+}
+
+// moveRight 光标右移一个字素簇
+func (e *Editor) moveRight() {
+	line := e.lineContent(e.line)
+	if e.col < len(line) {
+		e.col = common.NextGraphemeBoundary(line, e.col)
+	} else if e.line < e.Tree().GetLineCount() {
+		e.line++
+		e.col = 0
+	}
+	e.wantCol = visualColumn(e.lineContent(e.line), e.col, e.tabSize)
+}
+
+// moveVertical 光标上下移动，尽量保持显示列不变
+func (e *Editor) moveVertical(delta int) {
+	e.line += delta
+	if e.line < 1 {
+		e.line = 1
+	}
+	if lineCount := e.Tree().GetLineCount(); e.line > lineCount {
+		e.line = lineCount
+	}
+	e.col = byteColumnAt(e.lineContent(e.line), e.wantCol, e.tabSize)
+}
+
+// undo 撤销或重做，并把光标移动到最后一处修改
+func (e *Editor) undo(redo bool) {
+	var ops []buffer.EditOperation
+	if redo {
+		ops = e.history.Redo()
+	} else {
+		ops = e.history.Undo()
+	}
+	if ops == nil {
+		if redo {
+			e.message = "Nothing to redo"
+		} else {
+			e.message = "Nothing to undo"
+		}
+		return
+	}
+	e.version++
+	last := ops[len(ops)-1]
+	e.moveToOffset(last.Offset + len(last.Text))
+}
+
+// findNext 从光标之后查找下一个匹配，到达末尾时从头开始
+// 搜索内容全部是小写时不区分大小写
+func (e *Editor) findNext() {
+	if e.query == "" {
+		e.message = "No search query; press Ctrl-F"
+		return
+	}
+	// 从光标之后的字符开始查找，到达末尾时回到开头
+	offset := e.offset()
+	from := e.Tree().ModifyPosition(*e.Tree().GetPositionAt(offset), 1)
+	match, err := e.Tree().FindNextMatch(e.query, from, buffer.SearchOptions{
+		MatchCase: strings.IndexFunc(e.query, unicode.IsUpper) >= 0,
+	})
+	if err != nil {
+		e.message = err.Error()
+		return
+	}
+	if match == nil {
+		e.message = fmt.Sprintf("Not found: %s", e.query)
+		return
+	}
+
+	e.message = ""
+	if match.StartOffset <= offset {
+		e.message = "Search wrapped"
+	}
+	e.moveToOffset(match.StartOffset)
+}
+
+// Save 保存文件，保留原有的 BOM 和换行符
+func (e *Editor) Save() error {
+	if e.path == "" {
+		return fmt.Errorf("editor: no file name")
+	}
+	content := e.bom + e.Tree().GetLinesRawContent()
+
+	if err := fsutil.WriteFile(e.path, []byte(content)); err != nil {
+		return err
+	}
+	e.savedVersion = e.version
+	e.message = fmt.Sprintf("Wrote %d bytes to %s", len(content), e.path)
+	return nil
+}
+
+// scroll 调整滚动位置，保证光标可见
+func (e *Editor) scroll() {
+	if e.line-1 < e.rowOffset {
+		e.rowOffset = e.line - 1
+	}
+	if e.line-1 >= e.rowOffset+e.textRows() {
+		e.rowOffset = e.line - e.textRows()
+	}
+
+	visual := visualColumn(e.lineContent(e.line), e.col, e.tabSize)
+	if visual < e.colOffset {
+		e.colOffset = visual
+	}
+	if visual >= e.colOffset+e.cols {
+		e.colOffset = visual - e.cols + 1
+	}
+}
+
+// Render 输出整个屏幕
+func (e *Editor) Render(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	out.WriteString("\x1b[?25l\x1b[H")
+
+	lineCount := e.Tree().GetLineCount()
+	for row := 0; row < e.textRows(); row++ {
+		lineNumber := e.rowOffset + row + 1
+		if lineNumber <= lineCount {
+			out.WriteString(renderLine(e.lineContent(lineNumber), e.colOffset, e.cols, e.tabSize))
+		} else {
+			out.WriteString("~")
+		}
+		out.WriteString("\x1b[K\r\n")
+	}
+
+	out.WriteString("\x1b[7m")
+	out.WriteString(e.statusLine())
+	out.WriteString("\x1b[m\r\n")
+
+	message := e.message
+	if e.prompting {
+		message = "Search: " + e.query
+	}
+	out.WriteString(renderLine(message, 0, e.cols, e.tabSize))
+	out.WriteString("\x1b[K")
+
+	cursorRow := e.line - e.rowOffset
+	cursorCol := visualColumn(e.lineContent(e.line), e.col, e.tabSize) - e.colOffset + 1
+	if e.prompting {
+		cursorRow = e.rows
+		cursorCol = visualColumn(message, len(message), e.tabSize) + 1
+	}
+	fmt.Fprintf(out, "\x1b[%d;%dH\x1b[?25h", cursorRow, cursorCol)
+	return out.Flush()
+}
+
+// statusLine 返回状态栏内容，宽度与屏幕一致
+func (e *Editor) statusLine() string {
+	name := "[No Name]"
+	if e.path != "" {
+		name = filepath.Base(e.path)
+	}
+	if e.IsDirty() {
+		name += " [+]"
+	}
+	eol := "LF"
+	if e.Tree().GetEOL() == "\r\n" {
+		eol = "CRLF"
+	}
+	right := fmt.Sprintf("%s  %d:%d ", eol, e.line, visualColumn(e.lineContent(e.line), e.col, e.tabSize)+1)
+	left := " " + name
+
+	padding := e.cols - visualColumn(left, len(left), e.tabSize) - len(right)
+	if padding < 1 {
+		return renderLine(left+" "+right, 0, e.cols, e.tabSize)
+	}
+	return left + strings.Repeat(" ", padding) + right
+}
+
+// visualColumn 返回行内字节偏移量 col 处的显示列（从 0 开始）
+func visualColumn(line string, col, tabSize int) int {
+	return common.VisibleColumnFromColumn(line, col+1, tabSize)
+}
+
+// byteColumnAt 返回最接近显示列 visual 的字素簇边界的字节偏移量
+func byteColumnAt(line string, visual, tabSize int) int {
+	return common.ColumnFromVisibleColumn(line, visual, tabSize) - 1
+}
+
+// renderLine 把一行渲染为屏幕上 [colOffset, colOffset+width) 范围内的内容
+// 制表符展开为空格，宽字符占两列，控制字符显示为 ?
+func renderLine(line string, colOffset, width, tabSize int) string {
+	var sb strings.Builder
+	visual := 0
+	for i := 0; i < len(line) && visual < colOffset+width; {
+		next := common.NextGraphemeBoundary(line, i)
+		cluster := line[i:next]
+		i = next
+
+		if cluster == "\t" {
+			for n := tabSize - visual%tabSize; n > 0; n-- {
+				if visual >= colOffset && visual < colOffset+width {
+					sb.WriteByte(' ')
+				}
+				visual++
+			}
+			continue
+		}
+		if !utf8.ValidString(cluster) {
+			// 非法字节（例如单独的 0x9b 在 8 位终端上就是 CSI）同样不能直接输出，和导出一样显示为 U+FFFD
+			cluster = strings.ToValidUTF8(cluster, string(utf8.RuneError))
+		}
+		if r, _ := utf8.DecodeRuneInString(cluster); unicode.IsControl(r) {
+			// C0、DEL 和 C1 控制字符（包括单字符 CSI U+009B）不能直接输出到终端
+			cluster = "?"
+		}
+		clusterWidth := common.GraphemeWidth(cluster)
+		switch {
+		case visual < colOffset && visual+clusterWidth > colOffset:
+			// 宽字符被左边界截断，露出的部分显示为空格
+			sb.WriteString(strings.Repeat(" ", visual+clusterWidth-colOffset))
+		case visual >= colOffset && visual+clusterWidth > colOffset+width:
+			// 宽字符放不下，用空格填满右边界
+			sb.WriteString(strings.Repeat(" ", colOffset+width-visual))
+		case visual >= colOffset:
+			sb.WriteString(cluster)
+		}
+		visual += clusterWidth
+	}
+	return sb.String()
+}
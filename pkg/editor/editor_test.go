@@ -0,0 +1,179 @@
+package editor
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kebaren/textbuffer/pkg/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// typeKeys feeds raw terminal input to the editor.
+func typeKeys(e *Editor, input string) {
+	var decoder KeyDecoder
+	for _, key := range decoder.Feed([]byte(input)) {
+		e.HandleKey(key)
+	}
+}
+
+func TestKeyDecoder(t *testing.T) {
+	var decoder KeyDecoder
+	keys := decoder.Feed([]byte("a\x1b[A\x1b[3~\x1b[1;5C\x1bOH\x7f\r\x13\xe4\xb8"))
+	assert.Equal(t, []Key{
+		{Code: KeyRune, Rune: 'a'},
+		{Code: KeyUp},
+		{Code: KeyDelete},
+		{Code: KeyRight},
+		{Code: KeyHome},
+		{Code: KeyBackspace},
+		{Code: KeyEnter},
+		Ctrl('s'),
+	}, keys)
+
+	// The incomplete UTF-8 sequence is completed by the next read.
+	assert.Equal(t, []Key{{Code: KeyRune, Rune: '世'}, {Code: KeyEscape}}, decoder.Feed([]byte("\x96\x1b")))
+}
+
+func TestGraphemeMovementAndEditing(t *testing.T) {
+	e := NewEditor("éx👍🏽y\nsecond\n")
+
+	typeKeys(e, "\x1b[C\x1b[C")
+	assert.Equal(t, common.Position{LineNumber: 1, Column: 5}, e.Cursor())
+	typeKeys(e, "\x1b[C")
+	assert.Equal(t, common.Position{LineNumber: 1, Column: 13}, e.Cursor())
+
+	typeKeys(e, "\x7f")
+	assert.Equal(t, "éxy", e.Tree().GetLineContent(1))
+	typeKeys(e, "\x1b[D\x1b[3~")
+	assert.Equal(t, "éy", e.Tree().GetLineContent(1))
+
+	typeKeys(e, "\x1b[F\x1b[3~")
+	assert.Equal(t, "éysecond", e.Tree().GetLineContent(1))
+	typeKeys(e, "\r")
+	assert.Equal(t, "second", e.Tree().GetLineContent(2))
+	assert.Equal(t, common.Position{LineNumber: 2, Column: 1}, e.Cursor())
+
+	typeKeys(e, "\x7f世")
+	assert.Equal(t, "éy世second\n", e.Tree().GetLinesRawContent())
+
+	// Undo walks back through every edit, redo replays it.
+	for e.history.CanUndo() {
+		typeKeys(e, "\x1a")
+	}
+	assert.Equal(t, "éx👍🏽y\nsecond\n", e.Tree().GetLinesRawContent())
+	typeKeys(e, "\x19")
+	assert.Equal(t, "éxy\nsecond\n", e.Tree().GetLinesRawContent())
+}
+
+func TestVerticalMovementKeepsVisualColumn(t *testing.T) {
+	e := NewEditor("\tabc\nx\n12345678")
+	typeKeys(e, "\x1b[F")
+	assert.Equal(t, common.Position{LineNumber: 1, Column: 5}, e.Cursor())
+
+	typeKeys(e, "\x1b[B")
+	assert.Equal(t, common.Position{LineNumber: 2, Column: 2}, e.Cursor())
+	typeKeys(e, "\x1b[B")
+	assert.Equal(t, common.Position{LineNumber: 3, Column: 8}, e.Cursor())
+	typeKeys(e, "\x1b[B")
+	assert.Equal(t, common.Position{LineNumber: 3, Column: 8}, e.Cursor())
+}
+
+func TestSearchWrapsAround(t *testing.T) {
+	e := NewEditor("foo\nbar Foo\nfoo")
+	typeKeys(e, "\x06foo\r")
+	assert.Equal(t, common.Position{LineNumber: 2, Column: 5}, e.Cursor())
+	typeKeys(e, "\x0e")
+	assert.Equal(t, common.Position{LineNumber: 3, Column: 1}, e.Cursor())
+	typeKeys(e, "\x0e")
+	assert.Equal(t, common.Position{LineNumber: 1, Column: 1}, e.Cursor())
+	assert.Equal(t, "Search wrapped", e.Message())
+
+	typeKeys(e, "\x06Foo\r\x0e")
+	assert.Equal(t, common.Position{LineNumber: 2, Column: 5}, e.Cursor())
+	typeKeys(e, "\x06zzz\r")
+	assert.Equal(t, "Not found: zzz", e.Message())
+}
+
+func TestSavePreservesBOMAndEOL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, os.WriteFile(path, []byte("\xEF\xBB\xBFone\r\ntwo\r\n"), 0600))
+
+	e, err := Open(path)
+	require.NoError(t, err)
+	typeKeys(e, "\x1b[F\r!")
+	assert.True(t, e.IsDirty())
+
+	typeKeys(e, "\x11")
+	assert.False(t, e.ShouldQuit())
+	typeKeys(e, "\x13\x11")
+	assert.True(t, e.ShouldQuit())
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "\xEF\xBB\xBFone\r\n!\r\ntwo\r\n", string(content))
+}
+
+func TestSaveReplacesFileAtomically(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+	require.NoError(t, os.WriteFile(path, []byte("old"), 0600))
+	link := filepath.Join(dir, "link.txt")
+	require.NoError(t, os.Symlink(path, link))
+
+	e, err := Open(link)
+	require.NoError(t, err)
+	typeKeys(e, "\x1b[Fnew")
+	require.NoError(t, e.Save())
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "oldnew", string(content))
+	info, err := os.Lstat(link)
+	require.NoError(t, err)
+	assert.NotZero(t, info.Mode()&os.ModeSymlink, "the symlink is kept")
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "no temporary file is left behind")
+}
+
+func TestRenderScrollsToCursor(t *testing.T) {
+	e := NewEditor("1\n2\n3\n4\n5\n6\n\tlong line that is wider than the screen")
+	e.SetSize(5, 10)
+	typeKeys(e, "\x1b[6~\x1b[6~\x1b[F")
+
+	var out bytes.Buffer
+	require.NoError(t, e.Render(&out))
+	screen := out.String()
+	// Lines 5 and 6 are scrolled out horizontally along with the long line.
+	assert.Contains(t, screen, "\x1b[H\x1b[K\r\n\x1b[K\r\nhe screen\x1b[K\r\n")
+	assert.Contains(t, screen, "\x1b[3;10H")
+}
+
+func TestWideCharacters(t *testing.T) {
+	e := NewEditor("世界ab\nabcdef")
+	e.SetSize(5, 10)
+	typeKeys(e, "\x1b[C\x1b[C\x1b[B")
+	// 世界 takes four cells, so moving down lands after "abcd".
+	assert.Equal(t, common.Position{LineNumber: 2, Column: 5}, e.Cursor())
+
+	var out bytes.Buffer
+	require.NoError(t, e.Render(&out))
+	assert.Contains(t, out.String(), "\x1b[2;5H")
+
+	assert.Equal(t, "世界ab", renderLine("世界ab", 0, 6, 4))
+	assert.Equal(t, " 界a", renderLine("世界ab", 1, 4, 4))
+	assert.Equal(t, "世 ", renderLine("世界ab", 0, 3, 4))
+
+	// C0, DEL and C1 controls never reach the terminal.
+	assert.Equal(t, "a?b?c?d", renderLine("a\x1bb\x7fc\u009bd", 0, 20, 4))
+	assert.Equal(t, "??", renderLine("\u0085\u009f", 0, 20, 4))
+	// Raw C1 bytes that are not valid UTF-8 never reach the terminal.
+	assert.Equal(t, "a\ufffdb\ufffd", renderLine("a\x9bb\x80", 0, 20, 4))
+	assert.Equal(t, "\ufffd[", renderLine("\x9b[", 0, 20, 4))
+}
@@ -0,0 +1,12 @@
+//go:build darwin || freebsd || netbsd || openbsd
+
+package editor
+
+import "syscall"
+
+const (
+	// ioctlGetTermios 读取终端属性的 ioctl 请求
+	ioctlGetTermios = syscall.TIOCGETA
+	// ioctlSetTermios 设置终端属性的 ioctl 请求
+	ioctlSetTermios = syscall.TIOCSETA
+)
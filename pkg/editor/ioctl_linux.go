@@ -0,0 +1,10 @@
+package editor
+
+import "syscall"
+
+const (
+	// ioctlGetTermios 读取终端属性的 ioctl 请求
+	ioctlGetTermios = syscall.TCGETS
+	// ioctlSetTermios 设置终端属性的 ioctl 请求
+	ioctlSetTermios = syscall.TCSETS
+)
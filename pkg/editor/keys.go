@@ -0,0 +1,169 @@
+package editor
+
+import "unicode/utf8"
+
+// KeyCode 按键类型
+type KeyCode int
+
+const (
+	// KeyRune 可打印字符，字符保存在 Key.Rune 中
+	KeyRune KeyCode = iota
+	// KeyCtrl Ctrl 组合键，小写字母保存在 Key.Rune 中
+	KeyCtrl
+	// KeyEnter 回车
+	KeyEnter
+	// KeyTab 制表
+	KeyTab
+	// KeyBackspace 退格
+	KeyBackspace
+	// KeyDelete 删除
+	KeyDelete
+	// KeyEscape Esc
+	KeyEscape
+	// KeyUp 上
+	KeyUp
+	// KeyDown 下
+	KeyDown
+	// KeyLeft 左
+	KeyLeft
+	// KeyRight 右
+	KeyRight
+	// KeyHome Home
+	KeyHome
+	// KeyEnd End
+	KeyEnd
+	// KeyPageUp PageUp
+	KeyPageUp
+	// KeyPageDown PageDown
+	KeyPageDown
+	// KeyF3 F3
+	KeyF3
+)
+
+// Key 一次按键
+type Key struct {
+	// Code 按键类型
+	Code KeyCode
+	// Rune 字符（仅用于 KeyRune 和 KeyCtrl）
+	Rune rune
+}
+
+// Ctrl 返回 Ctrl 组合键
+func Ctrl(r rune) Key {
+	return Key{Code: KeyCtrl, Rune: r}
+}
+
+// csiKeys 以 ESC [ 或 ESC O 开头、以字母结尾的序列
+var csiKeys = map[byte]KeyCode{
+	'A': KeyUp,
+	'B': KeyDown,
+	'C': KeyRight,
+	'D': KeyLeft,
+	'H': KeyHome,
+	'F': KeyEnd,
+	'R': KeyF3,
+}
+
+// tildeKeys 以 ESC [ n ~ 形式编码的按键
+var tildeKeys = map[int]KeyCode{
+	1:  KeyHome,
+	3:  KeyDelete,
+	4:  KeyEnd,
+	5:  KeyPageUp,
+	6:  KeyPageDown,
+	7:  KeyHome,
+	8:  KeyEnd,
+	13: KeyF3,
+}
+
+// KeyDecoder 把终端输入的字节流解码为按键
+// 终端通常在一次读取中返回完整的转义序列，所以读取末尾单独的 ESC 被当作 Esc 键，
+// 而不完整的 UTF-8 序列会保留到下一次读取
+type KeyDecoder struct {
+	pending []byte
+}
+
+// Feed 解码一次读取到的字节
+func (d *KeyDecoder) Feed(data []byte) []Key {
+	buf := append(d.pending, data...)
+	d.pending = nil
+
+	var keys []Key
+	for len(buf) > 0 {
+		key, n, ok := decodeKey(buf)
+		if n == 0 {
+			d.pending = append([]byte(nil), buf...)
+			break
+		}
+		if ok {
+			keys = append(keys, key)
+		}
+		buf = buf[n:]
+	}
+	return keys
+}
+
+// decodeKey 解码 buf 开头的一个按键，返回消耗的字节数
+// 消耗 0 字节表示需要更多输入，ok 为 false 表示无法识别的序列
+func decodeKey(buf []byte) (key Key, n int, ok bool) {
+	b := buf[0]
+	switch {
+	case b == 0x1b:
+		return decodeEscape(buf)
+	case b == '\r' || b == '\n':
+		return Key{Code: KeyEnter}, 1, true
+	case b == '\t':
+		return Key{Code: KeyTab}, 1, true
+	case b == 0x7f || b == 0x08:
+		return Key{Code: KeyBackspace}, 1, true
+	case b >= 1 && b <= 26:
+		return Ctrl(rune('a' + b - 1)), 1, true
+	case b < 0x20:
+		return Key{}, 1, false
+	case b < utf8.RuneSelf:
+		return Key{Code: KeyRune, Rune: rune(b)}, 1, true
+	}
+
+	if !utf8.FullRune(buf) {
+		return Key{}, 0, false
+	}
+	r, size := utf8.DecodeRune(buf)
+	if r == utf8.RuneError {
+		return Key{}, size, false
+	}
+	return Key{Code: KeyRune, Rune: r}, size, true
+}
+
+// decodeEscape 解码以 ESC 开头的序列
+func decodeEscape(buf []byte) (Key, int, bool) {
+	if len(buf) == 1 || (buf[1] != '[' && buf[1] != 'O') {
+		return Key{Code: KeyEscape}, 1, true
+	}
+
+	// 参数字节 0x30-0x3F，中间字节 0x20-0x2F，结束字节 0x40-0x7E
+	i := 2
+	param := 0
+	firstParam := true
+	for i < len(buf) && buf[i] >= 0x20 && buf[i] <= 0x3F {
+		switch {
+		case buf[i] >= '0' && buf[i] <= '9' && firstParam:
+			param = param*10 + int(buf[i]-'0')
+		case buf[i] == ';':
+			// 忽略修饰键参数
+			firstParam = false
+		}
+		i++
+	}
+	if i >= len(buf) {
+		// 不完整的序列，当作 Esc 键并丢弃剩余部分
+		return Key{Code: KeyEscape}, len(buf), true
+	}
+
+	final := buf[i]
+	if final == '~' {
+		code, ok := tildeKeys[param]
+		return Key{Code: code}, i + 1, ok
+	}
+	code, ok := csiKeys[final]
+	return Key{Code: code}, i + 1, ok
+}
@@ -0,0 +1,45 @@
+package editor
+
+import (
+	"os"
+)
+
+// Run 在终端中打开文件并运行编辑器，直到用户退出
+func Run(path string) error {
+	e, err := Open(path)
+	if err != nil {
+		return err
+	}
+
+	in, out := os.Stdin, os.Stdout
+	state, err := MakeRaw(int(in.Fd()))
+	if err != nil {
+		return err
+	}
+	defer Restore(int(in.Fd()), state)
+
+	// 使用备用屏幕，退出后恢复原来的终端内容
+	out.WriteString("\x1b[?1049h")
+	defer out.WriteString("\x1b[?1049l")
+
+	var decoder KeyDecoder
+	buf := make([]byte, 1024)
+	for !e.ShouldQuit() {
+		// 每次按键后重新读取窗口大小，这样不需要处理 SIGWINCH
+		if rows, cols, err := GetSize(int(out.Fd())); err == nil {
+			e.SetSize(rows, cols)
+		}
+		if err := e.Render(out); err != nil {
+			return err
+		}
+
+		n, err := in.Read(buf)
+		if err != nil {
+			return err
+		}
+		for _, key := range decoder.Feed(buf[:n]) {
+			e.HandleKey(key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package editor
+
+import "errors"
+
+// errUnsupported 当前平台不支持终端原始模式
+var errUnsupported = errors.New("editor: raw terminal mode is not supported on this platform")
+
+// TermState 进入原始模式之前的终端状态
+type TermState struct{}
+
+// MakeRaw 把终端切换到原始模式，当前平台不支持
+func MakeRaw(fd int) (*TermState, error) {
+	return nil, errUnsupported
+}
+
+// Restore 恢复终端状态，当前平台不支持
+func Restore(fd int, state *TermState) error {
+	return errUnsupported
+}
+
+// GetSize 返回终端的行数和列数，当前平台不支持
+func GetSize(fd int) (rows, cols int, err error) {
+	return 0, 0, errUnsupported
+}
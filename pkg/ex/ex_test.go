@@ -0,0 +1,103 @@
+package ex
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+	"github.com/kebaren/textbuffer/pkg/buffer/buffertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newInterpreter(text string) *Interpreter {
+	return NewInterpreter(buffer.NewHistory(buffertest.NewTree(text), 0))
+}
+
+func content(in *Interpreter) string {
+	return in.tree().GetLinesRawContent()
+}
+
+func TestCommands(t *testing.T) {
+	cases := []struct {
+		name     string
+		text     string
+		script   string
+		expected string
+		current  int
+	}{
+		{"delete range", "1\n2\n3\n4\n", "2,3d", "1\n4\n", 2},
+		{"delete last line without eol", "1\n2\n3", "$d", "1\n2", 2},
+		{"delete everything", "1\n2\n", "%d", "", 0},
+		{"delete with count", "1\n2\n3\n4\n", "2d 2", "1\n4\n", 2},
+		{"substitute first", "a a\na a\n", "%s/a/b/", "b a\nb a\n", 2},
+		{"substitute global with groups", "key=value\n", `s/(\w+)=(\w+)/\2=\1 [&]/g`, "value=key [key=value]\n", 1},
+		{"substitute splits lines", "a,b,c\nz\n", `1s/,/\n/g|$s/z/Z/`, "a\nb\nc\nZ\n", 4},
+		{"substitute ignore case", "Foo\n", "s/foo/bar/i", "bar\n", 1},
+		{"repeat substitute", "a a\na a\n", "1s/a/b/|2&", "b a\nb a\n", 2},
+		{"move down", "1\n2\n3\n4\n", "1,2m$", "3\n4\n1\n2\n", 4},
+		{"move up", "1\n2\n3\n4\n", "4m0", "4\n1\n2\n3\n", 1},
+		{"move last line without eol", "1\n2\n3", "3m0", "3\n1\n2", 1},
+		{"copy", "1\n2\n", "1t$|1co0", "1\n1\n2\n1\n", 1},
+		{"join", "a\n  b\n)c\n", "1,3j", "a b)c\n", 1},
+		{"join bang", "a\n  b\n", "1j!", "a  b\n", 1},
+		{"reverse with global", "1\n2\n3\n4\n", "g/^/m0", "4\n3\n2\n1\n", 1},
+		{"global delete", "keep\ndrop\nkeep\ndrop\n", "g/drop/d", "keep\nkeep\n", 2},
+		{"vglobal delete", "keep\ndrop\nkeep\n", "v/keep/d", "keep\nkeep\n", 2},
+		{"global with relative range", "x\n1\ny\nx\n2\ny\n", "g/x/.,+1d", "y\ny\n", 2},
+		{"global substitute with empty pattern", "ab\ncd\nab\n", "g/a/s//A/", "Ab\ncd\nAb\n", 3},
+		{"global copy", "a\nb\n", "g/./t.", "a\na\nb\nb\n", 4},
+		{"pattern addresses", "start\nx\nend\ny\n", "/start/+1,/end/-1d", "start\nend\ny\n", 2},
+		{"backward search wraps", "a\nb\nc\n", "1|?c?d", "a\nb\n", 2},
+		{"marks follow edits", "1\n2\n3\n", "3ka|1d|'as/3/three/", "2\nthree\n", 2},
+		{"semicolon range", "a\nb\nc\nd\n", "2;+1d", "a\nd\n", 2},
+		{"comments and colons", "a\nb\n", "\" a comment\n:2d\n: 1s/a/A/", "A\n", 1},
+		{"crlf", "a\r\nb\r\n", "1t$|s/a/c/", "a\r\nb\r\nc\r\n", 3},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			in := newInterpreter(c.text)
+			require.NoError(t, in.Execute(c.script))
+			assert.Equal(t, c.expected, content(in))
+			assert.Equal(t, c.current, in.CurrentLine())
+		})
+	}
+}
+
+func TestScriptIsSingleUndoBatch(t *testing.T) {
+	in := newInterpreter("b\na\nc\n")
+	require.NoError(t, in.Execute("g/^/m0\n%s/$/;/"))
+	assert.Equal(t, "c;\na;\nb;\n", content(in))
+
+	require.NotNil(t, in.history.Undo())
+	assert.Equal(t, "b\na\nc\n", content(in))
+	assert.False(t, in.history.CanUndo())
+}
+
+func TestFailedScriptRollsBack(t *testing.T) {
+	in := newInterpreter("1\n2\n3\n")
+	in.SetCurrentLine(2)
+	require.NoError(t, in.Execute("1ka"))
+
+	err := in.Execute("1d|s/nothing/x/")
+	assert.ErrorIs(t, err, ErrPatternNotFound)
+	assert.Equal(t, "1\n2\n3\n", content(in))
+	assert.Equal(t, 1, in.Mark('a'))
+	assert.False(t, in.history.CanUndo())
+
+	for _, script := range []string{"5d", "3,1d", "1,3m2", "frob", "g/x/g/y/d", "'zd", "s/(/x/", "0d"} {
+		assert.Error(t, in.Execute(script), script)
+		assert.Equal(t, "1\n2\n3\n", content(in), script)
+	}
+}
+
+func TestPrintCommands(t *testing.T) {
+	in := newInterpreter("one\ntwo\nthree\n")
+	var out bytes.Buffer
+	in.Output = &out
+
+	require.NoError(t, in.Execute("g/o/\n2nu|=|%s/t/T/gn"))
+	assert.Equal(t, "one\ntwo\n     2  two\n3\n2 matches\n", out.String())
+	assert.Equal(t, "one\ntwo\nthree\n", content(in))
+}
@@ -0,0 +1,680 @@
+package ex
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+)
+
+// ErrPatternNotFound 搜索或替换没有找到匹配
+var ErrPatternNotFound = errors.New("ex: pattern not found")
+
+// Interpreter 对片段树执行 ex 命令
+// 行号从 1 开始，与 vi 一致，文件末尾的换行符不算作一个空行。
+// 正则表达式使用 Go 的 RE2 语法，替换内容支持 &、\0-\9、\n（拆分行）和 \t。
+type Interpreter struct {
+	// Output p、nu、= 命令的输出，为 nil 时丢弃
+	Output io.Writer
+
+	// history 撤销/重做栈
+	history *buffer.History
+	// current 当前行，缓冲区为空时为 0
+	current int
+	// marks 标记
+	marks map[rune]int
+	// lastPattern 上一次使用的正则表达式
+	lastPattern string
+	// lastReplacement 上一次 s 命令的替换内容
+	lastReplacement string
+	// lastFlags 上一次 s 命令的标志
+	lastFlags string
+
+	// undo 当前脚本中已经应用的编辑的逆操作
+	undo []buffer.EditOperation
+	// globalLines 正在执行 g 命令时等待处理的行，随编辑一起更新
+	globalLines []int
+	// inGlobal 是否正在执行 g 命令
+	inGlobal bool
+}
+
+// NewInterpreter 创建一个新的解释器，当前行为最后一行
+func NewInterpreter(history *buffer.History) *Interpreter {
+	in := &Interpreter{
+		history: history,
+		marks:   make(map[rune]int),
+	}
+	in.current = in.lineCount()
+	return in
+}
+
+// CurrentLine 返回当前行
+func (in *Interpreter) CurrentLine() int {
+	return in.current
+}
+
+// SetCurrentLine 设置当前行，超出范围时被限制在 [1, 行数] 内
+func (in *Interpreter) SetCurrentLine(line int) {
+	in.current = in.clampLine(line)
+}
+
+// Mark 返回标记所在的行，标记不存在或所在行已被删除时返回 0
+func (in *Interpreter) Mark(name rune) int {
+	return in.marks[name]
+}
+
+// Execute 执行脚本
+// 脚本中的所有修改作为一个批次记录到撤销栈中；任何命令出错时已经做出的修改都会被撤销，
+// 当前行和标记也恢复到执行之前的状态
+func (in *Interpreter) Execute(script string) error {
+	commands, err := parseScript(script)
+	if err != nil {
+		return err
+	}
+
+	current := in.current
+	marks := make(map[rune]int, len(in.marks))
+	for k, v := range in.marks {
+		marks[k] = v
+	}
+	in.undo = nil
+
+	for i := range commands {
+		if err = in.run(&commands[i]); err != nil {
+			break
+		}
+	}
+
+	inverse := make([]buffer.EditOperation, len(in.undo))
+	for i, op := range in.undo {
+		inverse[len(in.undo)-1-i] = op
+	}
+	in.undo = nil
+
+	if err != nil {
+		in.tree().ApplyEdits(inverse)
+		in.current = current
+		in.marks = marks
+		return err
+	}
+	if len(inverse) > 0 {
+		in.history.Push(inverse)
+	}
+	return nil
+}
+
+// tree 返回片段树
+func (in *Interpreter) tree() *buffer.PieceTreeBase {
+	return in.history.Tree()
+}
+
+// lineCount 返回行数，文件末尾的换行符之后的空行不计算在内
+func (in *Interpreter) lineCount() int {
+	tree := in.tree()
+	n := tree.GetLineCount()
+	if tree.GetLineLength(n) == 0 {
+		n--
+	}
+	return n
+}
+
+// endsWithEOL 判断文件是否以换行符结尾
+func (in *Interpreter) endsWithEOL() bool {
+	tree := in.tree()
+	n := tree.GetLineCount()
+	return n > 1 && tree.GetLineLength(n) == 0
+}
+
+// line 返回行的内容（不包含换行符）
+func (in *Interpreter) line(n int) string {
+	return in.tree().GetLineContent(n)
+}
+
+// lines 返回 [start, end] 范围内的行
+func (in *Interpreter) lines(start, end int) []string {
+	result := make([]string, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		result = append(result, in.line(i))
+	}
+	return result
+}
+
+// lineStart 返回行开始的偏移量，超过最后一行时返回文档长度
+func (in *Interpreter) lineStart(n int) int {
+	tree := in.tree()
+	if n > tree.GetLineCount() {
+		return tree.GetLength()
+	}
+	return tree.GetOffsetAt(n, 1)
+}
+
+// clampLine 把行号限制在 [1, 行数] 内，缓冲区为空时返回 0
+func (in *Interpreter) clampLine(n int) int {
+	if count := in.lineCount(); n > count {
+		n = count
+	}
+	if n < 1 && in.lineCount() > 0 {
+		n = 1
+	}
+	return n
+}
+
+// replaceLines 用 lines 替换 [start, end] 范围内的行，并更新当前行之外的所有行号
+// end 等于 start-1 时在 start 之前插入
+func (in *Interpreter) replaceLines(start, end int, lines []string) {
+	in.replaceLinesRaw(start, end, lines)
+
+	count := len(lines)
+	shift := func(m int) int {
+		switch {
+		case m < start:
+			return m
+		case m <= end:
+			if count == 0 {
+				return 0
+			}
+			return start + minInt(m-start, count-1)
+		default:
+			return m + count - (end - start + 1)
+		}
+	}
+	in.remapLines(shift)
+}
+
+// replaceLinesRaw 用 lines 替换 [start, end] 范围内的行，不更新行号
+func (in *Interpreter) replaceLinesRaw(start, end int, lines []string) {
+	tree := in.tree()
+	eol := tree.GetEOL()
+	startOffset := in.lineStart(start)
+	endOffset := in.lineStart(end + 1)
+
+	var sb strings.Builder
+	for _, line := range lines {
+		sb.WriteString(line)
+		sb.WriteString(eol)
+	}
+	text := sb.String()
+
+	// 最后一行没有换行符时保持这一点
+	if endOffset == tree.GetLength() && !in.endsWithEOL() && in.lineCount() > 0 {
+		if len(lines) > 0 {
+			text = text[:len(text)-len(eol)]
+			if startOffset == endOffset {
+				text = eol + text
+			}
+		} else if start > 1 {
+			startOffset = in.lineStart(start-1) + tree.GetLineLength(start-1)
+		}
+	}
+
+	op := buffer.NewEditOperation(startOffset, endOffset-startOffset, text)
+	if !op.IsNoop() {
+		in.undo = append(in.undo, tree.ApplyEdit(op))
+	}
+}
+
+// remapLines 更新标记和 g 命令等待处理的行，映射为 0 表示该行已被删除
+func (in *Interpreter) remapLines(f func(int) int) {
+	for name, m := range in.marks {
+		if m = f(m); m > 0 {
+			in.marks[name] = m
+		} else {
+			delete(in.marks, name)
+		}
+	}
+	remaining := in.globalLines[:0]
+	for _, m := range in.globalLines {
+		if m = f(m); m > 0 {
+			remaining = append(remaining, m)
+		}
+	}
+	in.globalLines = remaining
+}
+
+// resolveAddress 计算地址对应的行号
+func (in *Interpreter) resolveAddress(addr address) (int, error) {
+	var n int
+	switch addr.kind {
+	case addrCurrent, addrNone:
+		n = in.current
+	case addrLast:
+		n = in.lineCount()
+	case addrNumber:
+		n = addr.number
+	case addrMark:
+		m, ok := in.marks[addr.mark]
+		if !ok {
+			return 0, fmt.Errorf("ex: mark '%c not set", addr.mark)
+		}
+		n = m
+	case addrForward, addrBackward:
+		re, err := in.compile(addr.pattern, false)
+		if err != nil {
+			return 0, err
+		}
+		n = in.search(re, addr.kind == addrForward)
+		if n == 0 {
+			return 0, fmt.Errorf("%w: %s", ErrPatternNotFound, re.String())
+		}
+	}
+
+	n += addr.offset
+	if n < 0 || n > in.lineCount() {
+		return 0, fmt.Errorf("ex: line %d out of range [0, %d]", n, in.lineCount())
+	}
+	return n, nil
+}
+
+// search 从当前行的下一行（或上一行）开始搜索，到达末尾时从另一端继续
+func (in *Interpreter) search(re *regexp.Regexp, forward bool) int {
+	count := in.lineCount()
+	for i := 1; i <= count; i++ {
+		n := in.current - i
+		if forward {
+			n = in.current + i
+		}
+		n = ((n-1)%count+count)%count + 1
+		if re.MatchString(in.line(n)) {
+			return n
+		}
+	}
+	return 0
+}
+
+// resolveRange 计算范围，没有地址时使用 [def, def]
+func (in *Interpreter) resolveRange(rng addressRange, def int) (int, int, error) {
+	if len(rng.addrs) == 0 {
+		return def, def, nil
+	}
+	first, err := in.resolveAddress(rng.addrs[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(rng.addrs) == 1 {
+		return first, first, nil
+	}
+
+	saved := in.current
+	if rng.semicolon {
+		in.current = first
+	}
+	second, err := in.resolveAddress(rng.addrs[1])
+	if err != nil {
+		in.current = saved
+		return 0, 0, err
+	}
+	if first > second {
+		in.current = saved
+		return 0, 0, fmt.Errorf("ex: backwards range %d,%d", first, second)
+	}
+	return first, second, nil
+}
+
+// compile 编译正则表达式，空模式使用上一次的模式
+func (in *Interpreter) compile(pattern string, ignoreCase bool) (*regexp.Regexp, error) {
+	if pattern == "" {
+		if in.lastPattern == "" {
+			return nil, fmt.Errorf("ex: no previous regular expression")
+		}
+		pattern = in.lastPattern
+	}
+	in.lastPattern = pattern
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("ex: invalid regular expression: %v", err)
+	}
+	return re, nil
+}
+
+// run 执行一个命令
+func (in *Interpreter) run(cmd *command) error {
+	def := in.current
+	switch cmd.name {
+	case "global", "vglobal":
+		if in.inGlobal {
+			return fmt.Errorf("ex: global commands cannot be nested")
+		}
+		if len(cmd.rng.addrs) == 0 {
+			return in.global(cmd, 1, in.lineCount())
+		}
+	case "=":
+		def = in.lineCount()
+	}
+
+	start, end, err := in.resolveRange(cmd.rng, def)
+	if err != nil {
+		return err
+	}
+	if cmd.count > 0 {
+		// 命令后的数字表示从范围的最后一行开始的行数
+		start = end
+		end = start + cmd.count - 1
+		if end > in.lineCount() {
+			end = in.lineCount()
+		}
+	}
+
+	switch cmd.name {
+	case "goto", "=", "mark", "move", "copy":
+	default:
+		if start == 0 {
+			if in.lineCount() == 0 {
+				return fmt.Errorf("ex: buffer is empty")
+			}
+			return fmt.Errorf("ex: line 0 is not allowed for %s", cmd.name)
+		}
+	}
+
+	switch cmd.name {
+	case "goto":
+		if end == 0 && in.lineCount() > 0 {
+			return fmt.Errorf("ex: line 0 is not allowed")
+		}
+		in.current = end
+	case "delete":
+		in.replaceLines(start, end, nil)
+		in.current = in.clampLine(start)
+	case "move":
+		return in.move(cmd, start, end)
+	case "copy":
+		return in.copy(cmd, start, end)
+	case "join":
+		return in.join(cmd, start, end)
+	case "substitute":
+		return in.substitute(cmd, start, end)
+	case "global", "vglobal":
+		return in.global(cmd, start, end)
+	case "print", "number":
+		for i := start; i <= end; i++ {
+			if cmd.name == "number" {
+				in.printf("%6d  %s\n", i, in.line(i))
+			} else {
+				in.printf("%s\n", in.line(i))
+			}
+		}
+		in.current = end
+	case "=":
+		in.printf("%d\n", end)
+	case "mark":
+		if end == 0 {
+			return fmt.Errorf("ex: line 0 is not allowed for mark")
+		}
+		in.marks[cmd.mark] = end
+	}
+	return nil
+}
+
+// printf 输出到 Output
+func (in *Interpreter) printf(format string, args ...interface{}) {
+	if in.Output != nil {
+		fmt.Fprintf(in.Output, format, args...)
+	}
+}
+
+// destination 计算 m、t 命令的目标行
+func (in *Interpreter) destination(cmd *command) (int, error) {
+	_, dest, err := in.resolveRange(*cmd.dest, in.current)
+	return dest, err
+}
+
+// move 把 [start, end] 范围内的行移动到 dest 之后，标记随行一起移动
+func (in *Interpreter) move(cmd *command, start, end int) error {
+	if start == 0 {
+		return fmt.Errorf("ex: line 0 is not allowed for move")
+	}
+	dest, err := in.destination(cmd)
+	if err != nil {
+		return err
+	}
+	if dest >= start && dest < end {
+		return fmt.Errorf("ex: cannot move lines into themselves")
+	}
+
+	k := end - start + 1
+	if dest == end || dest == start-1 {
+		in.current = end
+		return nil
+	}
+
+	lines := in.lines(start, end)
+	var remap func(int) int
+	if dest > end {
+		in.replaceLinesRaw(dest+1, dest, lines)
+		in.replaceLinesRaw(start, end, nil)
+		remap = func(m int) int {
+			switch {
+			case m >= start && m <= end:
+				return dest - k + 1 + (m - start)
+			case m > end && m <= dest:
+				return m - k
+			}
+			return m
+		}
+		in.current = dest
+	} else {
+		in.replaceLinesRaw(start, end, nil)
+		in.replaceLinesRaw(dest+1, dest, lines)
+		remap = func(m int) int {
+			switch {
+			case m >= start && m <= end:
+				return dest + 1 + (m - start)
+			case m > dest && m < start:
+				return m + k
+			}
+			return m
+		}
+		in.current = dest + k
+	}
+	in.remapLines(remap)
+	return nil
+}
+
+// copy 把 [start, end] 范围内的行复制到 dest 之后
+func (in *Interpreter) copy(cmd *command, start, end int) error {
+	if start == 0 {
+		return fmt.Errorf("ex: line 0 is not allowed for copy")
+	}
+	dest, err := in.destination(cmd)
+	if err != nil {
+		return err
+	}
+	lines := in.lines(start, end)
+	in.replaceLines(dest+1, dest, lines)
+	in.current = dest + len(lines)
+	return nil
+}
+
+// join 合并 [start, end] 范围内的行，只有一行时与下一行合并
+// 没有 ! 时去掉后续行开头的空白，并用一个空格连接
+func (in *Interpreter) join(cmd *command, start, end int) error {
+	if start == end {
+		end = start + 1
+	}
+	if end > in.lineCount() {
+		return fmt.Errorf("ex: cannot join past the last line")
+	}
+
+	joined := in.line(start)
+	for i := start + 1; i <= end; i++ {
+		next := in.line(i)
+		if cmd.bang {
+			joined += next
+			continue
+		}
+		next = strings.TrimLeftFunc(next, unicode.IsSpace)
+		if next != "" && !strings.HasPrefix(next, ")") && joined != "" &&
+			!strings.HasSuffix(joined, " ") && !strings.HasSuffix(joined, "\t") {
+			joined += " "
+		}
+		joined += next
+	}
+	in.replaceLines(start, end, []string{joined})
+	in.current = start
+	return nil
+}
+
+// substitute 在 [start, end] 范围内的每一行上替换
+func (in *Interpreter) substitute(cmd *command, start, end int) error {
+	pattern, replacement, flags := cmd.pattern, cmd.replacement, cmd.flags
+	if cmd.repeat {
+		if in.lastPattern == "" {
+			return fmt.Errorf("ex: no previous substitute")
+		}
+		pattern, replacement = in.lastPattern, in.lastReplacement
+		if flags == "" {
+			flags = in.lastFlags
+		}
+	}
+	if strings.Contains(flags, "c") {
+		return fmt.Errorf("ex: the c flag is not supported")
+	}
+	ignoreCase := strings.Contains(flags, "i") && !strings.Contains(flags, "I")
+	re, err := in.compile(pattern, ignoreCase)
+	if err != nil {
+		return err
+	}
+	in.lastReplacement, in.lastFlags = replacement, flags
+
+	global := strings.Contains(flags, "g")
+	countOnly := strings.Contains(flags, "n")
+	total := 0
+	for i := start; i <= end; i++ {
+		line := in.line(i)
+		result, n := substituteLine(re, line, replacement, global)
+		if n == 0 {
+			continue
+		}
+		total += n
+		if countOnly {
+			continue
+		}
+		lines := strings.Split(result, "\n")
+		in.replaceLines(i, i, lines)
+		i += len(lines) - 1
+		end += len(lines) - 1
+		in.current = i
+	}
+
+	if countOnly {
+		in.printf("%d matches\n", total)
+		return nil
+	}
+	if total == 0 && !in.inGlobal {
+		return fmt.Errorf("%w: %s", ErrPatternNotFound, pattern)
+	}
+	return nil
+}
+
+// substituteLine 替换一行中的匹配，返回结果和替换次数
+// 空匹配紧跟在上一个匹配之后时被跳过，与 vi 一致
+func substituteLine(re *regexp.Regexp, line, replacement string, global bool) (string, int) {
+	var sb strings.Builder
+	count := 0
+	last := 0
+	prevEnd := -1
+	for _, loc := range re.FindAllStringSubmatchIndex(line, -1) {
+		if loc[0] == loc[1] && loc[0] == prevEnd {
+			continue
+		}
+		sb.WriteString(line[last:loc[0]])
+		sb.WriteString(expandReplacement(replacement, line, loc))
+		last = loc[1]
+		prevEnd = loc[1]
+		count++
+		if !global {
+			break
+		}
+	}
+	if count == 0 {
+		return line, 0
+	}
+	sb.WriteString(line[last:])
+	return sb.String(), count
+}
+
+// expandReplacement 展开替换内容中的 &、\0-\9、\n、\r 和 \t
+func expandReplacement(replacement, line string, loc []int) string {
+	group := func(i int) string {
+		if 2*i+1 < len(loc) && loc[2*i] >= 0 {
+			return line[loc[2*i]:loc[2*i+1]]
+		}
+		return ""
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(replacement); i++ {
+		c := replacement[i]
+		switch {
+		case c == '&':
+			sb.WriteString(group(0))
+		case c == '\\' && i+1 < len(replacement):
+			i++
+			switch next := replacement[i]; {
+			case next >= '0' && next <= '9':
+				sb.WriteString(group(int(next - '0')))
+			case next == 'n' || next == 'r':
+				sb.WriteByte('\n')
+			case next == 't':
+				sb.WriteByte('\t')
+			default:
+				sb.WriteByte(next)
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// global 对 [start, end] 范围内匹配（v 命令为不匹配）的每一行执行命令
+// 先标记所有行再逐个执行，被前面的命令删除的行不再执行
+func (in *Interpreter) global(cmd *command, start, end int) error {
+	invert := cmd.name == "vglobal" || cmd.bang
+	re, err := in.compile(cmd.pattern, false)
+	if err != nil {
+		return err
+	}
+	if start == 0 {
+		start = 1
+	}
+
+	in.globalLines = nil
+	for i := start; i <= end; i++ {
+		if re.MatchString(in.line(i)) != invert {
+			in.globalLines = append(in.globalLines, i)
+		}
+	}
+
+	in.inGlobal = true
+	defer func() {
+		in.inGlobal = false
+		in.globalLines = nil
+	}()
+	for len(in.globalLines) > 0 {
+		in.current = in.globalLines[0]
+		in.globalLines = in.globalLines[1:]
+		for i := range cmd.commands {
+			sub := cmd.commands[i]
+			if err := in.run(&sub); err != nil {
+				return err
+			}
+		}
+	}
+	in.current = in.clampLine(in.current)
+	return nil
+}
+
+// minInt 返回两个整数中较小的一个
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
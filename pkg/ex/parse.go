@@ -0,0 +1,439 @@
+package ex
+
+import (
+	"fmt"
+	"strings"
+)
+
+// addressKind 地址类型
+type addressKind int
+
+const (
+	// addrNone 没有基准地址，只有偏移量时相对于当前行
+	addrNone addressKind = iota
+	// addrCurrent 当前行 .
+	addrCurrent
+	// addrLast 最后一行 $
+	addrLast
+	// addrNumber 行号
+	addrNumber
+	// addrMark 标记 'x
+	addrMark
+	// addrForward 向前搜索 /re/
+	addrForward
+	// addrBackward 向后搜索 ?re?
+	addrBackward
+)
+
+// address 一个行地址
+type address struct {
+	kind    addressKind
+	number  int
+	mark    rune
+	pattern string
+	offset  int
+}
+
+// addressRange 命令的地址范围
+type addressRange struct {
+	// addrs 零到两个地址
+	addrs []address
+	// semicolon 第二个地址之前是否为 ;（先把当前行设置为第一个地址）
+	semicolon bool
+}
+
+// command 解析后的命令
+type command struct {
+	rng  addressRange
+	name string
+	bang bool
+
+	// 以下字段根据命令不同而使用
+	// pattern s、g 命令的正则表达式
+	pattern string
+	// replacement s 命令的替换内容
+	replacement string
+	// flags s 命令的标志
+	flags string
+	// repeat s 命令是否重复上一次替换
+	repeat bool
+	// commands g 命令对每个匹配行执行的命令
+	commands []command
+	// dest m、t 命令的目标地址
+	dest *addressRange
+	// count d、j、p 命令的行数
+	count int
+	// mark k 命令的标记名
+	mark rune
+}
+
+// commandNames 命令全名和缩写
+var commandNames = map[string]string{
+	"d": "delete", "de": "delete", "del": "delete", "delete": "delete",
+	"m": "move", "mo": "move", "move": "move",
+	"t": "copy", "co": "copy", "copy": "copy",
+	"j": "join", "jo": "join", "join": "join",
+	"s": "substitute", "su": "substitute", "substitute": "substitute",
+	"g": "global", "gl": "global", "global": "global",
+	"v": "vglobal", "vg": "vglobal", "vglobal": "vglobal",
+	"p": "print", "pr": "print", "print": "print",
+	"nu": "number", "number": "number", "#": "number",
+	"=": "=",
+	"k": "mark", "ma": "mark", "mark": "mark",
+}
+
+// parser 命令解析器
+type parser struct {
+	src string
+	pos int
+}
+
+// errorf 返回带位置信息的解析错误
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("ex: %s (at %q)", fmt.Sprintf(format, args...), p.src[p.pos:])
+}
+
+// peek 返回当前字符，到达末尾时返回 0
+func (p *parser) peek() byte {
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// skipSpaces 跳过空格和制表符
+func (p *parser) skipSpaces() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// parseScript 解析脚本，命令之间用换行或 | 分隔，以 " 开头的行是注释
+func parseScript(src string) ([]command, error) {
+	var commands []command
+	for _, line := range strings.Split(src, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(strings.TrimLeft(line, " \t:"), "\"") {
+			continue
+		}
+		p := &parser{src: line}
+		cmds, err := p.parseCommandList()
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, cmds...)
+	}
+	return commands, nil
+}
+
+// parseCommandList 解析用 | 分隔的命令列表直到行尾
+func (p *parser) parseCommandList() ([]command, error) {
+	var commands []command
+	for {
+		p.skipSpaces()
+		for p.peek() == ':' {
+			p.pos++
+			p.skipSpaces()
+		}
+		if p.pos >= len(p.src) {
+			return commands, nil
+		}
+		if p.peek() == '|' {
+			p.pos++
+			continue
+		}
+
+		cmd, err := p.parseCommand()
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, cmd)
+
+		p.skipSpaces()
+		if p.pos < len(p.src) {
+			if p.peek() != '|' {
+				return nil, p.errorf("trailing characters")
+			}
+			p.pos++
+		}
+	}
+}
+
+// parseCommand 解析一个命令
+func (p *parser) parseCommand() (command, error) {
+	var cmd command
+	rng, err := p.parseRange()
+	if err != nil {
+		return cmd, err
+	}
+	cmd.rng = rng
+	p.skipSpaces()
+
+	// 命令名由字母组成，或者是单个符号
+	start := p.pos
+	switch c := p.peek(); {
+	case c == '#' || c == '=' || c == '&':
+		p.pos++
+	default:
+		for p.pos < len(p.src) && isLetter(p.src[p.pos]) {
+			p.pos++
+		}
+	}
+	name := p.src[start:p.pos]
+	if name == "" {
+		cmd.name = "goto"
+		return cmd, nil
+	}
+	if name == "&" {
+		name = "s"
+		cmd.repeat = true
+	}
+	// kx 是 k x 的简写
+	if len(name) == 2 && name[0] == 'k' {
+		if _, ok := commandNames[name]; !ok {
+			p.pos--
+			name = "k"
+		}
+	}
+	full, ok := commandNames[name]
+	if !ok {
+		p.pos = start
+		return cmd, p.errorf("unknown command %q", name)
+	}
+	cmd.name = full
+	if p.peek() == '!' {
+		cmd.bang = true
+		p.pos++
+	}
+
+	switch cmd.name {
+	case "substitute":
+		return cmd, p.parseSubstitute(&cmd)
+	case "global", "vglobal":
+		return cmd, p.parseGlobal(&cmd)
+	case "move", "copy":
+		p.skipSpaces()
+		dest, err := p.parseRange()
+		if err != nil {
+			return cmd, err
+		}
+		if len(dest.addrs) != 1 {
+			return cmd, p.errorf("%s requires a single destination address", cmd.name)
+		}
+		cmd.dest = &dest
+	case "delete", "join", "print", "number":
+		p.skipSpaces()
+		cmd.count = p.parseNumber()
+	case "mark":
+		p.skipSpaces()
+		if !isLetter(p.peek()) {
+			return cmd, p.errorf("mark name must be a letter")
+		}
+		cmd.mark = rune(p.peek())
+		p.pos++
+	}
+	return cmd, nil
+}
+
+// parseSubstitute 解析 s/pattern/replacement/flags
+func (p *parser) parseSubstitute(cmd *command) error {
+	delim := p.peek()
+	if delim == 0 || delim == ' ' || delim == '|' || isLetter(delim) || cmd.repeat {
+		// 没有分隔符时重复上一次替换，可以带新的标志
+		cmd.repeat = true
+		start := p.pos
+		for p.pos < len(p.src) && isLetter(p.src[p.pos]) {
+			p.pos++
+		}
+		cmd.flags = p.src[start:p.pos]
+		return nil
+	}
+	if delim == '\\' || delim == '"' {
+		return p.errorf("invalid delimiter %q", delim)
+	}
+
+	p.pos++
+	cmd.pattern = p.parseDelimited(delim, true)
+	if p.peek() != delim {
+		// s/re 等价于 s/re//
+		return nil
+	}
+	p.pos++
+	cmd.replacement = p.parseDelimited(delim, false)
+	if p.peek() == delim {
+		p.pos++
+	}
+	start := p.pos
+	for p.pos < len(p.src) && strings.IndexByte("gicIn", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+	cmd.flags = p.src[start:p.pos]
+	return nil
+}
+
+// parseGlobal 解析 g/pattern/commands，commands 为空时为 p
+func (p *parser) parseGlobal(cmd *command) error {
+	delim := p.peek()
+	if delim == 0 || delim == '\\' || delim == '"' || delim == '|' || isLetter(delim) {
+		return p.errorf("global requires a delimited pattern")
+	}
+	p.pos++
+	cmd.pattern = p.parseDelimited(delim, true)
+	if p.peek() == delim {
+		p.pos++
+	}
+
+	// g 命令占用这一行剩余的全部内容
+	sub := &parser{src: p.src[p.pos:]}
+	p.pos = len(p.src)
+	commands, err := sub.parseCommandList()
+	if err != nil {
+		return err
+	}
+	for _, c := range commands {
+		if c.name == "global" || c.name == "vglobal" {
+			return fmt.Errorf("ex: global commands cannot be nested")
+		}
+	}
+	if len(commands) == 0 {
+		commands = []command{{name: "print"}}
+	}
+	cmd.commands = commands
+	return nil
+}
+
+// parseDelimited 读取直到未转义的分隔符，转义的分隔符被还原
+// 模式中其它的转义序列原样保留给正则表达式
+func (p *parser) parseDelimited(delim byte, isPattern bool) string {
+	var sb strings.Builder
+	for p.pos < len(p.src) && p.src[p.pos] != delim {
+		c := p.src[p.pos]
+		if c == '\\' && p.pos+1 < len(p.src) {
+			next := p.src[p.pos+1]
+			if next == delim {
+				if isPattern && strings.IndexByte(`.+*?()|[]{}^$`, delim) >= 0 {
+					sb.WriteByte('\\')
+				}
+				sb.WriteByte(delim)
+			} else {
+				sb.WriteByte(c)
+				sb.WriteByte(next)
+			}
+			p.pos += 2
+			continue
+		}
+		sb.WriteByte(c)
+		p.pos++
+	}
+	return sb.String()
+}
+
+// parseRange 解析零到两个地址
+func (p *parser) parseRange() (addressRange, error) {
+	var rng addressRange
+	p.skipSpaces()
+	if p.peek() == '%' {
+		p.pos++
+		rng.addrs = []address{{kind: addrNumber, number: 1}, {kind: addrLast}}
+		return rng, nil
+	}
+
+	first, ok, err := p.parseAddress()
+	if err != nil {
+		return rng, err
+	}
+	p.skipSpaces()
+	if c := p.peek(); c == ',' || c == ';' {
+		p.pos++
+		rng.semicolon = c == ';'
+		if !ok {
+			// ,x 的第一个地址是当前行
+			first = address{kind: addrCurrent}
+		}
+		second, ok, err := p.parseAddress()
+		if err != nil {
+			return rng, err
+		}
+		if !ok {
+			// x, 的第二个地址与第一个相同
+			second = first
+		}
+		rng.addrs = []address{first, second}
+		return rng, nil
+	}
+	if ok {
+		rng.addrs = []address{first}
+	}
+	return rng, nil
+}
+
+// parseAddress 解析一个地址，没有地址时 ok 为 false
+func (p *parser) parseAddress() (addr address, ok bool, err error) {
+	p.skipSpaces()
+	switch c := p.peek(); {
+	case c == '.':
+		p.pos++
+		addr.kind = addrCurrent
+	case c == '$':
+		p.pos++
+		addr.kind = addrLast
+	case c >= '0' && c <= '9':
+		addr.kind = addrNumber
+		addr.number = p.parseNumber()
+	case c == '\'':
+		p.pos++
+		if !isLetter(p.peek()) {
+			return addr, false, p.errorf("mark name must be a letter")
+		}
+		addr.kind = addrMark
+		addr.mark = rune(p.peek())
+		p.pos++
+	case c == '/' || c == '?':
+		p.pos++
+		addr.pattern = p.parseDelimited(c, true)
+		if p.peek() == c {
+			p.pos++
+		}
+		addr.kind = addrForward
+		if c == '?' {
+			addr.kind = addrBackward
+		}
+	}
+
+	// 偏移量：+n、-n，单独的 + 或 - 表示 1
+	for {
+		p.skipSpaces()
+		c := p.peek()
+		if c != '+' && c != '-' {
+			break
+		}
+		p.pos++
+		n := 1
+		if d := p.peek(); d >= '0' && d <= '9' {
+			n = p.parseNumber()
+		}
+		if c == '-' {
+			n = -n
+		}
+		addr.offset += n
+		if addr.kind == addrNone {
+			addr.kind = addrCurrent
+		}
+	}
+	return addr, addr.kind != addrNone, nil
+}
+
+// parseNumber 解析十进制数，没有数字时返回 0
+func (p *parser) parseNumber() int {
+	n := 0
+	for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+		n = n*10 + int(p.src[p.pos]-'0')
+		p.pos++
+	}
+	return n
+}
+
+// isLetter 判断是否为 ASCII 字母
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
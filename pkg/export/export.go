@@ -0,0 +1,324 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+	"github.com/kebaren/textbuffer/pkg/common"
+	"github.com/kebaren/textbuffer/pkg/tokenization"
+)
+
+// TokenSource 提供每行的标记，tokenization.Store 实现了这个接口
+type TokenSource interface {
+	GetLineTokens(lineNumber int) []tokenization.Token
+}
+
+// Options 导出选项
+type Options struct {
+	// Theme 主题，为 nil 时使用 DefaultTheme
+	Theme *Theme
+	// Tokens 标记来源，为 nil 时不做语法高亮
+	Tokens TokenSource
+	// Range 导出的范围，为 nil 时导出整个文档
+	Range *common.Range
+	// LineNumbers 是否在每行前输出行号
+	LineNumbers bool
+	// Highlights 使用主题的高亮背景色显示的范围
+	Highlights []common.Range
+}
+
+// segment 行内样式相同的一段，start 和 end 是字节偏移量
+type segment struct {
+	start, end int
+	style      Style
+}
+
+// exporter 逐行生成带样式的分段，两种输出格式共用
+type exporter struct {
+	tree   *buffer.PieceTreeBase
+	opts   Options
+	theme  *Theme
+	styles map[string]Style
+}
+
+func newExporter(tree *buffer.PieceTreeBase, opts Options) *exporter {
+	theme := opts.Theme
+	if theme == nil {
+		theme = DefaultTheme
+	}
+	return &exporter{tree: tree, opts: opts, theme: theme, styles: make(map[string]Style)}
+}
+
+// lines 返回导出的行号范围以及第一行的起始列和最后一行的结束列（从 1 开始）
+func (e *exporter) lines() (startLine, startColumn, endLine, endColumn int) {
+	lineCount := e.tree.GetLineCount()
+	startLine, startColumn, endLine, endColumn = 1, 1, lineCount, e.tree.GetLineLength(lineCount)+1
+	if r := e.opts.Range; r != nil {
+		start := common.Position{LineNumber: r.StartLineNumber, Column: r.StartColumn}
+		end := common.Position{LineNumber: r.EndLineNumber, Column: r.EndColumn}
+		if end.LineNumber < start.LineNumber || (end.LineNumber == start.LineNumber && end.Column < start.Column) {
+			start, end = end, start
+		}
+		startLine = min(max(start.LineNumber, 1), lineCount)
+		startColumn = min(max(start.Column, 1), e.tree.GetLineLength(startLine)+1)
+		endLine = min(max(end.LineNumber, 1), lineCount)
+		endColumn = min(max(end.Column, 1), e.tree.GetLineLength(endLine)+1)
+	}
+	return startLine, startColumn, endLine, endColumn
+}
+
+// style 返回作用域列表的样式，结果按作用域缓存
+func (e *exporter) style(scopes []string) Style {
+	key := strings.Join(scopes, " ")
+	s, ok := e.styles[key]
+	if !ok {
+		s = e.theme.Resolve(scopes)
+		e.styles[key] = s
+	}
+	return s
+}
+
+// highlights 返回行内被高亮的字节范围
+func (e *exporter) highlights(lineNumber, lineLength int) [][2]int {
+	var result [][2]int
+	for _, r := range e.opts.Highlights {
+		start := common.Position{LineNumber: r.StartLineNumber, Column: r.StartColumn}
+		end := common.Position{LineNumber: r.EndLineNumber, Column: r.EndColumn}
+		if end.LineNumber < start.LineNumber || (end.LineNumber == start.LineNumber && end.Column < start.Column) {
+			start, end = end, start
+		}
+		if lineNumber < start.LineNumber || lineNumber > end.LineNumber {
+			continue
+		}
+		from, to := 0, lineLength
+		if lineNumber == start.LineNumber {
+			from = min(max(start.Column-1, 0), lineLength)
+		}
+		if lineNumber == end.LineNumber {
+			to = min(max(end.Column-1, 0), lineLength)
+		}
+		if from < to {
+			result = append(result, [2]int{from, to})
+		}
+	}
+	return result
+}
+
+// segments 把行内 [from, to) 按标记和高亮范围分段，相邻的样式相同的段被合并
+func (e *exporter) segments(lineNumber int, line string, from, to int) []segment {
+	var tokens []tokenization.Token
+	if e.opts.Tokens != nil {
+		tokens = e.opts.Tokens.GetLineTokens(lineNumber)
+	}
+	highlights := e.highlights(lineNumber, len(line))
+
+	bounds := []int{from, to}
+	for _, t := range tokens {
+		bounds = append(bounds, t.StartIndex, t.EndIndex)
+	}
+	for _, h := range highlights {
+		bounds = append(bounds, h[0], h[1])
+	}
+	sort.Ints(bounds)
+
+	var result []segment
+	for i := 0; i+1 < len(bounds); i++ {
+		start, end := max(bounds[i], from), min(bounds[i+1], to)
+		if start >= end {
+			continue
+		}
+		var style Style
+		for _, t := range tokens {
+			if t.StartIndex <= start && start < t.EndIndex {
+				style = e.style(t.Scopes)
+				break
+			}
+		}
+		for _, h := range highlights {
+			if h[0] <= start && start < h[1] {
+				style.Background = e.theme.Highlight
+			}
+		}
+		if n := len(result); n > 0 && result[n-1].style == style && result[n-1].end == start {
+			result[n-1].end = end
+		} else {
+			result = append(result, segment{start: start, end: end, style: style})
+		}
+	}
+	return result
+}
+
+// each 对导出范围内的每一行调用 fn
+func (e *exporter) each(fn func(lineNumber int, line string, segments []segment) error) error {
+	startLine, startColumn, endLine, endColumn := e.lines()
+	for lineNumber := startLine; lineNumber <= endLine; lineNumber++ {
+		line := e.tree.GetLineContent(lineNumber)
+		from, to := 0, len(line)
+		if lineNumber == startLine {
+			from = startColumn - 1
+		}
+		if lineNumber == endLine {
+			to = endColumn - 1
+		}
+		if err := fn(lineNumber, line, e.segments(lineNumber, line, from, to)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lineNumberWidth 返回行号的宽度
+func (e *exporter) lineNumberWidth() int {
+	_, _, endLine, _ := e.lines()
+	return len(strconv.Itoa(endLine))
+}
+
+// cssStyle 返回样式的 CSS，没有样式时返回空字符串
+// 颜色来自主题，原样写入，放进属性之前需要转义
+func cssStyle(s Style) string {
+	var parts []string
+	if s.Foreground != "" {
+		parts = append(parts, "color:"+s.Foreground)
+	}
+	if s.Background != "" {
+		parts = append(parts, "background-color:"+s.Background)
+	}
+	if s.Bold {
+		parts = append(parts, "font-weight:bold")
+	}
+	if s.Italic {
+		parts = append(parts, "font-style:italic")
+	}
+	if s.Underline {
+		parts = append(parts, "text-decoration:underline")
+	}
+	return strings.Join(parts, ";")
+}
+
+// WriteHTML 把文档或其中的范围导出为 HTML 的 <pre> 元素，逐行写入 w
+func WriteHTML(w io.Writer, tree *buffer.PieceTreeBase, opts Options) error {
+	e := newExporter(tree, opts)
+	bw := bufio.NewWriter(w)
+	width := e.lineNumberWidth()
+
+	fmt.Fprintf(bw, `<pre style="%s"><code>`, html.EscapeString(cssStyle(Style{Foreground: e.theme.Foreground, Background: e.theme.Background})))
+	err := e.each(func(lineNumber int, line string, segments []segment) error {
+		if opts.LineNumbers {
+			fmt.Fprintf(bw, `<span style="%s;user-select:none">%*d </span>`, html.EscapeString(cssStyle(Style{Foreground: e.theme.LineNumber})), width, lineNumber)
+		}
+		for _, s := range segments {
+			text := html.EscapeString(line[s.start:s.end])
+			if css := cssStyle(s.style); css != "" {
+				fmt.Fprintf(bw, `<span style="%s">%s</span>`, html.EscapeString(css), text)
+			} else {
+				bw.WriteString(text)
+			}
+		}
+		_, err := bw.WriteString("\n")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	bw.WriteString("</code></pre>\n")
+	return bw.Flush()
+}
+
+// sgr 返回设置样式的 ANSI 转义序列，颜色使用 24 位真彩色，没有样式时返回空字符串
+func sgr(s Style) string {
+	var parts []string
+	if r, g, b, ok := parseColor(s.Foreground); ok {
+		parts = append(parts, fmt.Sprintf("38;2;%d;%d;%d", r, g, b))
+	}
+	if r, g, b, ok := parseColor(s.Background); ok {
+		parts = append(parts, fmt.Sprintf("48;2;%d;%d;%d", r, g, b))
+	}
+	if s.Bold {
+		parts = append(parts, "1")
+	}
+	if s.Italic {
+		parts = append(parts, "3")
+	}
+	if s.Underline {
+		parts = append(parts, "4")
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "\x1b[" + strings.Join(parts, ";") + "m"
+}
+
+// parseColor 解析 #rrggbb 格式的颜色
+func parseColor(color string) (r, g, b uint8, ok bool) {
+	if len(color) != 7 || color[0] != '#' {
+		return 0, 0, 0, false
+	}
+	v, err := strconv.ParseUint(color[1:], 16, 32)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	return uint8(v >> 16), uint8(v >> 8), uint8(v), true
+}
+
+// escapeControl 把制表符以外的控制字符替换为 ^X 的形式，C1 控制字符替换为 M-^X 的形式，防止文档内容控制终端
+func escapeControl(text string) string {
+	if !strings.ContainsFunc(text, func(r rune) bool { return unicode.IsControl(r) && r != '\t' }) {
+		return text
+	}
+	var sb strings.Builder
+	for _, r := range text {
+		switch {
+		case r == 0x7f:
+			sb.WriteString("^?")
+		case r < 0x20 && r != '\t':
+			sb.WriteByte('^')
+			sb.WriteRune(r + '@')
+		case r >= 0x80 && r <= 0x9f:
+			sb.WriteString("M-^")
+			sb.WriteRune(r - 0x80 + '@')
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// WriteANSI 把文档或其中的范围导出为带有 ANSI 颜色的终端输出，逐行写入 w
+// 不设置默认的前景色和背景色，保留终端自己的配色
+func WriteANSI(w io.Writer, tree *buffer.PieceTreeBase, opts Options) error {
+	e := newExporter(tree, opts)
+	bw := bufio.NewWriter(w)
+	width := e.lineNumberWidth()
+	lineNumberStyle := sgr(Style{Foreground: e.theme.LineNumber})
+
+	err := e.each(func(lineNumber int, line string, segments []segment) error {
+		if opts.LineNumbers {
+			if lineNumberStyle != "" {
+				fmt.Fprintf(bw, "%s%*d\x1b[0m ", lineNumberStyle, width, lineNumber)
+			} else {
+				fmt.Fprintf(bw, "%*d ", width, lineNumber)
+			}
+		}
+		for _, s := range segments {
+			text := escapeControl(line[s.start:s.end])
+			if code := sgr(s.style); code != "" {
+				bw.WriteString(code + text + "\x1b[0m")
+			} else {
+				bw.WriteString(text)
+			}
+		}
+		_, err := bw.WriteString("\n")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,138 @@
+package export
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/kebaren/textbuffer/pkg/buffer/buffertest"
+	"github.com/kebaren/textbuffer/pkg/common"
+	"github.com/kebaren/textbuffer/pkg/tokenization"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTokens returns fixed tokens per line.
+type fakeTokens map[int][]tokenization.Token
+
+func (f fakeTokens) GetLineTokens(lineNumber int) []tokenization.Token {
+	return f[lineNumber]
+}
+
+var testTheme = &Theme{
+	Foreground: "#ffffff",
+	Background: "#000000",
+	Highlight:  "#0000ff",
+	LineNumber: "#808080",
+	Rules: []ThemeRule{
+		{Scope: "keyword", Foreground: "#ff0000", FontStyle: "bold"},
+		{Scope: "string", Foreground: "#00ff00"},
+		{Scope: "string.quoted.double", Foreground: "#00ffff"},
+		{Scope: "comment, markup.italic", FontStyle: "italic"},
+		{Scope: "markup.heading", Background: "#111111"},
+	},
+}
+
+func TestResolve(t *testing.T) {
+	assert.Equal(t, Style{}, testTheme.Resolve([]string{"source.go"}))
+	assert.Equal(t, Style{Foreground: "#ff0000", Bold: true}, testTheme.Resolve([]string{"source.go", "keyword.control.go"}))
+	// The most specific selector wins, and "stringy" is not a "string".
+	assert.Equal(t, Style{Foreground: "#00ffff"}, testTheme.Resolve([]string{"source.go", "string.quoted.double.go"}))
+	assert.Equal(t, Style{Foreground: "#00ff00"}, testTheme.Resolve([]string{"source.go", "string.quoted.raw.go"}))
+	assert.Equal(t, Style{}, testTheme.Resolve([]string{"stringy"}))
+	// Each property comes from the innermost scope that sets it.
+	assert.Equal(t, Style{Foreground: "#00ff00", Background: "#111111", Italic: true},
+		testTheme.Resolve([]string{"text.md", "markup.heading.md", "string.x", "markup.italic.md"}))
+}
+
+func TestWriteHTML(t *testing.T) {
+	tree := buffertest.NewTree("if a<b {\n\treturn \"&\"\n}")
+	tokens := fakeTokens{
+		1: {{StartIndex: 0, EndIndex: 2, Scopes: []string{"source", "keyword.control"}}, {StartIndex: 2, EndIndex: 8, Scopes: []string{"source"}}},
+		2: {{StartIndex: 1, EndIndex: 7, Scopes: []string{"source", "keyword"}}, {StartIndex: 8, EndIndex: 11, Scopes: []string{"source", "string.quoted.double"}}},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, WriteHTML(&buf, tree, Options{Theme: testTheme, Tokens: tokens, LineNumbers: true}))
+	assert.Equal(t, `<pre style="color:#ffffff;background-color:#000000"><code>`+
+		`<span style="color:#808080;user-select:none">1 </span><span style="color:#ff0000;font-weight:bold">if</span> a&lt;b {`+"\n"+
+		`<span style="color:#808080;user-select:none">2 </span>`+"\t"+`<span style="color:#ff0000;font-weight:bold">return</span> <span style="color:#00ffff">&#34;&amp;&#34;</span>`+"\n"+
+		`<span style="color:#808080;user-select:none">3 </span>}`+"\n"+
+		"</code></pre>\n", buf.String())
+
+	// Colors from the theme cannot break out of the style attribute.
+	theme := &Theme{
+		Foreground: `red"><script>`,
+		LineNumber: `"onclick="x`,
+		Rules:      []ThemeRule{{Scope: "keyword", Foreground: `#fff"><b>`}},
+	}
+	buf.Reset()
+	require.NoError(t, WriteHTML(&buf, buffertest.NewTree("if"), Options{Theme: theme, Tokens: tokens, LineNumbers: true}))
+	assert.Equal(t, `<pre style="color:red&#34;&gt;&lt;script&gt;"><code>`+
+		`<span style="color:&#34;onclick=&#34;x;user-select:none">1 </span>`+
+		`<span style="color:#fff&#34;&gt;&lt;b&gt;">if</span>`+"\n"+
+		"</code></pre>\n", buf.String())
+}
+
+func TestWriteANSI(t *testing.T) {
+	tree := buffertest.NewTree("if x\n\x1b[2Jdone")
+	tokens := fakeTokens{1: {{StartIndex: 0, EndIndex: 2, Scopes: []string{"keyword"}}}}
+	var buf bytes.Buffer
+	require.NoError(t, WriteANSI(&buf, tree, Options{Theme: testTheme, Tokens: tokens}))
+	// Control characters in the document are escaped so they cannot drive the terminal.
+	assert.Equal(t, "\x1b[38;2;255;0;0;1mif\x1b[0m x\n^[[2Jdone\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, WriteANSI(&buf, tree, Options{Theme: testTheme, LineNumbers: true}))
+	assert.Equal(t, "\x1b[38;2;128;128;128m1\x1b[0m if x\n\x1b[38;2;128;128;128m2\x1b[0m ^[[2Jdone\n", buf.String())
+
+	// C1 controls such as CSI (U+009B) are escaped as well.
+	buf.Reset()
+	require.NoError(t, WriteANSI(&buf, buffertest.NewTree("a\u009b2Jb\u0085\x7f\tc"), Options{Theme: &Theme{}}))
+	assert.Equal(t, "aM-^[2JbM-^E^?\tc\n", buf.String())
+}
+
+func TestRangeAndHighlights(t *testing.T) {
+	lines := "line 1\nline 2\nline 3\nline 4\nline 5\nline 6\nline 7\nline 8\nline 9\nline 10"
+	tree := buffertest.NewTree(lines)
+	r := common.Range{StartLineNumber: 9, StartColumn: 3, EndLineNumber: 10, EndColumn: 5}
+	var buf bytes.Buffer
+	require.NoError(t, WriteANSI(&buf, tree, Options{Theme: &Theme{}, Range: &r, LineNumbers: true}))
+	assert.Equal(t, " 9 ne 9\n10 line\n", buf.String())
+
+	// Reversed ranges are normalized and out of range positions are clamped.
+	r = common.Range{StartLineNumber: 99, StartColumn: 99, EndLineNumber: 10, EndColumn: 3}
+	buf.Reset()
+	require.NoError(t, WriteANSI(&buf, tree, Options{Theme: &Theme{}, Range: &r}))
+	assert.Equal(t, "ne 10\n", buf.String())
+
+	// A highlight splits tokens and spans several lines.
+	tokens := fakeTokens{1: {{StartIndex: 0, EndIndex: 4, Scopes: []string{"keyword"}}}}
+	r = common.Range{StartLineNumber: 1, StartColumn: 1, EndLineNumber: 2, EndColumn: 7}
+	highlight := common.Range{StartLineNumber: 2, StartColumn: 3, EndLineNumber: 1, EndColumn: 3}
+	buf.Reset()
+	require.NoError(t, WriteHTML(&buf, tree, Options{Theme: testTheme, Tokens: tokens, Range: &r, Highlights: []common.Range{highlight}}))
+	assert.Equal(t, `<pre style="color:#ffffff;background-color:#000000"><code>`+
+		`<span style="color:#ff0000;font-weight:bold">li</span>`+
+		`<span style="color:#ff0000;background-color:#0000ff;font-weight:bold">ne</span>`+
+		`<span style="background-color:#0000ff"> 1</span>`+"\n"+
+		`<span style="background-color:#0000ff">li</span>ne 2`+"\n"+
+		"</code></pre>\n", buf.String())
+}
+
+func TestDefaultThemeAndEmptyDocument(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteHTML(&buf, buffertest.NewTree(""), Options{}))
+	assert.Equal(t, `<pre style="color:#d4d4d4;background-color:#1e1e1e"><code>`+"\n</code></pre>\n", buf.String())
+	assert.Equal(t, Style{Foreground: "#6a9955", Italic: true}, DefaultTheme.Resolve([]string{"source.go", "comment.line.go"}))
+}
+
+// failWriter fails every write.
+type failWriter struct{}
+
+func (failWriter) Write([]byte) (int, error) { return 0, errors.New("boom") }
+
+func TestWriteError(t *testing.T) {
+	tree := buffertest.NewTree("x")
+	assert.EqualError(t, WriteHTML(failWriter{}, tree, Options{}), "boom")
+	assert.EqualError(t, WriteANSI(failWriter{}, tree, Options{}), "boom")
+}
@@ -0,0 +1,117 @@
+package export
+
+import "strings"
+
+// ThemeRule 主题规则，Scope 是以逗号分隔的作用域选择器，例如 "string, comment.line"
+// 选择器匹配相同的作用域以及以它加 "." 开头的作用域
+type ThemeRule struct {
+	Scope string
+	// Foreground 和 Background 是 #rrggbb 格式的颜色，为空时不设置
+	Foreground string
+	Background string
+	// FontStyle 以空格分隔的 bold、italic、underline，为空时不设置
+	FontStyle string
+}
+
+// Theme 把作用域映射到颜色的主题
+type Theme struct {
+	Name string
+	// Foreground 和 Background 是默认的前景色和背景色
+	Foreground string
+	Background string
+	// Highlight 高亮范围的背景色
+	Highlight string
+	// LineNumber 行号的颜色
+	LineNumber string
+	Rules      []ThemeRule
+}
+
+// Style 解析后的标记样式
+type Style struct {
+	Foreground string
+	Background string
+	Bold       bool
+	Italic     bool
+	Underline  bool
+}
+
+// DefaultTheme 默认的深色主题
+var DefaultTheme = &Theme{
+	Name:       "default-dark",
+	Foreground: "#d4d4d4",
+	Background: "#1e1e1e",
+	Highlight:  "#264f78",
+	LineNumber: "#858585",
+	Rules: []ThemeRule{
+		{Scope: "comment", Foreground: "#6a9955", FontStyle: "italic"},
+		{Scope: "string", Foreground: "#ce9178"},
+		{Scope: "constant.numeric", Foreground: "#b5cea8"},
+		{Scope: "constant.language", Foreground: "#569cd6"},
+		{Scope: "constant.character, constant.other", Foreground: "#d7ba7d"},
+		{Scope: "keyword, storage", Foreground: "#569cd6"},
+		{Scope: "keyword.control", Foreground: "#c586c0"},
+		{Scope: "keyword.operator", Foreground: "#d4d4d4"},
+		{Scope: "entity.name.function", Foreground: "#dcdcaa"},
+		{Scope: "entity.name.type, support.type, storage.type.builtin", Foreground: "#4ec9b0"},
+		{Scope: "support.type.property-name", Foreground: "#9cdcfe"},
+		{Scope: "entity.name.section, markup.heading", Foreground: "#569cd6", FontStyle: "bold"},
+		{Scope: "markup.bold", FontStyle: "bold"},
+		{Scope: "markup.italic", FontStyle: "italic"},
+		{Scope: "markup.underline", FontStyle: "underline"},
+		{Scope: "markup.inline.raw, markup.raw", Foreground: "#ce9178"},
+		{Scope: "invalid", Foreground: "#f44747"},
+	},
+}
+
+// selectorMatches 返回选择器与作用域的匹配程度（选择器的段数），不匹配时返回 0
+func selectorMatches(selector, scope string) int {
+	if selector == "" || !strings.HasPrefix(scope, selector) || (len(scope) > len(selector) && scope[len(selector)] != '.') {
+		return 0
+	}
+	return strings.Count(selector, ".") + 1
+}
+
+// match 返回与作用域匹配的规则，多个规则匹配时选择最具体的，同样具体时选择后面的
+func (t *Theme) match(scope string, has func(ThemeRule) bool) (ThemeRule, bool) {
+	var best ThemeRule
+	bestScore := 0
+	for _, r := range t.Rules {
+		if !has(r) {
+			continue
+		}
+		for _, selector := range strings.Split(r.Scope, ",") {
+			if score := selectorMatches(strings.TrimSpace(selector), scope); score > 0 && score >= bestScore {
+				best, bestScore = r, score
+			}
+		}
+	}
+	return best, bestScore > 0
+}
+
+// Resolve 返回作用域列表（从外到内）的样式，每个属性取最内层能匹配到规则的作用域
+func (t *Theme) Resolve(scopes []string) Style {
+	var style Style
+	resolve := func(has func(ThemeRule) bool, apply func(ThemeRule)) {
+		for i := len(scopes) - 1; i >= 0; i-- {
+			if r, ok := t.match(scopes[i], has); ok {
+				apply(r)
+				return
+			}
+		}
+	}
+	resolve(func(r ThemeRule) bool { return r.Foreground != "" }, func(r ThemeRule) { style.Foreground = r.Foreground })
+	resolve(func(r ThemeRule) bool { return r.Background != "" }, func(r ThemeRule) { style.Background = r.Background })
+	resolve(func(r ThemeRule) bool { return r.FontStyle != "" }, func(r ThemeRule) {
+		for _, f := range strings.Fields(r.FontStyle) {
+			switch f {
+			case "bold":
+				style.Bold = true
+			case "italic":
+				style.Italic = true
+			case "underline":
+				style.Underline = true
+			}
+		}
+	})
+	return style
+}
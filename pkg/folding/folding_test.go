@@ -0,0 +1,172 @@
+package folding
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+	"github.com/kebaren/textbuffer/pkg/buffer/buffertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func indentRange(start, end int) Range {
+	return Range{StartLine: start, EndLine: end, Kind: RangeKindIndent}
+}
+
+func markerRange(start, end int) Range {
+	return Range{StartLine: start, EndLine: end, Kind: RangeKindMarker}
+}
+
+const goSource = `package main
+
+func main() {
+	if true {
+		println("a")
+
+		println("b")
+	}
+}
+
+func other() {
+	return
+}
+`
+
+func TestIndentRanges(t *testing.T) {
+	p := NewProvider(buffertest.NewTree(goSource), Options{TabSize: 4})
+	assert.Equal(t, []Range{indentRange(3, 8), indentRange(4, 7), indentRange(11, 12)}, p.Ranges())
+
+	r, ok := p.RangeAt(5)
+	assert.True(t, ok)
+	assert.Equal(t, indentRange(4, 7), r)
+	r, ok = p.RangeAt(3)
+	assert.True(t, ok)
+	assert.Equal(t, indentRange(3, 8), r)
+	_, ok = p.RangeAt(1)
+	assert.False(t, ok)
+}
+
+func TestOffSideRanges(t *testing.T) {
+	text := "def f():\n    a\n    b\n\n\ndef g():\n    c\n"
+	assert.Equal(t, []Range{indentRange(1, 5), indentRange(6, 8)}, NewProvider(buffertest.NewTree(text), Options{}).Ranges())
+	assert.Equal(t, []Range{indentRange(1, 3), indentRange(6, 7)}, NewProvider(buffertest.NewTree(text), Options{OffSide: true}).Ranges())
+}
+
+func TestMarkerRanges(t *testing.T) {
+	text := "// #region imports\n" +
+		"import a\n" +
+		"import b\n" +
+		"// #endregion\n" +
+		"#region outer\n" +
+		"x\n" +
+		"#region inner\n" +
+		"y\n" +
+		"#endregion\n" +
+		"#endregion\n" +
+		"#region unterminated\n" +
+		"z\n"
+	p := NewProvider(buffertest.NewTree(text), Options{Markers: RegionMarkers})
+	assert.Equal(t, []Range{markerRange(1, 4), markerRange(5, 10), markerRange(7, 9)}, p.Ranges())
+}
+
+func TestProviderIncremental(t *testing.T) {
+	rng := rand.New(rand.NewSource(40))
+	pieces := []string{"\n", "\t", "    ", "x", "#region\n", "#endregion\n", "{\n", "}\n"}
+	tree := buffertest.NewTree(goSource)
+	opts := Options{TabSize: 4, Markers: RegionMarkers}
+	p := NewProvider(tree, opts)
+
+	for i := 0; i < 300; i++ {
+		length := tree.GetLength()
+		op := buffer.NewEditOperation(rng.Intn(length+1), 0, "")
+		if rng.Intn(3) == 0 && length > op.Offset {
+			op.Length = rng.Intn(min(length-op.Offset, 10)) + 1
+		}
+		for n := rng.Intn(3); n > 0; n-- {
+			op.Text += pieces[rng.Intn(len(pieces))]
+		}
+		tree.ApplyEdit(op)
+		expected := NewProvider(tree, opts)
+		expected.Dispose()
+		require.Equal(t, expected.Ranges(), p.Ranges(), "after edit %d", i)
+	}
+}
+
+func TestHiddenRanges(t *testing.T) {
+	m := NewHiddenRangeModel(NewProvider(buffertest.NewTree(goSource), Options{TabSize: 4}))
+	assert.Equal(t, 14, m.GetVisibleLineCount())
+
+	// Folding inside the if statement folds the innermost range first.
+	assert.True(t, m.Fold(5))
+	assert.True(t, m.IsCollapsed(4))
+	assert.Equal(t, []Range{indentRange(5, 7)}, m.HiddenRanges())
+	assert.Equal(t, 11, m.GetVisibleLineCount())
+	assert.True(t, m.IsHidden(5))
+	assert.False(t, m.IsHidden(4))
+	assert.False(t, m.IsHidden(8))
+
+	assert.Equal(t, 4, m.ModelToVisible(4))
+	assert.Equal(t, 4, m.ModelToVisible(6))
+	assert.Equal(t, 5, m.ModelToVisible(8))
+	assert.Equal(t, 4, m.VisibleToModel(4))
+	assert.Equal(t, 8, m.VisibleToModel(5))
+	assert.Equal(t, 14, m.VisibleToModel(99))
+
+	// Folding the same line again folds the enclosing range, which contains the first one.
+	assert.True(t, m.Fold(4))
+	assert.Equal(t, []Range{indentRange(4, 8)}, m.HiddenRanges())
+	assert.Equal(t, []Range{indentRange(3, 8), indentRange(4, 7)}, m.Collapsed())
+	assert.Equal(t, 9, m.GetVisibleLineCount())
+
+	assert.True(t, m.Unfold(3))
+	assert.Equal(t, []Range{indentRange(5, 7)}, m.HiddenRanges())
+	assert.True(t, m.ToggleFold(4))
+	assert.Empty(t, m.HiddenRanges())
+	assert.False(t, m.Unfold(1))
+	assert.False(t, m.Fold(1))
+
+	m.FoldAll()
+	assert.Equal(t, []Range{indentRange(4, 8), indentRange(12, 12)}, m.HiddenRanges())
+	for line := 1; line <= 14; line++ {
+		if !m.IsHidden(line) {
+			assert.Equal(t, line, m.VisibleToModel(m.ModelToVisible(line)))
+		}
+	}
+	m.UnfoldAll()
+	assert.Equal(t, 14, m.GetVisibleLineCount())
+}
+
+func TestHiddenRangesAfterEdits(t *testing.T) {
+	tree := buffertest.NewTree(goSource)
+	m := NewHiddenRangeModel(NewProvider(tree, Options{TabSize: 4}))
+	require.True(t, m.SetCollapsed(11, true))
+	require.True(t, m.SetCollapsed(4, true))
+
+	// Inserting lines above moves the folded ranges.
+	tree.ApplyEdit(buffer.NewEditOperation(0, 0, "// comment\n\n"))
+	assert.Equal(t, []Range{indentRange(6, 9), indentRange(13, 14)}, m.Collapsed())
+
+	// Editing the header line keeps the range folded.
+	tree.ApplyEdit(buffer.NewEditOperation(tree.GetOffsetAt(6, 6), 0, "false || "))
+	assert.Equal(t, []Range{indentRange(6, 9), indentRange(13, 14)}, m.Collapsed())
+
+	// Deleting the line above a folded range keeps it folded.
+	tree.ApplyEdit(buffer.NewEditOperation(tree.GetOffsetAt(12, 1), tree.GetLineLength(12)+1, ""))
+	assert.Equal(t, []Range{indentRange(6, 9), indentRange(12, 13)}, m.Collapsed())
+
+	// Editing a hidden line unfolds the range.
+	tree.ApplyEdit(buffer.NewEditOperation(tree.GetOffsetAt(7, 1), 0, "\t"))
+	assert.Equal(t, []Range{indentRange(12, 13)}, m.Collapsed())
+
+	// Removing the range removes the folded state.
+	history := buffer.NewHistory(tree, 0)
+	history.Apply([]buffer.EditOperation{buffer.NewEditOperation(tree.GetOffsetAt(13, 1), 1, "")})
+	assert.Empty(t, m.Collapsed())
+	assert.Equal(t, tree.GetLineCount(), m.GetVisibleLineCount())
+
+	// Undoing through the history restores the range, which can be folded again.
+	history.Undo()
+	assert.True(t, m.Fold(12))
+	assert.Equal(t, []Range{indentRange(12, 13)}, m.Collapsed())
+}
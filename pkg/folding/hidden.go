@@ -0,0 +1,245 @@
+package folding
+
+import "sort"
+
+// HiddenRangeModel 记录已折叠的范围，并隐藏被折叠的行
+// 可见行号从 1 开始连续编号，被隐藏的行没有可见行号
+type HiddenRangeModel struct {
+	provider *Provider
+	// collapsed 已折叠范围的起始行，按升序排列
+	collapsed []int
+	// hidden 合并后的隐藏行范围，按起始行排序，dirty 为 true 时需要重新计算
+	hidden      []Range
+	hiddenCount int
+	dirty       bool
+}
+
+// NewHiddenRangeModel 创建隐藏范围模型，初始时没有折叠的范围
+// 之后修改文本缓冲区时提供者会通知这个模型，一个提供者只能用于一个模型
+func NewHiddenRangeModel(provider *Provider) *HiddenRangeModel {
+	m := &HiddenRangeModel{provider: provider}
+	provider.hidden = m
+	return m
+}
+
+// Provider 返回折叠范围提供者
+func (m *HiddenRangeModel) Provider() *Provider {
+	return m.provider
+}
+
+// update 丢弃不再对应折叠范围的起始行，重新计算隐藏的行
+func (m *HiddenRangeModel) update() {
+	if !m.dirty {
+		return
+	}
+	m.dirty = false
+
+	ranges := m.provider.Ranges()
+	collapsed := m.collapsed[:0]
+	m.hidden = m.hidden[:0]
+	m.hiddenCount = 0
+	for _, start := range m.collapsed {
+		r, ok := findRange(ranges, start)
+		if !ok {
+			continue
+		}
+		collapsed = append(collapsed, start)
+		// 包含在已隐藏范围内的折叠范围不需要单独记录
+		if n := len(m.hidden); n > 0 && m.hidden[n-1].EndLine >= r.StartLine+1 {
+			continue
+		}
+		m.hidden = append(m.hidden, Range{StartLine: r.StartLine + 1, EndLine: r.EndLine, Kind: r.Kind})
+		m.hiddenCount += r.EndLine - r.StartLine
+	}
+	m.collapsed = collapsed
+}
+
+// findRange 查找从 startLine 开始的折叠范围
+func findRange(ranges []Range, startLine int) (Range, bool) {
+	i := sort.Search(len(ranges), func(i int) bool { return ranges[i].StartLine >= startLine })
+	if i < len(ranges) && ranges[i].StartLine == startLine {
+		return ranges[i], true
+	}
+	return Range{}, false
+}
+
+// IsCollapsed 判断从 startLine 开始的折叠范围是否已折叠
+func (m *HiddenRangeModel) IsCollapsed(startLine int) bool {
+	m.update()
+	i := sort.SearchInts(m.collapsed, startLine)
+	return i < len(m.collapsed) && m.collapsed[i] == startLine
+}
+
+// SetCollapsed 折叠或展开从 startLine 开始的折叠范围，没有这样的范围或状态没有变化时返回 false
+func (m *HiddenRangeModel) SetCollapsed(startLine int, collapsed bool) bool {
+	if _, ok := findRange(m.provider.Ranges(), startLine); !ok {
+		return false
+	}
+	m.update()
+	i := sort.SearchInts(m.collapsed, startLine)
+	found := i < len(m.collapsed) && m.collapsed[i] == startLine
+	if found == collapsed {
+		return false
+	}
+	if collapsed {
+		m.collapsed = append(m.collapsed, 0)
+		copy(m.collapsed[i+1:], m.collapsed[i:])
+		m.collapsed[i] = startLine
+	} else {
+		m.collapsed = append(m.collapsed[:i], m.collapsed[i+1:]...)
+	}
+	m.dirty = true
+	return true
+}
+
+// containing 返回包含 lineNumber 的折叠范围，从最内层到最外层排列
+func (m *HiddenRangeModel) containing(lineNumber int) []Range {
+	var result []Range
+	ranges := m.provider.Ranges()
+	for i := sort.Search(len(ranges), func(i int) bool { return ranges[i].StartLine > lineNumber }) - 1; i >= 0; i-- {
+		if ranges[i].EndLine >= lineNumber {
+			result = append(result, ranges[i])
+		}
+	}
+	return result
+}
+
+// Fold 折叠包含 lineNumber 的最内层未折叠范围，没有可折叠的范围时返回 false
+func (m *HiddenRangeModel) Fold(lineNumber int) bool {
+	for _, r := range m.containing(lineNumber) {
+		if !m.IsCollapsed(r.StartLine) {
+			return m.SetCollapsed(r.StartLine, true)
+		}
+	}
+	return false
+}
+
+// Unfold 展开包含 lineNumber 的最内层已折叠范围，没有已折叠的范围时返回 false
+func (m *HiddenRangeModel) Unfold(lineNumber int) bool {
+	for _, r := range m.containing(lineNumber) {
+		if m.IsCollapsed(r.StartLine) {
+			return m.SetCollapsed(r.StartLine, false)
+		}
+	}
+	return false
+}
+
+// ToggleFold 切换包含 lineNumber 的最内层折叠范围的折叠状态
+func (m *HiddenRangeModel) ToggleFold(lineNumber int) bool {
+	ranges := m.containing(lineNumber)
+	if len(ranges) == 0 {
+		return false
+	}
+	start := ranges[0].StartLine
+	return m.SetCollapsed(start, !m.IsCollapsed(start))
+}
+
+// FoldAll 折叠所有范围
+func (m *HiddenRangeModel) FoldAll() {
+	ranges := m.provider.Ranges()
+	m.collapsed = m.collapsed[:0]
+	for _, r := range ranges {
+		m.collapsed = append(m.collapsed, r.StartLine)
+	}
+	m.dirty = true
+}
+
+// UnfoldAll 展开所有范围
+func (m *HiddenRangeModel) UnfoldAll() {
+	m.collapsed = m.collapsed[:0]
+	m.dirty = true
+}
+
+// Collapsed 返回已折叠的范围，按起始行排序
+func (m *HiddenRangeModel) Collapsed() []Range {
+	m.update()
+	ranges := m.provider.Ranges()
+	result := make([]Range, 0, len(m.collapsed))
+	for _, start := range m.collapsed {
+		r, _ := findRange(ranges, start)
+		result = append(result, r)
+	}
+	return result
+}
+
+// HiddenRanges 返回合并后被隐藏的行范围，按起始行排序
+func (m *HiddenRangeModel) HiddenRanges() []Range {
+	m.update()
+	return append([]Range(nil), m.hidden...)
+}
+
+// hiddenAt 返回包含 lineNumber 的隐藏范围的下标，不存在时返回 -1
+func (m *HiddenRangeModel) hiddenAt(lineNumber int) int {
+	i := sort.Search(len(m.hidden), func(i int) bool { return m.hidden[i].EndLine >= lineNumber })
+	if i < len(m.hidden) && m.hidden[i].StartLine <= lineNumber {
+		return i
+	}
+	return -1
+}
+
+// IsHidden 判断行是否被折叠隐藏
+func (m *HiddenRangeModel) IsHidden(lineNumber int) bool {
+	m.update()
+	return m.hiddenAt(lineNumber) >= 0
+}
+
+// GetVisibleLineCount 返回可见行的数量
+func (m *HiddenRangeModel) GetVisibleLineCount() int {
+	m.update()
+	return m.provider.tree.GetLineCount() - m.hiddenCount
+}
+
+// ModelToVisible 把模型行号转换为可见行号，被隐藏的行映射到折叠范围的起始行
+func (m *HiddenRangeModel) ModelToVisible(lineNumber int) int {
+	m.update()
+	lineNumber = min(max(lineNumber, 1), m.provider.tree.GetLineCount())
+	if i := m.hiddenAt(lineNumber); i >= 0 {
+		lineNumber = m.hidden[i].StartLine - 1
+	}
+	visible := lineNumber
+	for _, r := range m.hidden {
+		if r.StartLine > lineNumber {
+			break
+		}
+		visible -= r.EndLine - r.StartLine + 1
+	}
+	return visible
+}
+
+// VisibleToModel 把可见行号转换为模型行号，超出范围的行号会被限制在有效范围内
+func (m *HiddenRangeModel) VisibleToModel(visibleLine int) int {
+	m.update()
+	lineNumber := min(max(visibleLine, 1), m.GetVisibleLineCount())
+	for _, r := range m.hidden {
+		if r.StartLine > lineNumber {
+			break
+		}
+		lineNumber += r.EndLine - r.StartLine + 1
+	}
+	return lineNumber
+}
+
+// OnLinesReplaced 通知模型从 startLine 开始的 oldLineCount 个行被替换为 newLineCount 个新行
+// 被修改的行位于折叠范围内时展开该范围，其它已折叠范围随行号移动
+func (m *HiddenRangeModel) OnLinesReplaced(startLine, oldLineCount, newLineCount int) {
+	endLine := startLine + oldLineCount - 1
+	ranges := m.provider.Ranges()
+	collapsed := m.collapsed[:0]
+	for _, start := range m.collapsed {
+		r, ok := findRange(ranges, start)
+		switch {
+		case !ok, r.StartLine+1 <= endLine && r.EndLine >= startLine:
+			// 修改了被隐藏的行
+			continue
+		case start == endLine && start > startLine:
+			// 起始行是被替换的最后一行，它剩余的内容在新的最后一行
+			start = startLine + newLineCount - 1
+		case start > endLine:
+			start += newLineCount - oldLineCount
+		}
+		collapsed = append(collapsed, start)
+	}
+	m.collapsed = collapsed
+	m.provider.OnLinesReplaced(startLine, oldLineCount, newLineCount)
+	m.dirty = true
+}
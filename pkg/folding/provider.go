@@ -0,0 +1,259 @@
+package folding
+
+import (
+	"regexp"
+	"sort"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+)
+
+// RangeKind 折叠范围的来源
+type RangeKind int
+
+const (
+	// RangeKindIndent 由缩进层级得到的折叠范围
+	RangeKindIndent RangeKind = iota
+	// RangeKindMarker 由开始和结束标记得到的折叠范围
+	RangeKindMarker
+)
+
+// Range 可折叠的行范围，折叠后 StartLine 保持可见，StartLine+1 到 EndLine 被隐藏
+type Range struct {
+	// StartLine 起始行号（从 1 开始）
+	StartLine int
+	// EndLine 结束行号（包含）
+	EndLine int
+	// Kind 折叠范围的来源
+	Kind RangeKind
+}
+
+// Markers 折叠标记，匹配 Start 的行开始一个折叠范围，匹配 End 的行结束该范围
+type Markers struct {
+	Start *regexp.Regexp
+	End   *regexp.Regexp
+}
+
+// RegionMarkers #region 和 #endregion 标记，也允许前面带有 // 注释
+var RegionMarkers = &Markers{
+	Start: regexp.MustCompile(`^\s*(//\s*)?#region\b`),
+	End:   regexp.MustCompile(`^\s*(//\s*)?#endregion\b`),
+}
+
+// Options 折叠范围的计算选项
+type Options struct {
+	// TabSize 计算缩进层级时制表符的宽度
+	TabSize int
+	// Markers 折叠标记，为 nil 时只按缩进计算
+	Markers *Markers
+	// OffSide 越位规则语言（例如 Python），范围末尾的空行不属于该范围
+	OffSide bool
+}
+
+// tabSize 返回制表符的宽度，无效时为 4
+func (o Options) tabSize() int {
+	if o.TabSize > 0 {
+		return o.TabSize
+	}
+	return 4
+}
+
+// 行上的标记
+const (
+	markerNone = iota
+	markerStart
+	markerEnd
+)
+
+// lineInfo 计算折叠范围需要的每行信息
+type lineInfo struct {
+	// indent 缩进层级，只有空白的行为 -1
+	indent int
+	// marker 行上的标记
+	marker int8
+}
+
+// Provider 折叠范围提供者，根据缩进和折叠标记计算可折叠的范围
+// 每行的缩进和标记被缓存下来，修改文本缓冲区后只重新读取受影响的行
+type Provider struct {
+	tree  *buffer.PieceTreeBase
+	opts  Options
+	lines []lineInfo
+	// ranges 按起始行排序的折叠范围，dirty 为 true 时需要重新计算
+	ranges []Range
+	dirty  bool
+	// hidden 使用这个提供者的隐藏范围模型，可以为 nil
+	hidden *HiddenRangeModel
+	// removeListener 取消注册内容修改监听器
+	removeListener func()
+}
+
+// NewProvider 创建折叠范围提供者
+func NewProvider(tree *buffer.PieceTreeBase, opts Options) *Provider {
+	p := &Provider{tree: tree, opts: opts}
+	p.lines = make([]lineInfo, tree.GetLineCount())
+	for i := range p.lines {
+		p.lines[i] = p.computeLine(i + 1)
+	}
+	p.dirty = true
+	p.removeListener = tree.AddChangeListener(p.onContentChange)
+	return p
+}
+
+// Tree 返回文本缓冲区
+func (p *Provider) Tree() *buffer.PieceTreeBase {
+	return p.tree
+}
+
+// computeLine 计算一行的缩进层级和标记
+func (p *Provider) computeLine(lineNumber int) lineInfo {
+	line := p.tree.GetLineContent(lineNumber)
+	info := lineInfo{indent: indentLevel(line, p.opts.tabSize())}
+	if m := p.opts.Markers; m != nil {
+		if m.Start != nil && m.Start.MatchString(line) {
+			info.marker = markerStart
+		} else if m.End != nil && m.End.MatchString(line) {
+			info.marker = markerEnd
+		}
+	}
+	return info
+}
+
+// indentLevel 返回行的缩进宽度，只有空白的行返回 -1
+func indentLevel(line string, tabSize int) int {
+	indent := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			indent++
+		case '\t':
+			indent += tabSize - indent%tabSize
+		default:
+			return indent
+		}
+	}
+	return -1
+}
+
+// OnLinesReplaced 通知提供者从 startLine 开始的 oldLineCount 个行被替换为 newLineCount 个新行
+func (p *Provider) OnLinesReplaced(startLine, oldLineCount, newLineCount int) {
+	index := min(max(startLine-1, 0), len(p.lines))
+	oldLineCount = max(min(oldLineCount, len(p.lines)-index), 0)
+	newLineCount = max(min(newLineCount, p.tree.GetLineCount()-index), 0)
+
+	lines := make([]lineInfo, newLineCount)
+	for i := range lines {
+		lines[i] = p.computeLine(startLine + i)
+	}
+	tail := append([]lineInfo(nil), p.lines[index+oldLineCount:]...)
+	p.lines = append(append(p.lines[:index], lines...), tail...)
+	p.dirty = true
+}
+
+// onContentChange 文本缓冲区修改后更新受影响的行
+// 有隐藏范围模型时由它先根据修改前的折叠范围更新折叠状态，再更新提供者
+func (p *Provider) onContentChange(change buffer.ContentChange) {
+	if p.hidden != nil {
+		p.hidden.OnLinesReplaced(change.StartLine(), change.OldLineCount(), change.NewLineCount())
+		return
+	}
+	p.OnLinesReplaced(change.StartLine(), change.OldLineCount(), change.NewLineCount())
+}
+
+// Dispose 停止跟踪文本缓冲区的修改
+func (p *Provider) Dispose() {
+	p.removeListener()
+}
+
+// Ranges 返回按起始行排序的折叠范围
+func (p *Provider) Ranges() []Range {
+	if p.dirty {
+		p.ranges = computeRanges(p.lines, p.opts.OffSide)
+		p.dirty = false
+	}
+	return p.ranges
+}
+
+// RangeAt 返回包含 lineNumber 的最内层折叠范围（包括起始行）
+func (p *Provider) RangeAt(lineNumber int) (Range, bool) {
+	ranges := p.Ranges()
+	// 起始行不大于 lineNumber 的最后一个范围开始向前查找
+	for i := sort.Search(len(ranges), func(i int) bool { return ranges[i].StartLine > lineNumber }) - 1; i >= 0; i-- {
+		if ranges[i].EndLine >= lineNumber {
+			return ranges[i], true
+		}
+	}
+	return Range{}, false
+}
+
+// region 从后向前扫描时尚未结束的区域
+type region struct {
+	// indent 区域的缩进层级，-2 表示结束标记
+	indent int
+	// endAbove 区域结束的行号（不包含）
+	endAbove int
+	// line 区域开始的行号
+	line int
+}
+
+// computeRanges 从最后一行向前扫描，根据缩进层级和标记计算折叠范围
+// 缩进比后面的行小的行开始一个缩进范围，范围在下一个缩进不大于它的行之前结束；
+// 开始标记和与之配对的结束标记构成一个标记范围，包含结束标记所在的行
+func computeRanges(lines []lineInfo, offSide bool) []Range {
+	lineCount := len(lines)
+	var ranges []Range
+	regions := []region{{indent: -1, endAbove: lineCount + 1, line: lineCount + 1}}
+
+	for line := lineCount; line > 0; line-- {
+		info := lines[line-1]
+		previous := &regions[len(regions)-1]
+		if info.indent == -1 {
+			if offSide {
+				// 空行不属于它前面的区域
+				previous.endAbove = line
+			}
+			continue
+		}
+
+		switch info.marker {
+		case markerStart:
+			// 找到最近的结束标记，丢弃它之后的所有区域
+			i := len(regions) - 1
+			for i > 0 && regions[i].indent != -2 {
+				i--
+			}
+			if i > 0 {
+				regions = regions[:i+1]
+				previous = &regions[i]
+				ranges = append(ranges, Range{StartLine: line, EndLine: previous.line, Kind: RangeKindMarker})
+				previous.indent, previous.endAbove, previous.line = info.indent, line, line
+				continue
+			}
+			// 没有配对的结束标记，按普通行处理
+		case markerEnd:
+			regions = append(regions, region{indent: -2, endAbove: line, line: line})
+			continue
+		}
+
+		if previous.indent > info.indent {
+			// 丢弃缩进更深的区域，它们在这一行结束
+			for previous.indent > info.indent {
+				regions = regions[:len(regions)-1]
+				previous = &regions[len(regions)-1]
+			}
+			if endLine := previous.endAbove - 1; endLine-line >= 1 {
+				ranges = append(ranges, Range{StartLine: line, EndLine: endLine, Kind: RangeKindIndent})
+			}
+		}
+		if previous.indent == info.indent {
+			previous.endAbove = line
+		} else {
+			regions = append(regions, region{indent: info.indent, endAbove: line, line: line})
+		}
+	}
+
+	// 范围是从后向前得到的，反转为按起始行排序
+	for i, j := 0, len(ranges)-1; i < j; i, j = i+1, j-1 {
+		ranges[i], ranges[j] = ranges[j], ranges[i]
+	}
+	return ranges
+}
@@ -0,0 +1,56 @@
+package fsutil
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// WriteFile 原子地把 data 写入 path，见 WriteFileFunc
+func WriteFile(path string, data []byte) error {
+	return WriteFileFunc(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}, nil)
+}
+
+// WriteFileFunc 先把 write 写出的内容写入同一目录下的临时文件并同步到磁盘，再重命名覆盖目标文件，写入中途失败不会损坏原文件
+// 目标是符号链接时替换链接指向的文件；已有文件的权限保持不变，新文件的权限为 0644
+// backup 不为 nil 时，重命名之前把原文件改名为 backup(目标文件路径) 作为备份
+func WriteFileFunc(path string, write func(w io.Writer) error, backup func(target string) string) error {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if backup != nil {
+		if err := os.Rename(path, backup(path)); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,380 @@
+package grammar
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// rawCapture 语法文件中的捕获组
+type rawCapture struct {
+	Name string `json:"name"`
+}
+
+// rawRule 语法文件中的规则
+type rawRule struct {
+	Include       string                `json:"include"`
+	Name          string                `json:"name"`
+	ContentName   string                `json:"contentName"`
+	Match         string                `json:"match"`
+	Begin         string                `json:"begin"`
+	End           string                `json:"end"`
+	Captures      map[string]rawCapture `json:"captures"`
+	BeginCaptures map[string]rawCapture `json:"beginCaptures"`
+	EndCaptures   map[string]rawCapture `json:"endCaptures"`
+	Patterns      []*rawRule            `json:"patterns"`
+}
+
+// rawGrammar 语法文件
+type rawGrammar struct {
+	Name       string              `json:"name"`
+	ScopeName  string              `json:"scopeName"`
+	FileTypes  []string            `json:"fileTypes"`
+	Patterns   []*rawRule          `json:"patterns"`
+	Repository map[string]*rawRule `json:"repository"`
+}
+
+// rule 编译后的规则
+// 只有 match 的规则匹配一段文本；有 begin 和 end 的规则匹配 begin 后进入新的作用域，直到匹配 end；
+// 其它规则（include 和只有 patterns 的分组）在编译时展开
+type rule struct {
+	name        string
+	contentName string
+
+	match *pattern
+	begin *pattern
+	// end 不含反向引用的结束模式，endSource 是原始的结束模式
+	end       *pattern
+	endSource string
+	// anchored 和 endAnchored 模式以 ^ 开头，只在行首匹配
+	anchored    bool
+	endAnchored bool
+
+	captures      map[int]string
+	beginCaptures map[int]string
+	endCaptures   map[int]string
+
+	include  string
+	patterns []*rule
+	// flat 展开 include 和分组之后的子规则，只包含 match 规则和 begin/end 规则
+	flat []*rule
+}
+
+// Grammar 类 TextMate 的语法，把行分解为带有作用域的标记
+// 模式使用 Go 的 RE2 语法，不支持 Oniguruma 的环视和反向引用，但 end 模式可以用 \1 到 \9 引用 begin 的捕获组；
+// include 支持 #name、$self 和 $base，引用其它语法的 include 被忽略
+type Grammar struct {
+	// Name 语法的名称
+	Name string
+	// ScopeName 根作用域，例如 source.go
+	ScopeName string
+	// FileTypes 适用的文件扩展名
+	FileTypes []string
+
+	root       *rule
+	repository map[string]*rule
+
+	// endCache 用 begin 捕获组替换反向引用之后编译的结束模式，最多保存 endCacheSize 个
+	mu       sync.Mutex
+	endCache map[string]*pattern
+}
+
+// endCacheSize 结束模式缓存的容量，每个不同的捕获组内容都会编译一个结束模式，缓存满时整个清空
+const endCacheSize = 256
+
+// Parse 解析 JSON 格式的语法
+func Parse(data []byte) (*Grammar, error) {
+	var raw rawGrammar
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("grammar: %v", err)
+	}
+	if raw.ScopeName == "" {
+		return nil, fmt.Errorf("grammar: missing scopeName")
+	}
+
+	g := &Grammar{
+		Name:       raw.Name,
+		ScopeName:  raw.ScopeName,
+		FileTypes:  raw.FileTypes,
+		repository: make(map[string]*rule),
+		endCache:   make(map[string]*pattern),
+	}
+	root, err := compileRule(&rawRule{Patterns: raw.Patterns})
+	if err != nil {
+		return nil, err
+	}
+	g.root = root
+
+	// 先创建所有仓库规则，再统一展开，规则之间可以相互引用
+	names := make([]string, 0, len(raw.Repository))
+	for name := range raw.Repository {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r, err := compileRule(raw.Repository[name])
+		if err != nil {
+			return nil, fmt.Errorf("%v (in repository %q)", err, name)
+		}
+		g.repository[name] = r
+	}
+
+	all := append([]*rule{g.root}, collectRules(g.root)...)
+	for _, name := range names {
+		all = append(all, g.repository[name])
+		all = append(all, collectRules(g.repository[name])...)
+	}
+	for _, r := range all {
+		if r.match != nil {
+			continue
+		}
+		flat, err := g.flatten(r.patterns, nil)
+		if err != nil {
+			return nil, err
+		}
+		r.flat = flat
+	}
+	return g, nil
+}
+
+// LoadFile 从文件加载 JSON 格式的语法
+func LoadFile(path string) (*Grammar, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// compileRule 编译规则及其子规则
+func compileRule(raw *rawRule) (*rule, error) {
+	r := &rule{
+		name:          raw.Name,
+		contentName:   raw.ContentName,
+		include:       raw.Include,
+		captures:      compileCaptures(raw.Captures),
+		beginCaptures: compileCaptures(raw.BeginCaptures),
+		endCaptures:   compileCaptures(raw.EndCaptures),
+	}
+
+	var err error
+	switch {
+	case raw.Match != "":
+		r.match, err = compilePattern(raw.Match)
+		r.anchored = isAnchored(raw.Match)
+	case raw.Begin != "":
+		if r.begin, err = compilePattern(raw.Begin); err != nil {
+			break
+		}
+		r.anchored = isAnchored(raw.Begin)
+		r.endSource = raw.End
+		r.endAnchored = isAnchored(raw.End)
+		if !hasBackReference(raw.End) {
+			r.end, err = compilePattern(raw.End)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range raw.Patterns {
+		child, err := compileRule(p)
+		if err != nil {
+			return nil, err
+		}
+		r.patterns = append(r.patterns, child)
+	}
+	return r, nil
+}
+
+// compileCaptures 把捕获组的名称按组号索引
+func compileCaptures(raw map[string]rawCapture) map[int]string {
+	if len(raw) == 0 {
+		return nil
+	}
+	captures := make(map[int]string, len(raw))
+	for key, c := range raw {
+		if n, err := strconv.Atoi(key); err == nil && c.Name != "" {
+			captures[n] = c.Name
+		}
+	}
+	return captures
+}
+
+// pattern 编译后的模式，可以从行内任意位置开始查找
+type pattern struct {
+	re *regexp.Regexp
+	// afterRune 在 re 之前先匹配一个字符，从位置之前的字符开始查找，\b、\B 和 ^ 才能看到它
+	afterRune *regexp.Regexp
+}
+
+// compilePattern 编译模式，\h 转换为十六进制数字的字符类
+func compilePattern(source string) (*pattern, error) {
+	expanded := strings.ReplaceAll(source, `\h`, `[0-9a-fA-F]`)
+	re, err := regexp.Compile(expanded)
+	if err != nil {
+		return nil, fmt.Errorf("grammar: invalid pattern %q: %v", source, err)
+	}
+	afterRune, err := regexp.Compile(`(?s:.)(` + expanded + `)`)
+	if err != nil {
+		return nil, fmt.Errorf("grammar: invalid pattern %q: %v", source, err)
+	}
+	return &pattern{re: re, afterRune: afterRune}, nil
+}
+
+// findFrom 返回 line 中从 pos 开始的第一个匹配及其捕获组在行内的位置，没有匹配时返回 nil
+func (p *pattern) findFrom(line string, pos int) []int {
+	if pos == 0 {
+		return p.re.FindStringSubmatchIndex(line)
+	}
+	_, size := utf8.DecodeLastRuneInString(line[:pos])
+	m := p.afterRune.FindStringSubmatchIndex(line[pos-size:])
+	if m == nil {
+		return nil
+	}
+	// 去掉多匹配的字符，第 1 组就是 re 的整个匹配
+	m = m[2:]
+	for i := range m {
+		if m[i] >= 0 {
+			m[i] += pos - size
+		}
+	}
+	return m
+}
+
+// String 返回模式的源文本
+func (p *pattern) String() string {
+	return p.re.String()
+}
+
+// isAnchored 判断模式是否以 ^ 开头
+func isAnchored(pattern string) bool {
+	if strings.HasPrefix(pattern, "(?") {
+		// 跳过开头的标志，例如 (?i)
+		if i := strings.IndexByte(pattern, ')'); i > 0 && !strings.ContainsAny(pattern[2:i], ":=!<") {
+			pattern = pattern[i+1:]
+		}
+	}
+	return strings.HasPrefix(pattern, "^")
+}
+
+// hasBackReference 判断模式中是否有 \1 到 \9 反向引用
+func hasBackReference(pattern string) bool {
+	for i := 0; i+1 < len(pattern); i++ {
+		if pattern[i] == '\\' {
+			if c := pattern[i+1]; c >= '1' && c <= '9' {
+				return true
+			}
+			i++
+		}
+	}
+	return false
+}
+
+// collectRules 返回规则的所有后代规则
+func collectRules(r *rule) []*rule {
+	var result []*rule
+	for _, p := range r.patterns {
+		result = append(result, p)
+		result = append(result, collectRules(p)...)
+	}
+	return result
+}
+
+// flatten 展开 include 和分组，visiting 用于检测循环引用
+func (g *Grammar) flatten(patterns []*rule, visiting []*rule) ([]*rule, error) {
+	var result []*rule
+	for _, p := range patterns {
+		if p.match != nil || p.begin != nil {
+			result = append(result, p)
+			continue
+		}
+
+		target := p
+		switch {
+		case p.include == "$self" || p.include == "$base":
+			target = g.root
+		case strings.HasPrefix(p.include, "#"):
+			r, ok := g.repository[p.include[1:]]
+			if !ok {
+				return nil, fmt.Errorf("grammar: unknown include %q", p.include)
+			}
+			target = r
+		case p.include != "":
+			// 引用其它语法
+			continue
+		}
+		if target.match != nil || target.begin != nil {
+			result = append(result, target)
+			continue
+		}
+
+		cycle := false
+		for _, v := range visiting {
+			cycle = cycle || v == target
+		}
+		if cycle {
+			continue
+		}
+		children := target.patterns
+		if target != p && target.include != "" {
+			// 仓库中的规则本身也是 include
+			children = []*rule{target}
+		}
+		flat, err := g.flatten(children, append(visiting, target))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, flat...)
+	}
+	return result, nil
+}
+
+// resolveEnd 返回 begin/end 规则的结束模式，用 begin 的捕获组替换反向引用
+func (g *Grammar) resolveEnd(r *rule, line string, captures []int) *pattern {
+	if r.end != nil || r.endSource == "" {
+		return r.end
+	}
+
+	var sb strings.Builder
+	src := r.endSource
+	for i := 0; i < len(src); i++ {
+		if src[i] != '\\' || i+1 >= len(src) {
+			sb.WriteByte(src[i])
+			continue
+		}
+		c := src[i+1]
+		i++
+		if n := int(c - '0'); c >= '1' && c <= '9' {
+			if 2*n+1 < len(captures) && captures[2*n] >= 0 {
+				sb.WriteString(regexp.QuoteMeta(line[captures[2*n]:captures[2*n+1]]))
+			}
+			continue
+		}
+		sb.WriteByte('\\')
+		sb.WriteByte(c)
+	}
+
+	source := sb.String()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if p, ok := g.endCache[source]; ok {
+		return p
+	}
+	p, err := compilePattern(source)
+	if err != nil {
+		// 替换后无法编译时这个范围不会结束
+		p = nil
+	}
+	if len(g.endCache) >= endCacheSize {
+		clear(g.endCache)
+	}
+	g.endCache[source] = p
+	return p
+}
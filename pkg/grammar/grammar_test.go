@@ -0,0 +1,218 @@
+package grammar
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+	"github.com/kebaren/textbuffer/pkg/buffer/buffertest"
+	"github.com/kebaren/textbuffer/pkg/tokenization"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func load(t *testing.T, name string) *Grammar {
+	t.Helper()
+	g, err := LoadFile(filepath.Join("testdata", name+".tmLanguage.json"))
+	require.NoError(t, err)
+	return g
+}
+
+// render describes each token as "text=innermost scope", skipping tokens that only carry the root scope.
+func render(line string, tokens []tokenization.Token) []string {
+	var out []string
+	for _, tok := range tokens {
+		if len(tok.Scopes) == 1 {
+			continue
+		}
+		out = append(out, fmt.Sprintf("%s=%s", line[tok.StartIndex:tok.EndIndex], tok.Scopes[len(tok.Scopes)-1]))
+	}
+	return out
+}
+
+// tokenizeLines tokenizes the lines in order, carrying the state over.
+func tokenizeLines(g *Grammar, text string) [][]string {
+	var result [][]string
+	state := g.InitialState()
+	for _, line := range strings.Split(text, "\n") {
+		var tokens []tokenization.Token
+		tokens, state = g.Tokenize(line, state.Clone())
+		result = append(result, render(line, tokens))
+	}
+	return result
+}
+
+func TestTokensCoverLine(t *testing.T) {
+	g := load(t, "go")
+	line := `	x := fmt.Sprintf("%d\n", 0x1F) // done`
+	tokens, _ := g.Tokenize(line, g.InitialState())
+	require.NotEmpty(t, tokens)
+	assert.Equal(t, 0, tokens[0].StartIndex)
+	assert.Equal(t, len(line), tokens[len(tokens)-1].EndIndex)
+	for i := 1; i < len(tokens); i++ {
+		assert.Equal(t, tokens[i-1].EndIndex, tokens[i].StartIndex)
+		assert.NotEqual(t, tokens[i-1].Scopes, tokens[i].Scopes)
+	}
+	assert.Equal(t, []string{"source.go", "string.quoted.double.go", "constant.other.placeholder.go"}, tokens[7].Scopes)
+}
+
+func TestGoGrammar(t *testing.T) {
+	g := load(t, "go")
+	assert.Equal(t, "source.go", g.ScopeName)
+	assert.Equal(t, []string{"go"}, g.FileTypes)
+
+	lines := tokenizeLines(g, "package main\n"+
+		"func (s *T) Run(n int) error {\n"+
+		"\tif n > 0 { return nil } /* multi\n"+
+		"line */ s := `raw\n"+
+		"string` + \"a\\tb\"")
+	assert.Equal(t, []string{"package=keyword.package.go", "main=entity.name.type.package.go"}, lines[0])
+	assert.Equal(t, []string{
+		"func=storage.type.function.go", "(=punctuation.definition.parameters.begin.go",
+		")=punctuation.definition.parameters.end.go", "Run=entity.name.function.go", "int=storage.type.builtin.go",
+		"error=storage.type.builtin.go",
+	}, lines[1])
+	assert.Equal(t, []string{
+		"if=keyword.control.go", ">=keyword.operator.go", "0=constant.numeric.go",
+		"return=keyword.control.go", "nil=constant.language.go",
+		"/*=punctuation.definition.comment.go", " multi=comment.block.go",
+	}, lines[2])
+	assert.Equal(t, []string{
+		"line =comment.block.go", "*/=punctuation.definition.comment.go",
+		":==keyword.operator.assignment.go", "`=punctuation.definition.string.begin.go", "raw=string.quoted.raw.go",
+	}, lines[3])
+	assert.Equal(t, []string{
+		"string=string.quoted.raw.go", "`=punctuation.definition.string.end.go", "+=keyword.operator.go",
+		"\"=punctuation.definition.string.begin.go", "a=string.quoted.double.go", `\t=constant.character.escape.go`,
+		"b=string.quoted.double.go", "\"=punctuation.definition.string.end.go",
+	}, lines[4])
+}
+
+func TestJSONGrammar(t *testing.T) {
+	g := load(t, "json")
+	lines := tokenizeLines(g, "{\n  \"name\": \"x\\u0041\",\n  \"list\": [1, -2.5e3, true, null]\n}")
+	assert.Equal(t, []string{"{=punctuation.definition.dictionary.begin.json"}, lines[0])
+	assert.Equal(t, []string{
+		"  =meta.structure.dictionary.json",
+		"\"=punctuation.support.type.property-name.begin.json", "name=support.type.property-name.json",
+		"\"=punctuation.support.type.property-name.end.json", ":=punctuation.separator.dictionary.key-value.json",
+		" =meta.structure.dictionary.json",
+		"\"=punctuation.definition.string.begin.json", "x=string.quoted.double.json",
+		`\u0041=constant.character.escape.json`, "\"=punctuation.definition.string.end.json",
+		",=punctuation.separator.dictionary.pair.json",
+	}, lines[1])
+	assert.Contains(t, lines[2], "-2.5e3=constant.numeric.json")
+	assert.Contains(t, lines[2], "null=constant.language.json")
+	assert.Contains(t, lines[2], "]=punctuation.definition.array.end.json")
+	assert.Equal(t, []string{"}=punctuation.definition.dictionary.end.json"}, lines[3])
+}
+
+func TestMarkdownGrammar(t *testing.T) {
+	g := load(t, "markdown")
+	lines := tokenizeLines(g, "# Title\n"+
+		"Some **bold** and `code` with [a link](http://x.y).\n"+
+		"- item *one*\n"+
+		"````go\n"+
+		"```\n"+
+		"# not a heading\n"+
+		"````\n"+
+		"---")
+	assert.Equal(t, []string{"#=punctuation.definition.heading.markdown", " =markup.heading.markdown", "Title=entity.name.section.markdown"}, lines[0])
+	assert.Equal(t, []string{
+		"**=punctuation.definition.bold.markdown", "bold=markup.bold.markdown", "**=punctuation.definition.bold.markdown",
+		"`=punctuation.definition.raw.markdown", "code=markup.inline.raw.string.markdown", "`=punctuation.definition.raw.markdown",
+		"[=punctuation.definition.link.title.begin.markdown", "a link=string.other.link.title.markdown",
+		"]=punctuation.definition.link.title.end.markdown", "(=punctuation.definition.metadata.markdown",
+		"http://x.y=markup.underline.link.markdown", ")=punctuation.definition.metadata.markdown",
+	}, lines[1])
+	assert.Equal(t, []string{
+		"-=punctuation.definition.list.begin.markdown", " item =markup.list.markdown",
+		"*=punctuation.definition.italic.markdown", "one=markup.italic.markdown", "*=punctuation.definition.italic.markdown",
+	}, lines[2])
+	// The closing fence must match the opening one, so the shorter fence and the heading are code.
+	assert.Equal(t, []string{"````=punctuation.definition.markdown", "go=fenced_code.block.language.markdown"}, lines[3])
+	assert.Equal(t, []string{"```=markup.raw.block.markdown"}, lines[4])
+	assert.Equal(t, []string{"# not a heading=markup.raw.block.markdown"}, lines[5])
+	assert.Equal(t, []string{"````=punctuation.definition.markdown"}, lines[6])
+	assert.Equal(t, []string{"---=meta.separator.markdown"}, lines[7])
+}
+
+func TestStateEquality(t *testing.T) {
+	g := load(t, "markdown")
+	_, a := g.Tokenize("```", g.InitialState())
+	_, b := g.Tokenize("```js", g.InitialState())
+	_, c := g.Tokenize("~~~", g.InitialState())
+	assert.True(t, a.Equals(b))
+	assert.False(t, a.Equals(c))
+	assert.False(t, a.Equals(g.InitialState()))
+	assert.True(t, a.Equals(a.Clone()))
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := Parse([]byte(`{"patterns": []}`))
+	assert.EqualError(t, err, "grammar: missing scopeName")
+	_, err = Parse([]byte(`{"scopeName": "x", "patterns": [{"include": "#missing"}]}`))
+	assert.EqualError(t, err, `grammar: unknown include "#missing"`)
+	_, err = Parse([]byte(`{"scopeName": "x", "patterns": [{"match": "(?=a)"}]}`))
+	assert.Error(t, err)
+
+	// Recursive includes and empty matches do not loop forever.
+	g, err := Parse([]byte(`{"scopeName": "x", "patterns": [{"include": "#a"}, {"match": "", "name": "empty"}],
+		"repository": {"a": {"patterns": [{"include": "#b"}]}, "b": {"include": "#a"}}}`))
+	require.NoError(t, err)
+	tokens, _ := g.Tokenize("abc", g.InitialState())
+	assert.Equal(t, []tokenization.Token{{StartIndex: 0, EndIndex: 3, Scopes: []string{"x"}}}, tokens)
+
+	// Mutually recursive rules whose begin patterns match empty stop once they cycle.
+	g, err = Parse([]byte(`{"scopeName": "x", "patterns": [{"include": "#a"}],
+		"repository": {
+			"a": {"begin": "q*", "end": "z", "name": "a", "patterns": [{"include": "#b"}]},
+			"b": {"begin": "q*", "end": "z", "name": "b", "patterns": [{"include": "#a"}]}}}`))
+	require.NoError(t, err)
+	tokens, st := g.Tokenize("x", g.InitialState())
+	assert.Equal(t, []tokenization.Token{{StartIndex: 0, EndIndex: 1, Scopes: []string{"x", "a", "b"}}}, tokens)
+	tokens, _ = g.Tokenize("qqx", st)
+	assert.Equal(t, 3, tokens[len(tokens)-1].EndIndex)
+}
+
+func TestPatternsSeeTextBeforePosition(t *testing.T) {
+	g, err := Parse([]byte(`{"scopeName": "x", "patterns": [
+		{"match": "x", "name": "ident"},
+		{"match": "\\bif\\b", "name": "keyword"},
+		{"match": "^#", "name": "hash"},
+		{"begin": "<a", "end": "\\B>", "name": "tag"}]}`))
+	require.NoError(t, err)
+	tokens, _ := g.Tokenize("xif", g.InitialState())
+	assert.Equal(t, []string{"x=ident"}, render("xif", tokens))
+	tokens, _ = g.Tokenize("x if", g.InitialState())
+	assert.Equal(t, []string{"x=ident", "if=keyword"}, render("x if", tokens))
+	tokens, _ = g.Tokenize("x#", g.InitialState())
+	assert.Equal(t, []string{"x=ident"}, render("x#", tokens))
+	tokens, _ = g.Tokenize("<a>b >", g.InitialState())
+	assert.Equal(t, []string{"<a>b >=tag"}, render("<a>b >", tokens))
+}
+
+func TestEndCacheIsBounded(t *testing.T) {
+	g, err := Parse([]byte(`{"scopeName": "x", "patterns": [{"begin": "<(\\w+)>", "end": "</\\1>", "name": "tag"}]}`))
+	require.NoError(t, err)
+	for i := 0; i < 3*endCacheSize; i++ {
+		line := fmt.Sprintf("<t%d>a</t%d>", i, i)
+		tokens, _ := g.Tokenize(line, g.InitialState())
+		assert.Equal(t, []string{line + "=tag"}, render(line, tokens))
+	}
+	assert.LessOrEqual(t, len(g.endCache), endCacheSize)
+}
+
+func TestGrammarWithStore(t *testing.T) {
+	g := load(t, "go")
+	tree := buffertest.NewTree("a := 1\nb := 2\nc := 3\n")
+	store := tokenization.NewStore(tree, g)
+
+	assert.Equal(t, []string{":==keyword.operator.assignment.go", "3=constant.numeric.go"}, render("c := 3", store.GetLineTokens(3)))
+	tree.ApplyEdit(buffer.NewEditOperation(0, 0, "/*"))
+	assert.Equal(t, []string{"c := 3=comment.block.go"}, render("c := 3", store.GetLineTokens(3)))
+	tree.ApplyEdit(buffer.NewEditOperation(tree.GetOffsetAt(2, 1), 0, "*/"))
+	assert.Equal(t, []string{":==keyword.operator.assignment.go", "3=constant.numeric.go"}, render("c := 3", store.GetLineTokens(3)))
+}
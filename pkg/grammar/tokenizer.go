@@ -0,0 +1,210 @@
+package grammar
+
+import (
+	"slices"
+
+	"github.com/kebaren/textbuffer/pkg/tokenization"
+)
+
+// frame 规则栈中的一层，创建之后不再修改，可以在多个状态之间共享
+type frame struct {
+	rule *rule
+	// end 结束模式，根和没有结束模式的规则为 nil
+	end *pattern
+	// nameScopes begin 和 end 所在的作用域，contentScopes 两者之间的内容所在的作用域
+	nameScopes    []string
+	contentScopes []string
+}
+
+// state 行首的规则栈
+type state struct {
+	frames []*frame
+}
+
+// Clone 返回状态的副本
+func (s *state) Clone() tokenization.State {
+	return &state{frames: slices.Clone(s.frames)}
+}
+
+// Equals 判断两个规则栈是否相同
+func (s *state) Equals(other tokenization.State) bool {
+	o, ok := other.(*state)
+	if !ok || len(o.frames) != len(s.frames) {
+		return false
+	}
+	for i, f := range s.frames {
+		g := o.frames[i]
+		if f == g {
+			continue
+		}
+		if f.rule != g.rule || (f.end == nil) != (g.end == nil) || (f.end != nil && f.end.String() != g.end.String()) ||
+			!slices.Equal(f.contentScopes, g.contentScopes) {
+			return false
+		}
+	}
+	return true
+}
+
+// appendScope 返回追加了作用域 name 的新作用域列表，name 为空时返回原列表
+func appendScope(scopes []string, name string) []string {
+	if name == "" {
+		return scopes
+	}
+	return append(slices.Clip(scopes), name)
+}
+
+// InitialState 返回第一行开始时的状态
+func (g *Grammar) InitialState() tokenization.State {
+	scopes := []string{g.ScopeName}
+	return &state{frames: []*frame{{rule: g.root, nameScopes: scopes, contentScopes: scopes}}}
+}
+
+// lineTokens 生成一行的标记，相邻的作用域相同的标记被合并
+type lineTokens struct {
+	tokens []tokenization.Token
+	last   int
+}
+
+// produce 生成从上一个标记结束到 end 的标记
+func (lt *lineTokens) produce(end int, scopes []string) {
+	if end <= lt.last {
+		return
+	}
+	if n := len(lt.tokens); n > 0 && slices.Equal(lt.tokens[n-1].Scopes, scopes) {
+		lt.tokens[n-1].EndIndex = end
+	} else {
+		lt.tokens = append(lt.tokens, tokenization.Token{StartIndex: lt.last, EndIndex: end, Scopes: scopes})
+	}
+	lt.last = end
+}
+
+// produceCaptures 生成匹配范围内的标记，捕获组按名称追加作用域，嵌套的捕获组追加在外层之后
+func (lt *lineTokens) produceCaptures(match []int, captures map[int]string, scopes []string) {
+	type capture struct {
+		end    int
+		scopes []string
+	}
+	stack := []capture{{end: match[1], scopes: scopes}}
+	for i := 0; 2*i+1 < len(match); i++ {
+		name, ok := captures[i]
+		start, end := match[2*i], match[2*i+1]
+		if !ok || start < 0 || start == end {
+			continue
+		}
+		for len(stack) > 1 && stack[len(stack)-1].end <= start {
+			top := stack[len(stack)-1]
+			lt.produce(top.end, top.scopes)
+			stack = stack[:len(stack)-1]
+		}
+		top := stack[len(stack)-1]
+		lt.produce(start, top.scopes)
+		stack = append(stack, capture{end: end, scopes: appendScope(top.scopes, name)})
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		lt.produce(stack[i].end, stack[i].scopes)
+	}
+}
+
+// Tokenize 从状态 st 开始对一行分词，返回覆盖整行的标记和行末的状态
+// 每次在当前位置之后查找最早的匹配，位置相同时结束模式优先，其次按规则的顺序；
+// 模式总是在整行上匹配，\b 和 ^ 能看到当前位置之前的字符
+func (g *Grammar) Tokenize(line string, st tokenization.State) ([]tokenization.Token, tokenization.State) {
+	s, ok := st.(*state)
+	if !ok || len(s.frames) == 0 {
+		s = g.InitialState().(*state)
+	}
+	frames := slices.Clone(s.frames)
+	// pushedAt 本行中进入的规则在行内的位置，用于检测不前进的死循环
+	pushedAt := make(map[*frame]int)
+	// emptyPushes 在位置 emptyPos 以空匹配进入的规则，互相包含的规则可能在同一位置循环进入
+	emptyPushes, emptyPos := make(map[*rule]bool), -1
+	lt := &lineTokens{}
+
+	for pos := 0; ; {
+		top := frames[len(frames)-1]
+
+		var best []int
+		var bestRule *rule
+		isEnd := false
+		if top.end != nil && !(top.rule.endAnchored && pos > 0) {
+			if m := top.end.findFrom(line, pos); m != nil {
+				best, isEnd = m, true
+			}
+		}
+		for _, r := range top.rule.flat {
+			if r.anchored && pos > 0 {
+				continue
+			}
+			p := r.match
+			if p == nil {
+				p = r.begin
+			}
+			if m := p.findFrom(line, pos); m != nil && (best == nil || m[0] < best[0]) {
+				best, bestRule, isEnd = m, r, false
+			}
+		}
+
+		if best == nil {
+			lt.produce(len(line), top.contentScopes)
+			break
+		}
+		start, end := best[0], best[1]
+		lt.produce(start, top.contentScopes)
+
+		switch {
+		case isEnd:
+			captures := top.rule.endCaptures
+			if captures == nil {
+				captures = top.rule.captures
+			}
+			lt.produceCaptures(best, captures, top.nameScopes)
+			frames = frames[:len(frames)-1]
+			if at, ok := pushedAt[top]; ok && at == end {
+				// 进入之后没有前进就结束，继续会陷入死循环
+				lt.produce(len(line), frames[len(frames)-1].contentScopes)
+				return lt.tokens, &state{frames: frames}
+			}
+		case bestRule.match != nil:
+			lt.produceCaptures(best, bestRule.captures, appendScope(top.contentScopes, bestRule.name))
+			if end == start {
+				// 空匹配不会前进
+				lt.produce(len(line), top.contentScopes)
+				return lt.tokens, &state{frames: frames}
+			}
+		default:
+			if start == end {
+				if emptyPos != start {
+					clear(emptyPushes)
+					emptyPos = start
+				}
+				if top.rule == bestRule || emptyPushes[bestRule] {
+					// 没有前进就再次进入同一个规则，继续会陷入死循环
+					lt.produce(len(line), top.contentScopes)
+					return lt.tokens, &state{frames: frames}
+				}
+				emptyPushes[bestRule] = true
+			}
+			nameScopes := appendScope(top.contentScopes, bestRule.name)
+			captures := bestRule.beginCaptures
+			if captures == nil {
+				captures = bestRule.captures
+			}
+			lt.produceCaptures(best, captures, nameScopes)
+			f := &frame{
+				rule:          bestRule,
+				end:           g.resolveEnd(bestRule, line, best),
+				nameScopes:    nameScopes,
+				contentScopes: appendScope(nameScopes, bestRule.contentName),
+			}
+			frames = append(frames, f)
+			pushedAt[f] = end
+		}
+
+		if start == end && end == len(line) {
+			// 行尾的空匹配之后不会再有新的匹配
+			break
+		}
+		pos = end
+	}
+	return lt.tokens, &state{frames: frames}
+}
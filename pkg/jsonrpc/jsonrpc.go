@@ -0,0 +1,130 @@
+package jsonrpc
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Version JSON-RPC 协议版本
+const Version = "2.0"
+
+// 标准错误码
+const (
+	// CodeParseError 解析错误
+	CodeParseError = -32700
+	// CodeInvalidRequest 无效请求
+	CodeInvalidRequest = -32600
+	// CodeMethodNotFound 方法不存在
+	CodeMethodNotFound = -32601
+	// CodeInvalidParams 无效参数
+	CodeInvalidParams = -32602
+	// CodeInternalError 内部错误
+	CodeInternalError = -32603
+)
+
+// Error JSON-RPC 错误对象
+type Error struct {
+	// Code 错误码
+	Code int `json:"code"`
+	// Message 错误信息
+	Message string `json:"message"`
+	// Data 附加数据
+	Data interface{} `json:"data,omitempty"`
+}
+
+// NewError 创建一个新的错误
+func NewError(code int, format string, args ...interface{}) *Error {
+	return &Error{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// Error 实现 error 接口
+func (e *Error) Error() string {
+	return fmt.Sprintf("jsonrpc: %s (code %d)", e.Message, e.Code)
+}
+
+// Message JSON-RPC 消息，可以是请求、通知或响应
+type Message struct {
+	// JSONRPC 协议版本
+	JSONRPC string `json:"jsonrpc"`
+	// ID 请求标识，通知没有标识
+	ID *json.RawMessage `json:"id,omitempty"`
+	// Method 方法名
+	Method string `json:"method,omitempty"`
+	// Params 参数
+	Params json.RawMessage `json:"params,omitempty"`
+	// Result 结果
+	Result json.RawMessage `json:"result,omitempty"`
+	// Error 错误
+	Error *Error `json:"error,omitempty"`
+}
+
+// IsRequest 判断消息是否为请求
+func (m *Message) IsRequest() bool {
+	return m.Method != "" && m.ID != nil
+}
+
+// IsNotification 判断消息是否为通知
+func (m *Message) IsNotification() bool {
+	return m.Method != "" && m.ID == nil
+}
+
+// IsResponse 判断消息是否为响应
+func (m *Message) IsResponse() bool {
+	return m.Method == "" && m.ID != nil
+}
+
+// NewNotification 创建一个新的通知
+func NewNotification(method string, params interface{}) (*Message, error) {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{
+		JSONRPC: Version,
+		Method:  method,
+		Params:  raw,
+	}, nil
+}
+
+// NewResponse 创建对请求的响应，err 不为 nil 时返回错误响应
+func NewResponse(id *json.RawMessage, result interface{}, err error) *Message {
+	msg := &Message{
+		JSONRPC: Version,
+		ID:      id,
+	}
+	if msg.ID == nil {
+		null := json.RawMessage("null")
+		msg.ID = &null
+	}
+
+	if err != nil {
+		rpcErr, ok := err.(*Error)
+		if !ok {
+			rpcErr = NewError(CodeInternalError, "%s", err.Error())
+		}
+		msg.Error = rpcErr
+		return msg
+	}
+
+	raw, marshalErr := json.Marshal(result)
+	if marshalErr != nil {
+		msg.Error = NewError(CodeInternalError, "%s", marshalErr.Error())
+		return msg
+	}
+	msg.Result = raw
+	return msg
+}
+
+// UnmarshalParams 解析参数，失败时返回 CodeInvalidParams 错误
+func UnmarshalParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return NewError(CodeInvalidParams, "missing params")
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return NewError(CodeInvalidParams, "%s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MaxMessageSize 单条消息的最大长度（字节），超过时 Read 返回 ErrMessageTooLarge
+const MaxMessageSize = 64 << 20
+
+// ErrMessageTooLarge 消息超过了最大长度，之后流不再可用
+var ErrMessageTooLarge = errors.New("jsonrpc: message too large")
+
+// Stream 消息流
+type Stream interface {
+	// Read 读取下一条消息
+	Read() (*Message, error)
+	// Write 写入一条消息，可以被多个 goroutine 并发调用
+	Write(msg *Message) error
+}
+
+// headerStream 使用 Content-Length 头分帧的消息流（LSP 使用的格式）
+type headerStream struct {
+	reader *bufio.Reader
+	writer io.Writer
+	mu     sync.Mutex
+	// maxSize 单条消息的最大长度
+	maxSize int
+}
+
+// NewHeaderStream 创建一个使用 Content-Length 头分帧的消息流
+func NewHeaderStream(r io.Reader, w io.Writer) Stream {
+	return &headerStream{
+		reader:  bufio.NewReader(r),
+		writer:  w,
+		maxSize: MaxMessageSize,
+	}
+}
+
+// Read 读取下一条消息
+func (s *headerStream) Read() (*Message, error) {
+	header, err := textproto.NewReader(s.reader).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF && len(header) == 0 {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+
+	length, err := strconv.Atoi(strings.TrimSpace(header.Get("Content-Length")))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("jsonrpc: invalid Content-Length header %q", header.Get("Content-Length"))
+	}
+	if length > s.maxSize {
+		return nil, fmt.Errorf("%w: Content-Length %d exceeds %d", ErrMessageTooLarge, length, s.maxSize)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.reader, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return decodeMessage(body)
+}
+
+// Write 写入一条消息
+func (s *headerStream) Write(msg *Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := fmt.Fprintf(s.writer, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = s.writer.Write(body)
+	return err
+}
+
+// lineStream 每行一条 JSON 消息的消息流
+type lineStream struct {
+	reader *bufio.Reader
+	writer io.Writer
+	mu     sync.Mutex
+	// maxSize 单条消息的最大长度
+	maxSize int
+}
+
+// NewLineStream 创建一个每行一条 JSON 消息的消息流，便于非 Go 客户端接入
+func NewLineStream(r io.Reader, w io.Writer) Stream {
+	return &lineStream{
+		reader:  bufio.NewReader(r),
+		writer:  w,
+		maxSize: MaxMessageSize,
+	}
+}
+
+// Read 读取下一条消息，跳过空行
+func (s *lineStream) Read() (*Message, error) {
+	for {
+		line, err := s.readLine()
+		if len(strings.TrimSpace(string(line))) > 0 {
+			return decodeMessage(line)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readLine 读取一行，行的长度（不包括换行符）超过 maxSize 时返回 ErrMessageTooLarge
+func (s *lineStream) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := s.reader.ReadSlice('\n')
+		if len(line)+len(chunk) > s.maxSize+1 {
+			return nil, ErrMessageTooLarge
+		}
+		line = append(line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+// Write 写入一条消息
+func (s *lineStream) Write(msg *Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.writer.Write(append(body, '\n'))
+	return err
+}
+
+// decodeMessage 解析消息，格式错误时返回 *Error
+func decodeMessage(data []byte) (*Message, error) {
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, NewError(CodeParseError, "%s", err.Error())
+	}
+	if msg.JSONRPC != Version {
+		return nil, NewError(CodeInvalidRequest, "unsupported jsonrpc version %q", msg.JSONRPC)
+	}
+	return &msg, nil
+}
@@ -0,0 +1,101 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func headerInput(input string, maxSize int) Stream {
+	return &headerStream{reader: bufio.NewReader(strings.NewReader(input)), writer: io.Discard, maxSize: maxSize}
+}
+
+func lineInput(input string, maxSize int) Stream {
+	return &lineStream{reader: bufio.NewReader(strings.NewReader(input)), writer: io.Discard, maxSize: maxSize}
+}
+
+func TestStreamsRoundTrip(t *testing.T) {
+	id := json.RawMessage(`1`)
+	msg := &Message{JSONRPC: Version, ID: &id, Method: "ping", Params: json.RawMessage(`{"a":1}`)}
+	for name, newStream := range map[string]func(io.Reader, io.Writer) Stream{
+		"header": NewHeaderStream,
+		"line":   NewLineStream,
+	} {
+		var buf bytes.Buffer
+		require.NoError(t, newStream(nil, &buf).Write(msg), name)
+		require.NoError(t, newStream(nil, &buf).Write(msg), name)
+
+		s := newStream(&buf, io.Discard)
+		for i := 0; i < 2; i++ {
+			got, err := s.Read()
+			require.NoError(t, err, name)
+			assert.Equal(t, msg, got, name)
+		}
+		_, err := s.Read()
+		assert.Equal(t, io.EOF, err, name)
+	}
+}
+
+func TestHeaderStreamFraming(t *testing.T) {
+	body := `{"jsonrpc":"2.0","method":"x"}`
+
+	// Truncated body.
+	_, err := headerInput("Content-Length: 40\r\n\r\n"+body, 100).Read()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Missing Content-Length header.
+	_, err = headerInput("Content-Type: application/json\r\n\r\n"+body, 100).Read()
+	assert.EqualError(t, err, `jsonrpc: invalid Content-Length header ""`)
+	_, err = headerInput("Content-Length: -1\r\n\r\n", 100).Read()
+	assert.Error(t, err)
+
+	// Oversized length is rejected before allocating the body.
+	_, err = headerInput("Content-Length: 9999999999\r\n\r\n", MaxMessageSize).Read()
+	assert.ErrorIs(t, err, ErrMessageTooLarge)
+	_, err = headerInput("Content-Length: 31\r\n\r\n"+body, 30).Read()
+	assert.ErrorIs(t, err, ErrMessageTooLarge)
+	msg, err := headerInput("Content-Length: 30\r\n\r\n"+body, 30).Read()
+	require.NoError(t, err)
+	assert.Equal(t, "x", msg.Method)
+
+	// A header that is cut off is an error, not a clean end of stream.
+	_, err = headerInput("Content-Len", 100).Read()
+	assert.Error(t, err)
+	assert.NotEqual(t, io.EOF, err)
+}
+
+func TestLineStreamFraming(t *testing.T) {
+	body := `{"jsonrpc":"2.0","method":"x"}`
+
+	// Truncated body: the last line ends without a newline in the middle of the JSON.
+	_, err := lineInput(body[:20], 100).Read()
+	var rpcErr *Error
+	require.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, CodeParseError, rpcErr.Code)
+
+	// Blank lines are skipped and a message without a trailing newline is still read.
+	msg, err := lineInput("\n\r\n"+body, 100).Read()
+	require.NoError(t, err)
+	assert.Equal(t, "x", msg.Method)
+
+	// A message without the jsonrpc version is rejected.
+	_, err = lineInput(`{"method":"x"}`+"\n", 100).Read()
+	require.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, CodeInvalidRequest, rpcErr.Code)
+
+	// Oversized lines are rejected without buffering the rest of the line.
+	_, err = lineInput(body+"\n", len(body)-1).Read()
+	assert.ErrorIs(t, err, ErrMessageTooLarge)
+	_, err = lineInput(strings.Repeat(" ", 10000)+body+"\n", 5000).Read()
+	assert.ErrorIs(t, err, ErrMessageTooLarge)
+	msg, err = lineInput(body+"\r\n", len(body)+1).Read()
+	require.NoError(t, err)
+	assert.Equal(t, "x", msg.Method)
+}
@@ -0,0 +1,104 @@
+package lsp
+
+import (
+	"unicode/utf8"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+)
+
+// Document 服务器维护的文档
+type Document struct {
+	// URI 文档标识
+	URI string
+	// LanguageID 语言标识
+	LanguageID string
+	// Version 最近一次变更后的版本
+	Version int
+	// SavedVersion 最近一次保存时的版本
+	SavedVersion int
+	// Tree 文档内容
+	Tree *buffer.PieceTreeBase
+}
+
+// NewDocument 创建一个新的文档
+func NewDocument(item TextDocumentItem) *Document {
+	return &Document{
+		URI:          item.URI,
+		LanguageID:   item.LanguageID,
+		Version:      item.Version,
+		SavedVersion: item.Version,
+		Tree:         buffer.NewPieceTree(item.Text),
+	}
+}
+
+// Text 返回文档内容
+func (d *Document) Text() string {
+	return d.Tree.GetLinesRawContent()
+}
+
+// IsDirty 判断文档在上次保存后是否被修改
+func (d *Document) IsDirty() bool {
+	return d.Version != d.SavedVersion
+}
+
+// ApplyChange 应用一次内容变更
+func (d *Document) ApplyChange(change TextDocumentContentChangeEvent) {
+	if change.Range == nil {
+		d.Tree = buffer.NewPieceTree(change.Text)
+		return
+	}
+
+	start := d.OffsetAt(change.Range.Start)
+	end := d.OffsetAt(change.Range.End)
+	if end < start {
+		start, end = end, start
+	}
+	d.Tree.ApplyEdit(buffer.NewEditOperation(start, end-start, change.Text))
+}
+
+// OffsetAt 把 LSP 位置转换为字节偏移量
+// 超出行尾的列会被限制在行尾，超出文档的行会被限制在文档末尾
+func (d *Document) OffsetAt(pos Position) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= d.Tree.GetLineCount() {
+		return d.Tree.GetLength()
+	}
+
+	lineNumber := pos.Line + 1
+	line := d.Tree.GetLineContent(lineNumber)
+	column := 0
+	units := 0
+	for column < len(line) && units < pos.Character {
+		r, size := utf8.DecodeRuneInString(line[column:])
+		if r >= 0x10000 {
+			units += 2
+		} else {
+			units++
+		}
+		column += size
+	}
+
+	return d.Tree.GetOffsetAt(lineNumber, column+1)
+}
+
+// PositionAt 把字节偏移量转换为 LSP 位置
+func (d *Document) PositionAt(offset int) Position {
+	pos := d.Tree.GetPositionAt(offset)
+	line := d.Tree.GetLineContent(pos.LineNumber)
+	column := pos.Column - 1
+	if column > len(line) {
+		column = len(line)
+	}
+
+	units := 0
+	for _, r := range line[:column] {
+		if r >= 0x10000 {
+			units += 2
+		} else {
+			units++
+		}
+	}
+	return Position{Line: pos.LineNumber - 1, Character: units}
+}
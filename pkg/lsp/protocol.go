@@ -0,0 +1,139 @@
+package lsp
+
+// TextDocumentSyncKind 文档同步方式
+type TextDocumentSyncKind int
+
+const (
+	// SyncNone 不同步
+	SyncNone TextDocumentSyncKind = 0
+	// SyncFull 每次发送完整内容
+	SyncFull TextDocumentSyncKind = 1
+	// SyncIncremental 发送增量修改
+	SyncIncremental TextDocumentSyncKind = 2
+)
+
+// Position 文档中的位置，Line 和 Character 都从 0 开始，Character 以 UTF-16 码元计数
+type Position struct {
+	// Line 行号
+	Line int `json:"line"`
+	// Character 列号
+	Character int `json:"character"`
+}
+
+// Range 文档中的范围
+type Range struct {
+	// Start 开始位置
+	Start Position `json:"start"`
+	// End 结束位置
+	End Position `json:"end"`
+}
+
+// TextDocumentItem 打开的文档
+type TextDocumentItem struct {
+	// URI 文档标识
+	URI string `json:"uri"`
+	// LanguageID 语言标识
+	LanguageID string `json:"languageId"`
+	// Version 版本
+	Version int `json:"version"`
+	// Text 内容
+	Text string `json:"text"`
+}
+
+// TextDocumentIdentifier 文档标识
+type TextDocumentIdentifier struct {
+	// URI 文档标识
+	URI string `json:"uri"`
+}
+
+// VersionedTextDocumentIdentifier 带版本的文档标识
+type VersionedTextDocumentIdentifier struct {
+	// URI 文档标识
+	URI string `json:"uri"`
+	// Version 版本
+	Version int `json:"version"`
+}
+
+// TextDocumentContentChangeEvent 文档内容变更，Range 为空时 Text 是完整内容
+type TextDocumentContentChangeEvent struct {
+	// Range 被替换的范围
+	Range *Range `json:"range,omitempty"`
+	// RangeLength 被替换范围的长度（已废弃，仅用于兼容）
+	RangeLength *int `json:"rangeLength,omitempty"`
+	// Text 新文本
+	Text string `json:"text"`
+}
+
+// DidOpenTextDocumentParams textDocument/didOpen 参数
+type DidOpenTextDocumentParams struct {
+	// TextDocument 打开的文档
+	TextDocument TextDocumentItem `json:"textDocument"`
+}
+
+// DidChangeTextDocumentParams textDocument/didChange 参数
+type DidChangeTextDocumentParams struct {
+	// TextDocument 修改后的文档版本
+	TextDocument VersionedTextDocumentIdentifier `json:"textDocument"`
+	// ContentChanges 按顺序应用的变更
+	ContentChanges []TextDocumentContentChangeEvent `json:"contentChanges"`
+}
+
+// DidCloseTextDocumentParams textDocument/didClose 参数
+type DidCloseTextDocumentParams struct {
+	// TextDocument 关闭的文档
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+// DidSaveTextDocumentParams textDocument/didSave 参数
+type DidSaveTextDocumentParams struct {
+	// TextDocument 保存的文档
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	// Text 保存时的内容（仅在 includeText 为 true 时提供）
+	Text *string `json:"text,omitempty"`
+}
+
+// SaveOptions 保存选项
+type SaveOptions struct {
+	// IncludeText 保存通知是否包含内容
+	IncludeText bool `json:"includeText"`
+}
+
+// TextDocumentSyncOptions 文档同步选项
+type TextDocumentSyncOptions struct {
+	// OpenClose 是否发送打开和关闭通知
+	OpenClose bool `json:"openClose"`
+	// Change 变更同步方式
+	Change TextDocumentSyncKind `json:"change"`
+	// Save 保存选项
+	Save *SaveOptions `json:"save,omitempty"`
+}
+
+// ServerCapabilities 服务器能力
+type ServerCapabilities struct {
+	// TextDocumentSync 文档同步选项
+	TextDocumentSync TextDocumentSyncOptions `json:"textDocumentSync"`
+}
+
+// ServerInfo 服务器信息
+type ServerInfo struct {
+	// Name 名称
+	Name string `json:"name"`
+	// Version 版本
+	Version string `json:"version,omitempty"`
+}
+
+// InitializeResult initialize 响应
+type InitializeResult struct {
+	// Capabilities 服务器能力
+	Capabilities ServerCapabilities `json:"capabilities"`
+	// ServerInfo 服务器信息
+	ServerInfo *ServerInfo `json:"serverInfo,omitempty"`
+}
+
+// 错误码
+const (
+	// CodeServerNotInitialized 服务器尚未初始化
+	CodeServerNotInitialized = -32002
+	// CodeInvalidRequestAfterShutdown 关闭后收到请求
+	CodeInvalidRequestAfterShutdown = -32600
+)
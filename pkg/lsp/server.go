@@ -0,0 +1,215 @@
+package lsp
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+	"github.com/kebaren/textbuffer/pkg/jsonrpc"
+)
+
+// ServerName 服务器名称
+const ServerName = "textbuffer"
+
+// Server 实现 LSP 文本文档同步的服务器
+// 每个打开的 URI 对应一个片段树，可以作为语言服务器的文档存储
+type Server struct {
+	// mu 保护文档表
+	mu sync.RWMutex
+	// documents 打开的文档
+	documents map[string]*Document
+	// initialized 是否已收到 initialize 请求
+	initialized bool
+	// shutdown 是否已收到 shutdown 请求
+	shutdown bool
+	// exited 是否已收到 exit 通知
+	exited bool
+}
+
+// NewServer 创建一个新的服务器
+func NewServer() *Server {
+	return &Server{
+		documents: make(map[string]*Document),
+	}
+}
+
+// Document 返回打开的文档，不存在时返回 nil
+func (s *Server) Document(uri string) *Document {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.documents[uri]
+}
+
+// URIs 返回所有打开文档的 URI（按字典序）
+func (s *Server) URIs() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	uris := make([]string, 0, len(s.documents))
+	for uri := range s.documents {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	return uris
+}
+
+// ExitCode 返回进程应使用的退出码，按协议在 shutdown 之后退出时为 0
+func (s *Server) ExitCode() int {
+	if s.shutdown {
+		return 0
+	}
+	return 1
+}
+
+// Serve 处理消息直到收到 exit 通知或流结束
+func (s *Server) Serve(stream jsonrpc.Stream) error {
+	for !s.exited {
+		msg, err := stream.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			rpcErr, ok := err.(*jsonrpc.Error)
+			if !ok {
+				return err
+			}
+			// 无法解析的消息没有可用的标识
+			if err := stream.Write(jsonrpc.NewResponse(nil, nil, rpcErr)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if resp := s.Handle(msg); resp != nil {
+			if err := stream.Write(resp); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Handle 处理一条消息，请求返回响应，通知返回 nil
+func (s *Server) Handle(msg *jsonrpc.Message) *jsonrpc.Message {
+	if msg.IsResponse() {
+		return nil
+	}
+
+	result, err := s.dispatch(msg)
+	if msg.IsNotification() {
+		return nil
+	}
+	return jsonrpc.NewResponse(msg.ID, result, err)
+}
+
+// dispatch 根据方法名分发消息
+func (s *Server) dispatch(msg *jsonrpc.Message) (interface{}, error) {
+	switch msg.Method {
+	case "initialize":
+		s.initialized = true
+		return InitializeResult{
+			Capabilities: ServerCapabilities{
+				TextDocumentSync: TextDocumentSyncOptions{
+					OpenClose: true,
+					Change:    SyncIncremental,
+					Save:      &SaveOptions{IncludeText: true},
+				},
+			},
+			ServerInfo: &ServerInfo{Name: ServerName},
+		}, nil
+	case "exit":
+		s.exited = true
+		return nil, nil
+	}
+
+	if !s.initialized {
+		return nil, jsonrpc.NewError(CodeServerNotInitialized, "server not initialized")
+	}
+	if s.shutdown && msg.IsRequest() {
+		return nil, jsonrpc.NewError(CodeInvalidRequestAfterShutdown, "server is shutting down")
+	}
+
+	switch msg.Method {
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		return nil, s.didOpen(msg.Params)
+	case "textDocument/didChange":
+		return nil, s.didChange(msg.Params)
+	case "textDocument/didClose":
+		return nil, s.didClose(msg.Params)
+	case "textDocument/didSave":
+		return nil, s.didSave(msg.Params)
+	}
+	return nil, jsonrpc.NewError(jsonrpc.CodeMethodNotFound, "method %q not found", msg.Method)
+}
+
+// didOpen 处理 textDocument/didOpen
+func (s *Server) didOpen(raw json.RawMessage) error {
+	var params DidOpenTextDocumentParams
+	if err := jsonrpc.UnmarshalParams(raw, &params); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.documents[params.TextDocument.URI] = NewDocument(params.TextDocument)
+	return nil
+}
+
+// didChange 处理 textDocument/didChange
+func (s *Server) didChange(raw json.RawMessage) error {
+	var params DidChangeTextDocumentParams
+	if err := jsonrpc.UnmarshalParams(raw, &params); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	doc, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return jsonrpc.NewError(jsonrpc.CodeInvalidParams, "document %q is not open", params.TextDocument.URI)
+	}
+	for _, change := range params.ContentChanges {
+		doc.ApplyChange(change)
+	}
+	doc.Version = params.TextDocument.Version
+	return nil
+}
+
+// didClose 处理 textDocument/didClose
+func (s *Server) didClose(raw json.RawMessage) error {
+	var params DidCloseTextDocumentParams
+	if err := jsonrpc.UnmarshalParams(raw, &params); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.documents, params.TextDocument.URI)
+	return nil
+}
+
+// didSave 处理 textDocument/didSave
+func (s *Server) didSave(raw json.RawMessage) error {
+	var params DidSaveTextDocumentParams
+	if err := jsonrpc.UnmarshalParams(raw, &params); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	doc, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return jsonrpc.NewError(jsonrpc.CodeInvalidParams, "document %q is not open", params.TextDocument.URI)
+	}
+	if params.Text != nil && *params.Text != doc.Text() {
+		doc.Tree = buffer.NewPieceTree(*params.Text)
+	}
+	doc.SavedVersion = doc.Version
+	return nil
+}
@@ -0,0 +1,104 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/kebaren/textbuffer/pkg/jsonrpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// transcriptEntry is one line of a recorded transcript in testdata.
+type transcriptEntry struct {
+	Send     json.RawMessage `json:"send"`
+	Expect   json.RawMessage `json:"expect"`
+	Document *struct {
+		URI     string `json:"uri"`
+		Version int    `json:"version"`
+		Dirty   bool   `json:"dirty"`
+		Text    string `json:"text"`
+	} `json:"document"`
+}
+
+func TestRecordedTranscripts(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.jsonl"))
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			f, err := os.Open(file)
+			require.NoError(t, err)
+			defer f.Close()
+
+			var input, output bytes.Buffer
+			var entries []transcriptEntry
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				var entry transcriptEntry
+				require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+				if entry.Send != nil {
+					// Frame the recorded message exactly like an LSP client would.
+					input.WriteString("Content-Length: ")
+					input.WriteString(strconv.Itoa(len(entry.Send)))
+					input.WriteString("\r\n\r\n")
+					input.Write(entry.Send)
+				}
+				entries = append(entries, entry)
+			}
+			require.NoError(t, scanner.Err())
+
+			server := NewServer()
+			require.NoError(t, server.Serve(jsonrpc.NewHeaderStream(&input, &output)))
+
+			responses := jsonrpc.NewHeaderStream(&output, io.Discard)
+			expectedURIs := []string{}
+			for _, entry := range entries {
+				switch {
+				case entry.Expect != nil:
+					resp, err := responses.Read()
+					require.NoError(t, err)
+					actual, err := json.Marshal(resp)
+					require.NoError(t, err)
+					assert.JSONEq(t, string(entry.Expect), string(actual))
+				case entry.Document != nil:
+					doc := server.Document(entry.Document.URI)
+					require.NotNil(t, doc, entry.Document.URI)
+					assert.Equal(t, entry.Document.Text, doc.Text())
+					assert.Equal(t, entry.Document.Version, doc.Version)
+					assert.Equal(t, entry.Document.Dirty, doc.IsDirty())
+					expectedURIs = append(expectedURIs, entry.Document.URI)
+				}
+			}
+			_, err = responses.Read()
+			assert.Equal(t, io.EOF, err, "unexpected extra response")
+			if len(expectedURIs) > 0 {
+				assert.Equal(t, expectedURIs, server.URIs())
+			}
+		})
+	}
+}
+
+func TestUTF16PositionConversion(t *testing.T) {
+	doc := NewDocument(TextDocumentItem{URI: "file:///x", Text: "a😀b\r\n世界\n"})
+
+	assert.Equal(t, 0, doc.OffsetAt(Position{Line: 0, Character: 0}))
+	assert.Equal(t, 1, doc.OffsetAt(Position{Line: 0, Character: 1}))
+	assert.Equal(t, 5, doc.OffsetAt(Position{Line: 0, Character: 3}))
+	assert.Equal(t, 6, doc.OffsetAt(Position{Line: 0, Character: 4}))
+	assert.Equal(t, 6, doc.OffsetAt(Position{Line: 0, Character: 99}))
+	assert.Equal(t, 11, doc.OffsetAt(Position{Line: 1, Character: 1}))
+	assert.Equal(t, 15, doc.OffsetAt(Position{Line: 2, Character: 0}))
+	assert.Equal(t, 15, doc.OffsetAt(Position{Line: 9, Character: 0}))
+
+	assert.Equal(t, Position{Line: 0, Character: 3}, doc.PositionAt(5))
+	assert.Equal(t, Position{Line: 1, Character: 1}, doc.PositionAt(11))
+	assert.Equal(t, Position{Line: 2, Character: 0}, doc.PositionAt(15))
+}
@@ -0,0 +1,72 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+)
+
+// 错误类别，TextModel 返回的错误都是包装了其中一个类别的 *Error，可以用 errors.Is 判断
+// 位置和范围的错误类别与 buffer 的相同，两个包返回的错误可以用同一个类别判断
+var (
+	// ErrOutOfRange 偏移量、行号、列号或范围超出文档
+	ErrOutOfRange = buffer.ErrOutOfRange
+	// ErrNotBoundary 偏移量或列号位于多字节 UTF-8 字符或 CRLF 的中间
+	ErrNotBoundary = buffer.ErrNotBoundary
+	// ErrInvalidRange 范围的结束位置在开始位置之前
+	ErrInvalidRange = buffer.ErrInvalidRange
+	// ErrOverlappingEdits 同一批编辑中的范围相互重叠
+	ErrOverlappingEdits = errors.New("overlapping edits")
+	// ErrInvalidUTF8 文本不是合法的 UTF-8
+	ErrInvalidUTF8 = errors.New("invalid UTF-8")
+	// ErrInvalidEOL 换行符不是 "\n" 或 "\r\n"
+	ErrInvalidEOL = errors.New("invalid end of line sequence")
+	// ErrReadOnly 模型是只读的
+	ErrReadOnly = errors.New("read-only")
+	// ErrNothingToUndo 没有可以撤销或重做的编辑
+	ErrNothingToUndo = errors.New("nothing to undo")
+)
+
+// Error TextModel 操作返回的错误
+type Error struct {
+	// Op 失败的操作，例如 "Insert"
+	Op string
+	// Kind 错误类别，例如 ErrOutOfRange
+	Kind error
+	// Detail 错误的详细信息，可以为空
+	Detail string
+}
+
+// newError 创建一个新的错误，detail 按 format 格式化
+func newError(op string, kind error, format string, args ...any) *Error {
+	return &Error{Op: op, Kind: kind, Detail: fmt.Sprintf(format, args...)}
+}
+
+// fromBufferError 把 buffer 的检查返回的错误转换为 *Error，保留其中的详细信息，err 为 nil 时返回 nil
+func fromBufferError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, kind := range []error{ErrOutOfRange, ErrNotBoundary, ErrInvalidRange} {
+		if errors.Is(err, kind) {
+			return &Error{Op: op, Kind: kind, Detail: strings.TrimPrefix(err.Error(), kind.Error()+": ")}
+		}
+	}
+	return &Error{Op: op, Kind: err}
+}
+
+// Error 返回错误信息，与 buffer 共用的错误类别去掉 "buffer: " 前缀
+func (e *Error) Error() string {
+	msg := "model: " + e.Op + ": " + strings.TrimPrefix(e.Kind.Error(), "buffer: ")
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	return msg
+}
+
+// Unwrap 返回错误类别
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
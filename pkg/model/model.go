@@ -0,0 +1,302 @@
+package model
+
+import (
+	"sort"
+	"unicode/utf8"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+	"github.com/kebaren/textbuffer/pkg/common"
+)
+
+// Options 创建模型的选项
+type Options struct {
+	// DefaultEOL 文本中没有换行符时使用的换行符，"\n"（默认）或 "\r\n"
+	DefaultEOL string
+	// NormalizeEOL 是否把文本中的换行符统一为检测到的换行符
+	NormalizeEOL bool
+	// ReadOnly 是否只读
+	ReadOnly bool
+	// UndoLimit 最多保留的撤销批次，小于等于 0 时不限制
+	UndoLimit int
+}
+
+// Edit 基于范围的编辑，用 Text 替换 Range 中的内容
+type Edit struct {
+	Range common.Range
+	Text  string
+}
+
+// TextModel 在片段树之上提供稳定的、返回错误的接口
+// 所有可能失败的操作都返回包装了错误类别（ErrOutOfRange 等）的 *Error，而不是 panic 或者返回空值；
+// 修改操作失败时模型保持不变。TextModel 不是并发安全的
+type TextModel struct {
+	tree    *buffer.PieceTreeBase
+	history *buffer.History
+	// readOnly 是否只读
+	readOnly bool
+	// versionID 每次修改加一
+	versionID int
+}
+
+// NewTextModel 创建一个新的文本模型，text 不是合法的 UTF-8 时返回 ErrInvalidUTF8
+func NewTextModel(text string, opts Options) (*TextModel, error) {
+	if err := checkText("NewTextModel", text); err != nil {
+		return nil, err
+	}
+	defaultEOL := buffer.LF
+	switch opts.DefaultEOL {
+	case "", "\n":
+	case "\r\n":
+		defaultEOL = buffer.CRLF
+	default:
+		return nil, newError("NewTextModel", ErrInvalidEOL, "%q", opts.DefaultEOL)
+	}
+
+	builder := buffer.NewPieceTreeTextBufferBuilder()
+	builder.AcceptChunk(text)
+	tree := builder.Finish(opts.NormalizeEOL).Create(defaultEOL)
+	return &TextModel{
+		tree:     tree,
+		history:  buffer.NewHistory(tree, opts.UndoLimit),
+		readOnly: opts.ReadOnly,
+	}, nil
+}
+
+// Tree 返回底层的片段树，直接修改片段树会绕过模型的检查和撤销栈
+func (m *TextModel) Tree() *buffer.PieceTreeBase {
+	return m.tree
+}
+
+// GetValue 返回全部文本
+func (m *TextModel) GetValue() string {
+	return m.tree.GetLinesRawContent()
+}
+
+// GetLength 返回文本的长度（字节）
+func (m *TextModel) GetLength() int {
+	return m.tree.GetLength()
+}
+
+// GetLineCount 返回行数
+func (m *TextModel) GetLineCount() int {
+	return m.tree.GetLineCount()
+}
+
+// GetEOL 返回换行符
+func (m *TextModel) GetEOL() string {
+	return m.tree.GetEOL()
+}
+
+// GetVersionID 返回版本号，每次修改加一
+func (m *TextModel) GetVersionID() int {
+	return m.versionID
+}
+
+// IsReadOnly 判断模型是否只读
+func (m *TextModel) IsReadOnly() bool {
+	return m.readOnly
+}
+
+// SetReadOnly 设置模型是否只读
+func (m *TextModel) SetReadOnly(readOnly bool) {
+	m.readOnly = readOnly
+}
+
+// checkText 检查文本是否为合法的 UTF-8
+func checkText(op, text string) error {
+	if !utf8.ValidString(text) {
+		return newError(op, ErrInvalidUTF8, "")
+	}
+	return nil
+}
+
+// checkWritable 检查模型是否可以修改
+func (m *TextModel) checkWritable(op string) error {
+	if m.readOnly {
+		return newError(op, ErrReadOnly, "")
+	}
+	return nil
+}
+
+// checkLine 检查行号是否在 [1, 行数] 内
+func (m *TextModel) checkLine(op string, lineNumber int) error {
+	if lineCount := m.tree.GetLineCount(); lineNumber < 1 || lineNumber > lineCount {
+		return newError(op, ErrOutOfRange, "line %d not in [1, %d]", lineNumber, lineCount)
+	}
+	return nil
+}
+
+// offsetAt 检查位置并返回它的偏移量
+func (m *TextModel) offsetAt(op string, pos common.Position) (int, error) {
+	if err := m.tree.CheckPosition(pos.LineNumber, pos.Column); err != nil {
+		return 0, fromBufferError(op, err)
+	}
+	return m.tree.GetOffsetAt(pos.LineNumber, pos.Column), nil
+}
+
+// rangeOffsets 检查范围并返回它的开始和结束偏移量
+func (m *TextModel) rangeOffsets(op string, r common.Range) (int, int, error) {
+	if err := m.tree.CheckRange(r); err != nil {
+		return 0, 0, fromBufferError(op, err)
+	}
+	return m.tree.GetOffsetAt(r.StartLineNumber, r.StartColumn), m.tree.GetOffsetAt(r.EndLineNumber, r.EndColumn), nil
+}
+
+// GetLineContent 返回行的内容，不包括换行符
+func (m *TextModel) GetLineContent(lineNumber int) (string, error) {
+	if err := m.checkLine("GetLineContent", lineNumber); err != nil {
+		return "", err
+	}
+	return m.tree.GetLineContent(lineNumber), nil
+}
+
+// GetLineLength 返回行的长度（字节），不包括换行符
+func (m *TextModel) GetLineLength(lineNumber int) (int, error) {
+	if err := m.checkLine("GetLineLength", lineNumber); err != nil {
+		return 0, err
+	}
+	return len(m.tree.GetLineContent(lineNumber)), nil
+}
+
+// GetOffsetAt 返回位置的偏移量
+func (m *TextModel) GetOffsetAt(pos common.Position) (int, error) {
+	return m.offsetAt("GetOffsetAt", pos)
+}
+
+// GetPositionAt 返回偏移量的位置，偏移量位于多字节字符或 CRLF 中间时返回 ErrNotBoundary
+func (m *TextModel) GetPositionAt(offset int) (common.Position, error) {
+	if err := m.tree.CheckOffset(offset); err != nil {
+		return common.Position{}, fromBufferError("GetPositionAt", err)
+	}
+	return *m.tree.GetPositionAt(offset), nil
+}
+
+// GetValueInRange 返回范围内的文本
+func (m *TextModel) GetValueInRange(r common.Range) (string, error) {
+	start, end, err := m.rangeOffsets("GetValueInRange", r)
+	if err != nil {
+		return "", err
+	}
+	return m.tree.GetValueInOffsetRange(start, end), nil
+}
+
+// ValidatePosition 返回离位置最近的合法位置
+func (m *TextModel) ValidatePosition(pos common.Position) common.Position {
+	return m.tree.ValidatePosition(pos)
+}
+
+// ValidateRange 返回两端都是合法位置的范围
+func (m *TextModel) ValidateRange(r common.Range) common.Range {
+	return m.tree.ValidateRange(r)
+}
+
+// Insert 在位置处插入文本
+func (m *TextModel) Insert(pos common.Position, text string) error {
+	return m.apply("Insert", []Edit{{Range: common.Range{
+		StartLineNumber: pos.LineNumber, StartColumn: pos.Column, EndLineNumber: pos.LineNumber, EndColumn: pos.Column,
+	}, Text: text}})
+}
+
+// Delete 删除范围内的文本
+func (m *TextModel) Delete(r common.Range) error {
+	return m.apply("Delete", []Edit{{Range: r}})
+}
+
+// ApplyEdits 原子地应用一组编辑，记录为一个撤销批次
+// 所有范围都基于编辑之前的文本并且不能重叠，同一位置的多个插入按给定的顺序排列；
+// 任何一个编辑不合法时不做任何修改
+func (m *TextModel) ApplyEdits(edits []Edit) error {
+	return m.apply("ApplyEdits", edits)
+}
+
+// apply 检查并应用一组编辑
+func (m *TextModel) apply(op string, edits []Edit) error {
+	if err := m.checkWritable(op); err != nil {
+		return err
+	}
+	type span struct {
+		start, end int
+		text       string
+	}
+	spans := make([]span, len(edits))
+	for i, e := range edits {
+		if err := checkText(op, e.Text); err != nil {
+			return err
+		}
+		start, end, err := m.rangeOffsets(op, e.Range)
+		if err != nil {
+			return err
+		}
+		spans[i] = span{start: start, end: end, text: e.Text}
+	}
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	for i := 1; i < len(spans); i++ {
+		if spans[i].start < spans[i-1].end {
+			return newError(op, ErrOverlappingEdits, "[%d, %d) and [%d, %d)", spans[i-1].start, spans[i-1].end, spans[i].start, spans[i].end)
+		}
+	}
+
+	// 从后向前应用，前面的偏移量不受影响
+	ops := make([]buffer.EditOperation, 0, len(spans))
+	for i := len(spans) - 1; i >= 0; i-- {
+		op := buffer.NewEditOperation(spans[i].start, spans[i].end-spans[i].start, spans[i].text)
+		if !op.IsNoop() {
+			ops = append(ops, op)
+		}
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	m.history.Apply(ops)
+	m.versionID++
+	return nil
+}
+
+// CanUndo 是否可以撤销
+func (m *TextModel) CanUndo() bool {
+	return m.history.CanUndo()
+}
+
+// CanRedo 是否可以重做
+func (m *TextModel) CanRedo() bool {
+	return m.history.CanRedo()
+}
+
+// Undo 撤销最近的编辑批次
+func (m *TextModel) Undo() error {
+	if err := m.checkWritable("Undo"); err != nil {
+		return err
+	}
+	if m.history.Undo() == nil {
+		return newError("Undo", ErrNothingToUndo, "")
+	}
+	m.versionID++
+	return nil
+}
+
+// Redo 重做最近撤销的编辑批次
+func (m *TextModel) Redo() error {
+	if err := m.checkWritable("Redo"); err != nil {
+		return err
+	}
+	if m.history.Redo() == nil {
+		return newError("Redo", ErrNothingToUndo, "")
+	}
+	m.versionID++
+	return nil
+}
+
+// SetEOL 把所有换行符统一为 eol，eol 必须是 "\n" 或 "\r\n"
+// 换行符改变后偏移量不再对应，撤销栈被清空
+func (m *TextModel) SetEOL(eol string) error {
+	if eol != "\n" && eol != "\r\n" {
+		return newError("SetEOL", ErrInvalidEOL, "%q", eol)
+	}
+	if err := m.checkWritable("SetEOL"); err != nil {
+		return err
+	}
+	m.tree.SetEOL(eol)
+	m.history.Clear()
+	m.versionID++
+	return nil
+}
@@ -0,0 +1,153 @@
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+	"github.com/kebaren/textbuffer/pkg/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newModel(t *testing.T, text string) *TextModel {
+	t.Helper()
+	m, err := NewTextModel(text, Options{})
+	require.NoError(t, err)
+	return m
+}
+
+func rng(startLine, startColumn, endLine, endColumn int) common.Range {
+	return common.Range{StartLineNumber: startLine, StartColumn: startColumn, EndLineNumber: endLine, EndColumn: endColumn}
+}
+
+func pos(line, column int) common.Position {
+	return common.Position{LineNumber: line, Column: column}
+}
+
+func TestNewTextModel(t *testing.T) {
+	_, err := NewTextModel("a\xffb", Options{})
+	assert.ErrorIs(t, err, ErrInvalidUTF8)
+	assert.EqualError(t, err, "model: NewTextModel: invalid UTF-8")
+	_, err = NewTextModel("", Options{DefaultEOL: "\r"})
+	assert.ErrorIs(t, err, ErrInvalidEOL)
+
+	m, err := NewTextModel("", Options{DefaultEOL: "\r\n"})
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", m.GetEOL())
+
+	m, err = NewTextModel("a\r\nb\nc\r\n", Options{NormalizeEOL: true})
+	require.NoError(t, err)
+	assert.Equal(t, "a\r\nb\r\nc\r\n", m.GetValue())
+	assert.Equal(t, 4, m.GetLineCount())
+}
+
+func TestReads(t *testing.T) {
+	m := newModel(t, "héllo\nworld")
+
+	line, err := m.GetLineContent(2)
+	assert.NoError(t, err)
+	assert.Equal(t, "world", line)
+	_, err = m.GetLineContent(3)
+	assert.ErrorIs(t, err, ErrOutOfRange)
+	assert.EqualError(t, err, "model: GetLineContent: out of range: line 3 not in [1, 2]")
+	length, err := m.GetLineLength(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, length)
+	_, err = m.GetLineLength(0)
+	assert.ErrorIs(t, err, ErrOutOfRange)
+
+	offset, err := m.GetOffsetAt(pos(2, 3))
+	assert.NoError(t, err)
+	assert.Equal(t, 9, offset)
+	_, err = m.GetOffsetAt(pos(1, 8))
+	assert.ErrorIs(t, err, ErrOutOfRange)
+	_, err = m.GetOffsetAt(pos(1, 3))
+	assert.ErrorIs(t, err, ErrNotBoundary)
+	assert.ErrorIs(t, err, buffer.ErrNotBoundary)
+	assert.EqualError(t, err, "model: GetOffsetAt: not on a character boundary: column 3 on line 1")
+
+	p, err := m.GetPositionAt(7)
+	assert.NoError(t, err)
+	assert.Equal(t, pos(2, 1), p)
+	_, err = m.GetPositionAt(2)
+	assert.ErrorIs(t, err, ErrNotBoundary)
+	_, err = m.GetPositionAt(-1)
+	assert.ErrorIs(t, err, ErrOutOfRange)
+	_, err = m.GetPositionAt(13)
+	assert.ErrorIs(t, err, ErrOutOfRange)
+
+	value, err := m.GetValueInRange(rng(1, 4, 2, 2))
+	assert.NoError(t, err)
+	assert.Equal(t, "llo\nw", value)
+	_, err = m.GetValueInRange(rng(2, 1, 1, 1))
+	assert.ErrorIs(t, err, ErrInvalidRange)
+	assert.EqualError(t, err, "model: GetValueInRange: range end is before its start: [2,1 -> 1,1]")
+	_, err = m.GetValueInRange(rng(1, 1, 1, 3))
+	assert.ErrorIs(t, err, buffer.ErrNotBoundary)
+
+	assert.Equal(t, pos(1, 2), m.ValidatePosition(pos(1, 3)))
+	assert.Equal(t, *common.NewRange(1, 1, 2, 6), m.ValidateRange(rng(9, 9, 0, 0)))
+
+	m = newModel(t, "a\r\nb")
+	_, err = m.GetPositionAt(2)
+	assert.ErrorIs(t, err, ErrNotBoundary)
+}
+
+func TestEdits(t *testing.T) {
+	m := newModel(t, "hello world")
+	require.NoError(t, m.Insert(pos(1, 6), ","))
+	require.NoError(t, m.Delete(rng(1, 7, 1, 13)))
+	assert.Equal(t, "hello,", m.GetValue())
+	assert.Equal(t, 2, m.GetVersionID())
+
+	// All edits refer to the original text and are applied as one undo batch.
+	require.NoError(t, m.ApplyEdits([]Edit{
+		{Range: rng(1, 7, 1, 7), Text: " there"},
+		{Range: rng(1, 1, 1, 6), Text: "hi"},
+		{Range: rng(1, 7, 1, 7), Text: "!"},
+	}))
+	assert.Equal(t, "hi, there!", m.GetValue())
+	require.NoError(t, m.Undo())
+	assert.Equal(t, "hello,", m.GetValue())
+	require.NoError(t, m.Redo())
+	assert.Equal(t, "hi, there!", m.GetValue())
+	assert.ErrorIs(t, m.Redo(), ErrNothingToUndo)
+
+	// A bad edit leaves the model untouched.
+	version := m.GetVersionID()
+	for _, err := range []error{
+		m.Insert(pos(2, 1), "x"),
+		m.Insert(pos(1, 1), "\xc3"),
+		m.ApplyEdits([]Edit{{Range: rng(1, 1, 1, 4), Text: "a"}, {Range: rng(1, 3, 1, 5), Text: "b"}}),
+		m.ApplyEdits([]Edit{{Range: rng(1, 1, 1, 2), Text: "a"}, {Range: rng(1, 2, 1, 99)}}),
+	} {
+		assert.Error(t, err)
+	}
+	assert.Equal(t, "hi, there!", m.GetValue())
+	assert.Equal(t, version, m.GetVersionID())
+
+	var modelErr *Error
+	err := m.ApplyEdits([]Edit{{Range: rng(1, 1, 1, 4)}, {Range: rng(1, 3, 1, 5)}})
+	require.True(t, errors.As(err, &modelErr))
+	assert.Equal(t, "ApplyEdits", modelErr.Op)
+	assert.Equal(t, ErrOverlappingEdits, modelErr.Kind)
+}
+
+func TestReadOnlyAndEOL(t *testing.T) {
+	m, err := NewTextModel("a\nb", Options{ReadOnly: true})
+	require.NoError(t, err)
+	assert.True(t, m.IsReadOnly())
+	assert.ErrorIs(t, m.Insert(pos(1, 1), "x"), ErrReadOnly)
+	assert.ErrorIs(t, m.Undo(), ErrReadOnly)
+	assert.ErrorIs(t, m.SetEOL("\r\n"), ErrReadOnly)
+	assert.Equal(t, "a\nb", m.GetValue())
+
+	m.SetReadOnly(false)
+	assert.ErrorIs(t, m.Undo(), ErrNothingToUndo)
+	require.NoError(t, m.Insert(pos(2, 2), "c"))
+	assert.ErrorIs(t, m.SetEOL("\r"), ErrInvalidEOL)
+	require.NoError(t, m.SetEOL("\r\n"))
+	assert.Equal(t, "a\r\nbc", m.GetValue())
+	assert.False(t, m.CanUndo())
+}
@@ -0,0 +1,250 @@
+package navigation
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+	"github.com/kebaren/textbuffer/pkg/common"
+)
+
+// Options 光标移动的选项
+type Options struct {
+	// TabSize 制表符宽度，上下移动时用于计算显示列，小于等于 0 时为 4
+	TabSize int
+	// Classifier 单词字符分类器，为 nil 时使用默认分隔符
+	Classifier *common.WordClassifier
+}
+
+// Navigator 在片段树上计算光标移动后的位置
+// 所有命令都先把输入位置限制到文档内，返回的位置总是有效的，列总是落在字符边界上
+type Navigator struct {
+	tree       *buffer.PieceTreeBase
+	tabSize    int
+	classifier *common.WordClassifier
+}
+
+// NewNavigator 创建一个新的光标移动计算器
+func NewNavigator(tree *buffer.PieceTreeBase, opts Options) *Navigator {
+	if opts.TabSize <= 0 {
+		opts.TabSize = 4
+	}
+	if opts.Classifier == nil {
+		opts.Classifier = common.NewWordClassifier("")
+	}
+	return &Navigator{tree: tree, tabSize: opts.TabSize, classifier: opts.Classifier}
+}
+
+// Tree 返回片段树
+func (n *Navigator) Tree() *buffer.PieceTreeBase {
+	return n.tree
+}
+
+// Validate 把位置限制到文档内，落在多字节字符中间的列移动到字符开头
+func (n *Navigator) Validate(pos common.Position) common.Position {
+	return n.tree.ValidatePosition(pos)
+}
+
+// lineEnd 返回行尾的位置
+func (n *Navigator) lineEnd(line int) common.Position {
+	return common.Position{LineNumber: line, Column: n.tree.GetLineLength(line) + 1}
+}
+
+// Left 向左移动一个字素簇，在行首时移动到上一行的行尾
+func (n *Navigator) Left(pos common.Position) common.Position {
+	pos = n.Validate(pos)
+	switch {
+	case pos.Column > 1:
+		prev := common.PrevGraphemeBoundary(n.tree.GetLineContent(pos.LineNumber), pos.Column-1)
+		return common.Position{LineNumber: pos.LineNumber, Column: prev + 1}
+	case pos.LineNumber > 1:
+		return n.lineEnd(pos.LineNumber - 1)
+	}
+	return pos
+}
+
+// Right 向右移动一个字素簇，在行尾时移动到下一行的行首
+func (n *Navigator) Right(pos common.Position) common.Position {
+	pos = n.Validate(pos)
+	content := n.tree.GetLineContent(pos.LineNumber)
+	switch {
+	case pos.Column <= len(content):
+		next := common.NextGraphemeBoundary(content, pos.Column-1)
+		return common.Position{LineNumber: pos.LineNumber, Column: next + 1}
+	case pos.LineNumber < n.tree.GetLineCount():
+		return common.Position{LineNumber: pos.LineNumber + 1, Column: 1}
+	}
+	return pos
+}
+
+// segment 一行中类别相同的一段连续字符（不包含空白），[start, end) 是字节下标
+type segment struct {
+	start, end int
+}
+
+// segments 把一行拆分为类别相同的字符段，空白字符不属于任何段
+func (n *Navigator) segments(line string) []segment {
+	var result []segment
+	last := common.WordCharacterWhitespace
+	for i := 0; i < len(line); {
+		r, size := utf8.DecodeRuneInString(line[i:])
+		class := n.classifier.Get(r)
+		if class != common.WordCharacterWhitespace {
+			if k := len(result); k > 0 && result[k-1].end == i && class == last {
+				result[k-1].end = i + size
+			} else {
+				result = append(result, segment{start: i, end: i + size})
+			}
+		}
+		last = class
+		i += size
+	}
+	return result
+}
+
+// WordLeft 移动到光标之前的单词或分隔符段的开头，之前只有空白时移动到行首，在行首时移动到上一行的行尾
+func (n *Navigator) WordLeft(pos common.Position) common.Position {
+	pos = n.Validate(pos)
+	if pos.Column == 1 {
+		return n.Left(pos)
+	}
+	segments := n.segments(n.tree.GetLineContent(pos.LineNumber))
+	for i := len(segments) - 1; i >= 0; i-- {
+		if segments[i].start < pos.Column-1 {
+			return common.Position{LineNumber: pos.LineNumber, Column: segments[i].start + 1}
+		}
+	}
+	return common.Position{LineNumber: pos.LineNumber, Column: 1}
+}
+
+// WordRight 移动到光标之后的单词或分隔符段的结尾，之后只有空白时移动到行尾，在行尾时移动到下一行的行首
+func (n *Navigator) WordRight(pos common.Position) common.Position {
+	pos = n.Validate(pos)
+	content := n.tree.GetLineContent(pos.LineNumber)
+	if pos.Column > len(content) {
+		return n.Right(pos)
+	}
+	for _, seg := range n.segments(content) {
+		if seg.end > pos.Column-1 {
+			return common.Position{LineNumber: pos.LineNumber, Column: seg.end + 1}
+		}
+	}
+	return n.lineEnd(pos.LineNumber)
+}
+
+// Home 智能行首：不在第一个非空白字符处时移动到那里，否则移动到行首
+// 只有空白的行的第一个非空白字符位于行尾
+func (n *Navigator) Home(pos common.Position) common.Position {
+	pos = n.Validate(pos)
+	content := n.tree.GetLineContent(pos.LineNumber)
+	first := len(content) - len(strings.TrimLeft(content, " \t")) + 1
+	if pos.Column != first {
+		return common.Position{LineNumber: pos.LineNumber, Column: first}
+	}
+	return common.Position{LineNumber: pos.LineNumber, Column: 1}
+}
+
+// End 移动到行尾（换行符之前）
+func (n *Navigator) End(pos common.Position) common.Position {
+	return n.lineEnd(n.Validate(pos).LineNumber)
+}
+
+// isBlank 判断行是否只有空白字符
+func (n *Navigator) isBlank(line int) bool {
+	return strings.TrimSpace(n.tree.GetLineContent(line)) == ""
+}
+
+// ParagraphUp 移动到光标所在（或之前）的段落之前的空行的行首，没有时移动到文档开头
+// 段落是连续的非空行，只有空白字符的行视为空行
+func (n *Navigator) ParagraphUp(pos common.Position) common.Position {
+	line := n.Validate(pos).LineNumber
+	for line >= 1 && n.isBlank(line) {
+		line--
+	}
+	for line >= 1 && !n.isBlank(line) {
+		line--
+	}
+	if line < 1 {
+		return n.DocumentStart()
+	}
+	return common.Position{LineNumber: line, Column: 1}
+}
+
+// ParagraphDown 移动到光标所在（或之后）的段落之后的空行的行首，没有时移动到文档末尾
+func (n *Navigator) ParagraphDown(pos common.Position) common.Position {
+	lineCount := n.tree.GetLineCount()
+	line := n.Validate(pos).LineNumber
+	for line <= lineCount && n.isBlank(line) {
+		line++
+	}
+	for line <= lineCount && !n.isBlank(line) {
+		line++
+	}
+	if line > lineCount {
+		return n.DocumentEnd()
+	}
+	return common.Position{LineNumber: line, Column: 1}
+}
+
+// DocumentStart 返回文档开头
+func (n *Navigator) DocumentStart() common.Position {
+	return common.Position{LineNumber: 1, Column: 1}
+}
+
+// DocumentEnd 返回文档末尾
+func (n *Navigator) DocumentEnd() common.Position {
+	return n.lineEnd(n.tree.GetLineCount())
+}
+
+// VisibleColumn 返回位置的显示列（从 0 开始），制表符展开到 TabSize 的倍数
+func (n *Navigator) VisibleColumn(pos common.Position) int {
+	pos = n.Validate(pos)
+	return common.VisibleColumnFromColumn(n.tree.GetLineContent(pos.LineNumber), pos.Column, n.tabSize)
+}
+
+// Vertical 垂直移动 delta 行，delta 小于 0 时向上移动
+// visibleColumn 是希望保持的显示列，小于 0 时使用 pos 的显示列，连续上下移动时调用方应保持同一个值；
+// 超出第一行时移动到文档开头，超出最后一行时移动到文档末尾
+func (n *Navigator) Vertical(pos common.Position, delta, visibleColumn int) common.Position {
+	pos = n.Validate(pos)
+	if visibleColumn < 0 {
+		visibleColumn = n.VisibleColumn(pos)
+	}
+	line := pos.LineNumber + delta
+	switch {
+	case delta == 0:
+		return pos
+	case line < 1:
+		return n.DocumentStart()
+	case line > n.tree.GetLineCount():
+		return n.DocumentEnd()
+	}
+	column := common.ColumnFromVisibleColumn(n.tree.GetLineContent(line), visibleColumn, n.tabSize)
+	return common.Position{LineNumber: line, Column: column}
+}
+
+// Up 向上移动一行，visibleColumn 的含义同 Vertical
+func (n *Navigator) Up(pos common.Position, visibleColumn int) common.Position {
+	return n.Vertical(pos, -1, visibleColumn)
+}
+
+// Down 向下移动一行，visibleColumn 的含义同 Vertical
+func (n *Navigator) Down(pos common.Position, visibleColumn int) common.Position {
+	return n.Vertical(pos, 1, visibleColumn)
+}
+
+// PageUp 向上移动一页，viewportHeight 是视口的行数，翻页时保留一行重叠
+// visibleColumn 的含义同 Vertical
+func (n *Navigator) PageUp(pos common.Position, viewportHeight, visibleColumn int) common.Position {
+	return n.Vertical(pos, -pageSize(viewportHeight), visibleColumn)
+}
+
+// PageDown 向下移动一页，viewportHeight 和 visibleColumn 的含义同 PageUp
+func (n *Navigator) PageDown(pos common.Position, viewportHeight, visibleColumn int) common.Position {
+	return n.Vertical(pos, pageSize(viewportHeight), visibleColumn)
+}
+
+// pageSize 返回翻页移动的行数
+func pageSize(viewportHeight int) int {
+	return max(viewportHeight-1, 1)
+}
@@ -0,0 +1,143 @@
+package navigation
+
+import (
+	"testing"
+
+	"github.com/kebaren/textbuffer/pkg/buffer/buffertest"
+	"github.com/kebaren/textbuffer/pkg/common"
+	"github.com/kebaren/textbuffer/pkg/cursor"
+	"github.com/stretchr/testify/assert"
+)
+
+func newNavigator(text string) *Navigator {
+	return NewNavigator(buffertest.NewTree(text), Options{})
+}
+
+func pos(line, column int) common.Position {
+	return common.Position{LineNumber: line, Column: column}
+}
+
+func TestValidate(t *testing.T) {
+	n := newNavigator("héllo\r\nx")
+	assert.Equal(t, pos(1, 1), n.Validate(pos(0, -3)))
+	assert.Equal(t, pos(1, 7), n.Validate(pos(1, 99)))
+	assert.Equal(t, pos(2, 2), n.Validate(pos(9, 9)))
+	// Column 3 is inside the two-byte é.
+	assert.Equal(t, pos(1, 2), n.Validate(pos(1, 3)))
+}
+
+func TestLeftRight(t *testing.T) {
+	n := newNavigator("aé\U0001F1EB\U0001F1F7\r\n\nz")
+	assert.Equal(t, pos(1, 2), n.Right(pos(1, 1)))
+	assert.Equal(t, pos(1, 5), n.Right(pos(1, 2)))
+	assert.Equal(t, pos(1, 13), n.Right(pos(1, 5)))
+	// The CRLF is never split.
+	assert.Equal(t, pos(2, 1), n.Right(pos(1, 13)))
+	assert.Equal(t, pos(3, 1), n.Right(pos(2, 1)))
+	assert.Equal(t, pos(3, 2), n.Right(pos(3, 2)))
+
+	assert.Equal(t, pos(1, 5), n.Left(pos(1, 13)))
+	assert.Equal(t, pos(1, 2), n.Left(pos(1, 5)))
+	assert.Equal(t, pos(1, 13), n.Left(pos(2, 1)))
+	assert.Equal(t, pos(1, 1), n.Left(pos(1, 1)))
+}
+
+func TestWordLeftRight(t *testing.T) {
+	n := newNavigator("  foo.bar(baz) ->  \n中文abc")
+	var stops []common.Position
+	for p := pos(1, 1); ; {
+		next := n.WordRight(p)
+		if next == p {
+			break
+		}
+		stops = append(stops, next)
+		p = next
+	}
+	assert.Equal(t, []common.Position{
+		pos(1, 6), pos(1, 7), pos(1, 10), pos(1, 11), pos(1, 14), pos(1, 15), pos(1, 18), pos(1, 20),
+		pos(2, 1), pos(2, 7), pos(2, 10),
+	}, stops)
+
+	stops = nil
+	for p := n.DocumentEnd(); ; {
+		prev := n.WordLeft(p)
+		if prev == p {
+			break
+		}
+		stops = append(stops, prev)
+		p = prev
+	}
+	assert.Equal(t, []common.Position{
+		pos(2, 7), pos(2, 1), pos(1, 20), pos(1, 16), pos(1, 14), pos(1, 11), pos(1, 10), pos(1, 7), pos(1, 6),
+		pos(1, 3), pos(1, 1),
+	}, stops)
+}
+
+func TestHomeEnd(t *testing.T) {
+	n := newNavigator("\t  code\n   \nx")
+	assert.Equal(t, pos(1, 4), n.Home(pos(1, 8)))
+	assert.Equal(t, pos(1, 1), n.Home(pos(1, 4)))
+	assert.Equal(t, pos(1, 4), n.Home(pos(1, 1)))
+	assert.Equal(t, pos(2, 4), n.Home(pos(2, 2)))
+	assert.Equal(t, pos(2, 1), n.Home(pos(2, 4)))
+	assert.Equal(t, pos(1, 8), n.End(pos(1, 1)))
+	assert.Equal(t, pos(3, 2), n.End(pos(3, 1)))
+}
+
+func TestEndMixedEOL(t *testing.T) {
+	// Line lengths do not depend on which line break ends the line.
+	n := newNavigator("ab\r\ncd\nef")
+	assert.Equal(t, pos(1, 3), n.End(pos(1, 1)))
+	assert.Equal(t, pos(2, 3), n.End(pos(2, 1)))
+	assert.Equal(t, pos(3, 3), n.End(pos(3, 1)))
+	assert.Equal(t, pos(2, 1), n.Right(pos(1, 3)))
+	assert.Equal(t, pos(1, 3), n.Left(pos(2, 1)))
+}
+
+func TestParagraph(t *testing.T) {
+	n := newNavigator("a\nb\n\n  \nc\nd\n\ne")
+	assert.Equal(t, pos(3, 1), n.ParagraphDown(pos(1, 1)))
+	assert.Equal(t, pos(7, 1), n.ParagraphDown(pos(3, 1)))
+	assert.Equal(t, pos(8, 2), n.ParagraphDown(pos(7, 1)))
+	assert.Equal(t, pos(8, 2), n.ParagraphDown(pos(8, 2)))
+
+	assert.Equal(t, pos(7, 1), n.ParagraphUp(pos(8, 2)))
+	assert.Equal(t, pos(4, 1), n.ParagraphUp(pos(7, 1)))
+	assert.Equal(t, pos(1, 1), n.ParagraphUp(pos(4, 1)))
+}
+
+func TestVerticalAndPages(t *testing.T) {
+	n := newNavigator("abcdefgh\n\tx\nab\nabcdefgh\nlast")
+	// The visible column sticks across short lines and tabs.
+	p := pos(1, 7)
+	want := n.VisibleColumn(p)
+	p = n.Down(p, want)
+	assert.Equal(t, pos(2, 3), p)
+	p = n.Down(p, want)
+	assert.Equal(t, pos(3, 3), p)
+	p = n.Down(p, want)
+	assert.Equal(t, pos(4, 7), p)
+	assert.Equal(t, pos(4, 3), n.Down(pos(3, 3), -1))
+
+	assert.Equal(t, pos(1, 1), n.Up(pos(1, 5), -1))
+	assert.Equal(t, pos(5, 5), n.Down(pos(5, 2), -1))
+
+	assert.Equal(t, pos(4, 2), n.PageDown(pos(1, 2), 4, -1))
+	assert.Equal(t, pos(5, 5), n.PageDown(pos(4, 2), 4, -1))
+	assert.Equal(t, pos(2, 1), n.PageUp(pos(5, 2), 4, -1))
+	assert.Equal(t, pos(2, 1), n.PageDown(pos(1, 1), 0, -1))
+	assert.Equal(t, pos(1, 1), n.DocumentStart())
+	assert.Equal(t, pos(5, 5), n.DocumentEnd())
+}
+
+func TestMoveCursors(t *testing.T) {
+	n := newNavigator("one two\nthree")
+	c := cursor.NewCollection(n.Tree())
+	c.SetSelections([]common.Selection{*common.NewSelection(1, 1, 1, 1), *common.NewSelection(2, 1, 2, 1)})
+	c.Move(n.WordRight, true)
+	assert.Equal(t, []common.Selection{*common.NewSelection(1, 1, 1, 4), *common.NewSelection(2, 1, 2, 6)}, c.Selections())
+	c.Move(n.End, false)
+	assert.Equal(t, []common.Selection{*common.NewSelection(1, 8, 1, 8), *common.NewSelection(2, 6, 2, 6)}, c.Selections())
+	c.Move(func(common.Position) common.Position { return n.DocumentEnd() }, false)
+	assert.Equal(t, 1, c.Count())
+}
@@ -0,0 +1,137 @@
+package ot
+
+import (
+	"errors"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+)
+
+// ErrNoPendingOperation 在没有待确认操作时收到了确认
+var ErrNoPendingOperation = errors.New("ot: no pending operation to acknowledge")
+
+// ClientState 客户端状态
+type ClientState int
+
+const (
+	// Synchronized 没有待确认的本地操作
+	Synchronized ClientState = iota
+	// AwaitingConfirm 已发送一个操作，等待服务器确认
+	AwaitingConfirm
+	// AwaitingWithBuffer 等待确认的同时缓存了新的本地操作
+	AwaitingWithBuffer
+)
+
+// SendFunc 向服务器发送操作的回调，revision 是操作所基于的服务器版本
+type SendFunc func(revision int, op *TextOperation)
+
+// Client 协同编辑客户端
+// 本地操作立即应用到片段树并发送给服务器，同一时刻最多只有一个操作等待确认
+type Client struct {
+	// revision 已知的服务器版本
+	revision int
+	// state 当前状态
+	state ClientState
+	// outstanding 已发送等待确认的操作
+	outstanding *TextOperation
+	// buffered 尚未发送的本地操作
+	buffered *TextOperation
+	// tree 客户端文档
+	tree *buffer.PieceTreeBase
+	// send 发送回调
+	send SendFunc
+}
+
+// NewClient 创建一个新的客户端
+func NewClient(revision int, tree *buffer.PieceTreeBase, send SendFunc) *Client {
+	return &Client{
+		revision: revision,
+		state:    Synchronized,
+		tree:     tree,
+		send:     send,
+	}
+}
+
+// Revision 返回已知的服务器版本
+func (c *Client) Revision() int {
+	return c.revision
+}
+
+// State 返回当前状态
+func (c *Client) State() ClientState {
+	return c.state
+}
+
+// Tree 返回客户端文档
+func (c *Client) Tree() *buffer.PieceTreeBase {
+	return c.tree
+}
+
+// ApplyClient 应用本地操作
+func (c *Client) ApplyClient(op *TextOperation) error {
+	if err := op.ApplyToTree(c.tree); err != nil {
+		return err
+	}
+
+	switch c.state {
+	case Synchronized:
+		c.outstanding = op
+		c.state = AwaitingConfirm
+		c.send(c.revision, op)
+	case AwaitingConfirm:
+		c.buffered = op
+		c.state = AwaitingWithBuffer
+	case AwaitingWithBuffer:
+		composed, err := Compose(c.buffered, op)
+		if err != nil {
+			return err
+		}
+		c.buffered = composed
+	}
+	return nil
+}
+
+// ApplyServer 应用来自服务器的其他客户端操作，出错时客户端的状态和修订号保持不变
+func (c *Client) ApplyServer(op *TextOperation) error {
+	outstanding, buffered := c.outstanding, c.buffered
+	var err error
+	switch c.state {
+	case AwaitingConfirm:
+		if outstanding, op, err = Transform(outstanding, op); err != nil {
+			return err
+		}
+	case AwaitingWithBuffer:
+		if outstanding, op, err = Transform(outstanding, op); err != nil {
+			return err
+		}
+		if buffered, op, err = Transform(buffered, op); err != nil {
+			return err
+		}
+	}
+
+	if err := op.ApplyToTree(c.tree); err != nil {
+		return err
+	}
+	c.outstanding = outstanding
+	c.buffered = buffered
+	c.revision++
+	return nil
+}
+
+// ServerAck 处理服务器对已发送操作的确认
+func (c *Client) ServerAck() error {
+	switch c.state {
+	case AwaitingConfirm:
+		c.revision++
+		c.outstanding = nil
+		c.state = Synchronized
+	case AwaitingWithBuffer:
+		c.revision++
+		c.outstanding = c.buffered
+		c.buffered = nil
+		c.state = AwaitingConfirm
+		c.send(c.revision, c.outstanding)
+	default:
+		return ErrNoPendingOperation
+	}
+	return nil
+}
@@ -0,0 +1,427 @@
+package ot
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+)
+
+var (
+	// ErrLengthMismatch 操作的基础长度与文档或另一个操作不匹配
+	ErrLengthMismatch = errors.New("ot: operation length mismatch")
+	// ErrTooShort 组合或转换时某个操作提前结束
+	ErrTooShort = errors.New("ot: operation is too short")
+)
+
+// ComponentKind 操作分量类型
+type ComponentKind int
+
+const (
+	// Retain 保留字符
+	Retain ComponentKind = iota
+	// Insert 插入文本
+	Insert
+	// Delete 删除字符
+	Delete
+)
+
+// Component 操作分量
+// 长度单位与 PieceTreeBase 的偏移量一致，均为字节
+type Component struct {
+	// Kind 分量类型
+	Kind ComponentKind
+	// N 保留或删除的长度
+	N int
+	// Text 插入的文本
+	Text string
+}
+
+// TextOperation 文本操作，由一串分量组成，覆盖整个文档
+type TextOperation struct {
+	// components 分量
+	components []Component
+	// baseLength 应用前的文档长度
+	baseLength int
+	// targetLength 应用后的文档长度
+	targetLength int
+}
+
+// NewTextOperation 创建一个新的空文本操作
+func NewTextOperation() *TextOperation {
+	return &TextOperation{
+		components: make([]Component, 0),
+	}
+}
+
+// Components 返回操作的分量
+func (o *TextOperation) Components() []Component {
+	return o.components
+}
+
+// BaseLength 返回应用前的文档长度
+func (o *TextOperation) BaseLength() int {
+	return o.baseLength
+}
+
+// TargetLength 返回应用后的文档长度
+func (o *TextOperation) TargetLength() int {
+	return o.targetLength
+}
+
+// Retain 追加保留分量
+func (o *TextOperation) Retain(n int) *TextOperation {
+	if n <= 0 {
+		return o
+	}
+	o.baseLength += n
+	o.targetLength += n
+
+	if last := o.last(); last != nil && last.Kind == Retain {
+		last.N += n
+		return o
+	}
+	o.components = append(o.components, Component{Kind: Retain, N: n})
+	return o
+}
+
+// Insert 追加插入分量
+// 插入总是排在相邻的删除之前，使等价的操作具有相同的表示
+func (o *TextOperation) Insert(text string) *TextOperation {
+	if len(text) == 0 {
+		return o
+	}
+	o.targetLength += len(text)
+
+	n := len(o.components)
+	last := o.last()
+	if last != nil && last.Kind == Insert {
+		last.Text += text
+		return o
+	}
+	if last != nil && last.Kind == Delete {
+		if n >= 2 && o.components[n-2].Kind == Insert {
+			o.components[n-2].Text += text
+			return o
+		}
+		o.components = append(o.components, *last)
+		o.components[n-1] = Component{Kind: Insert, Text: text}
+		return o
+	}
+	o.components = append(o.components, Component{Kind: Insert, Text: text})
+	return o
+}
+
+// Delete 追加删除分量
+func (o *TextOperation) Delete(n int) *TextOperation {
+	if n <= 0 {
+		return o
+	}
+	o.baseLength += n
+
+	if last := o.last(); last != nil && last.Kind == Delete {
+		last.N += n
+		return o
+	}
+	o.components = append(o.components, Component{Kind: Delete, N: n})
+	return o
+}
+
+// last 返回最后一个分量
+func (o *TextOperation) last() *Component {
+	if len(o.components) == 0 {
+		return nil
+	}
+	return &o.components[len(o.components)-1]
+}
+
+// IsNoop 判断操作是否不改变文档
+func (o *TextOperation) IsNoop() bool {
+	return len(o.components) == 0 || (len(o.components) == 1 && o.components[0].Kind == Retain)
+}
+
+// Equals 判断两个操作是否相同
+func (o *TextOperation) Equals(other *TextOperation) bool {
+	if o.baseLength != other.baseLength || o.targetLength != other.targetLength {
+		return false
+	}
+	if len(o.components) != len(other.components) {
+		return false
+	}
+	for i := range o.components {
+		if o.components[i] != other.components[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone 克隆此操作
+func (o *TextOperation) Clone() *TextOperation {
+	components := make([]Component, len(o.components))
+	copy(components, o.components)
+	return &TextOperation{
+		components:   components,
+		baseLength:   o.baseLength,
+		targetLength: o.targetLength,
+	}
+}
+
+// Apply 将操作应用到字符串
+func (o *TextOperation) Apply(doc string) (string, error) {
+	if len(doc) != o.baseLength {
+		return "", ErrLengthMismatch
+	}
+
+	var sb strings.Builder
+	sb.Grow(o.targetLength)
+	index := 0
+	for _, c := range o.components {
+		switch c.Kind {
+		case Retain:
+			sb.WriteString(doc[index : index+c.N])
+			index += c.N
+		case Insert:
+			sb.WriteString(c.Text)
+		case Delete:
+			index += c.N
+		}
+	}
+	return sb.String(), nil
+}
+
+// Edits 将操作转换为按顺序应用的编辑操作
+func (o *TextOperation) Edits() []buffer.EditOperation {
+	edits := make([]buffer.EditOperation, 0)
+	offset := 0
+	for _, c := range o.components {
+		switch c.Kind {
+		case Retain:
+			offset += c.N
+		case Insert:
+			edits = append(edits, buffer.NewEditOperation(offset, 0, c.Text))
+			offset += len(c.Text)
+		case Delete:
+			if n := len(edits); n > 0 && edits[n-1].Length == 0 && edits[n-1].Offset+len(edits[n-1].Text) == offset {
+				// 紧跟在插入之后的删除可以合并为一次替换
+				edits[n-1].Length = c.N
+				continue
+			}
+			edits = append(edits, buffer.NewEditOperation(offset, c.N, ""))
+		}
+	}
+	return edits
+}
+
+// ApplyToTree 将操作应用到片段树
+func (o *TextOperation) ApplyToTree(tree *buffer.PieceTreeBase) error {
+	if tree.GetLength() != o.baseLength {
+		return ErrLengthMismatch
+	}
+	tree.ApplyEdits(o.Edits())
+	return nil
+}
+
+// Invert 计算操作的逆操作，tree 必须是应用此操作之前的文档
+func (o *TextOperation) Invert(tree *buffer.PieceTreeBase) *TextOperation {
+	inverse := NewTextOperation()
+	index := 0
+	for _, c := range o.components {
+		switch c.Kind {
+		case Retain:
+			inverse.Retain(c.N)
+			index += c.N
+		case Insert:
+			inverse.Delete(len(c.Text))
+		case Delete:
+			inverse.Insert(tree.GetValueInOffsetRange(index, index+c.N))
+			index += c.N
+		}
+	}
+	return inverse
+}
+
+// FromEdits 根据长度为 baseLength 的文档上按顺序应用的编辑操作构造文本操作
+func FromEdits(baseLength int, edits []buffer.EditOperation) (*TextOperation, error) {
+	result := NewTextOperation().Retain(baseLength)
+	length := baseLength
+	for _, edit := range edits {
+		if edit.Offset < 0 || edit.Length < 0 || edit.Offset+edit.Length > length {
+			return nil, ErrLengthMismatch
+		}
+		op := NewTextOperation().
+			Retain(edit.Offset).
+			Delete(edit.Length).
+			Insert(edit.Text).
+			Retain(length - edit.Offset - edit.Length)
+
+		composed, err := Compose(result, op)
+		if err != nil {
+			return nil, err
+		}
+		result = composed
+		length = op.targetLength
+	}
+	return result, nil
+}
+
+// Compose 组合两个连续的操作，使 apply(apply(S, a), b) = apply(S, Compose(a, b))
+func Compose(a, b *TextOperation) (*TextOperation, error) {
+	if a.targetLength != b.baseLength {
+		return nil, ErrLengthMismatch
+	}
+
+	result := NewTextOperation()
+	ops1, ops2 := newCursor(a.components), newCursor(b.components)
+	for !ops1.done() || !ops2.done() {
+		c1, c2 := ops1.peek(), ops2.peek()
+
+		if c1 != nil && c1.Kind == Delete {
+			result.Delete(c1.N)
+			ops1.next()
+			continue
+		}
+		if c2 != nil && c2.Kind == Insert {
+			result.Insert(c2.Text)
+			ops2.next()
+			continue
+		}
+		if c1 == nil || c2 == nil {
+			return nil, ErrTooShort
+		}
+
+		switch {
+		case c1.Kind == Retain && c2.Kind == Retain:
+			n := minInt(c1.N, c2.N)
+			result.Retain(n)
+			ops1.consume(n)
+			ops2.consume(n)
+		case c1.Kind == Insert && c2.Kind == Delete:
+			n := minInt(len(c1.Text), c2.N)
+			ops1.consume(n)
+			ops2.consume(n)
+		case c1.Kind == Insert && c2.Kind == Retain:
+			n := minInt(len(c1.Text), c2.N)
+			result.Insert(c1.Text[:n])
+			ops1.consume(n)
+			ops2.consume(n)
+		case c1.Kind == Retain && c2.Kind == Delete:
+			n := minInt(c1.N, c2.N)
+			result.Delete(n)
+			ops1.consume(n)
+			ops2.consume(n)
+		}
+	}
+	return result, nil
+}
+
+// Transform 转换两个基于同一文档的并发操作
+// 返回 (a', b')，满足 apply(apply(S, a), b') = apply(apply(S, b), a')
+// 两个操作在同一位置插入时，a 的插入排在前面
+func Transform(a, b *TextOperation) (*TextOperation, *TextOperation, error) {
+	if a.baseLength != b.baseLength {
+		return nil, nil, ErrLengthMismatch
+	}
+
+	aPrime, bPrime := NewTextOperation(), NewTextOperation()
+	ops1, ops2 := newCursor(a.components), newCursor(b.components)
+	for !ops1.done() || !ops2.done() {
+		c1, c2 := ops1.peek(), ops2.peek()
+
+		if c1 != nil && c1.Kind == Insert {
+			aPrime.Insert(c1.Text)
+			bPrime.Retain(len(c1.Text))
+			ops1.next()
+			continue
+		}
+		if c2 != nil && c2.Kind == Insert {
+			aPrime.Retain(len(c2.Text))
+			bPrime.Insert(c2.Text)
+			ops2.next()
+			continue
+		}
+		if c1 == nil || c2 == nil {
+			return nil, nil, ErrTooShort
+		}
+
+		n := minInt(c1.N, c2.N)
+		switch {
+		case c1.Kind == Retain && c2.Kind == Retain:
+			aPrime.Retain(n)
+			bPrime.Retain(n)
+		case c1.Kind == Delete && c2.Kind == Retain:
+			aPrime.Delete(n)
+		case c1.Kind == Retain && c2.Kind == Delete:
+			bPrime.Delete(n)
+		}
+		// 两边都删除时无需输出
+		ops1.consume(n)
+		ops2.consume(n)
+	}
+	return aPrime, bPrime, nil
+}
+
+// cursor 分量游标，支持部分消费
+type cursor struct {
+	components []Component
+	index      int
+	current    Component
+	valid      bool
+}
+
+// newCursor 创建一个新的分量游标
+func newCursor(components []Component) *cursor {
+	c := &cursor{components: components}
+	c.next()
+	return c
+}
+
+// done 是否已消费所有分量
+func (c *cursor) done() bool {
+	return !c.valid
+}
+
+// peek 返回当前分量
+func (c *cursor) peek() *Component {
+	if !c.valid {
+		return nil
+	}
+	return &c.current
+}
+
+// next 移动到下一个分量
+func (c *cursor) next() {
+	if c.index >= len(c.components) {
+		c.valid = false
+		return
+	}
+	c.current = c.components[c.index]
+	c.index++
+	c.valid = true
+}
+
+// consume 从当前分量消费 n 个长度单位
+func (c *cursor) consume(n int) {
+	switch c.current.Kind {
+	case Insert:
+		if n >= len(c.current.Text) {
+			c.next()
+			return
+		}
+		c.current.Text = c.current.Text[n:]
+	default:
+		if n >= c.current.N {
+			c.next()
+			return
+		}
+		c.current.N -= n
+	}
+}
+
+// minInt 返回两个整数中的较小值
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,247 @@
+package ot
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/kebaren/textbuffer/pkg/buffer/buffertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var randomTexts = []string{"a", "bc", "\n", "世", "xyz\n", "\r\n", " "}
+
+// randomOperation builds a random operation over doc that never splits UTF-8 sequences.
+func randomOperation(r *rand.Rand, doc string) *TextOperation {
+	op := NewTextOperation()
+	for i := 0; i < len(doc); {
+		size := 1
+		for i+size < len(doc) && doc[i+size]&0xC0 == 0x80 {
+			size++
+		}
+		switch r.Intn(10) {
+		case 0:
+			op.Insert(randomTexts[r.Intn(len(randomTexts))])
+			op.Retain(size)
+		case 1:
+			op.Delete(size)
+		default:
+			op.Retain(size)
+		}
+		i += size
+	}
+	if r.Intn(2) == 0 {
+		op.Insert(randomTexts[r.Intn(len(randomTexts))])
+	}
+	return op
+}
+
+func randomDoc(r *rand.Rand) string {
+	doc := ""
+	for i := r.Intn(20); i > 0; i-- {
+		doc += randomTexts[r.Intn(len(randomTexts))]
+	}
+	return doc
+}
+
+func TestTextOperationBuilder(t *testing.T) {
+	op := NewTextOperation().Retain(2).Retain(3).Delete(1).Insert("ab").Insert("c").Delete(2)
+	assert.Equal(t, 8, op.BaseLength())
+	assert.Equal(t, 8, op.TargetLength())
+	assert.Equal(t, []Component{
+		{Kind: Retain, N: 5},
+		{Kind: Insert, Text: "abc"},
+		{Kind: Delete, N: 3},
+	}, op.Components())
+
+	result, err := op.Apply("01234567")
+	require.NoError(t, err)
+	assert.Equal(t, "01234abc", result)
+
+	_, err = op.Apply("short")
+	assert.ErrorIs(t, err, ErrLengthMismatch)
+	assert.True(t, NewTextOperation().Retain(4).IsNoop())
+}
+
+func TestEditsRoundTrip(t *testing.T) {
+	op := NewTextOperation().Retain(1).Insert("XY").Delete(2).Retain(2).Delete(1).Insert("!")
+	tree := buffertest.NewTree("abcdef")
+	require.NoError(t, op.ApplyToTree(tree))
+
+	expected, err := op.Apply("abcdef")
+	require.NoError(t, err)
+	assert.Equal(t, expected, tree.GetLinesRawContent())
+
+	rebuilt, err := FromEdits(6, op.Edits())
+	require.NoError(t, err)
+	assert.True(t, op.Equals(rebuilt), "%v vs %v", op.Components(), rebuilt.Components())
+}
+
+func TestStrayAckKeepsRevision(t *testing.T) {
+	sent := 0
+	c := NewClient(3, buffertest.NewTree("abc"), func(int, *TextOperation) { sent++ })
+	assert.ErrorIs(t, c.ServerAck(), ErrNoPendingOperation)
+	assert.Equal(t, 3, c.Revision())
+
+	require.NoError(t, c.ApplyClient(NewTextOperation().Retain(3).Insert("d")))
+	require.NoError(t, c.ServerAck())
+	assert.Equal(t, 4, c.Revision())
+	assert.ErrorIs(t, c.ServerAck(), ErrNoPendingOperation)
+	assert.Equal(t, 4, c.Revision())
+	assert.Equal(t, 1, sent)
+}
+
+func TestFailedServerOperationKeepsState(t *testing.T) {
+	c := NewClient(3, buffertest.NewTree("abc"), func(int, *TextOperation) {})
+	assert.ErrorIs(t, c.ApplyServer(NewTextOperation().Retain(5)), ErrLengthMismatch)
+	assert.Equal(t, 3, c.Revision())
+
+	outstanding := NewTextOperation().Retain(3).Insert("d")
+	require.NoError(t, c.ApplyClient(outstanding))
+	buffered := NewTextOperation().Insert("x").Retain(4)
+	require.NoError(t, c.ApplyClient(buffered))
+	assert.Error(t, c.ApplyServer(NewTextOperation().Retain(5)))
+	assert.Equal(t, 3, c.Revision())
+	assert.Equal(t, AwaitingWithBuffer, c.State())
+	assert.Equal(t, "xabcd", c.Tree().GetLinesRawContent())
+
+	// The client still works once a valid operation arrives.
+	require.NoError(t, c.ApplyServer(NewTextOperation().Retain(3).Insert("!")))
+	assert.Equal(t, 4, c.Revision())
+	assert.Equal(t, "xabcd!", c.Tree().GetLinesRawContent())
+	require.NoError(t, c.ServerAck())
+	assert.Equal(t, 5, c.Revision())
+}
+
+func TestOperationProperties(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		doc := randomDoc(r)
+
+		// Transform: both application orders converge.
+		a, b := randomOperation(r, doc), randomOperation(r, doc)
+		aPrime, bPrime, err := Transform(a, b)
+		require.NoError(t, err)
+		afterA, _ := a.Apply(doc)
+		afterB, _ := b.Apply(doc)
+		left, err := bPrime.Apply(afterA)
+		require.NoError(t, err)
+		right, err := aPrime.Apply(afterB)
+		require.NoError(t, err)
+		assert.Equal(t, left, right)
+
+		// Compose: applying the composition equals applying both in sequence.
+		c := randomOperation(r, afterA)
+		composed, err := Compose(a, c)
+		require.NoError(t, err)
+		sequential, _ := c.Apply(afterA)
+		direct, err := composed.Apply(doc)
+		require.NoError(t, err)
+		assert.Equal(t, sequential, direct)
+
+		// Invert: applying an operation and its inverse restores the document.
+		tree := buffertest.NewTree(doc)
+		inverse := a.Invert(tree)
+		require.NoError(t, a.ApplyToTree(tree))
+		assert.Equal(t, afterA, tree.GetLinesRawContent())
+		require.NoError(t, inverse.ApplyToTree(tree))
+		assert.Equal(t, doc, tree.GetLinesRawContent())
+	}
+}
+
+type message struct {
+	revision int
+	op       *TextOperation
+	ack      bool
+}
+
+type simulatedClient struct {
+	client  *Client
+	inbox   []message
+	pending []message
+}
+
+func TestRandomizedClientsConverge(t *testing.T) {
+	for seed := int64(0); seed < 30; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		doc := randomDoc(r)
+		server := NewServer(buffertest.NewTree(doc))
+
+		clients := make([]*simulatedClient, 3)
+		for i := range clients {
+			sc := &simulatedClient{}
+			sc.client = NewClient(0, buffertest.NewTree(doc), func(revision int, op *TextOperation) {
+				sc.pending = append(sc.pending, message{revision: revision, op: op})
+			})
+			clients[i] = sc
+		}
+
+		deliverToServer := func(from int) {
+			sc := clients[from]
+			msg := sc.pending[0]
+			sc.pending = sc.pending[1:]
+			applied, err := server.ReceiveOperation(msg.revision, msg.op)
+			require.NoError(t, err)
+			for i, other := range clients {
+				if i == from {
+					other.inbox = append(other.inbox, message{ack: true})
+				} else {
+					other.inbox = append(other.inbox, message{op: applied})
+				}
+			}
+		}
+		deliverToClient := func(to int) {
+			sc := clients[to]
+			msg := sc.inbox[0]
+			sc.inbox = sc.inbox[1:]
+			if msg.ack {
+				require.NoError(t, sc.client.ServerAck())
+			} else {
+				require.NoError(t, sc.client.ApplyServer(msg.op))
+			}
+		}
+
+		for step := 0; step < 200; step++ {
+			i := r.Intn(len(clients))
+			sc := clients[i]
+			switch r.Intn(3) {
+			case 0:
+				op := randomOperation(r, sc.client.Tree().GetLinesRawContent())
+				require.NoError(t, sc.client.ApplyClient(op))
+			case 1:
+				if len(sc.pending) > 0 {
+					deliverToServer(i)
+				}
+			case 2:
+				if len(sc.inbox) > 0 {
+					deliverToClient(i)
+				}
+			}
+		}
+
+		for {
+			progressed := false
+			for i, sc := range clients {
+				if len(sc.pending) > 0 {
+					deliverToServer(i)
+					progressed = true
+				}
+				if len(sc.inbox) > 0 {
+					deliverToClient(i)
+					progressed = true
+				}
+			}
+			if !progressed {
+				break
+			}
+		}
+
+		expected := server.Tree().GetLinesRawContent()
+		for i, sc := range clients {
+			assert.Equal(t, Synchronized, sc.client.State(), "seed %d client %d", seed, i)
+			assert.Equal(t, server.Revision(), sc.client.Revision(), "seed %d client %d", seed, i)
+			assert.Equal(t, expected, sc.client.Tree().GetLinesRawContent(), "seed %d client %d", seed, i)
+			assert.Equal(t, server.Tree().GetLineCount(), sc.client.Tree().GetLineCount(), "seed %d client %d", seed, i)
+		}
+	}
+}
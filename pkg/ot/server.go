@@ -0,0 +1,67 @@
+package ot
+
+import (
+	"errors"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+)
+
+// ErrInvalidRevision 操作所基于的版本不在服务器历史范围内
+var ErrInvalidRevision = errors.New("ot: operation revision not in history")
+
+// Server 协同编辑服务器
+// 服务器持有权威文档和操作历史，把并发操作转换到最新版本后再应用
+type Server struct {
+	// tree 权威文档
+	tree *buffer.PieceTreeBase
+	// history 已应用的操作历史，下标即版本号
+	history []*TextOperation
+}
+
+// NewServer 创建一个新的服务器
+func NewServer(tree *buffer.PieceTreeBase) *Server {
+	return &Server{
+		tree:    tree,
+		history: make([]*TextOperation, 0),
+	}
+}
+
+// Revision 返回当前版本
+func (s *Server) Revision() int {
+	return len(s.history)
+}
+
+// Tree 返回权威文档
+func (s *Server) Tree() *buffer.PieceTreeBase {
+	return s.tree
+}
+
+// History 返回从 revision 开始的操作历史
+func (s *Server) History(revision int) []*TextOperation {
+	if revision < 0 || revision > len(s.history) {
+		return nil
+	}
+	return s.history[revision:]
+}
+
+// ReceiveOperation 接收基于 revision 的客户端操作
+// 返回转换到最新版本并已应用的操作，调用方应把它广播给其他客户端并向发送方确认
+func (s *Server) ReceiveOperation(revision int, op *TextOperation) (*TextOperation, error) {
+	if revision < 0 || revision > len(s.history) {
+		return nil, ErrInvalidRevision
+	}
+
+	for _, concurrent := range s.history[revision:] {
+		transformed, _, err := Transform(op, concurrent)
+		if err != nil {
+			return nil, err
+		}
+		op = transformed
+	}
+
+	if err := op.ApplyToTree(s.tree); err != nil {
+		return nil, err
+	}
+	s.history = append(s.history, op)
+	return op, nil
+}
@@ -0,0 +1,455 @@
+package sam
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+)
+
+// Range 字节偏移量范围 [Start, End)
+type Range struct {
+	// Start 开始偏移量
+	Start int
+	// End 结束偏移量（不包含）
+	End int
+}
+
+// change 一处修改，偏移量基于命令执行之前的文本
+type change struct {
+	start, end int
+	text       string
+}
+
+// Engine 在片段树上执行 sam 风格的结构化正则表达式命令
+// 支持的命令：x/re/ cmd、y/re/ cmd、g/re/ cmd、v/re/ cmd、a/text/、c/text/、i/text/、d、
+// s/re/text/[g]、p、= 以及 { } 命令组。
+// 与 sam 一样，一个顶层命令中的所有修改都基于执行之前的文本收集，在命令结束时一次性应用，
+// 修改之间不能重叠。正则表达式使用 Go 的 RE2 语法，^ 和 $ 匹配行首和行尾。
+// 顶层的 s 命令没有匹配时报错，x/y 循环中没有匹配的 s 命令什么也不做。
+type Engine struct {
+	// Output p 和 = 命令的输出，为 nil 时丢弃
+	Output io.Writer
+
+	// history 撤销/重做栈
+	history *buffer.History
+	// dot 当前选区
+	dot Range
+	// changes 当前顶层命令收集的修改
+	changes []change
+	// undo 当前脚本中已经应用的修改的逆操作
+	undo []buffer.EditOperation
+	// loops 正在执行的 x/y 循环层数，循环中没有匹配的 s 命令什么也不做
+	loops int
+}
+
+// NewEngine 创建一个新的引擎，初始选区为文件开头的空范围
+func NewEngine(history *buffer.History) *Engine {
+	return &Engine{history: history}
+}
+
+// Dot 返回当前选区
+func (e *Engine) Dot() Range {
+	return e.dot
+}
+
+// SetDot 设置当前选区，超出文档的部分会被截断
+func (e *Engine) SetDot(start, end int) {
+	length := e.tree().GetLength()
+	clamp := func(n int) int {
+		if n < 0 {
+			return 0
+		}
+		if n > length {
+			return length
+		}
+		return n
+	}
+	start, end = clamp(start), clamp(end)
+	if start > end {
+		start, end = end, start
+	}
+	e.dot = Range{Start: start, End: end}
+}
+
+// tree 返回片段树
+func (e *Engine) tree() *buffer.PieceTreeBase {
+	return e.history.Tree()
+}
+
+// Execute 执行脚本
+// 整个脚本作为一个批次记录到撤销栈中，任何命令出错时已经做出的修改都会被撤销
+func (e *Engine) Execute(script string) error {
+	commands, err := parseScript(script)
+	if err != nil {
+		return err
+	}
+
+	dot := e.dot
+	e.undo = nil
+	for _, c := range commands {
+		if err = e.runTopLevel(c); err != nil {
+			break
+		}
+	}
+
+	inverse := make([]buffer.EditOperation, len(e.undo))
+	for i, op := range e.undo {
+		inverse[len(e.undo)-1-i] = op
+	}
+	e.undo = nil
+
+	if err != nil {
+		e.tree().ApplyEdits(inverse)
+		e.dot = dot
+		return err
+	}
+	if len(inverse) > 0 {
+		e.history.Push(inverse)
+	}
+	return nil
+}
+
+// runTopLevel 执行一个顶层命令并应用收集到的修改
+func (e *Engine) runTopLevel(c *cmd) error {
+	e.changes = nil
+	dot, err := e.run(c, e.dot)
+	if err != nil {
+		return err
+	}
+	if len(e.changes) == 0 {
+		e.dot = dot
+		return nil
+	}
+
+	sort.SliceStable(e.changes, func(i, j int) bool {
+		return e.changes[i].start < e.changes[j].start
+	})
+	for i := 1; i < len(e.changes); i++ {
+		if e.changes[i-1].end > e.changes[i].start {
+			return fmt.Errorf("sam: changes overlap at #%d", e.changes[i].start)
+		}
+	}
+
+	// 从后向前应用，前面的修改的偏移量不受影响
+	tree := e.tree()
+	for i := len(e.changes) - 1; i >= 0; i-- {
+		ch := e.changes[i]
+		op := buffer.NewEditOperation(ch.start, ch.end-ch.start, ch.text)
+		e.undo = append(e.undo, tree.ApplyEdit(op))
+	}
+
+	// 选区设置为最后一处修改后的文本
+	delta := 0
+	for _, ch := range e.changes[:len(e.changes)-1] {
+		delta += len(ch.text) - (ch.end - ch.start)
+	}
+	last := e.changes[len(e.changes)-1]
+	e.dot = Range{Start: last.start + delta, End: last.start + delta + len(last.text)}
+	e.changes = nil
+	return nil
+}
+
+// text 返回范围内的文本
+func (e *Engine) text(r Range) string {
+	return e.tree().GetValueInOffsetRange(r.Start, r.End)
+}
+
+// compile 编译正则表达式
+func compile(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("sam: empty regular expression")
+	}
+	re, err := regexp.Compile("(?m)" + pattern)
+	if err != nil {
+		return nil, fmt.Errorf("sam: invalid regular expression: %v", err)
+	}
+	return re, nil
+}
+
+// matches 返回 text 中所有匹配的位置，紧跟在上一个匹配之后的空匹配被跳过
+func matches(re *regexp.Regexp, text string) [][]int {
+	var result [][]int
+	prevEnd := -1
+	for _, loc := range re.FindAllStringSubmatchIndex(text, -1) {
+		if loc[0] == loc[1] && loc[0] == prevEnd {
+			continue
+		}
+		result = append(result, loc)
+		prevEnd = loc[1]
+	}
+	return result
+}
+
+// run 在选区 dot 上执行命令，返回命令执行后的选区
+func (e *Engine) run(c *cmd, dot Range) (Range, error) {
+	if c.addr != nil {
+		r, err := e.resolve(c.addr, dot)
+		if err != nil {
+			return dot, err
+		}
+		dot = r
+	}
+
+	switch c.op {
+	case 0:
+	case 'x', 'y':
+		re, err := compile(c.pattern)
+		if err != nil {
+			return dot, err
+		}
+		text := e.text(dot)
+		var ranges []Range
+		prev := 0
+		for _, loc := range matches(re, text) {
+			if c.op == 'x' {
+				ranges = append(ranges, Range{Start: dot.Start + loc[0], End: dot.Start + loc[1]})
+			} else {
+				ranges = append(ranges, Range{Start: dot.Start + prev, End: dot.Start + loc[0]})
+			}
+			prev = loc[1]
+		}
+		if c.op == 'y' {
+			ranges = append(ranges, Range{Start: dot.Start + prev, End: dot.End})
+		}
+		e.loops++
+		defer func() { e.loops-- }()
+		for _, r := range ranges {
+			if _, err := e.run(c.sub, r); err != nil {
+				return dot, err
+			}
+		}
+	case 'g', 'v':
+		re, err := compile(c.pattern)
+		if err != nil {
+			return dot, err
+		}
+		if re.MatchString(e.text(dot)) == (c.op == 'g') {
+			return e.run(c.sub, dot)
+		}
+	case 'a':
+		e.changes = append(e.changes, change{start: dot.End, end: dot.End, text: c.text})
+	case 'i':
+		e.changes = append(e.changes, change{start: dot.Start, end: dot.Start, text: c.text})
+	case 'c':
+		e.changes = append(e.changes, change{start: dot.Start, end: dot.End, text: c.text})
+	case 'd':
+		e.changes = append(e.changes, change{start: dot.Start, end: dot.End})
+	case 's':
+		re, err := compile(c.pattern)
+		if err != nil {
+			return dot, err
+		}
+		text := e.text(dot)
+		found := matches(re, text)
+		if len(found) == 0 {
+			if e.loops > 0 {
+				return dot, nil
+			}
+			return dot, fmt.Errorf("sam: no match for %s", c.pattern)
+		}
+		if !c.global {
+			found = found[:1]
+		}
+		for _, loc := range found {
+			e.changes = append(e.changes, change{
+				start: dot.Start + loc[0],
+				end:   dot.Start + loc[1],
+				text:  expand(c.text, text, loc),
+			})
+		}
+	case 'p':
+		e.write(e.text(dot))
+	case '=':
+		e.write(fmt.Sprintf("#%d,#%d\n", dot.Start, dot.End))
+	case '{':
+		// 组中的每个命令都在同一个选区上执行
+		for _, sub := range c.block {
+			if _, err := e.run(sub, dot); err != nil {
+				return dot, err
+			}
+		}
+	}
+	return dot, nil
+}
+
+// write 输出到 Output
+func (e *Engine) write(s string) {
+	if e.Output != nil {
+		io.WriteString(e.Output, s)
+	}
+}
+
+// expand 展开替换内容中的 &、\0-\9、\& 和 \\
+func expand(template, text string, loc []int) string {
+	group := func(i int) string {
+		if 2*i+1 < len(loc) && loc[2*i] >= 0 {
+			return text[loc[2*i]:loc[2*i+1]]
+		}
+		return ""
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		switch {
+		case c == '&':
+			sb.WriteString(group(0))
+		case c == '\\' && i+1 < len(template):
+			i++
+			if next := template[i]; next >= '0' && next <= '9' {
+				sb.WriteString(group(int(next - '0')))
+			} else {
+				sb.WriteByte(next)
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// resolve 计算地址对应的范围
+func (e *Engine) resolve(a *addr, dot Range) (Range, error) {
+	tree := e.tree()
+	switch a.kind {
+	case addrDot:
+		return dot, nil
+	case addrEnd:
+		n := tree.GetLength()
+		return Range{Start: n, End: n}, nil
+	case addrChar:
+		if a.n > tree.GetLength() {
+			return dot, fmt.Errorf("sam: address #%d out of range", a.n)
+		}
+		return Range{Start: a.n, End: a.n}, nil
+	case addrLine:
+		return e.lineRange(a.n)
+	case addrForward:
+		return e.search(a.pattern, dot.End, true)
+	case addrBackward:
+		return e.search(a.pattern, dot.Start, false)
+	case addrRelative:
+		return e.resolveRelative(a, dot)
+	case addrRange:
+		left := Range{}
+		if a.left != nil {
+			r, err := e.resolve(a.left, dot)
+			if err != nil {
+				return dot, err
+			}
+			left = r
+		}
+		if a.op == ';' {
+			dot = left
+		}
+		right := Range{Start: tree.GetLength(), End: tree.GetLength()}
+		if a.right != nil {
+			r, err := e.resolve(a.right, dot)
+			if err != nil {
+				return dot, err
+			}
+			right = r
+		}
+		if right.End < left.Start {
+			return dot, fmt.Errorf("sam: addresses out of order")
+		}
+		return Range{Start: left.Start, End: right.End}, nil
+	}
+	return dot, fmt.Errorf("sam: invalid address")
+}
+
+// resolveRelative 计算 a1+a2 或 a1-a2，行号和正则表达式从 a1 的末尾（或开头）开始
+func (e *Engine) resolveRelative(a *addr, dot Range) (Range, error) {
+	base := dot
+	if a.left != nil {
+		r, err := e.resolve(a.left, dot)
+		if err != nil {
+			return dot, err
+		}
+		base = r
+	}
+	right := a.right
+	if right == nil {
+		right = &addr{kind: addrLine, n: 1}
+	}
+
+	forward := a.op == '+'
+	switch right.kind {
+	case addrLine:
+		tree := e.tree()
+		var line int
+		if forward {
+			pos := tree.GetPositionAt(base.End)
+			line = pos.LineNumber
+			if pos.Column == 1 && base.End > base.Start {
+				// 选区以换行结束时，下一行从选区末尾开始
+				line--
+			}
+			line += right.n
+		} else {
+			line = tree.GetPositionAt(base.Start).LineNumber - right.n
+		}
+		return e.lineRange(line)
+	case addrForward, addrBackward:
+		if forward {
+			return e.search(right.pattern, base.End, true)
+		}
+		return e.search(right.pattern, base.Start, false)
+	case addrChar:
+		n := base.End + right.n
+		if !forward {
+			n = base.Start - right.n
+		}
+		if n < 0 || n > e.tree().GetLength() {
+			return dot, fmt.Errorf("sam: address out of range")
+		}
+		return Range{Start: n, End: n}, nil
+	}
+	return dot, fmt.Errorf("sam: invalid relative address")
+}
+
+// lineRange 返回第 n 行的范围（包含换行符），0 表示文件开头的空范围
+func (e *Engine) lineRange(n int) (Range, error) {
+	tree := e.tree()
+	if n == 0 {
+		return Range{}, nil
+	}
+	if n < 0 || n > tree.GetLineCount() {
+		return Range{}, fmt.Errorf("sam: line %d out of range", n)
+	}
+	start := tree.GetOffsetAt(n, 1)
+	end := tree.GetLength()
+	if n < tree.GetLineCount() {
+		end = tree.GetOffsetAt(n+1, 1)
+	}
+	return Range{Start: start, End: end}, nil
+}
+
+// search 从 from 开始向前（或向后）搜索，到达末尾时从另一端继续
+// 直接在片段树上搜索，不跨行的正则表达式逐行匹配，不需要复制整个文档；作为地址时空匹配被忽略
+func (e *Engine) search(pattern string, from int, forward bool) (Range, error) {
+	if _, err := compile(pattern); err != nil {
+		return Range{}, err
+	}
+	tree := e.tree()
+	opts := buffer.SearchOptions{IsRegex: true, MatchCase: true}
+	pos := *tree.GetPositionAt(from)
+	var match *buffer.FindMatch
+	var err error
+	if forward {
+		match, err = tree.FindNextMatch(pattern, pos, opts)
+	} else {
+		match, err = tree.FindPreviousMatch(pattern, pos, opts)
+	}
+	if err != nil {
+		return Range{}, fmt.Errorf("sam: invalid regular expression: %v", err)
+	}
+	if match == nil {
+		return Range{}, fmt.Errorf("sam: no match for %s", pattern)
+	}
+	return Range{Start: match.StartOffset, End: match.EndOffset}, nil
+}
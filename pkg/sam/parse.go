@@ -0,0 +1,353 @@
+package sam
+
+import (
+	"fmt"
+	"strings"
+)
+
+// addrKind 地址类型
+type addrKind int
+
+const (
+	// addrDot 当前选区 .
+	addrDot addrKind = iota
+	// addrEnd 文件末尾 $
+	addrEnd
+	// addrChar 字节偏移量 #n
+	addrChar
+	// addrLine 行号 n，0 表示文件开头
+	addrLine
+	// addrForward 向前搜索 /re/
+	addrForward
+	// addrBackward 向后搜索 ?re?
+	addrBackward
+	// addrRelative 相对地址 a1+a2 或 a1-a2
+	addrRelative
+	// addrRange 范围 a1,a2 或 a1;a2
+	addrRange
+)
+
+// addr 地址表达式
+type addr struct {
+	kind    addrKind
+	n       int
+	pattern string
+	// op 相对地址和范围的运算符：+ - , ;
+	op          byte
+	left, right *addr
+}
+
+// cmd 解析后的命令
+type cmd struct {
+	addr *addr
+	// op 命令字符，0 表示只有地址
+	op byte
+	// pattern x、y、g、v、s 命令的正则表达式
+	pattern string
+	// text a、c、i 命令的文本或 s 命令的替换内容
+	text string
+	// global s 命令是否替换所有匹配
+	global bool
+	// sub x、y、g、v 命令对每个选区执行的命令
+	sub *cmd
+	// block { } 中的命令
+	block []*cmd
+}
+
+// parser 命令解析器
+type parser struct {
+	src string
+	pos int
+}
+
+// errorf 返回带位置信息的解析错误
+func (p *parser) errorf(format string, args ...interface{}) error {
+	rest := p.src[p.pos:]
+	if i := strings.IndexByte(rest, '\n'); i >= 0 {
+		rest = rest[:i]
+	}
+	return fmt.Errorf("sam: %s (at %q)", fmt.Sprintf(format, args...), rest)
+}
+
+// peek 返回当前字符，到达末尾时返回 0
+func (p *parser) peek() byte {
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// skipSpaces 跳过空格和制表符
+func (p *parser) skipSpaces() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// skipBlank 跳过空白字符和换行
+func (p *parser) skipBlank() {
+	for p.pos < len(p.src) && strings.IndexByte(" \t\r\n", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// parseScript 解析脚本
+func parseScript(src string) ([]*cmd, error) {
+	p := &parser{src: src}
+	var commands []*cmd
+	for {
+		p.skipBlank()
+		if p.pos >= len(p.src) {
+			return commands, nil
+		}
+		if p.peek() == '}' {
+			return nil, p.errorf("unexpected }")
+		}
+		c, err := p.parseCmd()
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, c)
+	}
+}
+
+// parseCmd 解析一个命令
+func (p *parser) parseCmd() (*cmd, error) {
+	p.skipSpaces()
+	a, err := p.parseAddr()
+	if err != nil {
+		return nil, err
+	}
+	c := &cmd{addr: a}
+	p.skipSpaces()
+
+	op := p.peek()
+	switch op {
+	case 0, '\n', '\r', '}':
+		if a == nil {
+			return nil, p.errorf("missing command")
+		}
+		return c, nil
+	}
+	p.pos++
+	c.op = op
+
+	switch op {
+	case 'x', 'y', 'g', 'v':
+		p.skipSpaces()
+		if d := p.peek(); d != 0 && d != ' ' && d != '{' && !isCommandChar(d) {
+			p.pos++
+			c.pattern = p.parseDelimited(d)
+			if p.peek() == d {
+				p.pos++
+			}
+		} else if op == 'x' {
+			// 没有正则表达式的 x 遍历每一行
+			c.pattern = ".*\n"
+		} else {
+			return nil, p.errorf("%c requires a regular expression", op)
+		}
+		p.skipSpaces()
+		if c.sub, err = p.parseCmd(); err != nil {
+			return nil, err
+		}
+	case 'a', 'c', 'i':
+		text, err := p.parseText()
+		if err != nil {
+			return nil, err
+		}
+		c.text = text
+	case 's':
+		d := p.peek()
+		if d == 0 || d == ' ' || d == '\n' || d == '\\' {
+			return nil, p.errorf("s requires a delimiter")
+		}
+		p.pos++
+		c.pattern = p.parseDelimited(d)
+		if p.peek() != d {
+			return nil, p.errorf("unterminated s command")
+		}
+		p.pos++
+		c.text = unescapeText(p.parseDelimited(d), true)
+		if p.peek() == d {
+			p.pos++
+		}
+		if p.peek() == 'g' {
+			c.global = true
+			p.pos++
+		}
+	case '{':
+		for {
+			p.skipBlank()
+			if p.peek() == '}' {
+				p.pos++
+				break
+			}
+			if p.pos >= len(p.src) {
+				return nil, p.errorf("missing }")
+			}
+			sub, err := p.parseCmd()
+			if err != nil {
+				return nil, err
+			}
+			c.block = append(c.block, sub)
+		}
+	case 'd', 'p', '=':
+	default:
+		p.pos--
+		return nil, p.errorf("unknown command %q", op)
+	}
+	return c, nil
+}
+
+// isCommandChar 判断字符是否为命令
+func isCommandChar(c byte) bool {
+	return strings.IndexByte("xygvacisdp={", c) >= 0
+}
+
+// parseText 解析 a、c、i 命令的 /text/
+func (p *parser) parseText() (string, error) {
+	d := p.peek()
+	if d == 0 || d == ' ' || d == '\n' || d == '\\' {
+		return "", p.errorf("missing text delimiter")
+	}
+	p.pos++
+	text := p.parseDelimited(d)
+	if p.peek() == d {
+		p.pos++
+	}
+	return unescapeText(text, false), nil
+}
+
+// parseDelimited 读取直到未转义的分隔符或行尾，转义的分隔符被还原，其它转义原样保留
+func (p *parser) parseDelimited(delim byte) string {
+	var sb strings.Builder
+	for p.pos < len(p.src) && p.src[p.pos] != delim && p.src[p.pos] != '\n' {
+		c := p.src[p.pos]
+		if c == '\\' && p.pos+1 < len(p.src) && p.src[p.pos+1] == delim {
+			if strings.IndexByte(`.+*?()|[]{}^$`, delim) >= 0 {
+				sb.WriteByte('\\')
+			}
+			sb.WriteByte(delim)
+			p.pos += 2
+			continue
+		}
+		if c == '\\' && p.pos+1 < len(p.src) {
+			sb.WriteByte(c)
+			sb.WriteByte(p.src[p.pos+1])
+			p.pos += 2
+			continue
+		}
+		sb.WriteByte(c)
+		p.pos++
+	}
+	return sb.String()
+}
+
+// unescapeText 展开文本中的 \n、\t 和 \\
+// replacement 为 true 时 \0-\9、\& 和 \\ 保留给替换时处理
+func unescapeText(text string, replacement bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c != '\\' || i+1 >= len(text) {
+			sb.WriteByte(c)
+			continue
+		}
+		i++
+		switch next := text[i]; {
+		case next == 'n':
+			sb.WriteByte('\n')
+		case next == 't':
+			sb.WriteByte('\t')
+		case replacement && (next == '\\' || next == '&' || (next >= '0' && next <= '9')):
+			sb.WriteByte('\\')
+			sb.WriteByte(next)
+		default:
+			sb.WriteByte(next)
+		}
+	}
+	return sb.String()
+}
+
+// parseAddr 解析地址，a1,a2 和 a1;a2 是右结合的
+func (p *parser) parseAddr() (*addr, error) {
+	left, err := p.parseRelative()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if c := p.peek(); c == ',' || c == ';' {
+		p.pos++
+		right, err := p.parseAddr()
+		if err != nil {
+			return nil, err
+		}
+		return &addr{kind: addrRange, op: c, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+// parseRelative 解析 a1+a2 和 a1-a2
+func (p *parser) parseRelative() (*addr, error) {
+	left, err := p.parseSimple()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		p.skipSpaces()
+		c := p.peek()
+		if c != '+' && c != '-' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseSimple()
+		if err != nil {
+			return nil, err
+		}
+		left = &addr{kind: addrRelative, op: c, left: left, right: right}
+	}
+}
+
+// parseSimple 解析简单地址，没有地址时返回 nil
+func (p *parser) parseSimple() (*addr, error) {
+	p.skipSpaces()
+	switch c := p.peek(); {
+	case c == '.':
+		p.pos++
+		return &addr{kind: addrDot}, nil
+	case c == '$':
+		p.pos++
+		return &addr{kind: addrEnd}, nil
+	case c == '#':
+		p.pos++
+		if d := p.peek(); d < '0' || d > '9' {
+			return nil, p.errorf("# requires a number")
+		}
+		return &addr{kind: addrChar, n: p.parseNumber()}, nil
+	case c >= '0' && c <= '9':
+		return &addr{kind: addrLine, n: p.parseNumber()}, nil
+	case c == '/' || c == '?':
+		p.pos++
+		pattern := p.parseDelimited(c)
+		if p.peek() == c {
+			p.pos++
+		}
+		kind := addrForward
+		if c == '?' {
+			kind = addrBackward
+		}
+		return &addr{kind: kind, pattern: pattern}, nil
+	}
+	return nil, nil
+}
+
+// parseNumber 解析十进制数
+func (p *parser) parseNumber() int {
+	n := 0
+	for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+		n = n*10 + int(p.src[p.pos]-'0')
+		p.pos++
+	}
+	return n
+}
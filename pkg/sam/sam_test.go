@@ -0,0 +1,101 @@
+package sam
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+	"github.com/kebaren/textbuffer/pkg/buffer/buffertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newEngine(text string) *Engine {
+	return NewEngine(buffer.NewHistory(buffertest.NewTree(text), 0))
+}
+
+func content(e *Engine) string {
+	return e.tree().GetLinesRawContent()
+}
+
+func TestCommands(t *testing.T) {
+	cases := []struct {
+		name     string
+		text     string
+		script   string
+		expected string
+	}{
+		{"rename identifiers", "n := n + 1 // no\n", `,x/[a-z]+/g/^n$/c/count/`, "count := count + 1 // no\n"},
+		{"delete matching lines", "a\nb\na2\n", `,x/.*\n/g/a/d`, "b\n"},
+		{"x without regex loops over lines", "a\nb\nc\n", `, x v/b/ d`, "b\n"},
+		{"y keeps separators", "a,b,c", `,y/,/c/X/`, "X,X,X"},
+		{"block wraps matches", "foo bar foo", `,x/foo/{i/</ a/>/}`, "<foo> bar <foo>"},
+		{"insert before each line", "a\nb\n", `,x/.*\n/i/> /`, "> a\n> b\n"},
+		{"append to lines", "a\nb\n", ",x/.+/a/;/", "a;\nb;\n"},
+		{"substitute with groups", "key=value\n", `,s/(\w+)=(\w+)/\2=\1 [&]/`, "value=key [key=value]\n"},
+		{"substitute all", "aaa\n", ",s/a/b/g", "bbb\n"},
+		{"line address", "1\n2\n3\n", "2d", "1\n3\n"},
+		{"line range", "1\n2\n3\n4\n", "2,3c/x\\n/", "1\nx\n4\n"},
+		{"relative lines", "1\n2\n3\n4\n", "2+d", "1\n2\n4\n"},
+		{"search range", "start\nx\nend\ny\n", "/start/+1,/end/-1d", "start\nend\ny\n"},
+		{"char address", "hello", "#1,#3d", "hlo"},
+		{"end address", "hello", "$a/!/", "hello!"},
+		{"two top level commands see each other", "a b\n", ",x/a/c/b/\n,x/b/c/c/", "c c\n"},
+		{"nested loops", "f(a, b)\ng(c)\n", `,x/\(.*\)/x/[a-z]/c/X/`, "f(X, X)\ng(X)\n"},
+		{"s without a match inside a loop does nothing", "ab\ncd\nab\n", `,x/.*\n/s/a/A/`, "Ab\ncd\nAb\n"},
+		{"backward search", "x1\ny\nx2\n", "$-/x/d", "x1\ny\n2\n"},
+		{"search wraps around", "a\nb\n", "/b/+/a/d", "\nb\n"},
+		{"multi-line search", "a\nb\nc\n", `/b\nc/d`, "a\n\n"},
+		{"v inverts", "keep 1\ndrop\nkeep 2\n", ",x/.*\\n/v/keep/d", "keep 1\nkeep 2\n"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			e := newEngine(c.text)
+			require.NoError(t, e.Execute(c.script))
+			assert.Equal(t, c.expected, content(e))
+		})
+	}
+}
+
+func TestDotFollowsLastChange(t *testing.T) {
+	e := newEngine("one two three")
+	require.NoError(t, e.Execute(",x/t[a-z]+/c/X/"))
+	assert.Equal(t, "one X X", content(e))
+	assert.Equal(t, Range{Start: 6, End: 7}, e.Dot())
+
+	require.NoError(t, e.Execute("/one/"))
+	assert.Equal(t, Range{Start: 0, End: 3}, e.Dot())
+	require.NoError(t, e.Execute("c/1/"))
+	assert.Equal(t, "1 X X", content(e))
+}
+
+func TestScriptIsAtomic(t *testing.T) {
+	e := newEngine("aaa\n")
+	require.NoError(t, e.Execute(",x/a/c/bb/\n,x/b/c/c/"))
+	assert.Equal(t, "cccccc\n", content(e))
+	require.NotNil(t, e.history.Undo())
+	assert.Equal(t, "aaa\n", content(e))
+
+	for _, script := range []string{
+		",x/a/c/b/\n,x/(/d",
+		",{c/x/ x/a/d}",
+		"5d",
+		",x/(/d",
+		",q",
+		",x/a/{d",
+		",s/z/y/",
+	} {
+		assert.Error(t, e.Execute(script), script)
+		assert.Equal(t, "aaa\n", content(e), script)
+	}
+	assert.False(t, e.history.CanUndo())
+}
+
+func TestPrint(t *testing.T) {
+	e := newEngine("alpha\nbeta\n")
+	var out bytes.Buffer
+	e.Output = &out
+	require.NoError(t, e.Execute(",x/[a-z]+/g/t/{p =}"))
+	assert.Equal(t, "beta#6,#10\n", out.String())
+}
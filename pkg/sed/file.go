@@ -0,0 +1,82 @@
+package sed
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+	"github.com/kebaren/textbuffer/pkg/fsutil"
+)
+
+// chunkSize 读取文件时每个文本块的大小
+const chunkSize = 64 * 1024
+
+// ReadTree 分块读取内容并构建片段树，返回片段树和被去掉的 BOM
+func ReadTree(r io.Reader) (*buffer.PieceTreeBase, string, error) {
+	builder := buffer.NewPieceTreeTextBufferBuilder()
+	chunk := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(r, chunk)
+		if n > 0 {
+			builder.AcceptChunk(string(chunk[:n]))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	return builder.Finish(false).Create(buffer.LF), builder.BOM, nil
+}
+
+// WriteTree 按片段写出片段树的内容，内容非空时先写出 BOM
+func WriteTree(w io.Writer, tree *buffer.PieceTreeBase, bom string) error {
+	if tree.GetLength() == 0 {
+		return nil
+	}
+	bw := bufio.NewWriter(w)
+	bw.WriteString(bom)
+	tree.Iterate(tree.Root, func(node *buffer.TreeNode) bool {
+		bw.WriteString(tree.GetPieceContent(node.Piece))
+		return true
+	})
+	return bw.Flush()
+}
+
+// EditFile 对文件执行脚本并原地写回，保留原来的换行符、BOM 和权限
+// backupSuffix 非空时把原文件保存为备份，其中的 * 被替换为文件名，否则追加在文件名之后
+func EditFile(path string, prog *Program, backupSuffix string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	tree, bom, err := ReadTree(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	prog.Apply(tree)
+
+	var backup func(string) string
+	if backupSuffix != "" {
+		backup = func(target string) string {
+			return backupPath(target, backupSuffix)
+		}
+	}
+	return fsutil.WriteFileFunc(path, func(w io.Writer) error {
+		return WriteTree(w, tree, bom)
+	}, backup)
+}
+
+// backupPath 返回备份文件的路径
+func backupPath(path, suffix string) string {
+	dir, base := filepath.Split(path)
+	if !strings.Contains(suffix, "*") {
+		return path + suffix
+	}
+	return filepath.Join(dir, strings.ReplaceAll(suffix, "*", base))
+}
@@ -0,0 +1,544 @@
+package sed
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// addrKind 地址类型
+type addrKind int
+
+const (
+	// addrLine 行号
+	addrLine addrKind = iota
+	// addrLast 最后一行 $
+	addrLast
+	// addrRegex 匹配正则表达式的行 /re/
+	addrRegex
+	// addrStep 范围的第二个地址 +n，表示之后的 n 行
+	addrStep
+)
+
+// address 一个行地址
+type address struct {
+	kind addrKind
+	n    int
+	// re 为 nil 时表示空正则 //，使用最近一次使用的正则表达式
+	re *regexp.Regexp
+}
+
+// command 解析后的命令
+type command struct {
+	addr1, addr2 *address
+	// negate 地址后的 ! 表示选择不匹配的行
+	negate bool
+	op     byte
+
+	// re s 命令的正则表达式，nil 表示使用最近一次使用的正则表达式
+	re *regexp.Regexp
+	// replacement s 命令的替换内容
+	replacement []replacePart
+	// global s 命令的 g 标志
+	global bool
+	// occurrence s 命令从第几个匹配开始替换，默认为 1
+	occurrence int
+	// print s 命令的 p 标志
+	print bool
+	// text a、i、c 命令的文本
+	text string
+	// from、to y 命令的字符映射
+	from, to []rune
+	// block { } 中的命令
+	block []*command
+
+	// 范围地址的运行状态
+	active  bool
+	endLine int
+}
+
+// replacePart 替换内容的一部分：字面文本或分组引用
+type replacePart struct {
+	literal string
+	// group 分组编号，-1 表示字面文本，0 表示整个匹配 &
+	group int
+}
+
+// parser 脚本解析器
+type parser struct {
+	src      string
+	pos      int
+	extended bool
+}
+
+// errorf 返回带位置信息的解析错误
+func (p *parser) errorf(format string, args ...interface{}) error {
+	rest := p.src[p.pos:]
+	if i := strings.IndexByte(rest, '\n'); i >= 0 {
+		rest = rest[:i]
+	}
+	return fmt.Errorf("sed: %s (at %q)", fmt.Sprintf(format, args...), rest)
+}
+
+// peek 返回当前字符，到达末尾时返回 0
+func (p *parser) peek() byte {
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// skipSpaces 跳过空格和制表符
+func (p *parser) skipSpaces() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// parseCommands 解析命令直到脚本末尾，inBlock 为 true 时遇到 } 结束
+func (p *parser) parseCommands(inBlock bool) ([]*command, error) {
+	var commands []*command
+	for {
+		for p.pos < len(p.src) && strings.IndexByte(" \t\r\n;", p.src[p.pos]) >= 0 {
+			p.pos++
+		}
+		switch p.peek() {
+		case 0:
+			if inBlock {
+				return nil, p.errorf("missing }")
+			}
+			return commands, nil
+		case '}':
+			if !inBlock {
+				return nil, p.errorf("unexpected }")
+			}
+			p.pos++
+			return commands, nil
+		case '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+		c, err := p.parseCommand()
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, c)
+	}
+}
+
+// parseCommand 解析一个带地址的命令
+func (p *parser) parseCommand() (*command, error) {
+	c := &command{occurrence: 1}
+	var err error
+	if c.addr1, err = p.parseAddress(false); err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if c.addr1 != nil && p.peek() == ',' {
+		p.pos++
+		p.skipSpaces()
+		if c.addr2, err = p.parseAddress(true); err != nil {
+			return nil, err
+		}
+		if c.addr2 == nil {
+			return nil, p.errorf("missing second address")
+		}
+	}
+	p.skipSpaces()
+	for p.peek() == '!' {
+		c.negate = true
+		p.pos++
+		p.skipSpaces()
+	}
+
+	c.op = p.peek()
+	if c.op == 0 {
+		return nil, p.errorf("missing command")
+	}
+	p.pos++
+	switch c.op {
+	case '{':
+		if c.block, err = p.parseCommands(true); err != nil {
+			return nil, err
+		}
+		return c, nil
+	case 'd', 'p':
+	case 'a', 'i', 'c':
+		c.text = p.parseText()
+		return c, nil
+	case 's':
+		if err := p.parseSubstitute(c); err != nil {
+			return nil, err
+		}
+	case 'y':
+		if err := p.parseTransliterate(c); err != nil {
+			return nil, err
+		}
+	default:
+		p.pos--
+		return nil, p.errorf("unknown command %q", c.op)
+	}
+	return c, p.endCommand()
+}
+
+// endCommand 检查命令之后只有空白、;、}、注释或换行
+func (p *parser) endCommand() error {
+	p.skipSpaces()
+	switch p.peek() {
+	case 0, '\n', '\r', ';', '}', '#':
+		return nil
+	}
+	return p.errorf("extra characters after command")
+}
+
+// parseAddress 解析一个地址，没有地址时返回 nil
+// second 为 true 时允许 +n 形式
+func (p *parser) parseAddress(second bool) (*address, error) {
+	switch c := p.peek(); {
+	case c >= '0' && c <= '9':
+		return &address{kind: addrLine, n: p.parseNumber()}, nil
+	case c == '$':
+		p.pos++
+		return &address{kind: addrLast}, nil
+	case c == '+' && second:
+		p.pos++
+		if d := p.peek(); d < '0' || d > '9' {
+			return nil, p.errorf("+ requires a number")
+		}
+		return &address{kind: addrStep, n: p.parseNumber()}, nil
+	case c == '/' || c == '\\':
+		p.pos++
+		delim := c
+		if c == '\\' {
+			if delim = p.peek(); delim == 0 || delim == '\n' || delim == '\\' {
+				return nil, p.errorf("invalid address delimiter")
+			}
+			p.pos++
+		}
+		pattern, ok := p.parseDelimited(delim, true)
+		if !ok {
+			return nil, p.errorf("unterminated address regex")
+		}
+		ignoreCase := false
+		if p.peek() == 'I' {
+			ignoreCase = true
+			p.pos++
+		}
+		re, err := p.compile(pattern, ignoreCase)
+		if err != nil {
+			return nil, err
+		}
+		return &address{kind: addrRegex, re: re}, nil
+	}
+	return nil, nil
+}
+
+// parseSubstitute 解析 s/regex/replacement/flags
+func (p *parser) parseSubstitute(c *command) error {
+	delim := p.peek()
+	if delim == 0 || delim == '\n' || delim == '\\' {
+		return p.errorf("s requires a delimiter")
+	}
+	p.pos++
+	pattern, ok := p.parseDelimited(delim, true)
+	if !ok {
+		return p.errorf("unterminated s command")
+	}
+	replacement, ok := p.parseDelimited(delim, false)
+	if !ok {
+		return p.errorf("unterminated s command")
+	}
+
+	ignoreCase := false
+	for {
+		switch f := p.peek(); {
+		case f == 'g':
+			c.global = true
+		case f == 'p':
+			c.print = true
+		case f == 'i' || f == 'I':
+			ignoreCase = true
+		case f >= '1' && f <= '9':
+			c.occurrence = p.parseNumber()
+			continue
+		default:
+			re, err := p.compile(pattern, ignoreCase)
+			if err != nil {
+				return err
+			}
+			c.re = re
+			c.replacement = parseReplacement(replacement)
+			return nil
+		}
+		p.pos++
+	}
+}
+
+// parseTransliterate 解析 y/source/dest/
+func (p *parser) parseTransliterate(c *command) error {
+	delim := p.peek()
+	if delim == 0 || delim == '\n' || delim == '\\' {
+		return p.errorf("y requires a delimiter")
+	}
+	p.pos++
+	from, ok := p.parseDelimited(delim, false)
+	if !ok {
+		return p.errorf("unterminated y command")
+	}
+	to, ok := p.parseDelimited(delim, false)
+	if !ok {
+		return p.errorf("unterminated y command")
+	}
+	c.from = []rune(unescapeText(from))
+	c.to = []rune(unescapeText(to))
+	if len(c.from) != len(c.to) {
+		return p.errorf("y strings have different lengths")
+	}
+	return nil
+}
+
+// parseDelimited 读取直到未转义的分隔符并跳过分隔符
+// 转义的分隔符表示分隔符字符本身，其它转义原样保留
+func (p *parser) parseDelimited(delim byte, isRegex bool) (string, bool) {
+	var sb strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == delim:
+			p.pos++
+			return sb.String(), true
+		case c == '\n':
+			return "", false
+		case c == '\\' && p.pos+1 < len(p.src):
+			next := p.src[p.pos+1]
+			switch {
+			case next == delim && isRegex && strings.IndexByte(`]^`, delim) >= 0:
+				sb.WriteByte('\\')
+				sb.WriteByte(delim)
+			case next == delim && isRegex && strings.IndexByte(`.[*$+?(){}|`, delim) >= 0:
+				// 放进方括号，在 BRE 和 ERE 中都是字面字符
+				sb.WriteByte('[')
+				sb.WriteByte(delim)
+				sb.WriteByte(']')
+			case next == delim:
+				sb.WriteByte(delim)
+			case next == '\n':
+				// 反斜杠加换行表示字面换行
+				sb.WriteString(`\n`)
+			default:
+				sb.WriteByte(c)
+				sb.WriteByte(next)
+			}
+			p.pos += 2
+		default:
+			sb.WriteByte(c)
+			p.pos++
+		}
+	}
+	return "", false
+}
+
+// parseText 解析 a、i、c 命令的文本
+// 支持 a\<换行>text 和 GNU 的单行形式 a text，行尾的反斜杠表示文本继续到下一行
+func (p *parser) parseText() string {
+	p.skipSpaces()
+	if p.peek() == '\\' {
+		p.pos++
+		if p.peek() == '\r' {
+			p.pos++
+		}
+		if p.peek() == '\n' {
+			p.pos++
+		} else {
+			p.skipSpaces()
+		}
+	}
+
+	var sb strings.Builder
+	for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+		c := p.src[p.pos]
+		if c == '\\' && p.pos+1 < len(p.src) {
+			p.pos++
+			c = p.src[p.pos]
+			switch c {
+			case 't':
+				c = '\t'
+			case 'n':
+				c = '\n'
+			}
+		}
+		sb.WriteByte(c)
+		p.pos++
+	}
+	return strings.TrimRight(sb.String(), "\r")
+}
+
+// parseNumber 解析十进制数
+func (p *parser) parseNumber() int {
+	n := 0
+	for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+		n = n*10 + int(p.src[p.pos]-'0')
+		p.pos++
+	}
+	return n
+}
+
+// compile 编译正则表达式，空模式返回 nil
+func (p *parser) compile(pattern string, ignoreCase bool) (*regexp.Regexp, error) {
+	if pattern == "" {
+		if ignoreCase {
+			return nil, p.errorf("empty regex cannot have flags")
+		}
+		return nil, nil
+	}
+	expr, err := translateRegex(pattern, p.extended)
+	if err != nil {
+		return nil, fmt.Errorf("sed: %v", err)
+	}
+	if ignoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("sed: invalid regex %q: %v", pattern, err)
+	}
+	return re, nil
+}
+
+// translateRegex 把 POSIX 正则表达式转换为 Go 的语法
+// 基本正则表达式（BRE）中 \( \) \{ \} \+ \? \| 是元字符，而不带反斜杠的形式是字面字符
+// 扩展正则表达式（ERE）与 Go 的语法基本相同，两者都把 \< \> 转换为 \b
+func translateRegex(pattern string, extended bool) (string, error) {
+	var sb strings.Builder
+	// atStart 当前位置是否在表达式或分组的开头，BRE 中这里的 * 是字面字符
+	atStart := true
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '[':
+			end := bracketEnd(pattern, i)
+			if end < 0 {
+				return "", fmt.Errorf("unterminated bracket expression in %q", pattern)
+			}
+			sb.WriteString(pattern[i : end+1])
+			i = end
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			next := pattern[i]
+			switch {
+			case next >= '1' && next <= '9':
+				return "", fmt.Errorf("backreferences are not supported in %q", pattern)
+			case next == '<' || next == '>':
+				sb.WriteString(`\b`)
+			case !extended && strings.IndexByte("(){}+?|", next) >= 0:
+				sb.WriteByte(next)
+				atStart = next == '(' || next == '|'
+				continue
+			default:
+				sb.WriteByte('\\')
+				sb.WriteByte(next)
+			}
+		case !extended && strings.IndexByte("(){}+?|", c) >= 0:
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case !extended && c == '*' && atStart:
+			sb.WriteString(`\*`)
+		default:
+			sb.WriteByte(c)
+			if extended {
+				atStart = false
+				continue
+			}
+			// ^ 之后仍然是表达式的开头
+			atStart = c == '^' && atStart
+			continue
+		}
+		atStart = false
+	}
+	return sb.String(), nil
+}
+
+// bracketEnd 返回从 start 开始的方括号表达式的结束位置，没有结束时返回 -1
+func bracketEnd(pattern string, start int) int {
+	i := start + 1
+	if i < len(pattern) && pattern[i] == '^' {
+		i++
+	}
+	// 开头的 ] 是字面字符
+	if i < len(pattern) && pattern[i] == ']' {
+		i++
+	}
+	for ; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '[':
+			// [:class:]、[=x=] 和 [.x.]
+			if i+1 < len(pattern) && strings.IndexByte(":=.", pattern[i+1]) >= 0 {
+				closing := string(pattern[i+1]) + "]"
+				if j := strings.Index(pattern[i+2:], closing); j >= 0 {
+					i += 2 + j + 1
+				}
+			}
+		case ']':
+			return i
+		}
+	}
+	return -1
+}
+
+// parseReplacement 解析替换内容中的 &、\0-\9、\n、\t 和转义字符
+func parseReplacement(text string) []replacePart {
+	var parts []replacePart
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() > 0 {
+			parts = append(parts, replacePart{literal: literal.String(), group: -1})
+			literal.Reset()
+		}
+	}
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '&':
+			flush()
+			parts = append(parts, replacePart{group: 0})
+		case c == '\\' && i+1 < len(text):
+			i++
+			switch next := text[i]; {
+			case next >= '0' && next <= '9':
+				flush()
+				parts = append(parts, replacePart{group: int(next - '0')})
+			case next == 'n':
+				literal.WriteByte('\n')
+			case next == 't':
+				literal.WriteByte('\t')
+			default:
+				literal.WriteByte(next)
+			}
+		default:
+			literal.WriteByte(c)
+		}
+	}
+	flush()
+	return parts
+}
+
+// unescapeText 展开 y 命令参数中的 \n、\t 和 \\
+func unescapeText(text string) string {
+	var sb strings.Builder
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c == '\\' && i+1 < len(text) {
+			i++
+			switch c = text[i]; c {
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			}
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
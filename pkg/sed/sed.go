@@ -0,0 +1,266 @@
+package sed
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+)
+
+// Options 编译脚本的选项
+type Options struct {
+	// Quiet 不自动输出模式空间，相当于 -n
+	Quiet bool
+	// Extended 使用扩展正则表达式，相当于 -E
+	Extended bool
+}
+
+// Program 编译后的 sed 脚本
+type Program struct {
+	commands []*command
+	quiet    bool
+}
+
+// Compile 编译 sed 脚本，脚本的第一行为 #n 时等价于 -n
+func Compile(script string, opts Options) (*Program, error) {
+	p := &parser{src: script, extended: opts.Extended}
+	commands, err := p.parseCommands(false)
+	if err != nil {
+		return nil, err
+	}
+	quiet := opts.Quiet || script == "#n" || strings.HasPrefix(script, "#n\n")
+	return &Program{commands: commands, quiet: quiet}, nil
+}
+
+// run 一次执行的状态
+type run struct {
+	// line 当前行号，lines 总行数（末尾换行符之后的空行不算）
+	line, lines int
+	// lastRegex 最近一次使用的正则表达式，供空正则 // 使用
+	lastRegex *regexp.Regexp
+
+	// 当前周期的状态
+	space    string
+	out      []string
+	appended []string
+	deleted  bool
+}
+
+// Apply 对片段树中的每一行执行脚本，只修改输出与原文不同的行，返回按撤销顺序排列的逆操作
+// 输出行之间使用该行原来的换行符，没有换行符的最后一行输出后仍然没有换行符
+func (prog *Program) Apply(tree *buffer.PieceTreeBase) []buffer.EditOperation {
+	for _, c := range prog.commands {
+		resetRanges(c)
+	}
+	r := &run{lines: tree.GetLineCount()}
+	length := tree.GetLength()
+	if length == 0 {
+		return nil
+	}
+	if tree.GetLineLength(r.lines) == 0 {
+		// 文件以换行符结尾，最后的空行不是一行
+		r.lines--
+	}
+
+	var ops []buffer.EditOperation
+	for r.line = 1; r.line <= r.lines; r.line++ {
+		start := tree.GetOffsetAt(r.line, 1)
+		end := length
+		if r.line < tree.GetLineCount() {
+			end = tree.GetOffsetAt(r.line+1, 1)
+		}
+		raw := tree.GetValueInOffsetRange(start, end)
+		content, eol := splitEOL(raw)
+
+		r.space = content
+		r.out = r.out[:0]
+		r.appended = r.appended[:0]
+		r.deleted = false
+		r.execute(prog.commands)
+		if !r.deleted && !prog.quiet {
+			r.out = append(r.out, r.space)
+		}
+		r.out = append(r.out, r.appended...)
+
+		lineEOL := eol
+		if lineEOL == "" {
+			lineEOL = tree.GetEOL()
+		}
+		text := strings.Join(r.out, "\n")
+		if lineEOL != "\n" {
+			text = strings.ReplaceAll(text, "\n", lineEOL)
+		}
+		if len(r.out) > 0 {
+			text += eol
+		}
+		if text != raw {
+			ops = append(ops, buffer.NewEditOperation(start, len(raw), text))
+		}
+	}
+
+	// 从后往前应用，前面的偏移量不受影响
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return tree.ApplyEdits(ops)
+}
+
+// resetRanges 清除范围地址的状态
+func resetRanges(c *command) {
+	c.active = false
+	for _, sub := range c.block {
+		resetRanges(sub)
+	}
+}
+
+// splitEOL 把一行的原始内容拆分为内容和换行符
+func splitEOL(raw string) (string, string) {
+	switch {
+	case strings.HasSuffix(raw, "\r\n"):
+		return raw[:len(raw)-2], "\r\n"
+	case strings.HasSuffix(raw, "\n"), strings.HasSuffix(raw, "\r"):
+		return raw[:len(raw)-1], raw[len(raw)-1:]
+	}
+	return raw, ""
+}
+
+// execute 执行命令列表，返回 false 表示结束当前周期
+func (r *run) execute(commands []*command) bool {
+	for _, c := range commands {
+		selected, last := r.selects(c)
+		if !selected {
+			continue
+		}
+		switch c.op {
+		case '{':
+			if !r.execute(c.block) {
+				return false
+			}
+		case 'd':
+			r.deleted = true
+			return false
+		case 'p':
+			r.out = append(r.out, r.space)
+		case 'i':
+			r.out = append(r.out, c.text)
+		case 'a':
+			r.appended = append(r.appended, c.text)
+		case 'c':
+			// 范围内只在最后一行输出文本
+			if last {
+				r.out = append(r.out, c.text)
+			}
+			r.deleted = true
+			return false
+		case 's':
+			if r.substitute(c) && c.print {
+				r.out = append(r.out, r.space)
+			}
+		case 'y':
+			r.space = strings.Map(func(ch rune) rune {
+				for i, from := range c.from {
+					if ch == from {
+						return c.to[i]
+					}
+				}
+				return ch
+			}, r.space)
+		}
+	}
+	return true
+}
+
+// selects 判断命令是否选中当前行，last 表示当前行是范围的最后一行
+func (r *run) selects(c *command) (selected, last bool) {
+	switch {
+	case c.addr1 == nil:
+		selected, last = true, true
+	case c.addr2 == nil:
+		selected, last = r.matches(c.addr1), true
+	case c.active:
+		selected = true
+		switch c.addr2.kind {
+		case addrLine, addrStep:
+			last = r.line >= c.endLine
+		default:
+			last = r.matches(c.addr2)
+		}
+		c.active = !last
+	case r.matches(c.addr1):
+		selected = true
+		switch c.addr2.kind {
+		case addrLine:
+			c.endLine = c.addr2.n
+			last = r.line >= c.endLine
+		case addrStep:
+			c.endLine = r.line + c.addr2.n
+			last = c.addr2.n == 0
+		case addrLast:
+			last = r.line == r.lines
+		default:
+			// 第二个正则地址从下一行开始检查
+			last = r.line == r.lines
+		}
+		c.active = !last
+	}
+	if c.negate {
+		return !selected, true
+	}
+	return selected, last
+}
+
+// matches 判断当前行是否匹配地址
+func (r *run) matches(a *address) bool {
+	switch a.kind {
+	case addrLine:
+		return r.line == a.n
+	case addrLast:
+		return r.line == r.lines
+	case addrRegex:
+		re := r.regex(a.re)
+		return re != nil && re.MatchString(r.space)
+	}
+	return false
+}
+
+// regex 返回要使用的正则表达式并记录为最近一次使用的正则表达式
+func (r *run) regex(re *regexp.Regexp) *regexp.Regexp {
+	if re == nil {
+		return r.lastRegex
+	}
+	r.lastRegex = re
+	return re
+}
+
+// substitute 执行 s 命令，返回是否发生了替换
+func (r *run) substitute(c *command) bool {
+	re := r.regex(c.re)
+	if re == nil {
+		return false
+	}
+	matches := re.FindAllStringSubmatchIndex(r.space, -1)
+	var sb strings.Builder
+	prev, count, replaced := 0, 0, false
+	for _, m := range matches {
+		count++
+		if count < c.occurrence || (replaced && !c.global) {
+			continue
+		}
+		sb.WriteString(r.space[prev:m[0]])
+		for _, part := range c.replacement {
+			if part.group < 0 {
+				sb.WriteString(part.literal)
+			} else if 2*part.group+1 < len(m) && m[2*part.group] >= 0 {
+				sb.WriteString(r.space[m[2*part.group]:m[2*part.group+1]])
+			}
+		}
+		prev = m[1]
+		replaced = true
+	}
+	if !replaced {
+		return false
+	}
+	sb.WriteString(r.space[prev:])
+	r.space = sb.String()
+	return true
+}
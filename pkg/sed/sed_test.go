@@ -0,0 +1,155 @@
+package sed
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sedString(t *testing.T, text, script string, opts Options) string {
+	t.Helper()
+	prog, err := Compile(script, opts)
+	require.NoError(t, err)
+	tree, bom, err := ReadTree(strings.NewReader(text))
+	require.NoError(t, err)
+	prog.Apply(tree)
+	var out bytes.Buffer
+	require.NoError(t, WriteTree(&out, tree, bom))
+	return out.String()
+}
+
+func TestScripts(t *testing.T) {
+	cases := []struct {
+		name     string
+		text     string
+		script   string
+		expected string
+	}{
+		{"substitute first", "aaa\n", "s/a/b/", "baa\n"},
+		{"substitute global", "aaa\nxa\n", "s/a/b/g", "bbb\nxb\n"},
+		{"substitute nth and later", "aaaa\n", "s/a/b/2g", "abbb\n"},
+		{"substitute ignore case", "Foo foo\n", "s/foo/bar/gI", "bar bar\n"},
+		{"basic regex groups", "key=value\n", `s/\([a-z]*\)=\(.*\)/\2=\1 [&]/`, "value=key [key=value]\n"},
+		{"basic regex literal plus", "a+b\n", "s/a+b/x/", "x\n"},
+		{"bracket keeps escapes", "a]b\n", "s/[]]/-/", "a-b\n"},
+		{"newline in replacement", "a,b\n", `s/,/\n/`, "a\nb\n"},
+		{"delete line", "1\n2\n3\n", "2d", "1\n3\n"},
+		{"delete last", "1\n2\n3", "$d", "1\n2\n"},
+		{"delete range", "1\n2\n3\n4\n", "2,3d", "1\n4\n"},
+		{"regex range", "a\nstart\nb\nend\nc\n", "/start/,/end/d", "a\nc\n"},
+		{"step range", "1\n2\n3\n4\n", "1,+2d", "4\n"},
+		{"second address before first", "1\n2\n3\n", "2,1d", "1\n3\n"},
+		{"negate", "1\n2\n3\n", "2!d", "2\n"},
+		{"print duplicates", "a\nb\n", "1p", "a\na\nb\n"},
+		{"insert and append", "a\nb\n", "1i\\\ntop\n$a bottom", "top\na\nb\nbottom\n"},
+		{"append to last line without newline", "a", "a b", "a\nb"},
+		{"change range once", "1\n2\n3\n4\n", "2,3c\\\nX", "1\nX\n4\n"},
+		{"change each line when negated", "1\n2\n3\n", "2!c X", "X\n2\nX\n"},
+		{"transliterate", "hello\n", "y/elo/ELO/", "hELLO\n"},
+		{"empty regex reuses last", "foo\nbar\n", "/foo/s//x/", "x\nbar\n"},
+		{"block", "a\nb\nc\n", "/b/{s/b/B/;p}", "a\nB\nB\nc\n"},
+		{"comments and separators", "a\n", "# comment\ns/a/b/ ; s/b/c/", "c\n"},
+		{"keeps CRLF", "a\r\nb\r\n", "s/a/x\\ny/;1a z", "x\r\ny\r\nz\r\nb\r\n"},
+		{"keeps BOM", "\ufeffa\nb\n", "1s/^a/x/", "\ufeffx\nb\n"},
+		{"empty input", "", "s/a/b/", ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, sedString(t, c.text, c.script, Options{}))
+		})
+	}
+}
+
+func TestQuietAndExtended(t *testing.T) {
+	assert.Equal(t, "b\nc\n", sedString(t, "a\nb\nc\nd\n", "/b/,/c/p", Options{Quiet: true}))
+	assert.Equal(t, "b\n", sedString(t, "a\nb\n", "#n\n2p", Options{}))
+	assert.Equal(t, "x\n", sedString(t, "a|b\nab\n", "s/a|b/x/gp", Options{Quiet: true}))
+	assert.Equal(t, "[oo]\n", sedString(t, "foo\n", "s/f(o+)/[\\1]/", Options{Extended: true}))
+	assert.Equal(t, "xx\n", sedString(t, "ab\n", "s/a|b/x/g", Options{Extended: true}))
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, script := range []string{
+		"s/a/b",
+		"k",
+		"1,",
+		"y/ab/c/",
+		"{p",
+		"p}",
+		"s/a/b/x",
+		`s/\(a\)\1/b/`,
+	} {
+		_, err := Compile(script, Options{})
+		assert.Error(t, err, script)
+	}
+}
+
+func TestRangeStateResetsBetweenFiles(t *testing.T) {
+	prog, err := Compile("/start/,/end/d", Options{})
+	require.NoError(t, err)
+
+	tree, _, err := ReadTree(strings.NewReader("start\nopen\n"))
+	require.NoError(t, err)
+	prog.Apply(tree)
+	assert.Equal(t, "", tree.GetLinesRawContent())
+
+	tree, _, err = ReadTree(strings.NewReader("keep\n"))
+	require.NoError(t, err)
+	prog.Apply(tree)
+	assert.Equal(t, "keep\n", tree.GetLinesRawContent())
+}
+
+func TestLargeInputIsReadInChunks(t *testing.T) {
+	line := strings.Repeat("x", 99) + "\n"
+	text := strings.Repeat(line, 3*chunkSize/len(line))
+	out := sedString(t, text, "s/^x/y/;$d", Options{})
+	assert.Equal(t, strings.Repeat("y"+line[1:], 3*chunkSize/len(line)-1), out)
+}
+
+func TestReadTreeKeepsCRAcrossChunks(t *testing.T) {
+	for _, text := range []string{
+		"\r",
+		"a\r",
+		strings.Repeat("a", chunkSize-1) + "\r\r",
+		strings.Repeat("a", chunkSize-1) + "\r\nb",
+		strings.Repeat("a", chunkSize-1) + "\r" + strings.Repeat("b", chunkSize) + "\r",
+	} {
+		tree, _, err := ReadTree(strings.NewReader(text))
+		require.NoError(t, err)
+		assert.True(t, tree.GetLinesRawContent() == text, "round trip of %d bytes", len(text))
+	}
+}
+
+func TestEditFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+	original := "\ufeffone\r\ntwo\r\n"
+	require.NoError(t, os.WriteFile(path, []byte(original), 0600))
+
+	prog, err := Compile("s/o/0/g", Options{})
+	require.NoError(t, err)
+	require.NoError(t, EditFile(path, prog, "*.orig"))
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "\ufeff0ne\r\ntw0\r\n", string(content))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	backup, err := os.ReadFile(filepath.Join(dir, "file.txt.orig"))
+	require.NoError(t, err)
+	assert.Equal(t, original, string(backup))
+
+	// without a suffix no backup is written
+	require.NoError(t, EditFile(path, prog, ""))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
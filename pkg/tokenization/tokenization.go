@@ -0,0 +1,173 @@
+package tokenization
+
+import (
+	"github.com/kebaren/textbuffer/pkg/buffer"
+)
+
+// State 分词器在行与行之间传递的状态
+type State interface {
+	// Clone 返回状态的副本，分词器不能修改传入的状态
+	Clone() State
+	// Equals 判断两个状态是否相同，用于判断重新分词是否已经收敛
+	Equals(other State) bool
+}
+
+// Token 行内的一个标记
+type Token struct {
+	// StartIndex 起始字节偏移量（从 0 开始）
+	StartIndex int
+	// EndIndex 结束字节偏移量（不包含）
+	EndIndex int
+	// Scopes 标记所在的作用域，从外到内排列，例如 ["source.go", "keyword.control.go"]
+	Scopes []string
+}
+
+// Tokenizer 逐行分词器
+type Tokenizer interface {
+	// InitialState 返回第一行开始时的状态
+	InitialState() State
+	// Tokenize 从状态 state 开始对一行分词，返回覆盖整行的标记和行末的状态
+	Tokenize(line string, state State) (tokens []Token, end State)
+}
+
+// lineEntry 每行缓存的分词结果
+type lineEntry struct {
+	tokens []Token
+	// begin 分词时行首的状态，end 行末的状态
+	begin, end State
+	// valid 行的内容在分词之后没有被修改
+	valid bool
+}
+
+// Store 缓存每行的标记和状态
+// 修改文本缓冲区后从第一个被修改的行开始失效，查询时才重新分词，
+// 行首状态与缓存的状态相同且内容没有修改的行直接复用缓存的标记
+type Store struct {
+	tree      *buffer.PieceTreeBase
+	tokenizer Tokenizer
+	lines     []lineEntry
+	// invalidFrom 第一个行首状态可能已经改变的行（从 0 开始），之前的行都是最新的
+	invalidFrom int
+	// invalidCount 内容被修改过的行数
+	invalidCount int
+	// removeListener 取消注册内容修改监听器
+	removeListener func()
+}
+
+// NewStore 创建分词缓存，所有行都在第一次查询时才分词
+func NewStore(tree *buffer.PieceTreeBase, tokenizer Tokenizer) *Store {
+	s := &Store{tree: tree, tokenizer: tokenizer}
+	s.lines = make([]lineEntry, tree.GetLineCount())
+	s.invalidCount = len(s.lines)
+	s.removeListener = tree.AddChangeListener(s.onContentChange)
+	return s
+}
+
+// Tokenizer 返回分词器
+func (s *Store) Tokenizer() Tokenizer {
+	return s.tokenizer
+}
+
+// OnLinesReplaced 通知缓存从 startLine 开始的 oldLineCount 个行被替换为 newLineCount 个新行
+// 后面的行随之移动，缓存的标记保留到重新分词时再判断是否需要更新
+func (s *Store) OnLinesReplaced(startLine, oldLineCount, newLineCount int) {
+	index := min(max(startLine-1, 0), len(s.lines))
+	oldLineCount = max(min(oldLineCount, len(s.lines)-index), 0)
+	newLineCount = max(min(newLineCount, s.tree.GetLineCount()-index), 0)
+
+	// 之前重新分词停在 invalidFrom 行，它的行首状态可能已经过期；
+	// 失效位置提前之后把这一行标记为已修改，保证重新分词时不会越过它
+	if stale := s.invalidFrom; index < stale && stale >= index+oldLineCount && stale < len(s.lines) && s.lines[stale].valid {
+		s.lines[stale].valid = false
+		s.invalidCount++
+	}
+	for _, e := range s.lines[index : index+oldLineCount] {
+		if !e.valid {
+			s.invalidCount--
+		}
+	}
+	tail := append([]lineEntry(nil), s.lines[index+oldLineCount:]...)
+	s.lines = append(append(s.lines[:index], make([]lineEntry, newLineCount)...), tail...)
+	s.invalidCount += newLineCount
+	s.invalidFrom = min(s.invalidFrom, index)
+}
+
+// onContentChange 文本缓冲区修改后使受影响的行失效
+func (s *Store) onContentChange(change buffer.ContentChange) {
+	s.OnLinesReplaced(change.StartLine(), change.OldLineCount(), change.NewLineCount())
+}
+
+// Dispose 停止跟踪文本缓冲区的修改
+func (s *Store) Dispose() {
+	s.removeListener()
+}
+
+// stateBefore 返回第 index 行（从 0 开始）行首的状态，要求之前的行都是最新的
+func (s *Store) stateBefore(index int) State {
+	if index == 0 {
+		return s.tokenizer.InitialState()
+	}
+	return s.lines[index-1].end
+}
+
+// ensure 保证 [0, index] 行的标记和状态都是最新的
+func (s *Store) ensure(index int) {
+	for s.invalidFrom <= index && s.invalidFrom < len(s.lines) {
+		i := s.invalidFrom
+		begin := s.stateBefore(i)
+		e := &s.lines[i]
+		if e.valid && e.begin.Equals(begin) {
+			// 状态已经收敛，如果没有其它被修改的行，后面所有的行都是最新的
+			if s.invalidCount == 0 {
+				s.invalidFrom = len(s.lines)
+				return
+			}
+			s.invalidFrom++
+			continue
+		}
+
+		if !e.valid {
+			s.invalidCount--
+		}
+		tokens, end := s.tokenizer.Tokenize(s.tree.GetLineContent(i+1), begin.Clone())
+		*e = lineEntry{tokens: tokens, begin: begin, end: end, valid: true}
+		s.invalidFrom++
+	}
+}
+
+// GetLineTokens 返回一行的标记，必要时先对之前失效的行重新分词，行号无效时返回 nil
+func (s *Store) GetLineTokens(lineNumber int) []Token {
+	if lineNumber < 1 || lineNumber > len(s.lines) {
+		return nil
+	}
+	s.ensure(lineNumber - 1)
+	return s.lines[lineNumber-1].tokens
+}
+
+// GetStateBefore 返回一行行首的状态，行号无效时返回 nil
+func (s *Store) GetStateBefore(lineNumber int) State {
+	if lineNumber < 1 || lineNumber > len(s.lines) {
+		return nil
+	}
+	s.ensure(lineNumber - 2)
+	return s.stateBefore(lineNumber - 1)
+}
+
+// IsUpToDate 判断一行的标记是否是最新的，不会触发分词
+func (s *Store) IsUpToDate(lineNumber int) bool {
+	return lineNumber >= 1 && lineNumber <= s.invalidFrom
+}
+
+// FirstInvalidLine 返回第一个可能需要重新分词的行号，所有行都是最新的时返回 0
+func (s *Store) FirstInvalidLine() int {
+	if s.invalidFrom >= len(s.lines) {
+		return 0
+	}
+	return s.invalidFrom + 1
+}
+
+// TokenizeNext 最多对 maxLines 行重新分词，用于在后台分批完成分词，全部完成时返回 true
+func (s *Store) TokenizeNext(maxLines int) bool {
+	s.ensure(min(s.invalidFrom+maxLines, len(s.lines)) - 1)
+	return s.invalidFrom >= len(s.lines)
+}
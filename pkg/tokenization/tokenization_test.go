@@ -0,0 +1,162 @@
+package tokenization
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+	"github.com/kebaren/textbuffer/pkg/buffer/buffertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// commentState records whether a line starts inside a /* */ comment.
+type commentState struct {
+	inComment bool
+}
+
+func (s *commentState) Clone() State {
+	c := *s
+	return &c
+}
+
+func (s *commentState) Equals(other State) bool {
+	o, ok := other.(*commentState)
+	return ok && o.inComment == s.inComment
+}
+
+// commentTokenizer splits lines into "comment" and "text" tokens and counts its calls.
+type commentTokenizer struct {
+	calls int
+}
+
+func (t *commentTokenizer) InitialState() State {
+	return &commentState{}
+}
+
+func (t *commentTokenizer) Tokenize(line string, state State) ([]Token, State) {
+	t.calls++
+	s := state.(*commentState)
+	var tokens []Token
+	add := func(start, end int, scope string) {
+		if start < end {
+			tokens = append(tokens, Token{StartIndex: start, EndIndex: end, Scopes: []string{"source", scope}})
+		}
+	}
+	for i := 0; i < len(line); {
+		if s.inComment {
+			j := strings.Index(line[i:], "*/")
+			if j < 0 {
+				add(i, len(line), "comment")
+				break
+			}
+			add(i, i+j+2, "comment")
+			i += j + 2
+			s.inComment = false
+			continue
+		}
+		j := strings.Index(line[i:], "/*")
+		if j < 0 {
+			add(i, len(line), "text")
+			break
+		}
+		add(i, i+j, "text")
+		i += j
+		s.inComment = true
+	}
+	return tokens, s
+}
+
+func scopesOf(tokens []Token) []string {
+	var scopes []string
+	for _, t := range tokens {
+		scopes = append(scopes, t.Scopes[len(t.Scopes)-1])
+	}
+	return scopes
+}
+
+func TestLazyTokenization(t *testing.T) {
+	tree := buffertest.NewTree(strings.Repeat("line\n", 999) + "last")
+	tok := &commentTokenizer{}
+	s := NewStore(tree, tok)
+	assert.Equal(t, 0, tok.calls)
+	assert.Equal(t, 1, s.FirstInvalidLine())
+
+	assert.Equal(t, []Token{{StartIndex: 0, EndIndex: 4, Scopes: []string{"source", "text"}}}, s.GetLineTokens(10))
+	assert.Equal(t, 10, tok.calls)
+	assert.True(t, s.IsUpToDate(10))
+	assert.False(t, s.IsUpToDate(11))
+
+	for !s.TokenizeNext(300) {
+	}
+	assert.Equal(t, 1000, tok.calls)
+	assert.Equal(t, 0, s.FirstInvalidLine())
+
+	// Typing in a line only re-tokenizes that line once the state converges.
+	tok.calls = 0
+	tree.ApplyEdit(buffer.NewEditOperation(tree.GetOffsetAt(500, 1), 0, "x"))
+	assert.Equal(t, 500, s.FirstInvalidLine())
+	assert.Equal(t, []string{"text"}, scopesOf(s.GetLineTokens(1000)))
+	assert.Equal(t, 1, tok.calls)
+
+	// Opening a comment re-tokenizes every following line.
+	tok.calls = 0
+	tree.ApplyEdit(buffer.NewEditOperation(tree.GetOffsetAt(998, 1), 0, "/*"))
+	assert.Equal(t, []string{"comment"}, scopesOf(s.GetLineTokens(1000)))
+	assert.Equal(t, 3, tok.calls)
+	assert.Equal(t, &commentState{inComment: true}, s.GetStateBefore(999))
+	assert.Equal(t, &commentState{}, s.GetStateBefore(998))
+
+	// Inserting lines shifts the cached lines.
+	tok.calls = 0
+	tree.ApplyEdit(buffer.NewEditOperation(0, 0, "a\nb\n"))
+	assert.Equal(t, []string{"comment"}, scopesOf(s.GetLineTokens(1002)))
+	assert.Equal(t, 3, tok.calls)
+	assert.Nil(t, s.GetLineTokens(1003))
+}
+
+func TestStaleStateAfterPartialTokenization(t *testing.T) {
+	tree := buffertest.NewTree(strings.Repeat("line\n", 20))
+	tok := &commentTokenizer{}
+	s := NewStore(tree, tok)
+	s.GetLineTokens(21)
+
+	// Opening a comment and only querying the line itself leaves later lines stale.
+	tree.ApplyEdit(buffer.NewEditOperation(tree.GetOffsetAt(10, 1), 0, "/*"))
+	assert.Equal(t, []string{"comment"}, scopesOf(s.GetLineTokens(10)))
+
+	// An edit above must not let the converged state skip over the stale lines.
+	tree.ApplyEdit(buffer.NewEditOperation(tree.GetOffsetAt(2, 1), 0, "x"))
+	assert.Equal(t, []string{"comment"}, scopesOf(s.GetLineTokens(15)))
+}
+
+func TestIncrementalTokenization(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	pieces := []string{"/*", "*/", "\n", "word", " "}
+	tree := buffertest.NewTree("one\n/* two\nthree */ four\nfive\n")
+	s := NewStore(tree, &commentTokenizer{})
+
+	for i := 0; i < 500; i++ {
+		length := tree.GetLength()
+		op := buffer.NewEditOperation(r.Intn(length+1), 0, "")
+		if r.Intn(3) == 0 && length > op.Offset {
+			op.Length = r.Intn(min(length-op.Offset, 6)) + 1
+		}
+		for n := r.Intn(3); n > 0; n-- {
+			op.Text += pieces[r.Intn(len(pieces))]
+		}
+		tree.ApplyEdit(op)
+
+		// Query a random line, leaving the rest of the store partially tokenized.
+		line := r.Intn(tree.GetLineCount()) + 1
+		expected := NewStore(tree, &commentTokenizer{})
+		expected.Dispose()
+		require.Equal(t, expected.GetLineTokens(line), s.GetLineTokens(line), "after edit %d", i)
+	}
+
+	expected := NewStore(tree, &commentTokenizer{})
+	for line := 1; line <= tree.GetLineCount(); line++ {
+		require.Equal(t, expected.GetLineTokens(line), s.GetLineTokens(line), "line %d", line)
+	}
+}
@@ -0,0 +1,98 @@
+package viewmodel
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/kebaren/textbuffer/pkg/common"
+)
+
+// breakAfterASCII 之后可以换行的 ASCII 标点
+const breakAfterASCII = "-/?.,;:!)]}|&%"
+
+// isSpace 判断是否为空格或制表符
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+// isBreakAfter 判断字符之后是否可以换行
+func isBreakAfter(r rune) bool {
+	if r < utf8.RuneSelf {
+		return strings.ContainsRune(breakAfterASCII, r)
+	}
+	return unicode.IsPunct(r)
+}
+
+// isNoBreakBefore 判断字符之前是否不能换行：空格总是留在上一行末尾，标点不出现在行首
+func isNoBreakBefore(r rune) bool {
+	return isSpace(r) || unicode.IsPunct(r)
+}
+
+// canBreakBetween 判断两个相邻字符之间是否可以换行
+func canBreakBetween(prev, cur rune) bool {
+	if isNoBreakBefore(cur) {
+		return false
+	}
+	return isSpace(prev) || isBreakAfter(prev) || common.IsCJK(prev) || common.IsCJK(cur)
+}
+
+// segmentWidth 返回从显示列 visible 开始的字素簇 segment 占的列数
+func segmentWidth(segment string, visible, tabSize int) int {
+	if segment == "\t" {
+		return common.NextTabStop(visible, tabSize) - visible
+	}
+	return common.GraphemeWidth(segment)
+}
+
+// computeBreaks 计算一行在 wrappingColumn 列处软换行的位置，返回每个视图行（除最后一行外）结束的字节偏移量
+// 优先在空格之后、中日韩文字之间和标点之后换行，找不到换行机会时在字素簇边界处强制换行
+// 行尾的空格可以超出换行列，不会单独换到下一行
+func computeBreaks(line string, wrappingColumn, tabSize int) []int {
+	if wrappingColumn <= 0 || (len(line) <= wrappingColumn/2 && !strings.ContainsRune(line, '\t')) {
+		// 每个字素簇最多占两列，足够短的行不需要换行
+		return nil
+	}
+
+	var breaks []int
+	lineStart := 0
+	visible := 0
+	// lastBreak 上一个换行机会的偏移量
+	lastBreak := -1
+	prev := rune(-1)
+
+	for i := 0; i < len(line); {
+		next := common.NextGraphemeBoundary(line, i)
+		cur, _ := utf8.DecodeRuneInString(line[i:])
+
+		if i > lineStart && prev >= 0 && canBreakBetween(prev, cur) {
+			lastBreak = i
+		}
+
+		width := segmentWidth(line[i:next], visible, tabSize)
+
+		// 换行机会之后的内容加上当前字符仍然放不下时，第二次循环在当前字符之前强制换行
+		for visible+width > wrappingColumn && i > lineStart && !isSpace(cur) {
+			breakAt := i
+			if lastBreak > lineStart {
+				breakAt = lastBreak
+			}
+			breaks = append(breaks, breakAt)
+			lineStart = breakAt
+			lastBreak = -1
+			// 移到新视图行的内容从第 0 列重新计算，制表符的宽度取决于它所在的列
+			visible = 0
+			for j := breakAt; j < i; {
+				end := common.NextGraphemeBoundary(line, j)
+				visible += segmentWidth(line[j:end], visible, tabSize)
+				j = end
+			}
+			width = segmentWidth(line[i:next], visible, tabSize)
+		}
+
+		visible += width
+		prev, _ = utf8.DecodeLastRuneInString(line[i:next])
+		i = next
+	}
+	return breaks
+}
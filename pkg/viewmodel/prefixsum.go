@@ -0,0 +1,97 @@
+package viewmodel
+
+import "sort"
+
+// prefixSum 支持插入和删除的前缀和，前缀和在查询时从第一个失效的位置开始惰性重新计算
+type prefixSum struct {
+	values []int
+	sums   []int
+	// validIndex sums[0..validIndex] 是有效的
+	validIndex int
+}
+
+// newPrefixSum 创建前缀和
+func newPrefixSum(values []int) *prefixSum {
+	return &prefixSum{
+		values:     values,
+		sums:       make([]int, len(values)),
+		validIndex: -1,
+	}
+}
+
+// count 返回元素数量
+func (p *prefixSum) count() int {
+	return len(p.values)
+}
+
+// invalidate 使 index 及之后的前缀和失效
+func (p *prefixSum) invalidate(index int) {
+	if index-1 < p.validIndex {
+		p.validIndex = index - 1
+	}
+}
+
+// set 修改 index 处的值
+func (p *prefixSum) set(index, value int) {
+	if p.values[index] == value {
+		return
+	}
+	p.values[index] = value
+	p.invalidate(index)
+}
+
+// replace 把 [index, index+removed) 处的值替换为 values
+func (p *prefixSum) replace(index, removed int, values []int) {
+	tail := append([]int(nil), p.values[index+removed:]...)
+	p.values = append(append(p.values[:index], values...), tail...)
+	if cap(p.sums) >= len(p.values) {
+		p.sums = p.sums[:len(p.values)]
+	} else {
+		sums := make([]int, len(p.values))
+		copy(sums, p.sums)
+		p.sums = sums
+	}
+	p.invalidate(index)
+}
+
+// ensureValid 重新计算到 index 为止的前缀和
+func (p *prefixSum) ensureValid(index int) {
+	for i := p.validIndex + 1; i <= index; i++ {
+		prev := 0
+		if i > 0 {
+			prev = p.sums[i-1]
+		}
+		p.sums[i] = prev + p.values[i]
+	}
+	if index > p.validIndex {
+		p.validIndex = index
+	}
+}
+
+// total 返回所有值的和
+func (p *prefixSum) total() int {
+	if len(p.values) == 0 {
+		return 0
+	}
+	p.ensureValid(len(p.values) - 1)
+	return p.sums[len(p.values)-1]
+}
+
+// sumBefore 返回 [0, index) 的和
+func (p *prefixSum) sumBefore(index int) int {
+	if index <= 0 {
+		return 0
+	}
+	p.ensureValid(index - 1)
+	return p.sums[index-1]
+}
+
+// indexOf 返回累计和 sum（从 0 开始）所在的元素下标和在该元素内的余数
+func (p *prefixSum) indexOf(sum int) (index, remainder int) {
+	p.ensureValid(len(p.values) - 1)
+	index = sort.Search(len(p.sums), func(i int) bool { return p.sums[i] > sum })
+	if index >= len(p.values) {
+		index = len(p.values) - 1
+	}
+	return index, sum - p.sumBefore(index)
+}
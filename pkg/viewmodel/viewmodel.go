@@ -0,0 +1,217 @@
+package viewmodel
+
+import (
+	"sort"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+	"github.com/kebaren/textbuffer/pkg/common"
+)
+
+// WrappingOptions 软换行设置
+type WrappingOptions struct {
+	// Column 换行列（显示宽度），不大于 0 时不换行
+	Column int
+	// TabSize 制表符的宽度
+	TabSize int
+}
+
+// tabSize 返回制表符的宽度，无效时为 4
+func (o WrappingOptions) tabSize() int {
+	if o.TabSize > 0 {
+		return o.TabSize
+	}
+	return 4
+}
+
+// ViewPosition 视图中的位置
+type ViewPosition struct {
+	// LineNumber 视图行号（从 1 开始）
+	LineNumber int
+	// Column 视图行内的列号（从 1 开始的字节列）
+	Column int
+}
+
+// ViewLine 一个视图行
+type ViewLine struct {
+	// ModelLineNumber 所属的模型行号
+	ModelLineNumber int
+	// StartColumn 在模型行中的起始列（包含）
+	StartColumn int
+	// EndColumn 在模型行中的结束列（不包含）
+	EndColumn int
+	// Content 视图行的内容
+	Content string
+}
+
+// ViewModel 软换行视图模型，把模型行映射为按换行列折行后的视图行
+// 修改文本缓冲区时通过修改监听器自动增量更新映射
+type ViewModel struct {
+	tree *buffer.PieceTreeBase
+	opts WrappingOptions
+	// breaks 每个模型行的换行位置（字节偏移量）
+	breaks [][]int
+	// counts 每个模型行的视图行数
+	counts *prefixSum
+	// removeListener 取消注册内容修改监听器
+	removeListener func()
+}
+
+// NewViewModel 创建视图模型
+func NewViewModel(tree *buffer.PieceTreeBase, opts WrappingOptions) *ViewModel {
+	vm := &ViewModel{tree: tree, opts: opts}
+	vm.recompute()
+	vm.removeListener = tree.AddChangeListener(vm.onContentChange)
+	return vm
+}
+
+// Options 返回软换行设置
+func (vm *ViewModel) Options() WrappingOptions {
+	return vm.opts
+}
+
+// SetOptions 修改软换行设置并重新计算所有行
+func (vm *ViewModel) SetOptions(opts WrappingOptions) {
+	if opts == vm.opts {
+		return
+	}
+	vm.opts = opts
+	vm.recompute()
+}
+
+// SetWrappingColumn 修改换行列并重新计算所有行
+func (vm *ViewModel) SetWrappingColumn(column int) {
+	opts := vm.opts
+	opts.Column = column
+	vm.SetOptions(opts)
+}
+
+// recompute 重新计算所有行的换行位置
+func (vm *ViewModel) recompute() {
+	lineCount := vm.tree.GetLineCount()
+	vm.breaks = make([][]int, lineCount)
+	counts := make([]int, lineCount)
+	for i := range vm.breaks {
+		vm.breaks[i] = vm.computeLine(i + 1)
+		counts[i] = len(vm.breaks[i]) + 1
+	}
+	vm.counts = newPrefixSum(counts)
+}
+
+// computeLine 计算模型行的换行位置
+func (vm *ViewModel) computeLine(lineNumber int) []int {
+	return computeBreaks(vm.tree.GetLineContent(lineNumber), vm.opts.Column, vm.opts.tabSize())
+}
+
+// OnLinesReplaced 通知视图模型从 startLine 开始的 oldLineCount 个模型行被替换为 newLineCount 个新行
+// 只重新计算新的行，其它行的映射保持不变
+func (vm *ViewModel) OnLinesReplaced(startLine, oldLineCount, newLineCount int) {
+	if startLine < 1 {
+		startLine = 1
+	}
+	index := startLine - 1
+	if index > len(vm.breaks) {
+		index = len(vm.breaks)
+	}
+	if index+oldLineCount > len(vm.breaks) {
+		oldLineCount = len(vm.breaks) - index
+	}
+	if lineCount := vm.tree.GetLineCount(); index+newLineCount > lineCount {
+		newLineCount = lineCount - index
+	}
+	if oldLineCount < 0 {
+		oldLineCount = 0
+	}
+	if newLineCount < 0 {
+		newLineCount = 0
+	}
+
+	breaks := make([][]int, newLineCount)
+	counts := make([]int, newLineCount)
+	for i := range breaks {
+		breaks[i] = vm.computeLine(startLine + i)
+		counts[i] = len(breaks[i]) + 1
+	}
+	tail := append([][]int(nil), vm.breaks[index+oldLineCount:]...)
+	vm.breaks = append(append(vm.breaks[:index], breaks...), tail...)
+	vm.counts.replace(index, oldLineCount, counts)
+}
+
+// onContentChange 文本缓冲区修改后更新受影响的行
+func (vm *ViewModel) onContentChange(change buffer.ContentChange) {
+	vm.OnLinesReplaced(change.StartLine(), change.OldLineCount(), change.NewLineCount())
+}
+
+// Dispose 停止跟踪文本缓冲区的修改
+func (vm *ViewModel) Dispose() {
+	vm.removeListener()
+}
+
+// GetViewLineCount 返回视图行的总数
+func (vm *ViewModel) GetViewLineCount() int {
+	return vm.counts.total()
+}
+
+// GetModelLineViewLineCount 返回模型行折行后的视图行数，行号无效时返回 0
+func (vm *ViewModel) GetModelLineViewLineCount(lineNumber int) int {
+	if lineNumber < 1 || lineNumber > len(vm.breaks) {
+		return 0
+	}
+	return len(vm.breaks[lineNumber-1]) + 1
+}
+
+// clampModelPosition 把模型位置限制在文档范围内
+func (vm *ViewModel) clampModelPosition(position common.Position) common.Position {
+	lineNumber := min(max(position.LineNumber, 1), len(vm.breaks))
+	column := min(max(position.Column, 1), vm.tree.GetLineLength(lineNumber)+1)
+	return common.Position{LineNumber: lineNumber, Column: column}
+}
+
+// segment 返回模型行第 index 个视图行的字节范围 [start, end)
+func (vm *ViewModel) segment(lineNumber, index int) (start, end int) {
+	breaks := vm.breaks[lineNumber-1]
+	if index > 0 {
+		start = breaks[index-1]
+	}
+	if index < len(breaks) {
+		end = breaks[index]
+	} else {
+		end = vm.tree.GetLineLength(lineNumber)
+	}
+	return start, end
+}
+
+// ModelToView 把模型位置转换为视图位置，位置正好在换行处时属于下一个视图行的开头
+func (vm *ViewModel) ModelToView(position common.Position) ViewPosition {
+	position = vm.clampModelPosition(position)
+	offset := position.Column - 1
+	index := sort.SearchInts(vm.breaks[position.LineNumber-1], offset+1)
+	start, _ := vm.segment(position.LineNumber, index)
+	return ViewPosition{
+		LineNumber: vm.counts.sumBefore(position.LineNumber-1) + index + 1,
+		Column:     offset - start + 1,
+	}
+}
+
+// ViewToModel 把视图位置转换为模型位置，超出范围的行号和列号会被限制在有效范围内
+func (vm *ViewModel) ViewToModel(viewLineNumber, column int) common.Position {
+	viewLineNumber = min(max(viewLineNumber, 1), vm.GetViewLineCount())
+	index, remainder := vm.counts.indexOf(viewLineNumber - 1)
+	lineNumber := index + 1
+	start, end := vm.segment(lineNumber, remainder)
+	column = min(max(column, 1), end-start+1)
+	return common.Position{LineNumber: lineNumber, Column: start + column}
+}
+
+// GetViewLine 返回视图行，行号超出范围时限制在有效范围内
+func (vm *ViewModel) GetViewLine(viewLineNumber int) ViewLine {
+	viewLineNumber = min(max(viewLineNumber, 1), vm.GetViewLineCount())
+	index, remainder := vm.counts.indexOf(viewLineNumber - 1)
+	lineNumber := index + 1
+	start, end := vm.segment(lineNumber, remainder)
+	return ViewLine{
+		ModelLineNumber: lineNumber,
+		StartColumn:     start + 1,
+		EndColumn:       end + 1,
+		Content:         vm.tree.GetLineContent(lineNumber)[start:end],
+	}
+}
@@ -0,0 +1,190 @@
+package viewmodel
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+	"github.com/kebaren/textbuffer/pkg/buffer/buffertest"
+	"github.com/kebaren/textbuffer/pkg/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// viewLines renders every view line of the model as a string.
+func viewLines(vm *ViewModel) []string {
+	var lines []string
+	for i := 1; i <= vm.GetViewLineCount(); i++ {
+		lines = append(lines, vm.GetViewLine(i).Content)
+	}
+	return lines
+}
+
+func wrap(line string, column int) []string {
+	return viewLines(NewViewModel(buffertest.NewTree(line), WrappingOptions{Column: column, TabSize: 4}))
+}
+
+func TestComputeBreaks(t *testing.T) {
+	// Breaks after spaces; trailing spaces hang past the wrapping column.
+	assert.Equal(t, []string{"hello ", "world ", "foo"}, wrap("hello world foo", 8))
+	assert.Equal(t, []string{"abc     ", "def"}, wrap("abc     def", 4))
+
+	// Breaks after punctuation but never before it.
+	assert.Equal(t, []string{"foo.bar.", "baz"}, wrap("foo.bar.baz", 8))
+	assert.Equal(t, []string{"a-b-c-", "d"}, wrap("a-b-c-d", 6))
+	assert.Equal(t, []string{"aaaaaa", "a.b"}, wrap("aaaaaaa.b", 6))
+
+	// CJK characters take two columns and can break between each other.
+	assert.Equal(t, []string{"中文文", "本换行"}, wrap("中文文本换行", 6))
+	assert.Equal(t, []string{"ab中", "文"}, wrap("ab中文", 5))
+	// No break before CJK punctuation.
+	assert.Equal(t, []string{"中文", "本。"}, wrap("中文本。", 6))
+
+	// Forced breaks when no opportunity exists, at grapheme boundaries.
+	assert.Equal(t, []string{"abcd", "efgh", "ij"}, wrap("abcdefghij", 4))
+	assert.Equal(t, []string{"éé", "é"}, wrap("ééé", 2))
+	// Content after the last opportunity is still force-broken if too long.
+	assert.Equal(t, []string{"a ", "bcdef", "gh"}, wrap("a bcdefgh", 5))
+
+	// Tabs expand to tab stops.
+	assert.Equal(t, []string{"\tab ", "cd"}, wrap("\tab cd", 6))
+	// A tab moved to the next view line is measured from that line's start.
+	assert.Equal(t, []string{"abcdef ", "x\t.!?;", ":"}, wrap("abcdef x\t.!?;:", 8))
+
+	// No wrapping column or short lines.
+	assert.Equal(t, []string{"hello world"}, wrap("hello world", 0))
+	assert.Equal(t, []string{"short"}, wrap("short", 80))
+}
+
+func TestViewLineCounts(t *testing.T) {
+	tree := buffertest.NewTree("aaaa bbbb cccc\n\nshort\ndddd eeee")
+	vm := NewViewModel(tree, WrappingOptions{Column: 5, TabSize: 4})
+
+	assert.Equal(t, 3, vm.GetModelLineViewLineCount(1))
+	assert.Equal(t, 1, vm.GetModelLineViewLineCount(2))
+	assert.Equal(t, 1, vm.GetModelLineViewLineCount(3))
+	assert.Equal(t, 2, vm.GetModelLineViewLineCount(4))
+	assert.Equal(t, 0, vm.GetModelLineViewLineCount(5))
+	assert.Equal(t, 7, vm.GetViewLineCount())
+
+	line := vm.GetViewLine(2)
+	assert.Equal(t, ViewLine{ModelLineNumber: 1, StartColumn: 6, EndColumn: 11, Content: "bbbb "}, line)
+	assert.Equal(t, ViewLine{ModelLineNumber: 4, StartColumn: 6, EndColumn: 10, Content: "eeee"}, vm.GetViewLine(7))
+
+	vm.SetWrappingColumn(0)
+	assert.Equal(t, 4, vm.GetViewLineCount())
+	assert.Equal(t, "aaaa bbbb cccc", vm.GetViewLine(1).Content)
+}
+
+func TestModelToView(t *testing.T) {
+	tree := buffertest.NewTree("first\naaaa bbbb cccc")
+	vm := NewViewModel(tree, WrappingOptions{Column: 5, TabSize: 4})
+
+	assert.Equal(t, ViewPosition{LineNumber: 1, Column: 3}, vm.ModelToView(common.Position{LineNumber: 1, Column: 3}))
+	assert.Equal(t, ViewPosition{LineNumber: 2, Column: 1}, vm.ModelToView(common.Position{LineNumber: 2, Column: 1}))
+	assert.Equal(t, ViewPosition{LineNumber: 2, Column: 5}, vm.ModelToView(common.Position{LineNumber: 2, Column: 5}))
+	// A position exactly at a break belongs to the start of the next view line.
+	assert.Equal(t, ViewPosition{LineNumber: 3, Column: 1}, vm.ModelToView(common.Position{LineNumber: 2, Column: 6}))
+	assert.Equal(t, ViewPosition{LineNumber: 4, Column: 5}, vm.ModelToView(common.Position{LineNumber: 2, Column: 15}))
+	// Out of range positions are clamped.
+	assert.Equal(t, ViewPosition{LineNumber: 4, Column: 5}, vm.ModelToView(common.Position{LineNumber: 9, Column: 99}))
+	assert.Equal(t, ViewPosition{LineNumber: 1, Column: 1}, vm.ModelToView(common.Position{LineNumber: 0, Column: 0}))
+
+	assert.Equal(t, common.Position{LineNumber: 2, Column: 8}, vm.ViewToModel(3, 3))
+	assert.Equal(t, common.Position{LineNumber: 2, Column: 11}, vm.ViewToModel(3, 99))
+	assert.Equal(t, common.Position{LineNumber: 1, Column: 1}, vm.ViewToModel(-1, -1))
+	assert.Equal(t, common.Position{LineNumber: 2, Column: 15}, vm.ViewToModel(99, 99))
+}
+
+func TestRoundTrip(t *testing.T) {
+	text := "The quick brown fox jumps over the lazy dog.\n" +
+		"中文文本，需要在合适的位置换行。\n" +
+		"\tindented, with-hyphenated-words and/or/slashes\n" +
+		"averyveryverylongwordwithoutanybreakopportunity\n" +
+		"émojis 👍🏽 and 한국어 텍스트"
+	tree := buffertest.NewTree(text)
+	for _, column := range []int{1, 3, 7, 10, 16, 80} {
+		vm := NewViewModel(tree, WrappingOptions{Column: column, TabSize: 4})
+
+		// Every view line is reproduced by joining the view lines of each model line.
+		for lineNumber := 1; lineNumber <= tree.GetLineCount(); lineNumber++ {
+			var sb strings.Builder
+			first := vm.ModelToView(common.Position{LineNumber: lineNumber, Column: 1}).LineNumber
+			for i := 0; i < vm.GetModelLineViewLineCount(lineNumber); i++ {
+				sb.WriteString(vm.GetViewLine(first + i).Content)
+			}
+			assert.Equal(t, tree.GetLineContent(lineNumber), sb.String())
+		}
+
+		// Every view position maps to a model position and back.
+		for viewLine := 1; viewLine <= vm.GetViewLineCount(); viewLine++ {
+			line := vm.GetViewLine(viewLine)
+			maxColumn := len(line.Content)
+			if line.EndColumn == tree.GetLineLength(line.ModelLineNumber)+1 {
+				maxColumn++
+			}
+			for column := 1; column <= maxColumn; column++ {
+				pos := vm.ViewToModel(viewLine, column)
+				assert.Equal(t, ViewPosition{LineNumber: viewLine, Column: column}, vm.ModelToView(pos))
+			}
+		}
+	}
+}
+
+func TestIncrementalUpdates(t *testing.T) {
+	rng := rand.New(rand.NewSource(39))
+	words := []string{"alpha ", "beta", "-", ".", "中文", "\n", "  ", "gamma,", "\t", "longerwordhere"}
+
+	tree := buffertest.NewTree("initial text\nwith a few lines\n")
+	opts := WrappingOptions{Column: 8, TabSize: 4}
+	vm := NewViewModel(tree, opts)
+
+	for i := 0; i < 300; i++ {
+		length := tree.GetLength()
+		offset := rng.Intn(length + 1)
+		op := buffer.NewEditOperation(offset, 0, "")
+		if rng.Intn(3) == 0 && length > offset {
+			op.Length = rng.Intn(min(length-offset, 20)) + 1
+		}
+		if rng.Intn(4) != 0 {
+			for n := rng.Intn(4) + 1; n > 0; n-- {
+				op.Text += words[rng.Intn(len(words))]
+			}
+		}
+		tree.ApplyEdit(op)
+
+		expected := NewViewModel(tree, opts)
+		expected.Dispose()
+		require.Equal(t, expected.breaks, vm.breaks, "after edit %d", i)
+		require.Equal(t, expected.GetViewLineCount(), vm.GetViewLineCount(), "after edit %d", i)
+	}
+
+	// Edits and undo through a history keep the view model in sync.
+	history := buffer.NewHistory(tree, 0)
+	history.Apply([]buffer.EditOperation{
+		buffer.NewEditOperation(0, 0, "a new first line\n"),
+		buffer.NewEditOperation(5, 10, ""),
+	})
+	assert.Equal(t, viewLines(NewViewModel(tree, opts)), viewLines(vm))
+	history.Undo()
+	assert.Equal(t, viewLines(NewViewModel(tree, opts)), viewLines(vm))
+
+	// A disposed view model no longer follows the buffer.
+	vm.Dispose()
+	lineCount := vm.GetViewLineCount()
+	tree.ApplyEdit(buffer.NewEditOperation(0, 0, "x\n"))
+	assert.Equal(t, lineCount, vm.GetViewLineCount())
+}
+
+func TestDirectEditsAreObserved(t *testing.T) {
+	tree := buffertest.NewTree("one\ntwo\nthree")
+	vm := NewViewModel(tree, WrappingOptions{Column: 4, TabSize: 4})
+	assert.Equal(t, 4, vm.GetViewLineCount())
+
+	// Direct inserts and deletes are observed as well.
+	tree.Insert(tree.GetOffsetAt(2, 4), " two two\nnew", true)
+	assert.Equal(t, []string{"one", "two ", "two ", "two", "new", "thre", "e"}, viewLines(vm))
+	tree.Delete(0, 4)
+	assert.Equal(t, []string{"two ", "two ", "two", "new", "thre", "e"}, viewLines(vm))
+}
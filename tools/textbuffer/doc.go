@@ -0,0 +1,33 @@
+// textbuffer 回放录制的编辑轨迹，用于发现片段树 Insert 和 Delete 的性能退化
+//
+// 用法：
+//
+//	go run ./tools/textbuffer [-count N] [-v] TRACE...
+//
+// TRACE 为 - 时从标准输入读取。每个轨迹回放 N 次，报告每秒操作数、每个操作的内存分配、
+// 最终的片段数量和树的深度。轨迹中给出了期望的哈希值时，校验最终内容，不一致时退出码为 1。
+//
+// 轨迹格式
+//
+// 轨迹可以是一个 JSON 对象：
+//
+//	{
+//	  "initial": "初始内容",
+//	  "sha256": "最终内容的 SHA-256，十六进制",
+//	  "ops": [
+//	    {"op": "insert", "offset": 0, "text": "hello"},
+//	    {"op": "delete", "offset": 0, "length": 2}
+//	  ]
+//	}
+//
+// 也可以是每行一个 JSON 对象的 NDJSON。带 initial 或 sha256 而没有 op 的行是头部，
+// 必须出现在所有操作之前，其余每行是一个操作：
+//
+//	{"initial": "", "sha256": "..."}
+//	{"op": "insert", "offset": 0, "text": "hello"}
+//	{"op": "delete", "offset": 0, "length": 2}
+//
+// offset 和 length 都是 UTF-8 字节数，与 PieceTreeBase 一致。insert 在 offset 处插入 text，
+// delete 删除 [offset, offset+length)。超出当前内容范围的操作是错误。
+// initial 和 sha256 都可以省略，省略 sha256 时只打印最终内容的哈希值。
+package main
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"time"
+)
+
+// result 一个轨迹的回放结果
+type result struct {
+	ops     int
+	elapsed time.Duration
+	mallocs uint64
+	bytes   uint64
+	pieces  int
+	depth   int
+	length  int
+	lines   int
+	hash    string
+}
+
+func main() {
+	count := flag.Int("count", 5, "replay each trace `N` times")
+	verbose := flag.Bool("v", false, "print the timing of every run")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: textbuffer [-count N] [-v] TRACE...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 || *count < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	status := 0
+	for _, name := range flag.Args() {
+		if err := benchmark(os.Stdout, name, *count, *verbose); err != nil {
+			fmt.Fprintf(os.Stderr, "textbuffer: %s: %v\n", name, err)
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
+
+// benchmark 读取轨迹并回放 count 次，输出报告，哈希值不一致时返回错误
+func benchmark(w io.Writer, name string, count int, verbose bool) error {
+	tr, err := load(name)
+	if err != nil {
+		return err
+	}
+
+	var total result
+	for i := 0; i < count; i++ {
+		res, err := run(tr)
+		if err != nil {
+			return err
+		}
+		if verbose {
+			fmt.Fprintf(w, "%s: run %d: %v\n", name, i+1, res.elapsed)
+		}
+		total.ops += res.ops
+		total.elapsed += res.elapsed
+		total.mallocs += res.mallocs
+		total.bytes += res.bytes
+		total.pieces, total.depth = res.pieces, res.depth
+		total.length, total.lines, total.hash = res.length, res.lines, res.hash
+	}
+
+	opsPerSec := 0.0
+	if total.elapsed > 0 {
+		opsPerSec = float64(total.ops) / total.elapsed.Seconds()
+	}
+	perOp := func(v uint64) float64 {
+		if total.ops == 0 {
+			return 0
+		}
+		return float64(v) / float64(total.ops)
+	}
+	fmt.Fprintf(w, "%s: %d ops x %d runs in %v\n", name, len(tr.Ops), count, total.elapsed)
+	fmt.Fprintf(w, "  %.0f ops/sec, %.1f allocs/op, %.1f B/op\n", opsPerSec, perOp(total.mallocs), perOp(total.bytes))
+	fmt.Fprintf(w, "  %d pieces, depth %d, %d bytes, %d lines\n", total.pieces, total.depth, total.length, total.lines)
+
+	if tr.SHA256 == "" {
+		fmt.Fprintf(w, "  sha256 %s\n", total.hash)
+		return nil
+	}
+	if tr.SHA256 != total.hash {
+		return fmt.Errorf("final content hash %s does not match expected %s", total.hash, tr.SHA256)
+	}
+	fmt.Fprintf(w, "  sha256 %s ok\n", total.hash)
+	return nil
+}
+
+// load 读取轨迹文件，- 表示标准输入
+func load(name string) (*trace, error) {
+	if name == "-" {
+		return readTrace(os.Stdin)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readTrace(f)
+}
+
+// run 在新的片段树上回放一次轨迹，只统计回放本身的时间和内存分配
+func run(tr *trace) (result, error) {
+	tree := tr.newTree()
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	err := tr.replay(tree)
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		return result{}, err
+	}
+
+	return result{
+		ops:     len(tr.Ops),
+		elapsed: elapsed,
+		mallocs: after.Mallocs - before.Mallocs,
+		bytes:   after.TotalAlloc - before.TotalAlloc,
+		pieces:  pieceCount(tree),
+		depth:   treeDepth(tree.Root),
+		length:  tree.GetLength(),
+		lines:   tree.GetLineCount(),
+		hash:    hashTree(tree),
+	}, nil
+}
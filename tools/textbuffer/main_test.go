@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadTraceFormats(t *testing.T) {
+	doc := `{"initial": "ab", "ops": [{"op": "insert", "offset": 1, "text": "x"}, {"op": "delete", "offset": 0, "length": 1}]}`
+	lines := `{"initial": "ab"}
+{"op": "insert", "offset": 1, "text": "x"}
+{"op": "delete", "offset": 0, "length": 1}
+`
+	for _, input := range []string{doc, lines} {
+		tr, err := readTrace(strings.NewReader(input))
+		require.NoError(t, err)
+		assert.Equal(t, "ab", tr.Initial)
+		require.Len(t, tr.Ops, 2)
+
+		tree := tr.newTree()
+		require.NoError(t, tr.replay(tree))
+		assert.Equal(t, "xb", tree.GetLinesRawContent())
+	}
+}
+
+func TestReadTraceErrors(t *testing.T) {
+	for _, input := range []string{
+		`{"op": "insert", "offset": 0, "text": "a"}` + "\n" + `{"initial": "b"}`,
+		`{"op": "replace", "offset": 0}`,
+		`{"op": "insert", "offset": `,
+	} {
+		_, err := readTrace(strings.NewReader(input))
+		assert.Error(t, err, input)
+	}
+}
+
+func TestReplayRejectsOutOfRangeOps(t *testing.T) {
+	for _, input := range []string{
+		`{"initial": "ab", "ops": [{"op": "insert", "offset": 3, "text": "x"}]}`,
+		`{"initial": "ab", "ops": [{"op": "delete", "offset": 1, "length": 2}]}`,
+	} {
+		tr, err := readTrace(strings.NewReader(input))
+		require.NoError(t, err)
+		assert.Error(t, tr.replay(tr.newTree()), input)
+	}
+}
+
+func TestRecordedTraces(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*"))
+	require.NoError(t, err)
+	require.NotEmpty(t, paths)
+
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			var out bytes.Buffer
+			require.NoError(t, benchmark(&out, path, 2, false))
+			assert.Contains(t, out.String(), "ops/sec")
+			assert.Contains(t, out.String(), " ok\n")
+		})
+	}
+}
+
+func TestHashMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.json")
+	trace := `{"initial": "a", "sha256": "0000", "ops": [{"op": "insert", "offset": 1, "text": "b"}]}`
+	require.NoError(t, os.WriteFile(path, []byte(trace), 0644))
+
+	var out bytes.Buffer
+	err := benchmark(&out, path, 1, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "does not match")
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/kebaren/textbuffer/pkg/buffer"
+)
+
+// traceOp 轨迹中的一个编辑操作
+type traceOp struct {
+	// Op 操作类型：insert 或 delete
+	Op string `json:"op"`
+	// Offset 字节偏移量
+	Offset int `json:"offset"`
+	// Length delete 删除的字节数
+	Length int `json:"length,omitempty"`
+	// Text insert 插入的文本
+	Text string `json:"text,omitempty"`
+}
+
+// trace 一个编辑轨迹
+type trace struct {
+	// Initial 初始内容
+	Initial string `json:"initial"`
+	// SHA256 最终内容的 SHA-256，为空时不校验
+	SHA256 string `json:"sha256,omitempty"`
+	// Ops 编辑操作
+	Ops []traceOp `json:"ops"`
+}
+
+// record JSON 或 NDJSON 中的一个对象，可能是完整的轨迹、头部或操作
+type record struct {
+	traceOp
+	Initial *string   `json:"initial"`
+	SHA256  string    `json:"sha256"`
+	Ops     []traceOp `json:"ops"`
+}
+
+// readTrace 读取 JSON 或 NDJSON 格式的轨迹
+func readTrace(r io.Reader) (*trace, error) {
+	dec := json.NewDecoder(r)
+	tr := &trace{}
+	for n := 1; ; n++ {
+		var rec record
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("record %d: %v", n, err)
+		}
+
+		if rec.traceOp.Op == "" {
+			if len(tr.Ops) > 0 {
+				return nil, fmt.Errorf("record %d: header after operations", n)
+			}
+			if rec.Initial != nil {
+				tr.Initial = *rec.Initial
+			}
+			if rec.SHA256 != "" {
+				tr.SHA256 = strings.ToLower(rec.SHA256)
+			}
+			tr.Ops = append(tr.Ops, rec.Ops...)
+			continue
+		}
+		tr.Ops = append(tr.Ops, rec.traceOp)
+	}
+
+	for i, op := range tr.Ops {
+		if op.Op != "insert" && op.Op != "delete" {
+			return nil, fmt.Errorf("op %d: unknown operation %q", i, op.Op)
+		}
+	}
+	return tr, nil
+}
+
+// newTree 用轨迹的初始内容创建片段树
+func (tr *trace) newTree() *buffer.PieceTreeBase {
+	return buffer.NewPieceTree(tr.Initial)
+}
+
+// replay 在片段树上依次执行所有操作
+func (tr *trace) replay(tree *buffer.PieceTreeBase) error {
+	for i, op := range tr.Ops {
+		length := tree.GetLength()
+		switch op.Op {
+		case "insert":
+			if op.Offset < 0 || op.Offset > length {
+				return fmt.Errorf("op %d: insert at %d outside [0, %d]", i, op.Offset, length)
+			}
+			tree.Insert(op.Offset, op.Text, false)
+		case "delete":
+			if op.Offset < 0 || op.Length < 0 || op.Offset+op.Length > length {
+				return fmt.Errorf("op %d: delete [%d, %d) outside [0, %d)", i, op.Offset, op.Offset+op.Length, length)
+			}
+			tree.Delete(op.Offset, op.Length)
+		}
+	}
+	return nil
+}
+
+// hashTree 返回片段树内容的 SHA-256，十六进制
+func hashTree(tree *buffer.PieceTreeBase) string {
+	h := sha256.New()
+	if tree.Root != buffer.SENTINEL {
+		tree.Iterate(tree.Root, func(node *buffer.TreeNode) bool {
+			io.WriteString(h, tree.GetPieceContent(node.Piece))
+			return true
+		})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// pieceCount 返回片段树中片段的数量
+func pieceCount(tree *buffer.PieceTreeBase) int {
+	count := 0
+	if tree.Root != buffer.SENTINEL {
+		tree.Iterate(tree.Root, func(node *buffer.TreeNode) bool {
+			count++
+			return true
+		})
+	}
+	return count
+}
+
+// treeDepth 返回片段树的深度，空树为 0
+func treeDepth(node *buffer.TreeNode) int {
+	if node == nil || node == buffer.SENTINEL {
+		return 0
+	}
+	left, right := treeDepth(node.Left), treeDepth(node.Right)
+	if left > right {
+		return left + 1
+	}
+	return right + 1
+}